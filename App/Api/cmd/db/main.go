@@ -1,10 +1,11 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"lostpets/cmd/internal/settings"
+	appconfig "lostpets/internal/config"
 	"os"
 	"path/filepath"
 
@@ -12,32 +13,13 @@ import (
 	goose "github.com/pressly/goose"
 )
 
-const (
-	postgresDBString = "user=%s password=%s host=%s port=%d dbname=%s sslmode=%s"
-)
-
-type (
-	//this config overlaps with the db portion of the server config, so they can use the same config file if wanted
-
-	dbConfig struct {
-		MigrationPath string `json:"migrationPath"`
-		Username      string `json:"migrationUser"`
-		Password      string `json:"migrationPassword"`
-		Host          string `json:"host"`
-		Port          int    `json:"port"`
-		DBName        string `json:"dbName"`
-		SSLMode       string `json:"sslMode"`
-	}
-
-	config struct {
-		DB dbConfig `json:"db"`
-	}
-)
-
 func main() {
 	// -- Flags -- //
 	flags := flag.NewFlagSet("goose", flag.ExitOnError)
-	configFile := flags.String("c", "./db.json", "config file path")
+	configFile := flags.String("c", "./db.json", "config file path, settings can also be set with LOSTPETS_DB_* environment variables")
+	var sets appconfig.Sets
+	flags.Var(&sets, "set", "path=value to override a setting, e.g. -set db.host=localhost, can be repeated")
+	printConfig := flags.Bool("print-config", false, "print the effective configuration with secrets redacted and exit")
 	printVersion := flags.Bool("version", false, "print version and exit")
 	verbose := flags.Bool("v", false, "enable verbose mode") //just copying GOOSE Flags so can document them
 	help := flags.Bool("h", false, "print help")
@@ -45,7 +27,7 @@ func main() {
 	flags.Parse(os.Args[1:])
 	args := flags.Args()
 
-	if len(args) < 1 || *help {
+	if (len(args) < 1 && !*printConfig) || *help {
 		flags.Usage()
 		return
 	}
@@ -59,16 +41,34 @@ func main() {
 		goose.SetVerbose(true)
 	}
 
+	//the default file is optional, the settings can all come from the environment instead
+	if _, err := os.Stat(*configFile); os.IsNotExist(err) && !isSet(flags, "c") {
+		*configFile = ""
+	}
+
 	// load conf file
-	var conf config
-	err := conf.load(*configFile)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error reading configuration file:", err)
+	conf := settings.DefaultMigrations()
+	problems := appconfig.Load(&conf, appconfig.Sources{File: *configFile, Env: os.Environ(), Known: settings.KnownEnv(), Sets: sets})
+	conf.DB.Validate(&problems)
+
+	if *printConfig {
+		out, err := appconfig.Redacted(conf)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(string(out))
+	}
+	if err := problems.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+	if *printConfig {
+		os.Exit(0)
+	}
 
 	command := args[0]
-	dbString := conf.DB.postgresDBString()
+	dbString := conf.DB.PostgresDBString()
 
 	db, err := goose.OpenDBWithDriver("postgres", dbString)
 	if err != nil {
@@ -80,9 +80,9 @@ func main() {
 		arguments = append(arguments, args[1:]...)
 	}
 
-	//migrations path is relative to the config
+	//migrations path is relative to the config, or the working directory without one
 	migrationPath := conf.DB.MigrationPath
-	if !filepath.IsAbs(migrationPath) {
+	if !filepath.IsAbs(migrationPath) && *configFile != "" {
 		migrationPath, err = filepath.Abs(filepath.Dir(*configFile))
 
 		if err != nil {
@@ -126,26 +126,10 @@ Commands:
 	}
 }
 
-/*
-* load config from the given file into the global config struct
- */
-func (conf *config) load(filename string) error {
-	file, err := os.Open(filename)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	jdc := json.NewDecoder(file)
-	err = jdc.Decode(conf)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func (conf *dbConfig) postgresDBString() string {
-	fmt.Printf(postgresDBString+"\n", conf.Username, conf.Password, conf.Host, conf.Port, conf.DBName, conf.SSLMode)
-	return fmt.Sprintf(postgresDBString, conf.Username, conf.Password, conf.Host, conf.Port, conf.DBName, conf.SSLMode)
+func isSet(flags *flag.FlagSet, name string) bool {
+	set := false
+	flags.Visit(func(f *flag.Flag) {
+		set = set || f.Name == name
+	})
+	return set
 }
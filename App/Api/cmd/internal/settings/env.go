@@ -0,0 +1,12 @@
+package settings
+
+import appconfig "lostpets/internal/config"
+
+// testDBEnv is the database the postgres integration tests run against, it isn't a setting of any command
+const testDBEnv = "LOSTPETS_TEST_DB"
+
+// KnownEnv is every LOSTPETS_* variable read by one of the commands, any other is a mistake
+func KnownEnv() []string {
+	known := append(appconfig.EnvNames(&Server{}), appconfig.EnvNames(&Migrations{})...)
+	return append(known, testDBEnv)
+}
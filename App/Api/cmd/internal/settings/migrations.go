@@ -0,0 +1,42 @@
+package settings
+
+import (
+	"fmt"
+	appconfig "lostpets/internal/config"
+)
+
+const postgresDBString = "user=%s password=%s host=%s port=%d dbname=%s sslmode=%s"
+
+type (
+	// Migrations is the config of cmd/db, it overlaps with the db section of the server config so they can use the same file if wanted
+	Migrations struct {
+		DB MigrationDB `json:"db"`
+	}
+
+	MigrationDB struct {
+		MigrationPath string `json:"migrationPath"`
+		Username      string `json:"migrationUser"`
+		Password      string `json:"migrationPassword" secret:"true"`
+		Host          string `json:"host"`
+		Port          int    `json:"port"`
+		DBName        string `json:"dbName"`
+		SSLMode       string `json:"sslMode"`
+	}
+)
+
+func DefaultMigrations() Migrations {
+	return Migrations{DB: MigrationDB{Host: "localhost", Port: 5432, SSLMode: "require"}}
+}
+
+// Validate adds every setting the migrations can't run without
+func (conf *MigrationDB) Validate(problems *appconfig.Problems) {
+	problems.Required("db.migrationPath", conf.MigrationPath)
+	problems.Required("db.migrationUser", conf.Username)
+	problems.Required("db.host", conf.Host)
+	problems.Required("db.dbName", conf.DBName)
+	problems.Range("db.port", float64(conf.Port), 1, 65535)
+}
+
+func (conf *MigrationDB) PostgresDBString() string {
+	return fmt.Sprintf(postgresDBString, conf.Username, conf.Password, conf.Host, conf.Port, conf.DBName, conf.SSLMode)
+}
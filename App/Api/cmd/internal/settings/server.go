@@ -0,0 +1,127 @@
+/*
+Package settings holds the config of each command. The commands share the environment, so each needs to
+know the LOSTPETS_* variables of the others to tell them apart from mistyped ones.
+*/
+package settings
+
+import (
+	"lostpets/internal/auth"
+	appconfig "lostpets/internal/config"
+	filestore "lostpets/internal/data/file-store"
+	"lostpets/internal/data/postgres"
+	"lostpets/internal/geocoding"
+	"lostpets/internal/http"
+	"lostpets/internal/jobs"
+	"lostpets/internal/lifecycle"
+	"lostpets/internal/logging"
+	"lostpets/internal/matching"
+	"lostpets/internal/notifications"
+	"strings"
+)
+
+// Server is the config of cmd/server
+type Server struct {
+	Logger    logging.LogrusConfig `json:"logger"`
+	Server    http.Config          `json:"server"`
+	Database  postgres.Config      `json:"db"`
+	FileStore filestore.Config     `json:"fileStore"`
+	Lifecycle lifecycle.Config     `json:"lifecycle"`
+	Matching  matching.Config      `json:"matching"`
+	Jobs      jobs.Config          `json:"jobs"`
+	Geocoding geocoding.Config     `json:"geocoding"`
+	Auth      auth.Config          `json:"auth"`
+	// Notifications is how the outbox sends emails, webhooks and texts, the SMTP server is set in server.emailSettings
+	Notifications notifications.Config `json:"notifications"`
+}
+
+// DefaultServer is what the config file and environment are applied over, settings the services default themselves are left 0
+func DefaultServer() Server {
+	var c Server
+	c.Logger.Level = "info"
+	c.Logger.Stdout = true
+	c.Server.Port = 8080
+	c.Server.Email.Template.DefaultLocale = "en"
+	c.Database.Host = "localhost"
+	c.Database.Port = 5432
+	c.Database.SSLMode = "require"
+	c.FileStore.Location = "./files"
+	return c
+}
+
+// Validate adds every setting the server can't start with, so they can all be fixed at once
+func (c *Server) Validate(problems *appconfig.Problems) {
+	problems.Required("db.host", c.Database.Host)
+	problems.Required("db.dbName", c.Database.Name)
+	problems.Required("db.username", c.Database.Username)
+	problems.Range("db.port", float64(c.Database.Port), 1, 65535)
+	problems.Required("fileStore.location", c.FileStore.Location)
+
+	if len(c.Auth.Secret) < auth.MinSecretLength {
+		problems.Add("auth.secret", "must be at least %d characters", auth.MinSecretLength)
+	}
+
+	if c.Server.Socket == "" {
+		problems.Range("server.port", float64(c.Server.Port), 1, 65535)
+	}
+	if (c.Server.TLS.CertFile == "") != (c.Server.TLS.KeyFile == "") {
+		problems.Add("server.tls", "needs both certFile and keyFile")
+	}
+	problems.Required("server.reports.secret", c.Server.Reports.Secret)
+	if c.Server.Verification.Secret != "" {
+		problems.Required("server.verification.linkBase", c.Server.Verification.LinkBase)
+	}
+
+	switch strings.ToLower(c.Logger.Level) {
+	case "", "debug", "info", "error":
+	default:
+		problems.Add("logger.level", "must be debug, info or error")
+	}
+
+	problems.Range("matching.threshold", c.Matching.Threshold, 0, 1)
+	if c.Lifecycle.WarnDaysBefore > 0 && c.Lifecycle.WarnDaysBefore >= c.Lifecycle.ExpireAfterDays {
+		problems.Add("lifecycle.warnDaysBefore", "must be less than expireAfterDays")
+	}
+
+	//in file order so the problems are listed the same way every time
+	for _, n := range []struct {
+		path  string
+		value int
+	}{
+		{"server.reports.autoHideAfter", c.Server.Reports.AutoHideAfter},
+		{"server.reports.perAddressPerHour", c.Server.Reports.PerAddressPerHour},
+		{"server.emailSettings.relay.perAddressPerHour", c.Server.Email.Relay.PerAddressPerHour},
+		{"server.emailSettings.relay.perRecordPerHour", c.Server.Email.Relay.PerRecordPerHour},
+		{"server.timeouts.readHeaderSeconds", c.Server.Timeouts.ReadHeaderSeconds},
+		{"server.timeouts.readSeconds", c.Server.Timeouts.ReadSeconds},
+		{"server.timeouts.writeSeconds", c.Server.Timeouts.WriteSeconds},
+		{"server.timeouts.idleSeconds", c.Server.Timeouts.IdleSeconds},
+		{"server.timeouts.shutdownSeconds", c.Server.Timeouts.ShutdownSeconds},
+		{"lifecycle.expireAfterDays", c.Lifecycle.ExpireAfterDays},
+		{"lifecycle.checkIntervalMinutes", c.Lifecycle.CheckIntervalMinutes},
+		{"lifecycle.verifyWithinHours", c.Lifecycle.VerifyWithinHours},
+		{"lifecycle.warnDaysBefore", c.Lifecycle.WarnDaysBefore},
+		{"matching.dateWindowDays", c.Matching.DateWindowDays},
+		{"jobs.workers", c.Jobs.Workers},
+		{"jobs.pollIntervalSeconds", c.Jobs.PollIntervalSeconds},
+		{"jobs.maxAttempts", c.Jobs.MaxAttempts},
+		{"jobs.backoffSeconds", c.Jobs.BackoffSeconds},
+		{"jobs.leaseSeconds", c.Jobs.LeaseSeconds},
+		{"auth.accessTokenMinutes", c.Auth.AccessTokenMinutes},
+		{"auth.refreshTokenDays", c.Auth.RefreshTokenDays},
+		{"notifications.pollIntervalSeconds", c.Notifications.PollIntervalSeconds},
+		{"notifications.maxAttempts", c.Notifications.MaxAttempts},
+		{"notifications.backoffSeconds", c.Notifications.BackoffSeconds},
+		{"notifications.leaseSeconds", c.Notifications.LeaseSeconds},
+		{"notifications.perRecipientPerHour", c.Notifications.PerRecipientPerHour},
+		{"server.emailSettings.timeoutSeconds", c.Server.Email.TimeoutSeconds},
+		{"notifications.webhook.timeoutSeconds", c.Notifications.Webhook.TimeoutSeconds},
+		{"notifications.sms.timeoutSeconds", c.Notifications.SMS.TimeoutSeconds},
+	} {
+		if n.value < 0 {
+			problems.Add(n.path, "can't be negative")
+		}
+	}
+	if c.Matching.MaxDistanceKm < 0 {
+		problems.Add("matching.maxDistanceKm", "can't be negative")
+	}
+}
@@ -1,23 +1,30 @@
 package main
 
 import (
-	"encoding/json"
+	"bufio"
+	"context"
 	"flag"
 	"fmt"
+	"lostpets"
+	"lostpets/cmd/internal/settings"
+	"lostpets/internal/auth"
+	appconfig "lostpets/internal/config"
 	filestore "lostpets/internal/data/file-store"
 	"lostpets/internal/data/postgres"
+	"lostpets/internal/geocoding"
 	"lostpets/internal/http"
+	"lostpets/internal/jobs"
+	"lostpets/internal/lifecycle"
 	"lostpets/internal/logging"
+	"lostpets/internal/matching"
+	"lostpets/internal/notifications"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 )
 
-type config struct {
-	Logger    logging.LogrusConfig
-	Server    http.Config      `json:"server"`
-	Database  postgres.Config  `json:"db"`
-	FileStore filestore.Config `json:"fileStore"`
-}
-
 var (
 	version   string
 	timestamp string
@@ -25,7 +32,14 @@ var (
 
 func main() {
 	printVersion := flag.Bool("version", false, "print version and exit")
-	configFileName := flag.String("c", "", "configuration file to use")
+	configFileName := flag.String("c", "", "configuration file to use, settings can also be set with LOSTPETS_* environment variables")
+	var sets appconfig.Sets
+	flag.Var(&sets, "set", "path=value to override a setting, e.g. -set server.port=8081, can be repeated")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration with secrets redacted and exit")
+	rematch := flag.Bool("rematch", false, "re-run matching for every open posting and exit")
+	backfill := flag.Bool("geocode", false, "geocode the postings and sightings without coordinates and exit")
+	addUser := flag.String("add-user", "", "add an account with this email, reading the password from stdin, and exit")
+	role := flag.String("role", string(lostpets.RoleAdmin), "role of the account added with -add-user")
 	flag.Parse()
 
 	if *printVersion {
@@ -33,13 +47,25 @@ func main() {
 		os.Exit(0)
 	}
 
-	var config config
-	err := config.load(*configFileName)
+	config := settings.DefaultServer()
+	problems := appconfig.Load(&config, appconfig.Sources{File: *configFileName, Env: os.Environ(), Known: settings.KnownEnv(), Sets: sets})
+	config.Validate(&problems)
 
-	if err != nil {
+	if *printConfig {
+		out, err := appconfig.Redacted(config)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println(string(out))
+	}
+	if err := problems.Err(); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	if *printConfig {
+		os.Exit(0)
+	}
 
 	log, err := logging.NewLogrusWrapper(config.Logger)
 	if err != nil {
@@ -59,16 +85,159 @@ func main() {
 		os.Exit(1)
 	}
 
-	http.StartServer(config.Server, db, db, fs, log, version)
+	geocoder, err := geocoding.NewGeocoder(config.Geocoding)
+	if err != nil {
+		fmt.Printf("Failed to load gazetteer: %s", err)
+		os.Exit(1)
+	}
+
+	if *backfill {
+		if geocoder == nil {
+			fmt.Println("No gazetteer configured, set geocoding.gazetteerPath")
+			os.Exit(1)
+		}
+		result, err := geocoding.Backfill(db, geocoder)
+		if err != nil {
+			fmt.Printf("Failed to geocode: %s", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Geocoded %d postings and %d sightings, %d locations not found. Run with -rematch to update matches\n", result.Postings, result.Sightings, result.Unknown)
+		os.Exit(0)
+	}
+
+	authService, err := auth.NewService(config.Auth, db)
+	if err != nil {
+		fmt.Printf("Failed to create auth service: %s", err)
+		os.Exit(1)
+	}
+
+	if *addUser != "" {
+		user, err := addAccount(authService, *addUser, lostpets.Role(*role))
+		if err != nil {
+			fmt.Printf("Failed to add user: %s", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Added %s account %d for %s\n", user.Role, user.ID, user.Email)
+		os.Exit(0)
+	}
+
+	matches := matching.NewService(db, matching.NewMatcher(config.Matching), log)
+
+	if *rematch {
+		err := rematchAll(db, matches)
+		if err != nil {
+			fmt.Printf("Failed to rematch: %s", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	//verification links work for as long as pending records are kept
+	config.Server.Verification.ValidFor = time.Duration(config.Lifecycle.VerifyWithinHours) * time.Hour
+	config.Server.Webhook = config.Notifications.Webhook
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	templates, err := notifications.NewRegistry(config.Server.Email.Template.Dir, config.Server.Email.Template.DefaultLocale, config.Server.Email.Template.Default)
+	if err != nil {
+		fmt.Printf("Failed to load email templates: %s", err)
+		os.Exit(1)
+	}
+
+	notifiers, err := notifications.Notifiers(config.Notifications, config.Server.Email.SMTPConfig)
+	if err != nil {
+		fmt.Printf("Failed to set up notifications: %s", err)
+		os.Exit(1)
+	}
+	sender := notifications.NewSender(config.Notifications, db, notifiers, log)
+	sender.Start()
+
+	pool := jobs.NewPool(config.Jobs, db, log)
+	deps := http.Deps{
+		Repo:          db,
+		Files:         db,
+		FileStore:     fs,
+		Jobs:          pool,
+		Geocoder:      geocoder,
+		Auth:          authService,
+		Moderation:    db,
+		Reports:       db,
+		Contacts:      db,
+		Messages:      db,
+		Notifications: db,
+		Outbox:        sender,
+		Matches:       matches,
+		Templates:     templates,
+		Logger:        log,
+	}
+	http.RegisterJobs(pool, config.Server, deps)
+	pool.Start()
+
+	expirer := lifecycle.NewExpirer(config.Lifecycle, db, pool, log)
+	go expirer.Run(ctx)
+
+	serveErr := http.StartServer(ctx, config.Server, deps, version)
+	if serveErr != nil {
+		fmt.Printf("Server stopped: %s\n", serveErr)
+	}
+	stop()
 
+	if err := shutdown(config.Server.Timeouts.Shutdown(), pool, sender, db); err != nil {
+		fmt.Printf("Failed to shut down cleanly: %s\n", err)
+		os.Exit(1)
+	}
+	if serveErr != nil {
+		os.Exit(1)
+	}
 }
 
-func (c *config) load(filename string) error {
-	file, err := os.Open(filename)
+// rematchAll runs matching for every open posting, matching from the postings side covers every open pair
+func rematchAll(repo lostpets.LostPetsRepo, matches lostpets.MatchService) error {
+	postings, err := repo.GetAllPostings(lostpets.FilterMap{
+		"status": {{Comparator: "=", Value: string(lostpets.StatusOpen)}},
+	})
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	err = json.NewDecoder(file).Decode(c)
-	return err
+
+	total := 0
+	for _, p := range postings {
+		found, err := matches.MatchPosting(p)
+		if err != nil {
+			return err
+		}
+		total += found
+	}
+
+	fmt.Printf("Matched %d postings, %d matches recorded\n", len(postings), total)
+	return nil
+}
+
+// addAccount bootstraps accounts from the command line, the password is read from stdin so it stays out of the shell history
+func addAccount(service lostpets.AuthService, email string, role lostpets.Role) (*lostpets.User, error) {
+	fmt.Print("Password: ")
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	return service.Register(email, "", strings.TrimRight(password, "\r\n"), role)
+}
+
+/*
+shutdown stops the background work once the server has stopped taking requests, running jobs and the email being sent
+are given the timeout to finish and anything left over is picked up again on the next start.
+Jobs are drained first as they can add to the outbox, the database is closed last.
+*/
+func shutdown(timeout time.Duration, pool *jobs.Pool, sender *notifications.Sender, db *postgres.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := pool.Shutdown(ctx); err != nil {
+		return fmt.Errorf("draining jobs: %w", err)
+	}
+	if err := sender.Shutdown(ctx); err != nil {
+		return fmt.Errorf("draining notifications: %w", err)
+	}
+	return db.Close()
 }
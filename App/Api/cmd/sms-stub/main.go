@@ -0,0 +1,61 @@
+/*
+sms-stub stands in for an SMS gateway during development, it prints the messages posted to it
+instead of sending them. Point notifications.sms.url at it:
+
+	go run ./cmd/sms-stub -addr :9090 -key dev-key
+*/
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+)
+
+type message struct {
+	To   string `json:"to"`
+	From string `json:"from"`
+	Text string `json:"text"`
+}
+
+func main() {
+	addr := flag.String("addr", ":9090", "address to listen on")
+	key := flag.String("key", "", "api key the requests must carry as a bearer token, any when empty")
+	flag.Parse()
+
+	var mu sync.Mutex
+	sent := 0
+
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if *key != "" && r.Header.Get("Authorization") != "Bearer "+*key {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var m message
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil || m.To == "" {
+			http.Error(w, "expected {\"to\", \"from\", \"text\"}", http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		sent++
+		id := sent
+		mu.Unlock()
+
+		log.Printf("sms %d from %q to %s: %s", id, m.From, m.To, m.Text)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, `{"id": %d}`, id)
+	})
+
+	log.Printf("sms stub listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
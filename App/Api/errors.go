@@ -0,0 +1,60 @@
+package lostpets
+
+import "fmt"
+
+// ErrorKind is what went wrong in terms a client can act on, the api maps each kind to a status code
+type ErrorKind string
+
+const (
+	KindNotFound     ErrorKind = "not-found"
+	KindInvalidInput ErrorKind = "invalid-input"
+	KindConflict     ErrorKind = "conflict"
+	KindForbidden    ErrorKind = "forbidden"
+)
+
+/*
+Error is an error caused by the request rather than the server. Message is safe to show to clients,
+Err is the underlying cause and is only logged.
+*/
+type Error struct {
+	Kind    ErrorKind
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// NotFound is returned when the record or file asked for doesn't exist
+func NotFound(format string, args ...interface{}) *Error {
+	return &Error{Kind: KindNotFound, Message: fmt.Sprintf(format, args...)}
+}
+
+// InvalidInput is returned when the request has a value the repo can't store or search with
+func InvalidInput(format string, args ...interface{}) *Error {
+	return &Error{Kind: KindInvalidInput, Message: fmt.Sprintf(format, args...)}
+}
+
+// Conflict is returned when the change can't be made in the record's current state
+func Conflict(format string, args ...interface{}) *Error {
+	return &Error{Kind: KindConflict, Message: fmt.Sprintf(format, args...)}
+}
+
+// Forbidden is returned when the caller isn't allowed to make the change
+func Forbidden(format string, args ...interface{}) *Error {
+	return &Error{Kind: KindForbidden, Message: fmt.Sprintf(format, args...)}
+}
+
+// Wrap keeps the cause of the error for the logs
+func (e *Error) Wrap(err error) *Error {
+	e.Err = err
+	return e
+}
@@ -0,0 +1,259 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	domain "lostpets"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type (
+	Config struct {
+		Secret             string `json:"secret" secret:"true"` // signs the access tokens, keep it out of source control
+		Issuer             string `json:"issuer"`               // iss claim of the access tokens
+		AccessTokenMinutes int    `json:"accessTokenMinutes"`   // how long an access token can be used
+		RefreshTokenDays   int    `json:"refreshTokenDays"`     // how long a refresh token can be used to get new tokens
+	}
+
+	// Service issues and checks the JWT access tokens and the refresh tokens stored in the repo
+	Service struct {
+		repo       domain.UserRepo
+		secret     []byte
+		issuer     string
+		accessTTL  time.Duration
+		refreshTTL time.Duration
+		cost       int
+		now        func() time.Time
+		// compared against when an email isn't registered, so a missing account takes as long as a wrong password
+		dummyHash []byte
+	}
+
+	claims struct {
+		jwt.StandardClaims
+		Email string      `json:"email"`
+		Role  domain.Role `json:"role"`
+	}
+
+	// InputError is returned when an account can't be registered with the given details
+	InputError struct {
+		Field  string
+		Reason string
+	}
+)
+
+const (
+	defaultIssuer        = "lostpets"
+	defaultAccessMinutes = 15
+	defaultRefreshDays   = 30
+	MinSecretLength      = 32 // shortest secret the access tokens can be signed with
+	minPasswordLength    = 8
+	maxPasswordLength    = 72 // bcrypt ignores anything longer
+	refreshTokenBytes    = 32
+)
+
+var errNoSecret = fmt.Errorf("auth: secret must be at least %d characters", MinSecretLength)
+
+func (e *InputError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Reason)
+}
+
+func NewService(config Config, repo domain.UserRepo) (*Service, error) {
+	if len(config.Secret) < MinSecretLength {
+		return nil, errNoSecret
+	}
+
+	service := &Service{
+		repo:       repo,
+		secret:     []byte(config.Secret),
+		issuer:     config.Issuer,
+		accessTTL:  time.Duration(config.AccessTokenMinutes) * time.Minute,
+		refreshTTL: time.Duration(config.RefreshTokenDays) * 24 * time.Hour,
+		cost:       bcrypt.DefaultCost,
+		now:        time.Now,
+	}
+
+	if service.issuer == "" {
+		service.issuer = defaultIssuer
+	}
+	if service.accessTTL <= 0 {
+		service.accessTTL = defaultAccessMinutes * time.Minute
+	}
+	if service.refreshTTL <= 0 {
+		service.refreshTTL = defaultRefreshDays * 24 * time.Hour
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("not a real password"), service.cost)
+	if err != nil {
+		return nil, err
+	}
+	service.dummyHash = hash
+
+	return service, nil
+}
+
+// Register adds an account, the email is stored lower case so it can be used to log in however it is typed
+func (s *Service) Register(email, name, password string, role domain.Role) (*domain.User, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if _, err := mail.ParseAddress(email); err != nil {
+		return nil, &InputError{Field: "email", Reason: "not an email address"}
+	}
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return nil, &InputError{Field: "password", Reason: fmt.Sprintf("must be %d to %d characters", minPasswordLength, maxPasswordLength)}
+	}
+	if !role.Valid() {
+		return nil, &InputError{Field: "role", Reason: "unknown role"}
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), s.cost)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &domain.User{
+		Email:        email,
+		Name:         strings.TrimSpace(name),
+		PasswordHash: string(hash),
+		Role:         role,
+	}
+	if err := s.repo.AddUser(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *Service) Login(email, password string) (*domain.Tokens, error) {
+	user, err := s.repo.GetUserByEmail(strings.ToLower(strings.TrimSpace(email)))
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		bcrypt.CompareHashAndPassword(s.dummyHash, []byte(password))
+		return nil, domain.ErrInvalidCredentials
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return nil, domain.ErrInvalidCredentials
+	}
+
+	return s.issue(user)
+}
+
+func (s *Service) Refresh(refreshToken string) (*domain.Tokens, error) {
+	token, err := s.useRefreshToken(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.repo.GetUserByID(token.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, domain.ErrInvalidCredentials
+	}
+
+	return s.issue(user)
+}
+
+func (s *Service) Logout(refreshToken string) error {
+	_, err := s.useRefreshToken(refreshToken)
+	return err
+}
+
+func (s *Service) Authenticate(accessToken string) (*domain.Principal, error) {
+	parsed := &claims{}
+	_, err := jwt.ParseWithClaims(accessToken, parsed, func(t *jwt.Token) (interface{}, error) {
+		// only accept the algorithm the tokens are signed with, never 'none' or a public key algorithm
+		if t.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return s.secret, nil
+	})
+	if err != nil {
+		return nil, domain.ErrInvalidCredentials
+	}
+
+	if !parsed.VerifyIssuer(s.issuer, true) || !parsed.VerifyExpiresAt(s.now().Unix(), true) || !parsed.Role.Valid() {
+		return nil, domain.ErrInvalidCredentials
+	}
+
+	id, err := strconv.Atoi(parsed.Subject)
+	if err != nil {
+		return nil, domain.ErrInvalidCredentials
+	}
+
+	return &domain.Principal{UserID: id, Email: parsed.Email, Role: parsed.Role}, nil
+}
+
+// issue creates a signed access token and stores a new refresh token for the user
+func (s *Service) issue(user *domain.User) (*domain.Tokens, error) {
+	now := s.now()
+
+	access := jwt.NewWithClaims(jwt.SigningMethodHS256, claims{
+		StandardClaims: jwt.StandardClaims{
+			Subject:   strconv.Itoa(user.ID),
+			Issuer:    s.issuer,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(s.accessTTL).Unix(),
+		},
+		Email: user.Email,
+		Role:  user.Role,
+	})
+	signed, err := access.SignedString(s.secret)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := make([]byte, refreshTokenBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	refresh := base64.RawURLEncoding.EncodeToString(raw)
+
+	err = s.repo.AddRefreshToken(&domain.RefreshToken{
+		UserID:    user.ID,
+		TokenHash: hashToken(refresh),
+		ExpiresOn: now.Add(s.refreshTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.Tokens{
+		AccessToken:  signed,
+		RefreshToken: refresh,
+		ExpiresIn:    int(s.accessTTL.Seconds()),
+	}, nil
+}
+
+// useRefreshToken checks the refresh token and revokes it so it can only be used once
+func (s *Service) useRefreshToken(refreshToken string) (*domain.RefreshToken, error) {
+	token, err := s.repo.GetRefreshToken(hashToken(refreshToken))
+	if err != nil {
+		return nil, err
+	}
+	if token == nil || token.RevokedOn != nil || !s.now().Before(token.ExpiresOn) {
+		return nil, domain.ErrInvalidCredentials
+	}
+
+	// the revoke only succeeds once, so two requests racing with the same token can't both use it
+	if err := s.repo.RevokeRefreshToken(token.ID); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// refresh tokens are random so a fast hash is enough, the database never sees the token itself
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,285 @@
+package auth
+
+import (
+	"lostpets"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fakeRepo keeps users and refresh tokens in memory
+type fakeRepo struct {
+	users  []lostpets.User
+	tokens []lostpets.RefreshToken
+}
+
+func (r *fakeRepo) AddUser(user *lostpets.User) error {
+	for _, u := range r.users {
+		if u.Email == user.Email {
+			return lostpets.ErrEmailTaken
+		}
+	}
+	user.ID = len(r.users) + 1
+	r.users = append(r.users, *user)
+	return nil
+}
+
+func (r *fakeRepo) GetUserByID(id int) (*lostpets.User, error) {
+	for _, u := range r.users {
+		if u.ID == id {
+			return &u, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeRepo) GetUserByEmail(email string) (*lostpets.User, error) {
+	for _, u := range r.users {
+		if u.Email == email {
+			return &u, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeRepo) AddRefreshToken(token *lostpets.RefreshToken) error {
+	token.ID = len(r.tokens) + 1
+	r.tokens = append(r.tokens, *token)
+	return nil
+}
+
+func (r *fakeRepo) GetRefreshToken(tokenHash string) (*lostpets.RefreshToken, error) {
+	for _, t := range r.tokens {
+		if t.TokenHash == tokenHash {
+			return &t, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeRepo) RevokeRefreshToken(id int) error {
+	t := &r.tokens[id-1]
+	if t.RevokedOn != nil {
+		return lostpets.ErrInvalidCredentials
+	}
+	now := time.Now()
+	t.RevokedOn = &now
+	return nil
+}
+
+const testSecret = "a test secret that is long enough"
+
+func newTestService(t *testing.T) *Service {
+	service, err := NewService(Config{Secret: testSecret}, &fakeRepo{})
+	require.NoError(t, err)
+	service.cost = bcrypt.MinCost
+	return service
+}
+
+func TestNewServiceNeedsSecret(t *testing.T) {
+	_, err := NewService(Config{Secret: "short"}, &fakeRepo{})
+	assert.Equal(t, errNoSecret, err)
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	service := newTestService(t)
+
+	user, err := service.Register(" Staff@Shelter.org ", "Sam", "correct horse", lostpets.RoleStaff)
+	require.NoError(t, err)
+	assert.Equal(t, "staff@shelter.org", user.Email)
+	assert.NotEqual(t, "correct horse", user.PasswordHash)
+
+	_, err = service.Register("staff@shelter.org", "Sam", "correct horse", lostpets.RoleStaff)
+	assert.ErrorIs(t, err, lostpets.ErrEmailTaken)
+
+	_, err = service.Login("staff@shelter.org", "wrong horse")
+	assert.ErrorIs(t, err, lostpets.ErrInvalidCredentials)
+
+	_, err = service.Login("nobody@shelter.org", "correct horse")
+	assert.ErrorIs(t, err, lostpets.ErrInvalidCredentials)
+
+	tokens, err := service.Login("STAFF@shelter.org", "correct horse")
+	require.NoError(t, err)
+	assert.Equal(t, defaultAccessMinutes*60, tokens.ExpiresIn)
+
+	principal, err := service.Authenticate(tokens.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, &lostpets.Principal{UserID: user.ID, Email: "staff@shelter.org", Role: lostpets.RoleStaff}, principal)
+}
+
+func TestRegisterRejectsBadInput(t *testing.T) {
+	service := newTestService(t)
+
+	_, err := service.Register("not an email", "", "correct horse", lostpets.RoleReporter)
+	assert.Equal(t, &InputError{Field: "email", Reason: "not an email address"}, err)
+
+	_, err = service.Register("a@b.org", "", "short", lostpets.RoleReporter)
+	assert.Equal(t, &InputError{Field: "password", Reason: "must be 8 to 72 characters"}, err)
+
+	_, err = service.Register("a@b.org", "", "correct horse", lostpets.Role("owner"))
+	assert.Equal(t, &InputError{Field: "role", Reason: "unknown role"}, err)
+}
+
+func TestRefreshTokensAreSingleUse(t *testing.T) {
+	service := newTestService(t)
+	_, err := service.Register("a@b.org", "", "correct horse", lostpets.RoleReporter)
+	require.NoError(t, err)
+
+	tokens, err := service.Login("a@b.org", "correct horse")
+	require.NoError(t, err)
+
+	refreshed, err := service.Refresh(tokens.RefreshToken)
+	require.NoError(t, err)
+	assert.NotEqual(t, tokens.RefreshToken, refreshed.RefreshToken)
+
+	_, err = service.Refresh(tokens.RefreshToken)
+	assert.ErrorIs(t, err, lostpets.ErrInvalidCredentials)
+
+	assert.NoError(t, service.Logout(refreshed.RefreshToken))
+	_, err = service.Refresh(refreshed.RefreshToken)
+	assert.ErrorIs(t, err, lostpets.ErrInvalidCredentials)
+}
+
+func TestWrongPasswordIssuesNoTokens(t *testing.T) {
+	service := newTestService(t)
+	repo := service.repo.(*fakeRepo)
+	_, err := service.Register("a@b.org", "", "correct horse", lostpets.RoleReporter)
+	require.NoError(t, err)
+
+	tokens, err := service.Login("a@b.org", "Correct horse")
+	assert.ErrorIs(t, err, lostpets.ErrInvalidCredentials)
+	assert.Nil(t, tokens)
+	assert.Empty(t, repo.tokens)
+}
+
+func TestRefreshTokenIsStoredHashed(t *testing.T) {
+	service := newTestService(t)
+	repo := service.repo.(*fakeRepo)
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	service.now = func() time.Time { return now }
+	_, err := service.Register("a@b.org", "", "correct horse", lostpets.RoleReporter)
+	require.NoError(t, err)
+
+	tokens, err := service.Login("a@b.org", "correct horse")
+	require.NoError(t, err)
+	require.Len(t, repo.tokens, 1)
+	assert.Equal(t, hashToken(tokens.RefreshToken), repo.tokens[0].TokenHash)
+	assert.NotContains(t, repo.tokens[0].TokenHash, tokens.RefreshToken)
+	assert.Equal(t, now.Add(defaultRefreshDays*24*time.Hour), repo.tokens[0].ExpiresOn)
+}
+
+func TestRefreshTokenExpires(t *testing.T) {
+	service := newTestService(t)
+	_, err := service.Register("a@b.org", "", "correct horse", lostpets.RoleReporter)
+	require.NoError(t, err)
+	tokens, err := service.Login("a@b.org", "correct horse")
+	require.NoError(t, err)
+
+	// the token stops working the moment it expires
+	service.now = func() time.Time { return time.Now().Add(defaultRefreshDays * 24 * time.Hour) }
+	_, err = service.Refresh(tokens.RefreshToken)
+	assert.ErrorIs(t, err, lostpets.ErrInvalidCredentials)
+	assert.ErrorIs(t, service.Logout(tokens.RefreshToken), lostpets.ErrInvalidCredentials)
+	assert.Nil(t, service.repo.(*fakeRepo).tokens[0].RevokedOn, "an expired token isn't revoked")
+
+	service.now = func() time.Time { return time.Now().Add(defaultRefreshDays*24*time.Hour - time.Hour) }
+	_, err = service.Refresh(tokens.RefreshToken)
+	assert.NoError(t, err)
+}
+
+// staleRepo returns refresh tokens as they were before being revoked, as a request racing another with the same token would see them
+type staleRepo struct {
+	*fakeRepo
+}
+
+func (r staleRepo) GetRefreshToken(tokenHash string) (*lostpets.RefreshToken, error) {
+	token, err := r.fakeRepo.GetRefreshToken(tokenHash)
+	if token != nil {
+		token.RevokedOn = nil
+	}
+	return token, err
+}
+
+func TestRefreshTokenRaceOnlyWinsOnce(t *testing.T) {
+	service := newTestService(t)
+	repo := &fakeRepo{}
+	service.repo = staleRepo{repo}
+	_, err := service.Register("a@b.org", "", "correct horse", lostpets.RoleReporter)
+	require.NoError(t, err)
+	tokens, err := service.Login("a@b.org", "correct horse")
+	require.NoError(t, err)
+
+	_, err = service.Refresh(tokens.RefreshToken)
+	require.NoError(t, err)
+	_, err = service.Refresh(tokens.RefreshToken)
+	assert.ErrorIs(t, err, lostpets.ErrInvalidCredentials, "the revoke decides, not the read")
+	assert.Len(t, repo.tokens, 2, "only the first refresh issued a new token")
+}
+
+func TestLogout(t *testing.T) {
+	service := newTestService(t)
+	repo := service.repo.(*fakeRepo)
+	_, err := service.Register("a@b.org", "", "correct horse", lostpets.RoleReporter)
+	require.NoError(t, err)
+	tokens, err := service.Login("a@b.org", "correct horse")
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, service.Logout("not a token"), lostpets.ErrInvalidCredentials)
+	assert.NoError(t, service.Logout(tokens.RefreshToken))
+	assert.NotNil(t, repo.tokens[0].RevokedOn)
+	assert.ErrorIs(t, service.Logout(tokens.RefreshToken), lostpets.ErrInvalidCredentials)
+}
+
+func TestRefreshForRemovedUser(t *testing.T) {
+	service := newTestService(t)
+	repo := service.repo.(*fakeRepo)
+	_, err := service.Register("a@b.org", "", "correct horse", lostpets.RoleReporter)
+	require.NoError(t, err)
+	tokens, err := service.Login("a@b.org", "correct horse")
+	require.NoError(t, err)
+
+	repo.users = nil
+	_, err = service.Refresh(tokens.RefreshToken)
+	assert.ErrorIs(t, err, lostpets.ErrInvalidCredentials)
+}
+
+func TestAuthenticateRejectsBadTokens(t *testing.T) {
+	service := newTestService(t)
+	_, err := service.Register("a@b.org", "", "correct horse", lostpets.RoleAdmin)
+	require.NoError(t, err)
+
+	// issued long enough ago that the access token has expired
+	service.now = func() time.Time { return time.Now().Add(-time.Hour) }
+	expired, err := service.Login("a@b.org", "correct horse")
+	require.NoError(t, err)
+	service.now = time.Now
+
+	_, err = service.Authenticate(expired.AccessToken)
+	assert.ErrorIs(t, err, lostpets.ErrInvalidCredentials)
+
+	other, err := NewService(Config{Secret: "some other secret that is long enough"}, &fakeRepo{users: []lostpets.User{{ID: 1, Role: lostpets.RoleAdmin}}})
+	require.NoError(t, err)
+	forged, err := other.issue(&lostpets.User{ID: 1, Role: lostpets.RoleAdmin})
+	require.NoError(t, err)
+	_, err = service.Authenticate(forged.AccessToken)
+	assert.ErrorIs(t, err, lostpets.ErrInvalidCredentials)
+
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims{
+		StandardClaims: jwt.StandardClaims{Subject: "1", Issuer: defaultIssuer, ExpiresAt: time.Now().Add(time.Hour).Unix()},
+		Role:           lostpets.RoleAdmin,
+	}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+	_, err = service.Authenticate(unsigned)
+	assert.ErrorIs(t, err, lostpets.ErrInvalidCredentials)
+}
+
+func TestRoleAtLeast(t *testing.T) {
+	assert.True(t, lostpets.RoleAdmin.AtLeast(lostpets.RoleStaff))
+	assert.True(t, lostpets.RoleStaff.AtLeast(lostpets.RoleStaff))
+	assert.False(t, lostpets.RoleReporter.AtLeast(lostpets.RoleStaff))
+	assert.False(t, lostpets.Role("").AtLeast(lostpets.RoleReporter))
+}
@@ -0,0 +1,330 @@
+/*
+Package config loads the json config of the commands in layers: the defaults the command starts with,
+the config file, LOSTPETS_* environment variables and then -set flags, each overriding the one before.
+
+Settings are named by their json path, db.password is LOSTPETS_DB_PASSWORD in the environment and
+-set db.password=... on the command line. Fields tagged `secret:"true"` are redacted when printed.
+*/
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type (
+	// Sources are where settings are read from, in the order they are applied
+	Sources struct {
+		File string
+		// Env is the environment as KEY=value, usually os.Environ()
+		Env []string
+		// Known are the LOSTPETS_* variables other commands read, any other variable the config doesn't have is a problem
+		Known []string
+		Sets  Sets
+	}
+
+	// Sets are the path=value settings from repeated -set flags
+	Sets []string
+
+	// Problems is every invalid or missing setting, they are reported together so they can be fixed in one go
+	Problems []string
+
+	// setting is a field of the config that can be set from the environment or a flag
+	setting struct {
+		path   string
+		env    string
+		secret bool
+		value  reflect.Value
+	}
+)
+
+const (
+	EnvPrefix = "LOSTPETS_"
+	redacted  = "********"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func (s *Sets) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *Sets) Set(value string) error {
+	if !strings.Contains(value, "=") {
+		return fmt.Errorf("%q must be path=value", value)
+	}
+	*s = append(*s, value)
+	return nil
+}
+
+func (p *Problems) Add(path string, format string, args ...interface{}) {
+	*p = append(*p, path+": "+fmt.Sprintf(format, args...))
+}
+
+// Required adds a problem if the value is empty
+func (p *Problems) Required(path string, value string) {
+	if strings.TrimSpace(value) == "" {
+		p.Add(path, "is required")
+	}
+}
+
+// Range adds a problem if the value is outside min and max
+func (p *Problems) Range(path string, value float64, min float64, max float64) {
+	if value < min || value > max {
+		p.Add(path, "must be between %v and %v", min, max)
+	}
+}
+
+func (p Problems) Error() string {
+	return "invalid configuration:\n  " + strings.Join(p, "\n  ")
+}
+
+// Err is nil when there are no problems
+func (p Problems) Err() error {
+	if len(p) == 0 {
+		return nil
+	}
+	return p
+}
+
+/*
+Load applies the sources over the defaults already in cfg, a pointer to the command's config struct.
+Every value that can't be read is returned, the settings that could be are still applied.
+*/
+func Load(cfg interface{}, sources Sources) Problems {
+	problems := Problems{}
+
+	if sources.File != "" {
+		if err := loadFile(cfg, sources.File); err != nil {
+			problems.Add(sources.File, "%s", err)
+		}
+	}
+
+	settings := settingsOf(cfg)
+	byEnv := map[string]setting{}
+	for _, s := range settings {
+		byEnv[s.env] = s
+	}
+	known := map[string]bool{}
+	for _, name := range sources.Known {
+		known[name] = true
+	}
+
+	//sorted so problems are reported in the same order every time
+	env := append([]string{}, sources.Env...)
+	sort.Strings(env)
+	for _, kv := range env {
+		key, value, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(key, EnvPrefix) {
+			continue
+		}
+		//the commands share the environment, a variable for another command's settings isn't a problem
+		s, ok := byEnv[key]
+		if !ok {
+			if !known[key] {
+				problems.Add(key, "unknown setting")
+			}
+			continue
+		}
+		if err := set(s.value, value); err != nil {
+			problems.Add(key, "%s", err)
+		}
+	}
+
+	for _, kv := range sources.Sets {
+		path, value, _ := strings.Cut(kv, "=")
+		s, ok := find(settings, path)
+		if !ok {
+			problems.Add(path, "unknown setting")
+			continue
+		}
+		if err := set(s.value, value); err != nil {
+			problems.Add(path, "%s", err)
+		}
+	}
+	return problems
+}
+
+// Redacted is the config as indented json with the secrets that are set replaced, for -print-config
+func Redacted(cfg interface{}) ([]byte, error) {
+	raw, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	tree := map[string]interface{}{}
+	if err := json.Unmarshal(raw, &tree); err != nil {
+		return nil, err
+	}
+
+	for _, s := range settingsOf(cfg) {
+		if s.secret && !s.value.IsZero() {
+			redact(tree, strings.Split(s.path, "."))
+		}
+	}
+	return json.MarshalIndent(tree, "", "  ")
+}
+
+// EnvName is the environment variable for the setting at the json path
+func EnvName(path string) string {
+	parts := strings.Split(path, ".")
+	for i, p := range parts {
+		parts[i] = snake(p)
+	}
+	return EnvPrefix + strings.Join(parts, "_")
+}
+
+// EnvNames are the environment variables of every setting of cfg
+func EnvNames(cfg interface{}) []string {
+	names := []string{}
+	for _, s := range settingsOf(cfg) {
+		names = append(names, s.env)
+	}
+	return names
+}
+
+func loadFile(cfg interface{}, filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	//decoding over cfg keeps the defaults of the settings the file leaves out
+	return json.NewDecoder(bytes.NewReader(data)).Decode(cfg)
+}
+
+// settingsOf lists the settable fields of cfg by their json path, sections are walked and not settable themselves
+func settingsOf(cfg interface{}) []setting {
+	settings := []setting{}
+	var walk func(v reflect.Value, prefix string)
+	walk = func(v reflect.Value, prefix string) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+
+			value := v.Field(i)
+			//embedded structs without a name are inlined, the way encoding/json does
+			if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+				walk(value, prefix)
+				continue
+			}
+			if !field.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+
+			path := prefix + name
+			if field.Type.Kind() == reflect.Struct && field.Type != durationType {
+				walk(value, path+".")
+				continue
+			}
+			settings = append(settings, setting{path: path, env: EnvName(path), secret: field.Tag.Get("secret") == "true", value: value})
+		}
+	}
+	walk(reflect.Indirect(reflect.ValueOf(cfg)), "")
+	return settings
+}
+
+// find looks the setting up by path, ignoring case like the json file does
+func find(settings []setting, path string) (setting, bool) {
+	for _, s := range settings {
+		if strings.EqualFold(s.path, path) {
+			return s, true
+		}
+	}
+	return setting{}, false
+}
+
+// set parses the text into the field, lists are comma separated
+func set(v reflect.Value, text string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(text)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration", text)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(text)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", text)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(text, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number", text)
+		}
+		v.SetInt(i)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(text, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%q is not a number", text)
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("can only be set in the config file")
+		}
+		items := reflect.MakeSlice(v.Type(), 0, 0)
+		for _, item := range strings.Split(text, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = reflect.Append(items, reflect.ValueOf(item).Convert(v.Type().Elem()))
+			}
+		}
+		v.Set(items)
+	default:
+		return fmt.Errorf("can only be set in the config file")
+	}
+	return nil
+}
+
+func redact(tree map[string]interface{}, path []string) {
+	for i, key := range path {
+		if i == len(path)-1 {
+			if _, ok := tree[key]; ok {
+				tree[key] = redacted
+			}
+			return
+		}
+		next, ok := tree[key].(map[string]interface{})
+		if !ok {
+			return
+		}
+		tree = next
+	}
+}
+
+// snake turns a json name such as dbName or SMTPConfig into DB_NAME or SMTP_CONFIG
+func snake(name string) string {
+	runes := []rune(name)
+	out := []rune{}
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				out = append(out, '_')
+			}
+		}
+		out = append(out, unicode.ToUpper(r))
+	}
+	return string(out)
+}
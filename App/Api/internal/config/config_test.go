@@ -0,0 +1,188 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type (
+	testSMTP struct {
+		Host     string `json:"host"`
+		Password string `json:"password" secret:"true"`
+	}
+
+	testEmail struct {
+		testSMTP
+		LinkBase string `json:"linkBase"`
+	}
+
+	testConfig struct {
+		Logger struct {
+			Level string
+		}
+		DB struct {
+			Host     string `json:"host"`
+			Port     int    `json:"port"`
+			Name     string `json:"dbName"`
+			Password string `json:"password" secret:"true"`
+		} `json:"db"`
+		Email     testEmail     `json:"emailSettings"`
+		Threshold float64       `json:"threshold"`
+		Debug     bool          `json:"debug"`
+		Hosts     []string      `json:"allowedHosts"`
+		Wait      time.Duration `json:"wait"`
+		Computed  string        `json:"-"`
+	}
+)
+
+func writeFile(t *testing.T, content string) string {
+	filename := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(filename, []byte(content), 0600))
+	return filename
+}
+
+func TestEnvName(t *testing.T) {
+	assert.Equal(t, "LOSTPETS_DB_PASSWORD", EnvName("db.password"))
+	assert.Equal(t, "LOSTPETS_DB_DB_NAME", EnvName("db.dbName"))
+	assert.Equal(t, "LOSTPETS_SERVER_EMAIL_SETTINGS_RELAY_INBOUND_SECRET", EnvName("server.emailSettings.relay.inboundSecret"))
+	assert.Equal(t, "LOSTPETS_SMS_API_KEY", EnvName("sms.apiKey"))
+	assert.Equal(t, "LOSTPETS_SMTP_HOST", EnvName("SMTPHost"))
+	assert.Equal(t, "LOSTPETS_LOGGER_LEVEL", EnvName("Logger.Level"))
+}
+
+func TestEnvNames(t *testing.T) {
+	names := EnvNames(&testConfig{})
+	assert.Contains(t, names, "LOSTPETS_DB_DB_NAME")
+	assert.Contains(t, names, "LOSTPETS_EMAIL_SETTINGS_PASSWORD", "embedded structs are inlined")
+	assert.NotContains(t, names, "LOSTPETS_COMPUTED")
+	assert.Len(t, names, 12)
+}
+
+func TestLoadLayers(t *testing.T) {
+	cfg := testConfig{}
+	cfg.DB.Host = "localhost"
+	cfg.DB.Port = 5432
+	cfg.DB.Name = "default"
+	cfg.Logger.Level = "info"
+
+	file := writeFile(t, `{"db":{"dbName":"fromFile","password":"file"},"emailSettings":{"host":"smtp.file"}}`)
+	problems := Load(&cfg, Sources{
+		File: file,
+		Env: []string{
+			"LOSTPETS_DB_PASSWORD=env",
+			"LOSTPETS_EMAIL_SETTINGS_HOST=smtp.env",
+			"LOSTPETS_DB_PORT=6432",
+			"HOME=/root",
+		},
+		Sets: Sets{"db.port=7432", "Logger.level=debug"},
+	})
+
+	assert.Empty(t, problems)
+	assert.Equal(t, "localhost", cfg.DB.Host, "defaults are kept when nothing sets them")
+	assert.Equal(t, "fromFile", cfg.DB.Name, "the file is applied over the defaults")
+	assert.Equal(t, "env", cfg.DB.Password, "the environment is applied over the file")
+	assert.Equal(t, "smtp.env", cfg.Email.Host, "embedded structs are inlined")
+	assert.Equal(t, 7432, cfg.DB.Port, "flags are applied over the environment")
+	assert.Equal(t, "debug", cfg.Logger.Level, "flag paths ignore case")
+}
+
+func TestLoadKinds(t *testing.T) {
+	cfg := testConfig{}
+	problems := Load(&cfg, Sources{Sets: Sets{
+		"threshold=0.75",
+		"debug=true",
+		"allowedHosts=a.example, b.example,",
+		"wait=90s",
+	}})
+
+	assert.Empty(t, problems)
+	assert.Equal(t, 0.75, cfg.Threshold)
+	assert.True(t, cfg.Debug)
+	assert.Equal(t, []string{"a.example", "b.example"}, cfg.Hosts)
+	assert.Equal(t, 90*time.Second, cfg.Wait)
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	cfg := testConfig{}
+	problems := Load(&cfg, Sources{
+		Env: []string{
+			"LOSTPETS_DB_PORT=five",
+			"LOSTPETS_DEBUG=maybe",
+			"LOSTPETS_NOT_A_SETTING=1",
+			"LOSTPETS_DB_MIGRATION_PATH=./migrations",
+		},
+		Known: []string{"LOSTPETS_DB_MIGRATION_PATH"},
+		Sets:  Sets{"threshold=high", "db.nope=1", "Computed=x", "db.host=set"},
+	})
+
+	assert.Equal(t, Problems{
+		`LOSTPETS_DB_PORT: "five" is not a whole number`,
+		`LOSTPETS_DEBUG: "maybe" is not true or false`,
+		"LOSTPETS_NOT_A_SETTING: unknown setting",
+		`threshold: "high" is not a number`,
+		"db.nope: unknown setting",
+		"Computed: unknown setting",
+	}, problems)
+	assert.Equal(t, "set", cfg.DB.Host, "the settings that can be read are still applied")
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	cfg := testConfig{}
+	problems := Load(&cfg, Sources{File: filepath.Join(t.TempDir(), "missing.json")})
+
+	require.Len(t, problems, 1)
+	assert.Contains(t, problems[0], "missing.json")
+}
+
+func TestProblems(t *testing.T) {
+	problems := Problems{}
+	assert.NoError(t, problems.Err())
+
+	problems.Required("db.host", " ")
+	problems.Required("db.dbName", "lostPets")
+	problems.Range("matching.threshold", 1.5, 0, 1)
+
+	assert.EqualError(t, problems.Err(), "invalid configuration:\n  db.host: is required\n  matching.threshold: must be between 0 and 1")
+}
+
+func TestSets(t *testing.T) {
+	sets := Sets{}
+	assert.NoError(t, sets.Set("db.host=localhost"))
+	assert.NoError(t, sets.Set("db.password=a=b"))
+	assert.Error(t, sets.Set("db.host"))
+	assert.Equal(t, "db.host=localhost,db.password=a=b", sets.String())
+
+	cfg := testConfig{}
+	assert.Empty(t, Load(&cfg, Sources{Sets: sets}))
+	assert.Equal(t, "a=b", cfg.DB.Password, "only the first = splits the path from the value")
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := testConfig{}
+	cfg.DB.Host = "localhost"
+	cfg.DB.Password = "hunter2"
+	cfg.Computed = "hidden"
+
+	out, err := Redacted(cfg)
+	require.NoError(t, err)
+	assert.NotContains(t, string(out), "hunter2")
+	assert.NotContains(t, string(out), "hidden")
+
+	printed := struct {
+		DB struct {
+			Host     string `json:"host"`
+			Password string `json:"password"`
+		} `json:"db"`
+		Email map[string]interface{} `json:"emailSettings"`
+	}{}
+	require.NoError(t, json.Unmarshal(out, &printed))
+	assert.Equal(t, "localhost", printed.DB.Host)
+	assert.Equal(t, "********", printed.DB.Password)
+	assert.Equal(t, "", printed.Email["password"], "secrets that aren't set are shown empty")
+}
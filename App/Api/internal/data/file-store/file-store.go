@@ -3,6 +3,7 @@ package filestore
 import (
 	"fmt"
 	"io"
+	domain "lostpets"
 	"lostpets/internal"
 	"mime/multipart"
 	"os"
@@ -37,7 +38,7 @@ func NewFileStore(config Config) (*FileStore, error) {
 func (fs *FileStore) GetFile(guid string) (string, error) {
 	filepath := path.Join(fs.FilePath, guid)
 	if _, err := os.Stat(filepath); os.IsNotExist(err) {
-		return "", fmt.Errorf("file: %s Not Found", guid)
+		return "", domain.NotFound("file %s not found", guid).Wrap(err)
 	}
 	return filepath, nil
 }
@@ -60,13 +61,13 @@ func (fs *FileStore) SaveFile(src multipart.File) (string, error) {
 func (fs *FileStore) DeleteFile(guid string) error {
 	filepath := path.Join(fs.FilePath, guid)
 	if _, err := os.Stat(filepath); os.IsNotExist(err) {
-		return fmt.Errorf("file: %s Not Found", guid)
+		return domain.NotFound("file %s not found", guid).Wrap(err)
 	}
 
 	err := os.Remove(filepath)
 
 	if err != nil {
-		return fmt.Errorf("error deleteing file: %s %e", guid, err)
+		return fmt.Errorf("error deleting file: %s %w", guid, err)
 	}
 
 	return nil
@@ -3,32 +3,88 @@ package postgres
 import (
 	"errors"
 	"fmt"
+	"math"
+	"reflect"
 	"strconv"
 	"strings"
 
 	domain "lostpets"
 )
 
-var errorEmptyIn = errors.New("A filter with IN operation has no value")
+var errorEmptyIn = domain.InvalidInput("A filter with IN operation has no value")
+
+// comparators that can be used in a filter, anything else is rejected before it gets near the sql
+var filterComparators = []string{"=", "!=", "<", "<=", ">", ">=", "in", "like", "is null", domain.ComparatorWithin}
+
+/*
+distance from the radius point to the table's coordinates in km (haversine), the record's accuracy is taken off
+so a record matches if the area it could be in overlaps the search radius. %[1]s is the table and %[2]s the param prefix.
+
+The haversine can't use an index, so it is only run on the records in a box around the radius. A record whose accuracy
+is at most the radius is within twice the radius, the box covers that, the few vaguer ones are found by the accuracy index.
+*/
+const withinTemplate = `(%[1]s.accuracy_km > :%[2]skm OR (
+%[1]s.latitude BETWEEN :%[2]sminlat AND :%[2]smaxlat AND %[1]s.longitude BETWEEN :%[2]sminlng AND :%[2]smaxlng
+)) AND (2 * 6371 * asin(sqrt(
+power(sin(radians(%[1]s.latitude - :%[2]slat) / 2), 2) +
+cos(radians(:%[2]slat)) * cos(radians(%[1]s.latitude)) * power(sin(radians(%[1]s.longitude - :%[2]slng) / 2), 2)
+)) - COALESCE(%[1]s.accuracy_km, 0)) <= :%[2]skm`
+
+const kmPerDegree = 6371 * math.Pi / 180
+
+// boundingBox is the latitude and longitude range within km of the point, the whole of either range when it crosses a pole or the antimeridian
+func boundingBox(r domain.Radius, km float64) (minLat, maxLat, minLng, maxLng float64) {
+	latDelta := km / kmPerDegree
+	minLat, maxLat = r.Lat-latDelta, r.Lat+latDelta
+	if minLat <= -90 || maxLat >= 90 {
+		return math.Max(minLat, -90), math.Min(maxLat, 90), -180, 180
+	}
+
+	//a degree of longitude is shortest at the edge of the box nearest the pole
+	lngDelta := latDelta / math.Cos(math.Max(math.Abs(minLat), math.Abs(maxLat))*math.Pi/180)
+	minLng, maxLng = r.Lng-lngDelta, r.Lng+lngDelta
+	if minLng < -180 || maxLng > 180 {
+		return minLat, maxLat, -180, 180
+	}
+	return minLat, maxLat, minLng, maxLng
+}
 
 func getFilters(fieldMap map[string]string, filterMap domain.FilterMap) (string, map[string]interface{}, error) {
 	filterArr := make([]string, 0)
 	params := make(map[string]interface{}, 0)
 
 	for key, filters := range filterMap {
+		//only mapped fields can be filtered on, the key is never used in the query
+		dbField, ok := fieldMap[strings.ToLower(key)]
+		if !ok {
+			return "", nil, &domain.FilterError{Field: key, Reason: "unknown field"}
+		}
+
 		for i, filter := range filters {
-			dbField := key
-			if str, ok := fieldMap[strings.ToLower(dbField)]; ok {
-				dbField = str
+			//the near field only makes sense as a radius, every other comparator would compare the table name
+			if strings.ToLower(key) == domain.FilterNear && strings.ToLower(filter.Comparator) != domain.ComparatorWithin {
+				return "", nil, &domain.FilterError{Field: key, Comparator: filter.Comparator, Reason: "unsupported comparator"}
 			}
 
 			index := dbField + strconv.Itoa(i)
 			f, err := getFilterStr(dbField, index, &filter)
 			if err != nil {
+				var filterErr *domain.FilterError
+				if errors.As(err, &filterErr) {
+					filterErr.Field = key
+				}
 				return "", nil, err
 			}
-			if f != "" {
-				filterArr = append(filterArr, f)
+			if f == "" {
+				continue
+			}
+			filterArr = append(filterArr, f)
+			if radius, ok := filter.Value.(domain.Radius); ok {
+				params[index+"lat"] = radius.Lat
+				params[index+"lng"] = radius.Lng
+				params[index+"km"] = radius.Km
+				params[index+"minlat"], params[index+"maxlat"], params[index+"minlng"], params[index+"maxlng"] = boundingBox(radius, 2*radius.Km)
+			} else {
 				params[index] = filter.Value
 			}
 		}
@@ -42,18 +98,50 @@ func getFilters(fieldMap map[string]string, filterMap domain.FilterMap) (string,
 }
 
 func getFilterStr(key string, index string, filter *domain.Filter) (string, error) {
-	switch filter.Comparator {
-	case "=":
-		//if interface is string
+	comparator := strings.ToLower(filter.Comparator)
+	if !isFilterComparator(comparator) {
+		return "", &domain.FilterError{Comparator: filter.Comparator, Reason: "unknown comparator"}
+	}
+
+	//lists are expanded by sqlx.In, so only IN can take one
+	if comparator != "in" && filter.Value != nil && reflect.TypeOf(filter.Value).Kind() == reflect.Slice {
+		return "", &domain.FilterError{Comparator: filter.Comparator, Reason: fmt.Sprintf("unsupported type for %s filter: %T", comparator, filter.Value)}
+	}
+
+	_, isRadius := filter.Value.(domain.Radius)
+	if isRadius != (comparator == domain.ComparatorWithin) {
+		return "", &domain.FilterError{Comparator: filter.Comparator, Reason: fmt.Sprintf("unsupported type for %s filter: %T", comparator, filter.Value)}
+	}
+
+	switch comparator {
+	case domain.ComparatorWithin:
+		return fmt.Sprintf(withinTemplate, key, index), nil
+	case "is null":
+		return fmt.Sprintf("%s IS NULL", key), nil
+	case "=", "!=":
 		if filter.Value == nil {
+			if comparator == "!=" {
+				return fmt.Sprintf("%s IS NOT NULL", key), nil
+			}
 			return fmt.Sprintf("%s IS NULL", key), nil
 		}
 
+		//if interface is string
 		if str, ok := filter.Value.(string); ok {
 			filter.Value = strings.ToLower(str)
+			if comparator == "!=" {
+				return fmt.Sprintf("lower(%s) %s :%s", key, "not like", index), nil
+			}
 			return fmt.Sprintf("lower(%s) %s :%s", key, "like", index), nil
 		}
-		return fmt.Sprintf("%s %s :%s", key, filter.Comparator, index), nil
+		return fmt.Sprintf("%s %s :%s", key, comparator, index), nil
+	case "like":
+		str, ok := filter.Value.(string)
+		if !ok {
+			return "", &domain.FilterError{Comparator: filter.Comparator, Reason: fmt.Sprintf("unsupported type for like filter: %T", filter.Value)}
+		}
+		filter.Value = strings.ToLower(str)
+		return fmt.Sprintf("lower(%s) like :%s", key, index), nil
 	case "in":
 		//if interface is string array
 		if strArr, ok := filter.Value.([]string); ok {
@@ -74,8 +162,46 @@ func getFilterStr(key string, index string, filter *domain.Filter) (string, erro
 			return fmt.Sprintf("%s IN (:%s)", key, index), nil
 		}
 
-		return "", fmt.Errorf("unsupported type for IN filter: %T", filter.Value)
+		return "", &domain.FilterError{Comparator: filter.Comparator, Reason: fmt.Sprintf("unsupported type for in filter: %T", filter.Value)}
 	default:
-		return fmt.Sprintf("%s %s :%s", key, filter.Comparator, index), nil
+		return fmt.Sprintf("%s %s :%s", key, comparator, index), nil
+	}
+}
+
+func isFilterComparator(comparator string) bool {
+	for _, c := range filterComparators {
+		if c == comparator {
+			return true
+		}
 	}
+	return false
+}
+
+var errInvalidSort = domain.InvalidInput("invalid sort field")
+var errInvalidOrder = domain.InvalidInput("invalid sort order")
+
+// getPageStr builds the ORDER BY, LIMIT and OFFSET for a page, ties are broken on idField so pages are stable
+func getPageStr(sortMap map[string]string, idField string, page domain.Page) (string, error) {
+	sortField, ok := sortMap[strings.ToLower(page.Sort)]
+	if !ok {
+		return "", errInvalidSort
+	}
+
+	order := strings.ToUpper(page.Order)
+	if order != "ASC" && order != "DESC" {
+		return "", errInvalidOrder
+	}
+
+	pageStr := fmt.Sprintf(" ORDER BY %s %s", sortField, order)
+	if sortField != idField {
+		pageStr += fmt.Sprintf(", %s %s", idField, order)
+	}
+	if page.Limit > 0 {
+		pageStr += fmt.Sprintf(" LIMIT %d", page.Limit)
+	}
+	if page.Offset > 0 {
+		pageStr += fmt.Sprintf(" OFFSET %d", page.Offset)
+	}
+
+	return pageStr, nil
 }
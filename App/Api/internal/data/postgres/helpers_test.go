@@ -1,8 +1,8 @@
 package postgres
 
 import (
-	"errors"
 	"lostpets"
+	"math"
 	"testing"
 	"time"
 
@@ -104,7 +104,7 @@ func TestGetFilterStr(t *testing.T) {
 			},
 		},
 		test{
-			name: "Should return in",
+			name: "Should return FilterError for in on a single value",
 			input: testInput{
 				key:   "dbField",
 				index: "mapIndex",
@@ -114,7 +114,7 @@ func TestGetFilterStr(t *testing.T) {
 				},
 			},
 			expected: testResult{
-				err:   errors.New("unsupported type for IN filter: int"),
+				err:   &lostpets.FilterError{Comparator: "in", Reason: "unsupported type for in filter: int"},
 				value: 23,
 			},
 		},
@@ -148,6 +148,92 @@ func TestGetFilterStr(t *testing.T) {
 				value: []string{},
 			},
 		},
+		test{
+			name: "Should return case insensitive not equals",
+			input: testInput{
+				key:   "dbField",
+				index: "mapIndex",
+				filter: &lostpets.Filter{
+					Comparator: "!=",
+					Value:      "testValue",
+				},
+			},
+			expected: testResult{
+				filterStr: "lower(dbField) not like :mapIndex",
+				value:     "testvalue",
+			},
+		},
+		test{
+			name: "Should return is not null",
+			input: testInput{
+				key:   "dbField",
+				index: "mapIndex",
+				filter: &lostpets.Filter{
+					Comparator: "!=",
+				},
+			},
+			expected: testResult{
+				filterStr: "dbField IS NOT NULL",
+			},
+		},
+		test{
+			name: "Should return is null",
+			input: testInput{
+				key:   "dbField",
+				index: "mapIndex",
+				filter: &lostpets.Filter{
+					Comparator: "IS NULL",
+				},
+			},
+			expected: testResult{
+				filterStr: "dbField IS NULL",
+			},
+		},
+		test{
+			name: "Should return case insensitive like",
+			input: testInput{
+				key:   "dbField",
+				index: "mapIndex",
+				filter: &lostpets.Filter{
+					Comparator: "like",
+					Value:      "%Main%",
+				},
+			},
+			expected: testResult{
+				filterStr: "lower(dbField) like :mapIndex",
+				value:     "%main%",
+			},
+		},
+		test{
+			name: "Should return FilterError for like on non strings",
+			input: testInput{
+				key:   "dbField",
+				index: "mapIndex",
+				filter: &lostpets.Filter{
+					Comparator: "like",
+					Value:      5,
+				},
+			},
+			expected: testResult{
+				err:   &lostpets.FilterError{Comparator: "like", Reason: "unsupported type for like filter: int"},
+				value: 5,
+			},
+		},
+		test{
+			name: "Should return FilterError for unknown comparators",
+			input: testInput{
+				key:   "dbField",
+				index: "mapIndex",
+				filter: &lostpets.Filter{
+					Comparator: "= 1; DROP TABLE postings; --",
+					Value:      5,
+				},
+			},
+			expected: testResult{
+				err:   &lostpets.FilterError{Comparator: "= 1; DROP TABLE postings; --", Reason: "unknown comparator"},
+				value: 5,
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -172,13 +258,13 @@ func TestGetFilters(t *testing.T) {
 				Value:      lowerTime,
 			},
 		},
-		"id": { //no ,mapping
+		"id": { //same name mapping
 			{
 				Comparator: "in",
 				Value:      []string{"ABC", "oNE", "two"},
 			},
 		},
-		"age": { //no ,mapping
+		"age": { //same name mapping
 			{
 				Comparator: "=",
 				Value:      56,
@@ -188,6 +274,8 @@ func TestGetFilters(t *testing.T) {
 
 	fieldMap := map[string]string{
 		"createdat": "created_at",
+		"id":        "id",
+		"age":       "age",
 	}
 
 	filterStr, params, err := getFilters(fieldMap, filters)
@@ -203,3 +291,115 @@ func TestGetFilters(t *testing.T) {
 		"id0":         []string{"abc", "one", "two"},
 	}, params)
 }
+
+func TestGetFiltersUnknownField(t *testing.T) {
+	filters := lostpets.FilterMap{
+		"id = 1 OR 1": {
+			{
+				Comparator: "=",
+				Value:      1,
+			},
+		},
+	}
+
+	_, _, err := getFilters(map[string]string{"id": "id"}, filters)
+	assert.Equal(t, &lostpets.FilterError{Field: "id = 1 OR 1", Reason: "unknown field"}, err)
+}
+
+func TestGetFiltersNear(t *testing.T) {
+	fieldMap := map[string]string{"near": "postings"}
+
+	filterStr, params, err := getFilters(fieldMap, lostpets.FilterMap{
+		"near": {{Comparator: lostpets.ComparatorWithin, Value: lostpets.Radius{Lat: 43.65, Lng: -79.38, Km: 5}}},
+	})
+	assert.NoError(t, err)
+	assert.Contains(t, filterStr, "postings.latitude - :postings0lat")
+	assert.Contains(t, filterStr, "COALESCE(postings.accuracy_km, 0)) <= :postings0km")
+	assert.Contains(t, filterStr, "postings.latitude BETWEEN :postings0minlat AND :postings0maxlat AND postings.longitude BETWEEN :postings0minlng AND :postings0maxlng")
+	assert.Contains(t, filterStr, "postings.accuracy_km > :postings0km OR")
+	assert.Equal(t, 43.65, params["postings0lat"])
+	assert.Equal(t, -79.38, params["postings0lng"])
+	assert.Equal(t, 5.0, params["postings0km"])
+	//the box reaches twice the radius so records with an accuracy up to the radius are in it
+	assert.InDelta(t, 43.56, params["postings0minlat"], 0.01)
+	assert.InDelta(t, 43.74, params["postings0maxlat"], 0.01)
+	assert.InDelta(t, -79.50, params["postings0minlng"], 0.01)
+	assert.InDelta(t, -79.26, params["postings0maxlng"], 0.01)
+
+	_, _, err = getFilters(fieldMap, lostpets.FilterMap{
+		"near": {{Comparator: "=", Value: "downtown"}},
+	})
+	assert.Equal(t, &lostpets.FilterError{Field: "near", Comparator: "=", Reason: "unsupported comparator"}, err)
+
+	_, _, err = getFilters(map[string]string{"id": "id"}, lostpets.FilterMap{
+		"id": {{Comparator: lostpets.ComparatorWithin, Value: 5}},
+	})
+	assert.Equal(t, &lostpets.FilterError{Field: "id", Comparator: "within", Reason: "unsupported type for within filter: int"}, err)
+}
+
+func TestBoundingBox(t *testing.T) {
+	minLat, maxLat, minLng, maxLng := boundingBox(lostpets.Radius{Lat: 0, Lng: 0}, 111.195)
+	assert.InDelta(t, -1, minLat, 0.001)
+	assert.InDelta(t, 1, maxLat, 0.001)
+	assert.InDelta(t, -1, minLng, 0.001)
+	assert.InDelta(t, 1, maxLng, 0.001)
+
+	//further from the equator a degree of longitude is shorter
+	_, _, minLng, maxLng = boundingBox(lostpets.Radius{Lat: 60, Lng: 10}, 111.195)
+	assert.Less(t, minLng, 8.0)
+	assert.Greater(t, maxLng, 12.0)
+
+	minLat, maxLat, minLng, maxLng = boundingBox(lostpets.Radius{Lat: 89.5, Lng: 10}, 111.195)
+	assert.Equal(t, []float64{88.5, 90, -180, 180}, []float64{math.Round(minLat*1000) / 1000, maxLat, minLng, maxLng})
+
+	_, _, minLng, maxLng = boundingBox(lostpets.Radius{Lat: 0, Lng: 179.5}, 111.195)
+	assert.Equal(t, []float64{-180, 180}, []float64{minLng, maxLng})
+}
+
+func TestGetPageStr(t *testing.T) {
+	type test struct {
+		name     string
+		page     lostpets.Page
+		expected string
+		err      error
+	}
+
+	sortMap := map[string]string{
+		lostpets.SortDate: "date",
+		lostpets.SortID:   "postings.id",
+	}
+
+	tests := []test{
+		test{
+			name:     "Should sort by field then id",
+			page:     lostpets.Page{Limit: 25, Offset: 50, Sort: lostpets.SortDate, Order: lostpets.OrderDesc},
+			expected: " ORDER BY date DESC, postings.id DESC LIMIT 25 OFFSET 50",
+		},
+		test{
+			name:     "Should not repeat the id field",
+			page:     lostpets.Page{Limit: 10, Sort: "ID", Order: "asc"},
+			expected: " ORDER BY postings.id ASC LIMIT 10",
+		},
+		test{
+			name:     "Should leave out limit when not set",
+			page:     lostpets.Page{Sort: lostpets.SortDate, Order: lostpets.OrderAsc},
+			expected: " ORDER BY date ASC, postings.id ASC",
+		},
+		test{
+			name: "Should return errInvalidSort for unmapped fields",
+			page: lostpets.Page{Sort: "email", Order: lostpets.OrderAsc},
+			err:  errInvalidSort,
+		},
+		test{
+			name: "Should return errInvalidOrder for unknown orders",
+			page: lostpets.Page{Sort: lostpets.SortDate, Order: "asc; DROP TABLE postings"},
+			err:  errInvalidOrder,
+		},
+	}
+
+	for _, test := range tests {
+		str, err := getPageStr(sortMap, "postings.id", test.page)
+		assert.Equal(t, test.expected, str, test.name)
+		assert.Equal(t, test.err, err, test.name)
+	}
+}
@@ -0,0 +1,59 @@
+-- +goose Up
+-- +goose StatementBegin
+
+ALTER TABLE "postings"
+  ADD COLUMN "status" text NOT NULL DEFAULT 'open',
+  ADD COLUMN "reunited_with" int,
+  ADD COLUMN "created_on" timestamp with time zone NOT NULL DEFAULT now(),
+  ADD COLUMN "reunited_on" timestamp with time zone,
+  ADD COLUMN "closed_on" timestamp with time zone,
+  ADD COLUMN "expired_on" timestamp with time zone,
+  ADD CONSTRAINT postings_status_check CHECK ("status" IN ('open', 'reunited', 'closed', 'expired')),
+  ADD CONSTRAINT postings_reunited_sighting_fk FOREIGN KEY ("reunited_with")
+        REFERENCES public.sightings ("id") MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE SET NULL;
+
+ALTER TABLE "sightings"
+  ADD COLUMN "status" text NOT NULL DEFAULT 'open',
+  ADD COLUMN "reunited_with" int,
+  ADD COLUMN "created_on" timestamp with time zone NOT NULL DEFAULT now(),
+  ADD COLUMN "reunited_on" timestamp with time zone,
+  ADD COLUMN "closed_on" timestamp with time zone,
+  ADD COLUMN "expired_on" timestamp with time zone,
+  ADD CONSTRAINT sightings_status_check CHECK ("status" IN ('open', 'reunited', 'closed', 'expired')),
+  ADD CONSTRAINT sightings_reunited_posting_fk FOREIGN KEY ("reunited_with")
+        REFERENCES public.postings ("id") MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE SET NULL;
+
+CREATE INDEX postings_status_idx ON postings ("status", "created_on");
+CREATE INDEX sightings_status_idx ON sightings ("status", "created_on");
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX sightings_status_idx;
+DROP INDEX postings_status_idx;
+
+ALTER TABLE "sightings"
+  DROP CONSTRAINT sightings_reunited_posting_fk,
+  DROP CONSTRAINT sightings_status_check,
+  DROP COLUMN "expired_on",
+  DROP COLUMN "closed_on",
+  DROP COLUMN "reunited_on",
+  DROP COLUMN "created_on",
+  DROP COLUMN "reunited_with",
+  DROP COLUMN "status";
+
+ALTER TABLE "postings"
+  DROP CONSTRAINT postings_reunited_sighting_fk,
+  DROP CONSTRAINT postings_status_check,
+  DROP COLUMN "expired_on",
+  DROP COLUMN "closed_on",
+  DROP COLUMN "reunited_on",
+  DROP COLUMN "created_on",
+  DROP COLUMN "reunited_with",
+  DROP COLUMN "status";
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+
+ALTER TABLE "matches"
+  ADD COLUMN "score" real NOT NULL DEFAULT 0,
+  ADD COLUMN "score_breakdown" jsonb NOT NULL DEFAULT '{}';
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE "matches"
+  DROP COLUMN "score_breakdown",
+  DROP COLUMN "score";
+-- +goose StatementEnd
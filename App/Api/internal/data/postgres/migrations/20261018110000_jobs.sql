@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+
+CREATE TABLE "jobs" (
+  "id" SERIAL PRIMARY KEY,
+  "kind" text NOT NULL,
+  "payload" jsonb NOT NULL,
+  "status" text NOT NULL DEFAULT 'pending',
+  "attempts" int NOT NULL DEFAULT 0,
+  "max_attempts" int NOT NULL,
+  "run_at" timestamp with time zone NOT NULL DEFAULT now(),
+  "locked_until" timestamp with time zone,
+  "last_error" text,
+  "created_on" timestamp with time zone NOT NULL DEFAULT now(),
+  "updated_on" timestamp with time zone NOT NULL DEFAULT now(),
+  CONSTRAINT jobs_status_check CHECK ("status" IN ('pending', 'running', 'done', 'dead'))
+);
+
+CREATE INDEX jobs_due_idx ON jobs ("status", "run_at");
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table public.jobs;
+-- +goose StatementEnd
@@ -0,0 +1,43 @@
+-- +goose Up
+-- +goose StatementBegin
+
+ALTER TABLE "postings"
+  ADD COLUMN "latitude" double precision,
+  ADD COLUMN "longitude" double precision,
+  ADD COLUMN "accuracy_km" real,
+  ADD CONSTRAINT postings_coordinates_check CHECK (
+    ("latitude" IS NULL AND "longitude" IS NULL) OR
+    ("latitude" BETWEEN -90 AND 90 AND "longitude" BETWEEN -180 AND 180)
+  );
+
+ALTER TABLE "sightings"
+  ADD COLUMN "latitude" double precision,
+  ADD COLUMN "longitude" double precision,
+  ADD COLUMN "accuracy_km" real,
+  ADD CONSTRAINT sightings_coordinates_check CHECK (
+    ("latitude" IS NULL AND "longitude" IS NULL) OR
+    ("latitude" BETWEEN -90 AND 90 AND "longitude" BETWEEN -180 AND 180)
+  );
+
+CREATE INDEX postings_coordinates_idx ON postings ("latitude", "longitude");
+CREATE INDEX sightings_coordinates_idx ON sightings ("latitude", "longitude");
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX sightings_coordinates_idx;
+DROP INDEX postings_coordinates_idx;
+
+ALTER TABLE "sightings"
+  DROP CONSTRAINT sightings_coordinates_check,
+  DROP COLUMN "accuracy_km",
+  DROP COLUMN "longitude",
+  DROP COLUMN "latitude";
+
+ALTER TABLE "postings"
+  DROP CONSTRAINT postings_coordinates_check,
+  DROP COLUMN "accuracy_km",
+  DROP COLUMN "longitude",
+  DROP COLUMN "latitude";
+-- +goose StatementEnd
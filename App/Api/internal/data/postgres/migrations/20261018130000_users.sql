@@ -0,0 +1,37 @@
+-- +goose Up
+-- +goose StatementBegin
+
+CREATE TABLE "users" (
+  "id" SERIAL PRIMARY KEY,
+  "email" text NOT NULL,
+  "name" text NOT NULL DEFAULT '',
+  "password_hash" text NOT NULL,
+  "role" text NOT NULL DEFAULT 'reporter',
+  "created_on" timestamp with time zone NOT NULL DEFAULT now(),
+  CONSTRAINT users_role_check CHECK ("role" IN ('reporter', 'staff', 'admin'))
+);
+
+CREATE UNIQUE INDEX users_email_idx ON users (lower("email"));
+
+CREATE TABLE "refresh_tokens" (
+  "id" SERIAL PRIMARY KEY,
+  "user_id" int NOT NULL,
+  "token_hash" text NOT NULL,
+  "expires_on" timestamp with time zone NOT NULL,
+  "revoked_on" timestamp with time zone,
+  "created_on" timestamp with time zone NOT NULL DEFAULT now(),
+  CONSTRAINT refresh_tokens_user_fk FOREIGN KEY ("user_id")
+        REFERENCES public.users ("id") MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX refresh_tokens_hash_idx ON refresh_tokens ("token_hash");
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table public.refresh_tokens;
+drop table public.users;
+-- +goose StatementEnd
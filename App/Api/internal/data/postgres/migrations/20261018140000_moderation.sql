@@ -0,0 +1,41 @@
+-- +goose Up
+-- +goose StatementBegin
+
+ALTER TABLE "postings" ADD COLUMN "hidden_on" timestamp with time zone;
+ALTER TABLE "sightings" ADD COLUMN "hidden_on" timestamp with time zone;
+
+ALTER TABLE "pictures"
+  ADD COLUMN "hidden_on" timestamp with time zone,
+  ADD COLUMN "created_on" timestamp with time zone NOT NULL DEFAULT now();
+
+CREATE TABLE "moderation_log" (
+  "id" SERIAL PRIMARY KEY,
+  "user_id" int,
+  "target" text NOT NULL,
+  "target_id" int NOT NULL,
+  "action" text NOT NULL,
+  "reason" text NOT NULL,
+  "created_on" timestamp with time zone NOT NULL DEFAULT now(),
+  CONSTRAINT moderation_log_target_check CHECK ("target" IN ('posting', 'sighting', 'picture')),
+  CONSTRAINT moderation_log_action_check CHECK ("action" IN ('hide', 'unhide', 'delete')),
+  CONSTRAINT moderation_log_user_fk FOREIGN KEY ("user_id")
+        REFERENCES public.users ("id") MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE SET NULL
+);
+
+CREATE INDEX moderation_log_target_idx ON moderation_log ("target", "target_id");
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table public.moderation_log;
+
+ALTER TABLE "pictures"
+  DROP COLUMN "created_on",
+  DROP COLUMN "hidden_on";
+
+ALTER TABLE "sightings" DROP COLUMN "hidden_on";
+ALTER TABLE "postings" DROP COLUMN "hidden_on";
+-- +goose StatementEnd
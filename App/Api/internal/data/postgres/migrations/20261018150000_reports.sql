@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+
+CREATE TABLE "reports" (
+  "id" SERIAL PRIMARY KEY,
+  "target" text NOT NULL,
+  "target_id" int NOT NULL,
+  "reporter" text NOT NULL,
+  "reason" text NOT NULL,
+  "text" text NOT NULL DEFAULT '',
+  "created_on" timestamp with time zone NOT NULL DEFAULT now(),
+  "resolved_on" timestamp with time zone,
+  CONSTRAINT reports_target_check CHECK ("target" IN ('posting', 'sighting')),
+  CONSTRAINT reports_reason_check CHECK ("reason" IN ('scam', 'fake', 'abusive', 'spam', 'other'))
+);
+
+-- one open report per reporter, so the open reports on a record count distinct reporters
+CREATE UNIQUE INDEX reports_open_reporter_idx ON reports ("target", "target_id", "reporter") WHERE "resolved_on" IS NULL;
+CREATE INDEX reports_target_idx ON reports ("target", "target_id");
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table public.reports;
+-- +goose StatementEnd
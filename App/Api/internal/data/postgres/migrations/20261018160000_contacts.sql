@@ -0,0 +1,43 @@
+-- +goose Up
+-- +goose StatementBegin
+
+CREATE TABLE "contacts" (
+  "id" SERIAL PRIMARY KEY,
+  "target" text NOT NULL,
+  "target_id" int NOT NULL,
+  "matched_with" int,
+  "requester_name" text NOT NULL DEFAULT '',
+  "requester_email" text NOT NULL,
+  "requester_alias" text NOT NULL,
+  "owner_alias" text NOT NULL,
+  "created_on" timestamp with time zone NOT NULL DEFAULT now(),
+  CONSTRAINT contacts_target_check CHECK ("target" IN ('posting', 'sighting'))
+);
+
+-- one conversation per requester and record, writing again adds to it
+CREATE UNIQUE INDEX contacts_requester_idx ON contacts ("target", "target_id", "requester_email");
+CREATE UNIQUE INDEX contacts_requester_alias_idx ON contacts ("requester_alias");
+CREATE UNIQUE INDEX contacts_owner_alias_idx ON contacts ("owner_alias");
+
+CREATE TABLE "contact_messages" (
+  "id" SERIAL PRIMARY KEY,
+  "contact_id" int NOT NULL,
+  "from_owner" boolean NOT NULL,
+  "message" text NOT NULL,
+  "created_on" timestamp with time zone NOT NULL DEFAULT now(),
+  "sent_on" timestamp with time zone,
+  CONSTRAINT contact_messages_contact_fk FOREIGN KEY ("contact_id")
+        REFERENCES public.contacts ("id") MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE CASCADE
+);
+
+CREATE INDEX contact_messages_contact_idx ON contact_messages ("contact_id", "created_on");
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table public.contact_messages;
+drop table public.contacts;
+-- +goose StatementEnd
@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+
+CREATE TABLE "match_messages" (
+  "id" SERIAL PRIMARY KEY,
+  "postings_id" int NOT NULL,
+  "sightings_id" int NOT NULL,
+  "from_posting" boolean NOT NULL,
+  "message" text NOT NULL,
+  "created_on" timestamp with time zone NOT NULL DEFAULT now(),
+  "read_on" timestamp with time zone,
+  "notified_on" timestamp with time zone,
+  CONSTRAINT match_messages_match_fk FOREIGN KEY ("postings_id", "sightings_id")
+        REFERENCES public.matches ("postings_id", "sightings_id") MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE CASCADE
+);
+
+CREATE INDEX match_messages_match_idx ON match_messages ("postings_id", "sightings_id", "created_on");
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table public.match_messages;
+-- +goose StatementEnd
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+
+ALTER TABLE "matches"
+  ADD COLUMN "decision" text,
+  ADD COLUMN "decided_by" text,
+  ADD COLUMN "decided_on" timestamp with time zone,
+  ADD CONSTRAINT matches_decision_check CHECK ("decision" IN ('confirmed', 'dismissed')),
+  ADD CONSTRAINT matches_decided_by_check CHECK ("decided_by" IN ('posting', 'sighting'));
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE "matches"
+  DROP CONSTRAINT matches_decided_by_check,
+  DROP CONSTRAINT matches_decision_check,
+  DROP COLUMN "decided_on",
+  DROP COLUMN "decided_by",
+  DROP COLUMN "decision";
+-- +goose StatementEnd
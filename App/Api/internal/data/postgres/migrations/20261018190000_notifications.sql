@@ -0,0 +1,32 @@
+-- +goose Up
+-- +goose StatementBegin
+
+CREATE TABLE "notifications" (
+  "id" SERIAL PRIMARY KEY,
+  "kind" text NOT NULL,
+  "recipient" text NOT NULL,
+  "reply_to" text NOT NULL DEFAULT '',
+  "subject" text NOT NULL,
+  "body" text NOT NULL,
+  "content_type" text NOT NULL,
+  "status" text NOT NULL DEFAULT 'pending',
+  "attempts" int NOT NULL DEFAULT 0,
+  "max_attempts" int NOT NULL,
+  "run_at" timestamp with time zone NOT NULL DEFAULT now(),
+  "locked_until" timestamp with time zone,
+  "last_error" text,
+  "created_on" timestamp with time zone NOT NULL DEFAULT now(),
+  "sent_on" timestamp with time zone,
+  CONSTRAINT notifications_status_check CHECK ("status" IN ('pending', 'sending', 'sent', 'failed'))
+);
+
+CREATE INDEX notifications_due_idx ON notifications ("status", "run_at");
+-- counts what was recently sent to a recipient for the rate limit
+CREATE INDEX notifications_recipient_idx ON notifications ("recipient", "sent_on");
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table public.notifications;
+-- +goose StatementEnd
@@ -0,0 +1,36 @@
+-- +goose Up
+-- +goose StatementBegin
+
+ALTER TABLE "postings"
+  ADD COLUMN "notify_by" text[] NOT NULL DEFAULT '{email}',
+  ADD COLUMN "phone" text NOT NULL DEFAULT '',
+  ADD COLUMN "webhook_url" text NOT NULL DEFAULT '';
+
+ALTER TABLE "sightings"
+  ADD COLUMN "notify_by" text[] NOT NULL DEFAULT '{email}',
+  ADD COLUMN "phone" text NOT NULL DEFAULT '',
+  ADD COLUMN "webhook_url" text NOT NULL DEFAULT '';
+
+-- the recipient is an email address, phone number or webhook url depending on the channel
+ALTER TABLE "notifications"
+  ADD COLUMN "channel" text NOT NULL DEFAULT 'email',
+  ADD CONSTRAINT notifications_channel_check CHECK ("channel" IN ('email', 'sms', 'webhook'));
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE "notifications"
+  DROP CONSTRAINT notifications_channel_check,
+  DROP COLUMN "channel";
+
+ALTER TABLE "sightings"
+  DROP COLUMN "webhook_url",
+  DROP COLUMN "phone",
+  DROP COLUMN "notify_by";
+
+ALTER TABLE "postings"
+  DROP COLUMN "webhook_url",
+  DROP COLUMN "phone",
+  DROP COLUMN "notify_by";
+-- +goose StatementEnd
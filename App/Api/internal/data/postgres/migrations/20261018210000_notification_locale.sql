@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- the locale emails are sent in, empty for the default
+ALTER TABLE "postings" ADD COLUMN "locale" text NOT NULL DEFAULT '';
+ALTER TABLE "sightings" ADD COLUMN "locale" text NOT NULL DEFAULT '';
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE "sightings" DROP COLUMN "locale";
+ALTER TABLE "postings" DROP COLUMN "locale";
+-- +goose StatementEnd
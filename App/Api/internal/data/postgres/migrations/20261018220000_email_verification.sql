@@ -0,0 +1,45 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- records start pending until their email is verified, the ones already there count as verified
+ALTER TABLE "postings"
+  ADD COLUMN "pending_on" timestamptz,
+  ADD COLUMN "verified_on" timestamptz,
+  DROP CONSTRAINT postings_status_check,
+  ADD CONSTRAINT postings_status_check CHECK ("status" IN ('pending', 'open', 'reunited', 'closed', 'expired'));
+
+ALTER TABLE "sightings"
+  ADD COLUMN "pending_on" timestamptz,
+  ADD COLUMN "verified_on" timestamptz,
+  DROP CONSTRAINT sightings_status_check,
+  ADD CONSTRAINT sightings_status_check CHECK ("status" IN ('pending', 'open', 'reunited', 'closed', 'expired'));
+
+UPDATE postings SET verified_on = created_on;
+UPDATE sightings SET verified_on = created_on;
+
+CREATE INDEX postings_pending_idx ON postings ("pending_on") WHERE "status" = 'pending';
+CREATE INDEX sightings_pending_idx ON sightings ("pending_on") WHERE "status" = 'pending';
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX sightings_pending_idx;
+DROP INDEX postings_pending_idx;
+
+-- there is no pending status to go back to, unverified records are expired so they don't go public
+UPDATE sightings SET status = 'expired', expired_on = now() WHERE status = 'pending';
+UPDATE postings SET status = 'expired', expired_on = now() WHERE status = 'pending';
+
+ALTER TABLE "sightings"
+  DROP CONSTRAINT sightings_status_check,
+  ADD CONSTRAINT sightings_status_check CHECK ("status" IN ('open', 'reunited', 'closed', 'expired')),
+  DROP COLUMN "verified_on",
+  DROP COLUMN "pending_on";
+
+ALTER TABLE "postings"
+  DROP CONSTRAINT postings_status_check,
+  ADD CONSTRAINT postings_status_check CHECK ("status" IN ('open', 'reunited', 'closed', 'expired')),
+  DROP COLUMN "verified_on",
+  DROP COLUMN "pending_on";
+-- +goose StatementEnd
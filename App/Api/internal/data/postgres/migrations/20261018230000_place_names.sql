@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- the gazetteer's name for geocoded coordinates, the location text is kept as the owner wrote it
+ALTER TABLE "postings"
+  ADD COLUMN "place_name" text;
+
+ALTER TABLE "sightings"
+  ADD COLUMN "place_name" text;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE "sightings"
+  DROP COLUMN "place_name";
+
+ALTER TABLE "postings"
+  DROP COLUMN "place_name";
+-- +goose StatementEnd
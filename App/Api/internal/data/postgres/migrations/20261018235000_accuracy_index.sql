@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- the near filter looks the few records with an accuracy wider than the radius up here, the rest by their coordinates
+CREATE INDEX postings_accuracy_idx ON postings ("accuracy_km");
+CREATE INDEX sightings_accuracy_idx ON sightings ("accuracy_km");
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX sightings_accuracy_idx;
+DROP INDEX postings_accuracy_idx;
+-- +goose StatementEnd
@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- when the owner was emailed that the posting is about to expire, so they are only told once
+ALTER TABLE "postings"
+  ADD COLUMN "expiry_warned_on" timestamp with time zone;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE "postings"
+  DROP COLUMN "expiry_warned_on";
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- the keyed hash of the IP address every report came from, logged in or not, so one address is only counted once per record
+ALTER TABLE "reports"
+  ADD COLUMN "address" text NOT NULL DEFAULT '';
+
+CREATE INDEX reports_address_idx ON reports ("address", "created_on");
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX reports_address_idx;
+ALTER TABLE "reports"
+  DROP COLUMN "address";
+-- +goose StatementEnd
@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- each owner's webhooks are signed with their own secret, it is copied onto the webhook notifications sent to them
+ALTER TABLE "postings"
+  ADD COLUMN "webhook_secret" text NOT NULL DEFAULT '';
+
+ALTER TABLE "sightings"
+  ADD COLUMN "webhook_secret" text NOT NULL DEFAULT '';
+
+ALTER TABLE "notifications"
+  ADD COLUMN "secret" text NOT NULL DEFAULT '';
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE "notifications"
+  DROP COLUMN "secret";
+
+ALTER TABLE "sightings"
+  DROP COLUMN "webhook_secret";
+
+ALTER TABLE "postings"
+  DROP COLUMN "webhook_secret";
+-- +goose StatementEnd
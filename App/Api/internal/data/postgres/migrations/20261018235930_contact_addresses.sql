@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- the keyed hash of the IP address each message to an owner was sent from, so the messages from one address can be limited
+ALTER TABLE "contact_messages"
+  ADD COLUMN "address" text NOT NULL DEFAULT '';
+
+CREATE INDEX contact_messages_address_idx ON contact_messages ("address", "created_on");
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX contact_messages_address_idx;
+ALTER TABLE "contact_messages"
+  DROP COLUMN "address";
+-- +goose StatementEnd
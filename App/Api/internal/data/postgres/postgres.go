@@ -2,6 +2,7 @@ package postgres
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	domain "lostpets"
@@ -18,11 +19,11 @@ import (
 type (
 	Config struct {
 		Username string `json:"username"`
-		Password string `json:"password"`
+		Password string `json:"password" secret:"true"`
 		Host     string `json:"host"`
 		Port     int    `json:"port"`
 		Name     string `json:"dbName"`
-		SSLMode  string `json:"sslmode"`
+		SSLMode  string `json:"sslMode"`
 	}
 
 	DB struct {
@@ -44,10 +45,22 @@ type (
 		PetID int
 	}
 
+	// dbCoordinates are the nullable location columns postings and sightings share
+	dbCoordinates struct {
+		Latitude   *float64
+		Longitude  *float64
+		AccuracyKm *float64
+		PlaceName  *string
+	}
+
 	matches struct {
 		PostingsID    int
 		SightingsID   int
+		Score         float64
 		LastContacted *time.Time
+		Decision      *string
+		DecidedBy     *string
+		DecidedOn     *time.Time
 	}
 
 	query struct {
@@ -60,6 +73,13 @@ var errorSentinel = errors.New("invalid in filter")
 
 var errID = errors.New("postgresDb: ID was not returned after insert")
 
+// the history timestamp set when moving to a status, the status is used as a key so it never gets into the query
+var statusColumns = map[domain.Status]string{
+	domain.StatusReunited: "reunited_on",
+	domain.StatusClosed:   "closed_on",
+	domain.StatusExpired:  "expired_on",
+}
+
 const connTemplate = "user=%s password=%s host=%s dbname=%s port=%d sslmode=%s TimeZone=UTC"
 
 func NewDBConnection(config Config) (*DB, error) {
@@ -80,16 +100,19 @@ func (db *DB) NewLostPetsRepo() *Repo {
 const matchesSelect = `SELECT
 postings_id,
 sightings_id,
-last_contacted
+score,
+last_contacted,
+decision,
+decided_by,
+decided_on
 FROM matches `
 
-func (db *DB) addPet(pet *domain.Pet) error {
-
+func (tx *Tx) addPet(pet *domain.Pet) error {
 	query := `INSERT INTO pets(
 		picture_id, type_id, name, color, marks)
-		VALUES (:picture_id, :type_id, :name, :color, :marks) RETURNING id;`
+		VALUES (NULLIF(:picture_id, 0), :type_id, :name, :color, :marks) RETURNING id;`
 
-	rows, err := db.NamedQuery(query, pet)
+	rows, err := tx.NamedQuery(query, pet)
 	if err != nil {
 		return err
 	}
@@ -101,15 +124,20 @@ func (db *DB) addPet(pet *domain.Pet) error {
 	} else {
 		return errID
 	}
+	if err != nil {
+		return err
+	}
+	// the results have to be read before the next statement on the same connection
+	rows.Close()
 
 	// add attributes
-	err = db.addBreeds(pet)
+	err = tx.addBreeds(pet)
 	if err != nil {
 		return err
 	}
 
 	// add certifications
-	err = db.addTag(pet)
+	err = tx.addTag(pet)
 	if err != nil {
 		return err
 	}
@@ -118,8 +146,7 @@ func (db *DB) addPet(pet *domain.Pet) error {
 
 }
 
-func (db *DB) addBreeds(pet *domain.Pet) error {
-
+func (tx *Tx) addBreeds(pet *domain.Pet) error {
 	// add breeds
 	breeds := []breed{}
 	for _, a := range pet.Breeds {
@@ -127,7 +154,7 @@ func (db *DB) addBreeds(pet *domain.Pet) error {
 	}
 	if len(breeds) != 0 {
 		query := `INSERT INTO pet_breeds(pet_id, name) VALUES (:pet_id, :name)`
-		_, err := db.NamedExec(query, breeds)
+		_, err := tx.NamedExec(query, breeds)
 		var pqErr *pq.Error
 		if errors.As(err, &pqErr) {
 			switch pqErr.Constraint {
@@ -140,8 +167,7 @@ func (db *DB) addBreeds(pet *domain.Pet) error {
 	return nil
 }
 
-func (db *DB) addTag(pet *domain.Pet) error {
-
+func (tx *Tx) addTag(pet *domain.Pet) error {
 	tag := tag{
 		Tag:   pet.Tag,
 		PetID: pet.ID,
@@ -151,7 +177,7 @@ func (db *DB) addTag(pet *domain.Pet) error {
 		pet_id, shape, text, color)
 		VALUES (:pet_id, :shape, :text, :color) RETURNING id;`
 
-	rows, err := db.NamedQuery(query, tag)
+	rows, err := tx.NamedQuery(query, tag)
 	if err != nil {
 		return err
 	}
@@ -159,27 +185,205 @@ func (db *DB) addTag(pet *domain.Pet) error {
 	defer rows.Close()
 
 	if rows.Next() {
+		return rows.Scan(&pet.Tag.ID)
+	}
+
+	return errID
+}
+
+func (tx *Tx) updatePet(pet *domain.Pet) error {
+	query := `UPDATE pets SET
+	picture_id=NULLIF(:picture_id, 0), type_id=:type_id, name=:name, color=:color, marks=:marks
+	WHERE id = :id`
+
+	_, err := tx.NamedExec(query, pet)
+	if err != nil {
+		return err
+	}
+
+	// replace breeds
+	_, err = tx.Exec("DELETE FROM pet_breeds WHERE pet_id = $1", pet.ID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.addBreeds(pet)
+	if err != nil {
+		return err
+	}
+
+	return tx.updateTag(pet)
+}
+
+func (tx *Tx) updateTag(pet *domain.Pet) error {
+	tag := tag{
+		Tag:   pet.Tag,
+		PetID: pet.ID,
+	}
+
+	query := `UPDATE tags SET
+	shape=:shape, text=:text, color=:color
+	WHERE pet_id = :pet_id RETURNING id`
+
+	rows, err := tx.NamedQuery(query, tag)
+	if err != nil {
+		return err
+	}
+
+	found := rows.Next()
+	if found {
 		err = rows.Scan(&pet.Tag.ID)
-	} else {
-		return errID
+	}
+	rows.Close()
+	if found || err != nil {
+		return err
 	}
 
-	return nil
+	// pet didn't have a tag yet
+	return tx.addTag(pet)
 }
 
-func (db *DB) AddMatch(pID int, sID int) error {
+func newDBCoordinates(c *domain.Coordinates) dbCoordinates {
+	if c == nil {
+		return dbCoordinates{}
+	}
+	accuracy := c.AccuracyKm
+	coordinates := dbCoordinates{Latitude: &c.Lat, Longitude: &c.Lng, AccuracyKm: &accuracy}
+	if c.Place != "" {
+		place := c.Place
+		coordinates.PlaceName = &place
+	}
+	return coordinates
+}
+
+func (c dbCoordinates) toDomain() *domain.Coordinates {
+	if c.Latitude == nil || c.Longitude == nil {
+		return nil
+	}
+	coordinates := &domain.Coordinates{Lat: *c.Latitude, Lng: *c.Longitude}
+	if c.AccuracyKm != nil {
+		coordinates.AccuracyKm = *c.AccuracyKm
+	}
+	if c.PlaceName != nil {
+		coordinates.Place = *c.PlaceName
+	}
+	return coordinates
+}
+
+func toChannels(values pq.StringArray) []domain.Channel {
+	channels := []domain.Channel{}
+	for _, v := range values {
+		channels = append(channels, domain.Channel(v))
+	}
+	return channels
+}
+
+func fromChannels(channels []domain.Channel) pq.StringArray {
+	values := pq.StringArray{}
+	for _, c := range channels {
+		values = append(values, string(c))
+	}
+	return values
+}
+
+func (db *DB) GetMatch(pID int, sID int) (*domain.Match, error) {
+	m := matches{}
+	err := db.Get(&m, matchesSelect+"WHERE postings_id = $1 AND sightings_id = $2", pID, sID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return m.toDomain(), nil
+}
+
+func (m matches) toDomain() *domain.Match {
+	match := &domain.Match{PostingID: m.PostingsID, SightingID: m.SightingsID, Score: m.Score, LastContacted: m.LastContacted, DecidedOn: m.DecidedOn}
+	if m.Decision != nil {
+		match.Decision = domain.MatchDecision(*m.Decision)
+	}
+	if m.DecidedBy != nil {
+		match.DecidedBy = domain.ModerationTarget(*m.DecidedBy)
+	}
+	return match
+}
+
+func (db *DB) AddMatch(pID int, sID int, score domain.MatchScore) (bool, error) {
+	breakdown, err := json.Marshal(score.Fields)
+	if err != nil {
+		return false, err
+	}
+
+	/*
+		an owner dismissed the pair, matching them again would only bring it back. xmax is 0 only for a row the
+		statement inserted, a pair that was already matched is re-scored and isn't new
+	*/
 	query := `INSERT INTO matches(
-		postings_id, sightings_id)
-		VALUES ($1,$2)`
+		postings_id, sightings_id, score, score_breakdown)
+		VALUES ($1,$2,$3,$4)
+		ON CONFLICT (postings_id, sightings_id) DO UPDATE SET
+		score = EXCLUDED.score, score_breakdown = EXCLUDED.score_breakdown
+		WHERE matches.decision IS DISTINCT FROM 'dismissed'
+		RETURNING (xmax = 0) AS inserted`
+
+	var inserted bool
+	err = db.Get(&inserted, query, pID, sID, score.Total, breakdown)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return inserted, err
+}
 
-	rows, err := db.Query(query, pID, sID)
+func (db *DB) DecideMatch(pID int, sID int, decision domain.MatchDecision, decidedBy domain.ModerationTarget) error {
+	result, err := db.Exec(`UPDATE matches SET decision = $1, decided_by = $2, decided_on = now()
+		WHERE postings_id = $3 AND sightings_id = $4`, decision, decidedBy, pID, sID)
 	if err != nil {
 		return err
 	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
 
-	defer rows.Close()
+// feedbackBands is how many bands of score the feedback is split into, a perfect score goes in the top band
+const feedbackBands = 10
+
+func (db *DB) GetMatchFeedback() ([]domain.MatchFeedback, error) {
+	query := `SELECT
+		LEAST(FLOOR(score * $1), $1 - 1)::int as band,
+		COUNT(*) as matches,
+		COUNT(*) FILTER (WHERE decision = 'confirmed') as confirmed,
+		COUNT(*) FILTER (WHERE decision = 'dismissed') as dismissed
+		FROM matches
+		GROUP BY band
+		ORDER BY band`
+
+	rows := []struct {
+		Band      int
+		Matches   int
+		Confirmed int
+		Dismissed int
+	}{}
+	if err := db.Select(&rows, query, feedbackBands); err != nil {
+		return nil, err
+	}
 
-	return nil
+	feedback := []domain.MatchFeedback{}
+	for _, r := range rows {
+		feedback = append(feedback, domain.MatchFeedback{
+			MinScore:  float64(r.Band) / feedbackBands,
+			MaxScore:  float64(r.Band+1) / feedbackBands,
+			Matches:   r.Matches,
+			Confirmed: r.Confirmed,
+			Dismissed: r.Dismissed,
+		})
+	}
+	return feedback, nil
 }
 func (db *DB) UpdateMatch(pID int, sID int, contactedOn time.Time) error {
 	query := `UPDATE matches SET
@@ -208,6 +412,45 @@ func (db *DB) RemoveMatch(pID int, sID int) error {
 	return nil
 }
 
+func (db *DB) RemoveStaleMatches(stale []domain.Match) error {
+	postings, sightings := pq.Int64Array{}, pq.Int64Array{}
+	for _, m := range stale {
+		postings = append(postings, int64(m.PostingID))
+		sightings = append(sightings, int64(m.SightingID))
+	}
+
+	// deleting a match deletes its messages, so a pair the owners have written about is kept along with decided ones
+	query := `DELETE FROM matches USING unnest($1::int[], $2::int[]) AS stale(postings_id, sightings_id)
+	WHERE matches.postings_id = stale.postings_id AND matches.sightings_id = stale.sightings_id
+	AND matches.decision IS NULL AND matches.last_contacted IS NULL`
+
+	_, err := db.Exec(query, postings, sightings)
+	return err
+}
+
+// transitionsTo is the statuses a record can change to next from, so the status updates agree with Status.CanTransition
+func transitionsTo(next domain.Status) pq.StringArray {
+	from := pq.StringArray{}
+	for _, s := range []domain.Status{domain.StatusPending, domain.StatusOpen, domain.StatusReunited, domain.StatusClosed, domain.StatusExpired} {
+		if s.CanTransition(next) {
+			from = append(from, string(s))
+		}
+	}
+	return from
+}
+
+// checkStatusUpdated returns ErrStatusTransition if a status update didn't change a row
+func checkStatusUpdated(result sql.Result) error {
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return domain.ErrStatusTransition
+	}
+	return nil
+}
+
 func (db *DB) GetPetTypes() ([]domain.PetType, error) {
 	query := "SELECT id, name FROM types ORDER BY id ASC"
 	types := []domain.PetType{}
@@ -0,0 +1,121 @@
+package postgres
+
+import (
+	"database/sql"
+	domain "lostpets"
+	"lostpets/internal"
+	"strings"
+	"time"
+)
+
+const contactSelect = `SELECT
+id,
+target,
+target_id,
+COALESCE(matched_with, 0) as matched_with,
+requester_name,
+requester_email,
+requester_alias,
+owner_alias,
+created_on
+FROM contacts `
+
+const contactMessageSelect = `SELECT
+id,
+contact_id,
+from_owner,
+message,
+address,
+created_on,
+sent_on
+FROM contact_messages `
+
+func (db *DB) AddContact(contact *domain.Contact) error {
+	requesterAlias, err := newAlias()
+	if err != nil {
+		return err
+	}
+	ownerAlias, err := newAlias()
+	if err != nil {
+		return err
+	}
+
+	// writing about the same record again keeps the conversation and its aliases
+	query := `INSERT INTO contacts(
+		target, target_id, matched_with, requester_name, requester_email, requester_alias, owner_alias)
+		VALUES ($1, $2, NULLIF($3, 0), $4, lower($5), $6, $7)
+		ON CONFLICT (target, target_id, requester_email) DO UPDATE SET
+		requester_name = EXCLUDED.requester_name,
+		matched_with = COALESCE(EXCLUDED.matched_with, contacts.matched_with)
+		RETURNING id, COALESCE(matched_with, 0), requester_email, requester_alias, owner_alias, created_on;`
+
+	return dbError(db.QueryRow(query, contact.Target, contact.TargetID, contact.MatchedWith, contact.RequesterName, contact.RequesterEmail, requesterAlias, ownerAlias).Scan(
+		&contact.ID, &contact.MatchedWith, &contact.RequesterEmail, &contact.RequesterAlias, &contact.OwnerAlias, &contact.CreatedOn))
+}
+
+func (db *DB) GetContact(id int) (*domain.Contact, error) {
+	return db.getContact(contactSelect+"WHERE id = $1", id)
+}
+
+func (db *DB) GetContactByAlias(alias string) (*domain.Contact, error) {
+	return db.getContact(contactSelect+"WHERE requester_alias = lower($1) OR owner_alias = lower($1)", alias)
+}
+
+func (db *DB) getContact(query string, arg interface{}) (*domain.Contact, error) {
+	contact := &domain.Contact{}
+	err := db.Get(contact, query, arg)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return contact, nil
+}
+
+func (db *DB) AddContactMessage(message *domain.ContactMessage) error {
+	query := `INSERT INTO contact_messages(
+		contact_id, from_owner, message, address)
+		VALUES ($1, $2, $3, $4) RETURNING id, created_on;`
+
+	return dbError(db.QueryRow(query, message.ContactID, message.FromOwner, message.Message, message.Address).Scan(&message.ID, &message.CreatedOn))
+}
+
+func (db *DB) GetContactMessage(id int) (*domain.ContactMessage, error) {
+	message := &domain.ContactMessage{}
+	err := db.Get(message, contactMessageSelect+"WHERE id = $1", id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+func (db *DB) MarkContactMessageSent(id int, sentOn time.Time) error {
+	_, err := db.Exec(`UPDATE contact_messages SET sent_on = $1 WHERE id = $2`, sentOn, id)
+	return err
+}
+
+func (db *DB) CountContactMessagesFrom(address string, since time.Time) (int, error) {
+	var count int
+	err := db.Get(&count, `SELECT COUNT(*) FROM contact_messages WHERE address = $1 AND NOT from_owner AND created_on >= $2`, address, since)
+	return count, err
+}
+
+func (db *DB) CountContactMessagesTo(target domain.ModerationTarget, targetID int, since time.Time) (int, error) {
+	var count int
+	err := db.Get(&count, `SELECT COUNT(*) FROM contact_messages
+		JOIN contacts ON contacts.id = contact_messages.contact_id
+		WHERE contacts.target = $1 AND contacts.target_id = $2 AND NOT contact_messages.from_owner AND contact_messages.created_on >= $3`,
+		target, targetID, since)
+	return count, err
+}
+
+// aliases are the local part of an email address, lower case hex so mail servers can't change them
+func newAlias() (string, error) {
+	uuid, err := internal.NewUUID()
+	if err != nil {
+		return "", err
+	}
+	return strings.ReplaceAll(uuid, "-", ""), nil
+}
@@ -8,10 +8,26 @@ import (
 const fileSelect = `SELECT
 id,
 guid,
-COALESCE (content_type, '') as content_type
+COALESCE (content_type, '') as content_type,
+created_on,
+hidden_on
 FROM
 pictures `
 
+const fileCount = `SELECT COUNT(*) FROM pictures `
+
+var fileSortMap = map[string]string{
+	domain.SortDate:    "created_on",
+	domain.SortCreated: "created_on",
+	domain.SortID:      "id",
+}
+
+var fileFieldMap = map[string]string{
+	"id":        "id",
+	"createdon": "created_on",
+	"hiddenon":  "hidden_on",
+}
+
 const addFileSQL = `INSERT INTO pictures
 (id,guid,content_type) VALUES
 (:id,:guid,:content_type) RETURNING id;
@@ -32,6 +48,32 @@ func (db *DB) GetFileMeta(id int) (*domain.FileMeta, error) {
 
 }
 
+func (db *DB) GetFileMetaPage(page domain.Page, filters ...domain.FilterMap) ([]domain.FileMeta, int, error) {
+	whereStr, args, err := db.buildQuery(fileFieldMap, filters...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pageStr, err := getPageStr(fileSortMap, "id", page)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	err = db.Get(&total, fileCount+whereStr, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	files := []domain.FileMeta{}
+	err = db.Select(&files, fileSelect+whereStr+pageStr, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return files, total, nil
+}
+
 func (db *DB) SaveFileMeta(meta *domain.FileMeta) error {
 	rows, err := db.NamedQuery(addFileSQL, meta)
 	if err != nil {
@@ -0,0 +1,71 @@
+package postgres
+
+import (
+	"database/sql"
+	domain "lostpets"
+	"time"
+)
+
+const addJobSQL = `INSERT INTO jobs
+(kind, payload, max_attempts, run_at) VALUES
+($1, $2, $3, $4) RETURNING id;
+`
+
+// claims the oldest due job, running jobs whose lease ran out are picked up again as their worker is gone
+const claimJobSQL = `UPDATE jobs SET
+status='running', attempts=attempts+1, locked_until=now() + $1 * interval '1 millisecond', updated_on=now()
+WHERE id = (
+	SELECT id FROM jobs
+	WHERE (status = 'pending' AND run_at <= now())
+	OR (status = 'running' AND locked_until < now())
+	ORDER BY run_at
+	FOR UPDATE SKIP LOCKED
+	LIMIT 1
+)
+RETURNING id, kind, payload, attempts, max_attempts, run_at, COALESCE(last_error, '') as last_error`
+
+func (db *DB) AddJob(job *domain.Job) error {
+	if job.RunAt.IsZero() {
+		job.RunAt = time.Now()
+	}
+
+	// payload goes in as a string, pq would send []byte as bytea
+	return dbError(db.QueryRow(addJobSQL, job.Kind, string(job.Payload), job.MaxAttempts, job.RunAt).Scan(&job.ID))
+}
+
+func (db *DB) ClaimJob(lease time.Duration) (*domain.Job, error) {
+	job := &domain.Job{}
+	err := db.Get(job, claimJobSQL, lease.Milliseconds())
+	if err == sql.ErrNoRows { //no job due isn't an error
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+func (db *DB) CompleteJob(id int) error {
+	query := `UPDATE jobs SET status='done', locked_until=NULL, updated_on=now() WHERE id = $1`
+
+	_, err := db.Exec(query, id)
+	return err
+}
+
+func (db *DB) RetryJob(id int, runAt time.Time, lastError string) error {
+	query := `UPDATE jobs SET
+	status='pending', run_at=$1, last_error=$2, locked_until=NULL, updated_on=now()
+	WHERE id = $3`
+
+	_, err := db.Exec(query, runAt, lastError, id)
+	return err
+}
+
+func (db *DB) DeadLetterJob(id int, lastError string) error {
+	query := `UPDATE jobs SET
+	status='dead', last_error=$1, locked_until=NULL, updated_on=now()
+	WHERE id = $2`
+
+	_, err := db.Exec(query, lastError, id)
+	return err
+}
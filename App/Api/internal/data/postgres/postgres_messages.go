@@ -0,0 +1,65 @@
+package postgres
+
+import (
+	"database/sql"
+	domain "lostpets"
+	"time"
+)
+
+const matchMessageSelect = `SELECT
+id,
+postings_id as posting_id,
+sightings_id as sighting_id,
+from_posting,
+message,
+created_on,
+read_on,
+notified_on
+FROM match_messages `
+
+func (db *DB) AddMatchMessage(message *domain.MatchMessage) error {
+	return db.InTx(func(tx *Tx) error {
+		query := `INSERT INTO match_messages(
+			postings_id, sightings_id, from_posting, message)
+			VALUES ($1, $2, $3, $4) RETURNING id, created_on;`
+
+		err := tx.QueryRow(query, message.PostingID, message.SightingID, message.FromPosting, message.Message).Scan(&message.ID, &message.CreatedOn)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`UPDATE matches SET last_contacted = $1 WHERE postings_id = $2 AND sightings_id = $3`,
+			message.CreatedOn, message.PostingID, message.SightingID)
+		return err
+	})
+}
+
+func (db *DB) GetMatchMessage(id int) (*domain.MatchMessage, error) {
+	message := &domain.MatchMessage{}
+	err := db.Get(message, matchMessageSelect+"WHERE id = $1", id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+func (db *DB) GetMatchMessages(pID int, sID int) ([]domain.MatchMessage, error) {
+	messages := []domain.MatchMessage{}
+	err := db.Select(&messages, matchMessageSelect+"WHERE postings_id = $1 AND sightings_id = $2 ORDER BY created_on, id", pID, sID)
+	return messages, err
+}
+
+func (db *DB) MarkMatchMessagesRead(pID int, sID int, readerIsPosting bool, readOn time.Time) error {
+	// the reader's side only reads the messages the other side wrote
+	_, err := db.Exec(`UPDATE match_messages SET read_on = $1
+		WHERE postings_id = $2 AND sightings_id = $3 AND from_posting = $4 AND read_on IS NULL`,
+		readOn, pID, sID, !readerIsPosting)
+	return err
+}
+
+func (db *DB) MarkMatchMessageNotified(id int, notifiedOn time.Time) error {
+	_, err := db.Exec(`UPDATE match_messages SET notified_on = $1 WHERE id = $2`, notifiedOn, id)
+	return err
+}
@@ -0,0 +1,155 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	domain "lostpets"
+)
+
+const moderationSelect = `SELECT
+id,
+COALESCE(user_id, 0) as user_id,
+target,
+target_id,
+action,
+reason,
+created_on
+FROM moderation_log `
+
+const moderationCount = `SELECT COUNT(*) FROM moderation_log `
+
+// the table each target is stored in, the target is used as a key so it never gets into the query
+var moderationTables = map[domain.ModerationTarget]string{
+	domain.TargetPosting:  "postings",
+	domain.TargetSighting: "sightings",
+	domain.TargetPicture:  "pictures",
+}
+
+var moderationSortMap = map[string]string{
+	domain.SortDate:    "created_on",
+	domain.SortCreated: "created_on",
+	domain.SortID:      "id",
+}
+
+var moderationFieldMap = map[string]string{
+	"userid":    "user_id",
+	"target":    "target",
+	"targetid":  "target_id",
+	"action":    "action",
+	"createdon": "created_on",
+}
+
+func (db *DB) Moderate(entry *domain.ModerationEntry) error {
+	table, ok := moderationTables[entry.Target]
+	if !ok {
+		return fmt.Errorf("unknown moderation target: %s", entry.Target)
+	}
+
+	return db.InTx(func(tx *Tx) error {
+		var err error
+		switch entry.Action {
+		case domain.ActionHide:
+			// hiding twice keeps the time it was first hidden
+			err = tx.execOne(`UPDATE `+table+` SET hidden_on = COALESCE(hidden_on, now()) WHERE id = $1`, entry.TargetID)
+		case domain.ActionUnhide:
+			err = tx.execOne(`UPDATE `+table+` SET hidden_on = NULL WHERE id = $1`, entry.TargetID)
+		case domain.ActionDelete:
+			if entry.Target == domain.TargetPicture {
+				err = tx.deletePicture(entry.TargetID)
+			} else {
+				err = tx.deleteRecord(table, entry.TargetID)
+			}
+		default:
+			return fmt.Errorf("unknown moderation action: %s", entry.Action)
+		}
+		if err != nil {
+			return err
+		}
+
+		// a moderator has looked at the target, automatic actions leave the reports for them
+		if entry.UserID != 0 {
+			if err := tx.resolveReports(entry.Target, entry.TargetID); err != nil {
+				return err
+			}
+		}
+
+		query := `INSERT INTO moderation_log(
+			user_id, target, target_id, action, reason)
+			VALUES (NULLIF($1, 0), $2, $3, $4, $5) RETURNING id, created_on;`
+
+		return tx.QueryRow(query, entry.UserID, entry.Target, entry.TargetID, entry.Action, entry.Reason).Scan(&entry.ID, &entry.CreatedOn)
+	})
+}
+
+// deleteRecord deletes a posting or sighting through its pet, along with the pet's picture if no other pet uses it
+func (tx *Tx) deleteRecord(table string, id int) error {
+	var petID, pictureID int
+	query := `SELECT pets.id, COALESCE(pets.picture_id, 0) FROM ` + table + ` JOIN pets ON pets.id = ` + table + `.pet_id WHERE ` + table + `.id = $1`
+	err := tx.QueryRow(query, id).Scan(&petID, &pictureID)
+	if err == sql.ErrNoRows {
+		return domain.ErrNotFound
+	} else if err != nil {
+		return err
+	}
+
+	// the record, breeds, tag and matches cascade from the pet
+	if _, err := tx.Exec(`DELETE FROM pets WHERE id = $1`, petID); err != nil {
+		return err
+	}
+
+	if pictureID == 0 {
+		return nil
+	}
+	_, err = tx.Exec(`DELETE FROM pictures WHERE id = $1 AND NOT EXISTS (SELECT 1 FROM pets WHERE picture_id = $1)`, pictureID)
+	return err
+}
+
+// deletePicture removes the picture from any pets using it before deleting it
+func (tx *Tx) deletePicture(id int) error {
+	if _, err := tx.Exec(`UPDATE pets SET picture_id = NULL WHERE picture_id = $1`, id); err != nil {
+		return err
+	}
+	return tx.execOne(`DELETE FROM pictures WHERE id = $1`, id)
+}
+
+// execOne runs the statement and returns ErrNotFound if it didn't change a row
+func (tx *Tx) execOne(query string, args ...interface{}) error {
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
+
+func (db *DB) GetModerationLog(page domain.Page, filters ...domain.FilterMap) ([]domain.ModerationEntry, int, error) {
+	whereStr, args, err := db.buildQuery(moderationFieldMap, filters...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pageStr, err := getPageStr(moderationSortMap, "id", page)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	err = db.Get(&total, moderationCount+whereStr, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	entries := []domain.ModerationEntry{}
+	err = db.Select(&entries, moderationSelect+whereStr+pageStr, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return entries, total, nil
+}
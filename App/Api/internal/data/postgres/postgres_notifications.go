@@ -0,0 +1,145 @@
+package postgres
+
+import (
+	"database/sql"
+	domain "lostpets"
+	"time"
+)
+
+const notificationSelect = `SELECT
+id,
+kind,
+channel,
+recipient,
+secret,
+reply_to,
+subject,
+body,
+content_type,
+status,
+attempts,
+max_attempts,
+run_at,
+COALESCE(last_error, '') as last_error,
+created_on,
+sent_on
+FROM notifications `
+
+const notificationCount = `SELECT COUNT(*) FROM notifications `
+
+// claims the oldest due notification, ones whose lease ran out are picked up again as their sender is gone
+const claimNotificationSQL = `UPDATE notifications SET
+status='sending', attempts=attempts+1, locked_until=now() + $1 * interval '1 millisecond'
+WHERE id = (
+	SELECT id FROM notifications
+	WHERE (status = 'pending' AND run_at <= now())
+	OR (status = 'sending' AND locked_until < now())
+	ORDER BY run_at
+	FOR UPDATE SKIP LOCKED
+	LIMIT 1
+)
+RETURNING id, kind, channel, recipient, secret, reply_to, subject, body, content_type, status, attempts, max_attempts, run_at,
+COALESCE(last_error, '') as last_error, created_on, sent_on`
+
+var notificationSortMap = map[string]string{
+	domain.SortDate:    "created_on",
+	domain.SortCreated: "created_on",
+	domain.SortID:      "id",
+}
+
+var notificationFieldMap = map[string]string{
+	"kind":      "kind",
+	"channel":   "channel",
+	"recipient": "recipient",
+	"status":    "status",
+	"createdon": "created_on",
+	"senton":    "sent_on",
+}
+
+func (db *DB) AddNotification(n *domain.Notification) error {
+	if n.RunAt.IsZero() {
+		n.RunAt = time.Now()
+	}
+
+	if n.Channel == "" {
+		n.Channel = domain.ChannelEmail
+	}
+
+	query := `INSERT INTO notifications(
+		kind, channel, recipient, secret, reply_to, subject, body, content_type, max_attempts, run_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id, status, created_on;`
+
+	return dbError(db.QueryRow(query, n.Kind, n.Channel, n.Recipient, n.Secret, n.ReplyTo, n.Subject, n.Body, n.ContentType, n.MaxAttempts, n.RunAt).Scan(
+		&n.ID, &n.Status, &n.CreatedOn))
+}
+
+func (db *DB) ClaimNotification(lease time.Duration) (*domain.Notification, error) {
+	n := &domain.Notification{}
+	err := db.Get(n, claimNotificationSQL, lease.Milliseconds())
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func (db *DB) MarkNotificationSent(id int, sentOn time.Time) error {
+	_, err := db.Exec(`UPDATE notifications SET status='sent', sent_on=$1, locked_until=NULL WHERE id = $2`, sentOn, id)
+	return err
+}
+
+func (db *DB) RetryNotification(id int, runAt time.Time, lastError string) error {
+	query := `UPDATE notifications SET
+	status='pending', run_at=$1, last_error=$2, locked_until=NULL
+	WHERE id = $3`
+
+	_, err := db.Exec(query, runAt, lastError, id)
+	return err
+}
+
+func (db *DB) DeferNotification(id int, runAt time.Time) error {
+	query := `UPDATE notifications SET
+	status='pending', run_at=$1, attempts=GREATEST(attempts-1, 0), locked_until=NULL
+	WHERE id = $2`
+
+	_, err := db.Exec(query, runAt, id)
+	return err
+}
+
+func (db *DB) FailNotification(id int, lastError string) error {
+	_, err := db.Exec(`UPDATE notifications SET status='failed', last_error=$1, locked_until=NULL WHERE id = $2`, lastError, id)
+	return err
+}
+
+func (db *DB) CountSentTo(recipient string, since time.Time) (int, error) {
+	var count int
+	err := db.Get(&count, `SELECT COUNT(*) FROM notifications WHERE recipient = $1 AND sent_on >= $2`, recipient, since)
+	return count, err
+}
+
+func (db *DB) GetNotificationsPage(page domain.Page, filters ...domain.FilterMap) ([]domain.Notification, int, error) {
+	whereStr, args, err := db.buildQuery(notificationFieldMap, filters...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pageStr, err := getPageStr(notificationSortMap, "id", page)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	err = db.Get(&total, notificationCount+whereStr, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	notifications := []domain.Notification{}
+	err = db.Select(&notifications, notificationSelect+whereStr+pageStr, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return notifications, total, nil
+}
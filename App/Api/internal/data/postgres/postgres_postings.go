@@ -5,6 +5,7 @@ import (
 	"fmt"
 	domain "lostpets"
 	"lostpets/internal"
+	"time"
 
 	"github.com/lib/pq"
 	_ "github.com/lib/pq" //postgres driver
@@ -13,11 +14,13 @@ import (
 type (
 	posting struct {
 		domain.Posting
+		dbCoordinates
 		PetID int
 	}
 
 	internalPostingAggregate struct {
 		domain.Posting
+		dbCoordinates
 		PictureID int
 		PetID     int
 		PetName   string
@@ -30,18 +33,38 @@ type (
 		TagColor  string
 		Text      string
 		PetBreeds pq.StringArray
+		// shadows the embedded NotifyBy so the array can be scanned
+		NotifyBy pq.StringArray
 	}
 )
 
 const postingSelect = `SELECT
 postings.id,
 postings.name,
-email
+email,
 guid,
 date,
 location,
+postings.latitude,
+postings.longitude,
+postings.accuracy_km,
+postings.place_name,
+postings.status,
+COALESCE(postings.reunited_with, 0) as reunited_with,
+postings.created_on,
+postings.reunited_on,
+postings.closed_on,
+postings.expired_on,
+postings.hidden_on,
+postings.pending_on,
+postings.verified_on,
+postings.notify_by,
+postings.phone,
+postings.webhook_url,
+postings.webhook_secret,
+postings.locale,
 pets.id as pet_id,
-picture_id,
+COALESCE(picture_id, 0) as picture_id,
 types.id as type_id,
 types.name as type,
 pets.name as pet_name,
@@ -52,7 +75,9 @@ shape,
 tags.color as tag_color,
 text,
 array_remove(ARRAY_AGG(distinct(pet_breeds.name)),NULL) as pet_breeds
-FROM postings 
+` + postingsFrom
+
+const postingsFrom = `FROM postings 
 LEFT JOIN pets ON pets.id = postings.pet_id
 LEFT JOIN types ON types.id = pets.type_id
 LEFT JOIN pet_breeds ON pets.id = pet_breeds.pet_id
@@ -66,6 +91,24 @@ email,
 guid,
 date,
 location,
+postings.latitude,
+postings.longitude,
+postings.accuracy_km,
+postings.place_name,
+postings.status,
+postings.reunited_with,
+postings.created_on,
+postings.reunited_on,
+postings.closed_on,
+postings.expired_on,
+postings.hidden_on,
+postings.pending_on,
+postings.verified_on,
+postings.notify_by,
+postings.phone,
+postings.webhook_url,
+postings.webhook_secret,
+postings.locale,
 pets.id,
 picture_id,
 types.id,
@@ -78,15 +121,31 @@ tags.id,
 tags.color,
 text  `
 
+const postingsCount = `SELECT COUNT(DISTINCT postings.id) ` + postingsFrom
+
+var postingSortMap = map[string]string{
+	domain.SortDate:     "date",
+	domain.SortID:       "postings.id",
+	domain.SortLocation: "location",
+	domain.SortPetType:  "types.name",
+	domain.SortCreated:  "postings.created_on",
+}
+
 var postingFieldMap = map[string]string{
 	"id":           "postings.id",
 	"name":         "postings.name",
 	"email":        "email",
 	"guid":         "guid",
+	"date":         "date",
 	"location":     "location",
+	"near":         "postings",
+	"status":       "postings.status",
+	"createdon":    "postings.created_on",
+	"hiddenon":     "postings.hidden_on",
 	"petid":        "pets.id",
 	"petpictureid": "picture_id",
 	"pettype":      "types.name",
+	"pettypeid":    "types.id",
 	"petname":      "pets.name",
 	"petcolor":     "pets.color",
 	"petmarks":     "marks",
@@ -113,24 +172,8 @@ func (db *DB) getPosting(filters domain.FilterMap) (*domain.Posting, error) {
 		return nil, err
 	}
 
-	aggregate.Pet = domain.Pet{
-		ID:        aggregate.PetID,
-		PictureID: aggregate.PictureID,
-		Name:      aggregate.PetName,
-		Color:     aggregate.PetColor,
-		Marks:     aggregate.Marks,
-		TypeID:    aggregate.TypeID,
-		Type:      aggregate.Type,
-		Breeds:    aggregate.PetBreeds,
-		Tag: domain.Tag{
-			ID:    aggregate.TagID,
-			Shape: aggregate.Shape,
-			Color: aggregate.TagColor,
-			Text:  aggregate.Text,
-		},
-	}
-
-	return &aggregate.Posting, nil
+	posting := aggregate.toPosting()
+	return &posting, nil
 }
 
 func (db *DB) GetPostingByGUID(guid string) (*domain.Posting, error) {
@@ -146,7 +189,8 @@ func (db *DB) GetPostingByGUID(guid string) (*domain.Posting, error) {
 }
 
 func (db *DB) GetMatchingSightings(id int) ([]domain.Sighting, error) {
-	matchesQuery := matchesSelect + "WHERE postings_id = $1"
+	//dismissed matches aren't shown again
+	matchesQuery := matchesSelect + "WHERE postings_id = $1 AND decision IS DISTINCT FROM 'dismissed'"
 	matches := []matches{}
 
 	err := db.Select((&matches), matchesQuery, id)
@@ -157,6 +201,9 @@ func (db *DB) GetMatchingSightings(id int) ([]domain.Sighting, error) {
 	for _, m := range matches {
 		sightingIDs = append(sightingIDs, m.SightingsID)
 	}
+	if len(sightingIDs) == 0 {
+		return []domain.Sighting{}, nil
+	}
 
 	sFilter := domain.Filter{
 		Comparator: "in",
@@ -164,6 +211,10 @@ func (db *DB) GetMatchingSightings(id int) ([]domain.Sighting, error) {
 	}
 	sFilters := domain.FilterMap{}
 	sFilters["id"] = []domain.Filter{sFilter}
+	//hidden records are left out of the matches shown to owners
+	sFilters["hiddenon"] = []domain.Filter{{Comparator: "is null"}}
+	//records moved back to pending by an email change are left out until verified again
+	sFilters["status"] = []domain.Filter{{Comparator: "!=", Value: string(domain.StatusPending)}}
 
 	sightings, err := db.GetAllSightings(sFilters)
 	if err != nil {
@@ -216,62 +267,239 @@ func (db *DB) GetAllPostings(filters ...domain.FilterMap) ([]domain.Posting, err
 	postings := []domain.Posting{}
 
 	for _, a := range aggregates {
-		a.Pet = domain.Pet{
-			ID:        a.PetID,
-			PictureID: a.PictureID,
-			Name:      a.PetName,
-			Color:     a.PetColor,
-			Marks:     a.Marks,
-			Type:      a.Type,
-			TypeID:    a.TypeID,
-			Breeds:    a.PetBreeds,
-			Tag: domain.Tag{
-				ID:    a.TagID,
-				Shape: a.Shape,
-				Color: a.TagColor,
-				Text:  a.Text,
-			},
-		}
-		postings = append(postings, a.Posting)
+		postings = append(postings, a.toPosting())
 	}
 
 	return postings, nil
 }
 
 func (db *DB) AddPosting(newPosting *domain.Posting) error {
-
 	guid, err := internal.NewUUID()
 	if err != nil {
 		return err
 	}
 	newPosting.GUID = guid
 
-	err = db.addPet(&newPosting.Pet)
+	return db.InTx(func(tx *Tx) error {
+		err := tx.addPet(&newPosting.Pet)
+		if err != nil {
+			return err
+		}
+
+		dbPosting := posting{
+			Posting:       *newPosting,
+			PetID:         newPosting.Pet.ID,
+			dbCoordinates: newDBCoordinates(newPosting.Coordinates),
+		}
+		//records are open unless the caller wants their email verified first
+		if dbPosting.Status == "" {
+			dbPosting.Status = domain.StatusOpen
+		}
+
+		query := `INSERT INTO postings(
+			guid, pet_id, date, location, latitude, longitude, accuracy_km, place_name, name, email, status, pending_on)
+			VALUES (:guid, :pet_id, :date, :location, :latitude, :longitude, :accuracy_km, :place_name, :name, :email,
+			:status, CASE WHEN :status = 'pending' THEN now() END) RETURNING id, status, created_on, pending_on;`
+
+		rows, err := tx.NamedQuery(query, dbPosting)
+		if err != nil {
+			return err
+		}
+
+		defer rows.Close()
+
+		if rows.Next() {
+			return rows.Scan(&newPosting.ID, &newPosting.Status, &newPosting.CreatedOn, &newPosting.PendingOn)
+		}
+		return errID
+	})
+}
+
+func (db *DB) GetPostingsPage(page domain.Page, filters ...domain.FilterMap) ([]domain.Posting, int, error) {
+	whereStr, args, err := db.buildQuery(postingFieldMap, filters...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pageStr, err := getPageStr(postingSortMap, "postings.id", page)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	err = db.Get(&total, postingsCount+whereStr, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	aggregates := []internalPostingAggregate{}
+	err = db.Select((&aggregates), postingSelect+whereStr+postingsGroupBy+pageStr, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	postings := []domain.Posting{}
+	for _, a := range aggregates {
+		postings = append(postings, a.toPosting())
+	}
+
+	return postings, total, nil
+}
+
+func (a internalPostingAggregate) toPosting() domain.Posting {
+	a.Pet = domain.Pet{
+		ID:        a.PetID,
+		PictureID: a.PictureID,
+		Name:      a.PetName,
+		Color:     a.PetColor,
+		Marks:     a.Marks,
+		Type:      a.Type,
+		TypeID:    a.TypeID,
+		Breeds:    a.PetBreeds,
+		Tag: domain.Tag{
+			ID:    a.TagID,
+			Shape: a.Shape,
+			Color: a.TagColor,
+			Text:  a.Text,
+		},
+	}
+	a.Coordinates = a.dbCoordinates.toDomain()
+	a.NotificationPreferences.NotifyBy = toChannels(a.NotifyBy)
+	return a.Posting
+}
+
+func (db *DB) UpdatePosting(update *domain.Posting) error {
+	return db.InTx(func(tx *Tx) error {
+		err := tx.updatePet(&update.Pet)
+		if err != nil {
+			return err
+		}
+
+		dbPosting := posting{
+			Posting:       *update,
+			PetID:         update.Pet.ID,
+			dbCoordinates: newDBCoordinates(update.Coordinates),
+		}
+
+		query := `UPDATE postings SET
+		pet_id=:pet_id, date=:date, location=:location, latitude=:latitude, longitude=:longitude, accuracy_km=:accuracy_km, place_name=:place_name, name=:name, email=:email
+		WHERE id = :id`
+
+		_, err = tx.NamedExec(query, dbPosting)
+		return err
+	})
+}
+
+// DeletePosting deletes the posting the same way moderation does, its picture goes too unless another pet uses it
+func (db *DB) DeletePosting(id int) error {
+	return db.InTx(func(tx *Tx) error {
+		return tx.deleteRecord("postings", id)
+	})
+}
+
+func (db *DB) UpdatePostingCoordinates(id int, coordinates *domain.Coordinates) error {
+	c := newDBCoordinates(coordinates)
+
+	query := `UPDATE postings SET
+	latitude=$1, longitude=$2, accuracy_km=$3, place_name=$4
+	WHERE id = $5`
+
+	_, err := db.Exec(query, c.Latitude, c.Longitude, c.AccuracyKm, c.PlaceName, id)
+	return err
+}
+
+func (db *DB) UpdatePostingNotifications(id int, preferences domain.NotificationPreferences) error {
+	query := `UPDATE postings SET
+	notify_by=$1, phone=$2, webhook_url=$3, webhook_secret=$4, locale=$5
+	WHERE id = $6`
+
+	_, err := db.Exec(query, fromChannels(preferences.NotifyBy), preferences.Phone, preferences.WebhookURL, preferences.WebhookSecret, preferences.Locale, id)
+	return err
+}
+
+func (db *DB) UpdatePostingStatus(id int, status domain.Status, reunitedWith int) error {
+	column, ok := statusColumns[status]
+	if !ok {
+		return domain.ErrStatusTransition
+	}
+
+	var with *int
+	if reunitedWith != 0 {
+		with = &reunitedWith
+	}
+
+	// only the statuses that can change to the new one are updated, e.g. pending postings can be closed but not reunited
+	query := `UPDATE postings SET
+	status=$1, reunited_with=COALESCE($2, reunited_with), ` + column + `=now()
+	WHERE id = $3 AND status = ANY($4)`
+
+	result, err := db.Exec(query, status, with, id, transitionsTo(status))
 	if err != nil {
 		return err
 	}
 
-	dbPosting := posting{
-		Posting: *newPosting,
-		PetID:   newPosting.Pet.ID,
+	return checkStatusUpdated(result)
+}
+
+func (db *DB) VerifyPosting(id int) error {
+	query := `UPDATE postings SET
+	status='open', verified_on=now()
+	WHERE id = $1 AND status = 'pending'`
+
+	result, err := db.Exec(query, id)
+	if err != nil {
+		return err
 	}
+	return checkStatusUpdated(result)
+}
 
-	query := `INSERT INTO postings(
-		guid, pet_id, date, location, name, email)
-		VALUES (:guid, :pet_id, :date, :location, :name, :email) RETURNING id;`
+func (db *DB) UnverifyPosting(id int) error {
+	query := `UPDATE postings SET
+	status='pending', pending_on=now(), verified_on=NULL
+	WHERE id = $1 AND status IN ('open', 'pending')`
 
-	rows, err := db.NamedQuery(query, dbPosting)
+	result, err := db.Exec(query, id)
 	if err != nil {
 		return err
 	}
+	return checkStatusUpdated(result)
+}
+
+func (db *DB) ExpirePendingPostings(pendingBefore time.Time) (int, error) {
+	query := `UPDATE postings SET
+	status='expired', expired_on=now()
+	WHERE status = 'pending' AND pending_on < $1`
+
+	result, err := db.Exec(query, pendingBefore)
+	if err != nil {
+		return 0, err
+	}
 
-	defer rows.Close()
+	count, err := result.RowsAffected()
+	return int(count), err
+}
 
-	if rows.Next() {
-		err = rows.Scan(&newPosting.ID)
-	} else {
-		return errID
+func (db *DB) WarnExpiringPostings(createdBefore time.Time) ([]int, error) {
+	query := `UPDATE postings SET
+	expiry_warned_on=now()
+	WHERE status = 'open' AND created_on < $1 AND expiry_warned_on IS NULL
+	RETURNING id`
+
+	ids := []int{}
+	err := db.Select(&ids, query, createdBefore)
+	return ids, err
+}
+
+func (db *DB) ExpirePostings(createdBefore time.Time) (int, error) {
+	query := `UPDATE postings SET
+	status='expired', expired_on=now()
+	WHERE status = 'open' AND created_on < $1`
+
+	result, err := db.Exec(query, createdBefore)
+	if err != nil {
+		return 0, err
 	}
 
-	return nil
+	count, err := result.RowsAffected()
+	return int(count), err
 }
@@ -0,0 +1,112 @@
+package postgres
+
+import (
+	domain "lostpets"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type reportedRecord struct {
+	domain.ReportedRecord
+	Reasons pq.StringArray
+}
+
+const reportSelect = `SELECT
+id,
+target,
+target_id,
+reporter,
+address,
+reason,
+text,
+created_on,
+resolved_on
+FROM reports `
+
+// records that were deleted since they were reported are left out, the joins only match the report's own table
+const reportQueueFrom = `FROM reports
+LEFT JOIN postings ON reports.target = 'posting' AND postings.id = reports.target_id
+LEFT JOIN sightings ON reports.target = 'sighting' AND sightings.id = reports.target_id
+WHERE reports.resolved_on IS NULL AND (postings.id IS NOT NULL OR sightings.id IS NOT NULL) `
+
+const reportQueueSelect = `SELECT
+reports.target,
+reports.target_id,
+COUNT(*) as reports,
+array_agg(DISTINCT reports.reason) as reasons,
+COALESCE(postings.hidden_on, sightings.hidden_on) IS NOT NULL as hidden,
+MIN(reports.created_on) as first_reported_on,
+MAX(reports.created_on) as last_reported_on
+` + reportQueueFrom + `
+GROUP BY reports.target, reports.target_id, postings.hidden_on, sightings.hidden_on
+ORDER BY reports DESC, last_reported_on DESC, reports.target, reports.target_id `
+
+const reportQueueCount = `SELECT COUNT(DISTINCT (reports.target, reports.target_id)) ` + reportQueueFrom
+
+func (db *DB) AddReport(report *domain.Report) (int, error) {
+	count := 0
+	err := db.InTx(func(tx *Tx) error {
+		// a repeat report from the same reporter or address adds nothing while the first one is open
+		query := `INSERT INTO reports(
+			target, target_id, reporter, address, reason, text)
+			SELECT $1, $2, $3, $4, $5, $6
+			WHERE NOT EXISTS (SELECT 1 FROM reports WHERE target = $1 AND target_id = $2 AND address = $4 AND resolved_on IS NULL)
+			ON CONFLICT (target, target_id, reporter) WHERE resolved_on IS NULL DO NOTHING
+			RETURNING id, created_on;`
+
+		rows, err := tx.Query(query, report.Target, report.TargetID, report.Reporter, report.Address, report.Reason, report.Text)
+		if err != nil {
+			return err
+		}
+		if rows.Next() {
+			err = rows.Scan(&report.ID, &report.CreatedOn)
+		}
+		rows.Close()
+		if err != nil {
+			return err
+		}
+
+		return tx.Get(&count, `SELECT COUNT(*) FROM reports WHERE target = $1 AND target_id = $2 AND resolved_on IS NULL`, report.Target, report.TargetID)
+	})
+	return count, err
+}
+
+func (db *DB) CountReportsFrom(address string, since time.Time) (int, error) {
+	var count int
+	err := db.Get(&count, `SELECT COUNT(*) FROM reports WHERE address = $1 AND created_on >= $2`, address, since)
+	return count, err
+}
+
+func (db *DB) GetReports(target domain.ModerationTarget, targetID int) ([]domain.Report, error) {
+	reports := []domain.Report{}
+	err := db.Select(&reports, reportSelect+`WHERE target = $1 AND target_id = $2 ORDER BY created_on DESC, id DESC`, target, targetID)
+	return reports, err
+}
+
+func (db *DB) GetReportQueue(page domain.Page) ([]domain.ReportedRecord, int, error) {
+	var total int
+	err := db.Get(&total, reportQueueCount)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	records := []reportedRecord{}
+	err = db.Select(&records, reportQueueSelect+`LIMIT $1 OFFSET $2`, page.Limit, page.Offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	queue := []domain.ReportedRecord{}
+	for _, r := range records {
+		r.ReportedRecord.Reasons = r.Reasons
+		queue = append(queue, r.ReportedRecord)
+	}
+	return queue, total, nil
+}
+
+// resolveReports closes the open reports on the target, later reports start a new count
+func (tx *Tx) resolveReports(target domain.ModerationTarget, targetID int) error {
+	_, err := tx.Exec(`UPDATE reports SET resolved_on = now() WHERE target = $1 AND target_id = $2 AND resolved_on IS NULL`, target, targetID)
+	return err
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	domain "lostpets"
 	"lostpets/internal"
+	"time"
 
 	"github.com/lib/pq"
 	_ "github.com/lib/pq" //postgres driver
@@ -12,11 +13,13 @@ import (
 type (
 	sighting struct {
 		domain.Sighting
+		dbCoordinates
 		PetID int
 	}
 
 	internalSightingAggregate struct {
 		domain.Sighting
+		dbCoordinates
 		PictureID int
 		PetID     int
 		PetName   string
@@ -29,6 +32,8 @@ type (
 		TagColor  string
 		Text      string
 		PetBreeds pq.StringArray
+		// shadows the embedded NotifyBy so the array can be scanned
+		NotifyBy pq.StringArray
 	}
 )
 
@@ -36,12 +41,30 @@ const sightingSelect = `SELECT
 sightings.id,
 sightings.name,
 in_custody,
-email
+email,
 guid,
 date,
 location,
+sightings.latitude,
+sightings.longitude,
+sightings.accuracy_km,
+sightings.place_name,
+sightings.status,
+COALESCE(sightings.reunited_with, 0) as reunited_with,
+sightings.created_on,
+sightings.reunited_on,
+sightings.closed_on,
+sightings.expired_on,
+sightings.hidden_on,
+sightings.pending_on,
+sightings.verified_on,
+sightings.notify_by,
+sightings.phone,
+sightings.webhook_url,
+sightings.webhook_secret,
+sightings.locale,
 pets.id as pet_id,
-picture_id,
+COALESCE(picture_id, 0) as picture_id,
 types.id as type_id,
 types.name as type,
 pets.name as pet_name,
@@ -52,7 +75,9 @@ shape,
 tags.color as tag_color,
 text,
 array_remove(ARRAY_AGG(distinct(pet_breeds.name)),NULL) as pet_breeds
-FROM sightings 
+` + sightingsFrom
+
+const sightingsFrom = `FROM sightings 
 LEFT JOIN pets ON pets.id = sightings.pet_id
 LEFT JOIN types ON types.id = pets.type_id
 LEFT JOIN pet_breeds ON pets.id = pet_breeds.pet_id
@@ -67,6 +92,24 @@ email,
 guid,
 date,
 location,
+sightings.latitude,
+sightings.longitude,
+sightings.accuracy_km,
+sightings.place_name,
+sightings.status,
+sightings.reunited_with,
+sightings.created_on,
+sightings.reunited_on,
+sightings.closed_on,
+sightings.expired_on,
+sightings.hidden_on,
+sightings.pending_on,
+sightings.verified_on,
+sightings.notify_by,
+sightings.phone,
+sightings.webhook_url,
+sightings.webhook_secret,
+sightings.locale,
 pets.id,
 picture_id,
 types.id,
@@ -79,16 +122,32 @@ tags.id,
 tags.color,
 text `
 
+const sightingsCount = `SELECT COUNT(DISTINCT sightings.id) ` + sightingsFrom
+
+var sightingSortMap = map[string]string{
+	domain.SortDate:     "date",
+	domain.SortID:       "sightings.id",
+	domain.SortLocation: "location",
+	domain.SortPetType:  "types.name",
+	domain.SortCreated:  "sightings.created_on",
+}
+
 var sightingsFieldMap = map[string]string{
 	"id":           "sightings.id",
 	"name":         "sightings.name",
 	"incustody":    "in_custody",
 	"email":        "email",
 	"guid":         "guid",
+	"date":         "date",
 	"location":     "location",
+	"near":         "sightings",
+	"status":       "sightings.status",
+	"createdon":    "sightings.created_on",
+	"hiddenon":     "sightings.hidden_on",
 	"petid":        "pets.id",
 	"petpictureid": "picture_id",
 	"pettype":      "types.name",
+	"pettypeid":    "types.id",
 	"petname":      "pets.name",
 	"petcolor":     "pets.color",
 	"petmarks":     "marks",
@@ -116,24 +175,8 @@ func (db *DB) getSighting(filters domain.FilterMap) (*domain.Sighting, error) {
 		return nil, err
 	}
 
-	aggregate.Pet = domain.Pet{
-		ID:        aggregate.PetID,
-		PictureID: aggregate.PictureID,
-		Name:      aggregate.PetName,
-		Color:     aggregate.PetColor,
-		Marks:     aggregate.Marks,
-		Type:      aggregate.Type,
-		TypeID:    aggregate.TypeID,
-		Breeds:    aggregate.PetBreeds,
-		Tag: domain.Tag{
-			ID:    aggregate.TagID,
-			Shape: aggregate.Shape,
-			Color: aggregate.TagColor,
-			Text:  aggregate.Text,
-		},
-	}
-
-	return &aggregate.Sighting, nil
+	sighting := aggregate.toSighting()
+	return &sighting, nil
 }
 
 func (db *DB) GetSightingByGUID(guid string) (*domain.Sighting, error) {
@@ -148,7 +191,8 @@ func (db *DB) GetSightingByGUID(guid string) (*domain.Sighting, error) {
 }
 
 func (db *DB) GetMatchingPostings(id int) ([]domain.Posting, error) {
-	matchesQuery := matchesSelect + " WHERE sightings_id = $1 "
+	//dismissed matches aren't shown again
+	matchesQuery := matchesSelect + " WHERE sightings_id = $1 AND decision IS DISTINCT FROM 'dismissed' "
 	matches := []matches{}
 
 	err := db.Select((&matches), matchesQuery, id)
@@ -160,6 +204,9 @@ func (db *DB) GetMatchingPostings(id int) ([]domain.Posting, error) {
 	for _, m := range matches {
 		postingIDs = append(postingIDs, m.PostingsID)
 	}
+	if len(postingIDs) == 0 {
+		return []domain.Posting{}, nil
+	}
 
 	pFilter := domain.Filter{
 		Comparator: "in",
@@ -167,6 +214,10 @@ func (db *DB) GetMatchingPostings(id int) ([]domain.Posting, error) {
 	}
 	pFilters := domain.FilterMap{}
 	pFilters["id"] = []domain.Filter{pFilter}
+	//hidden records are left out of the matches shown to owners
+	pFilters["hiddenon"] = []domain.Filter{{Comparator: "is null"}}
+	//records moved back to pending by an email change are left out until verified again
+	pFilters["status"] = []domain.Filter{{Comparator: "!=", Value: string(domain.StatusPending)}}
 
 	postings, err := db.GetAllPostings(pFilters)
 	if err != nil {
@@ -217,22 +268,7 @@ func (db *DB) GetAllSightings(filters ...domain.FilterMap) ([]domain.Sighting, e
 	sightings := []domain.Sighting{}
 
 	for _, a := range aggregates {
-		a.Pet = domain.Pet{
-			ID:        a.PetID,
-			PictureID: a.PictureID,
-			Name:      a.PetName,
-			Color:     a.PetColor,
-			Marks:     a.Marks,
-			Type:      a.Type,
-			Breeds:    a.PetBreeds,
-			Tag: domain.Tag{
-				ID:    a.TagID,
-				Shape: a.Shape,
-				Color: a.TagColor,
-				Text:  a.Text,
-			},
-		}
-		sightings = append(sightings, a.Sighting)
+		sightings = append(sightings, a.toSighting())
 	}
 
 	return sightings, nil
@@ -245,32 +281,215 @@ func (db *DB) AddSighting(newSighting *domain.Sighting) error {
 	}
 	newSighting.GUID = guid
 
-	err = db.addPet(&newSighting.Pet)
+	return db.InTx(func(tx *Tx) error {
+		err := tx.addPet(&newSighting.Pet)
+		if err != nil {
+			return err
+		}
+
+		dbSighting := sighting{
+			Sighting:      *newSighting,
+			PetID:         newSighting.Pet.ID,
+			dbCoordinates: newDBCoordinates(newSighting.Coordinates),
+		}
+		//records are open unless the caller wants their email verified first
+		if dbSighting.Status == "" {
+			dbSighting.Status = domain.StatusOpen
+		}
+
+		query := `INSERT INTO sightings(
+			guid, pet_id, date, location, latitude, longitude, accuracy_km, place_name, name, email, in_custody, status, pending_on)
+			VALUES (:guid, :pet_id, :date, :location, :latitude, :longitude, :accuracy_km, :place_name, :name, :email, :in_custody,
+			:status, CASE WHEN :status = 'pending' THEN now() END) RETURNING id, status, created_on, pending_on;`
+
+		rows, err := tx.NamedQuery(query, dbSighting)
+		if err != nil {
+			return err
+		}
+
+		defer rows.Close()
+
+		if rows.Next() {
+			return rows.Scan(&newSighting.ID, &newSighting.Status, &newSighting.CreatedOn, &newSighting.PendingOn)
+		}
+		return errID
+	})
+}
+
+func (db *DB) GetSightingsPage(page domain.Page, filters ...domain.FilterMap) ([]domain.Sighting, int, error) {
+	whereStr, args, err := db.buildQuery(sightingsFieldMap, filters...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pageStr, err := getPageStr(sightingSortMap, "sightings.id", page)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	err = db.Get(&total, sightingsCount+whereStr, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	aggregates := []internalSightingAggregate{}
+	err = db.Select((&aggregates), sightingSelect+whereStr+sightingsGroupBy+pageStr, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sightings := []domain.Sighting{}
+	for _, a := range aggregates {
+		sightings = append(sightings, a.toSighting())
+	}
+
+	return sightings, total, nil
+}
+
+func (a internalSightingAggregate) toSighting() domain.Sighting {
+	a.Pet = domain.Pet{
+		ID:        a.PetID,
+		PictureID: a.PictureID,
+		Name:      a.PetName,
+		Color:     a.PetColor,
+		Marks:     a.Marks,
+		Type:      a.Type,
+		TypeID:    a.TypeID,
+		Breeds:    a.PetBreeds,
+		Tag: domain.Tag{
+			ID:    a.TagID,
+			Shape: a.Shape,
+			Color: a.TagColor,
+			Text:  a.Text,
+		},
+	}
+	a.Coordinates = a.dbCoordinates.toDomain()
+	a.NotificationPreferences.NotifyBy = toChannels(a.NotifyBy)
+	return a.Sighting
+}
+
+func (db *DB) UpdateSighting(update *domain.Sighting) error {
+	return db.InTx(func(tx *Tx) error {
+		err := tx.updatePet(&update.Pet)
+		if err != nil {
+			return err
+		}
+
+		dbSighting := sighting{
+			Sighting:      *update,
+			PetID:         update.Pet.ID,
+			dbCoordinates: newDBCoordinates(update.Coordinates),
+		}
+
+		query := `UPDATE sightings SET
+		pet_id=:pet_id, date=:date, location=:location, latitude=:latitude, longitude=:longitude, accuracy_km=:accuracy_km, place_name=:place_name, name=:name, email=:email, in_custody=:in_custody
+		WHERE id = :id`
+
+		_, err = tx.NamedExec(query, dbSighting)
+		return err
+	})
+}
+
+// DeleteSighting deletes the sighting the same way moderation does, its picture goes too unless another pet uses it
+func (db *DB) DeleteSighting(id int) error {
+	return db.InTx(func(tx *Tx) error {
+		return tx.deleteRecord("sightings", id)
+	})
+}
+
+func (db *DB) UpdateSightingCoordinates(id int, coordinates *domain.Coordinates) error {
+	c := newDBCoordinates(coordinates)
+
+	query := `UPDATE sightings SET
+	latitude=$1, longitude=$2, accuracy_km=$3, place_name=$4
+	WHERE id = $5`
+
+	_, err := db.Exec(query, c.Latitude, c.Longitude, c.AccuracyKm, c.PlaceName, id)
+	return err
+}
+
+func (db *DB) UpdateSightingNotifications(id int, preferences domain.NotificationPreferences) error {
+	query := `UPDATE sightings SET
+	notify_by=$1, phone=$2, webhook_url=$3, webhook_secret=$4, locale=$5
+	WHERE id = $6`
+
+	_, err := db.Exec(query, fromChannels(preferences.NotifyBy), preferences.Phone, preferences.WebhookURL, preferences.WebhookSecret, preferences.Locale, id)
+	return err
+}
+
+func (db *DB) UpdateSightingStatus(id int, status domain.Status, reunitedWith int) error {
+	column, ok := statusColumns[status]
+	if !ok {
+		return domain.ErrStatusTransition
+	}
+
+	var with *int
+	if reunitedWith != 0 {
+		with = &reunitedWith
+	}
+
+	// only the statuses that can change to the new one are updated, e.g. pending sightings can be closed but not reunited
+	query := `UPDATE sightings SET
+	status=$1, reunited_with=COALESCE($2, reunited_with), ` + column + `=now()
+	WHERE id = $3 AND status = ANY($4)`
+
+	result, err := db.Exec(query, status, with, id, transitionsTo(status))
 	if err != nil {
 		return err
 	}
 
-	dbSighting := sighting{
-		Sighting: *newSighting,
-		PetID:    newSighting.Pet.ID,
+	return checkStatusUpdated(result)
+}
+
+func (db *DB) VerifySighting(id int) error {
+	query := `UPDATE sightings SET
+	status='open', verified_on=now()
+	WHERE id = $1 AND status = 'pending'`
+
+	result, err := db.Exec(query, id)
+	if err != nil {
+		return err
 	}
+	return checkStatusUpdated(result)
+}
 
-	query := `INSERT INTO sightings(
-		guid, pet_id, date, location, name, email, in_custody)
-		VALUES (:guid, :pet_id, :date, :location, :name, :email, :in_custody) RETURNING id;`
+func (db *DB) UnverifySighting(id int) error {
+	query := `UPDATE sightings SET
+	status='pending', pending_on=now(), verified_on=NULL
+	WHERE id = $1 AND status IN ('open', 'pending')`
 
-	rows, err := db.NamedQuery(query, dbSighting)
+	result, err := db.Exec(query, id)
 	if err != nil {
 		return err
 	}
+	return checkStatusUpdated(result)
+}
 
-	defer rows.Close()
+func (db *DB) ExpirePendingSightings(pendingBefore time.Time) (int, error) {
+	query := `UPDATE sightings SET
+	status='expired', expired_on=now()
+	WHERE status = 'pending' AND pending_on < $1`
 
-	if rows.Next() {
-		err = rows.Scan(&newSighting.ID)
-	} else {
-		return errID
+	result, err := db.Exec(query, pendingBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := result.RowsAffected()
+	return int(count), err
+}
+
+func (db *DB) ExpireSightings(createdBefore time.Time) (int, error) {
+	query := `UPDATE sightings SET
+	status='expired', expired_on=now()
+	WHERE status = 'open' AND created_on < $1`
+
+	result, err := db.Exec(query, createdBefore)
+	if err != nil {
+		return 0, err
 	}
 
-	return nil
+	count, err := result.RowsAffected()
+	return int(count), err
 }
@@ -0,0 +1,93 @@
+package postgres
+
+import (
+	"errors"
+	"lostpets"
+	"regexp"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+// the only shape a single filter is allowed to produce, field names come from the field map and values are always bound
+var safeWhere = regexp.MustCompile(`^ WHERE \((lower\()?[a-z_.]+\)? (IS NULL|IS NOT NULL|(=|!=|<|<=|>|>=|like|not like) \$1|IN \(\$1(, \$[0-9]+)*\))\)$`)
+
+func FuzzBuildQuery(f *testing.F) {
+	db := &DB{DB: sqlx.NewDb(nil, "postgres")}
+
+	f.Add("petType", "=", "dog", 0)
+	f.Add("id", "in", "1", 2)
+	f.Add("location", "like", "%Main%", 0)
+	f.Add("date", "is null", "", 0)
+	f.Add("id = 1 OR 1", "=", "1", 0)
+	f.Add("petName", "= 'a'; DROP TABLE postings; --", "x", 0)
+	f.Add("petName", "=", "'; DROP TABLE postings; --", 1)
+
+	f.Fuzz(func(t *testing.T, key string, comparator string, value string, n int) {
+		var v interface{} = value
+		switch {
+		case n%3 == 1:
+			v = []string{value, value}
+		case n%3 == 2:
+			v = []int{n, len(value)}
+		}
+
+		filters := lostpets.FilterMap{
+			key: {{Comparator: comparator, Value: v}},
+		}
+
+		queryStr, args, err := db.buildQuery(postingFieldMap, filters)
+		if err != nil {
+			var filterErr *lostpets.FilterError
+			if !errors.As(err, &filterErr) && !errors.Is(err, errorEmptyIn) {
+				t.Fatalf("unexpected error type %T: %s", err, err)
+			}
+			return
+		}
+
+		if !safeWhere.MatchString(queryStr) {
+			t.Fatalf("unsafe query for key %q comparator %q: %q", key, comparator, queryStr)
+		}
+
+		for _, a := range args {
+			if a == nil {
+				t.Fatalf("nil arg bound for %q", queryStr)
+			}
+		}
+	})
+}
+
+func TestTransitionsTo(t *testing.T) {
+	assert.Equal(t, pq.StringArray{"pending", "open"}, transitionsTo(lostpets.StatusClosed))
+	assert.Equal(t, pq.StringArray{"pending", "open"}, transitionsTo(lostpets.StatusExpired))
+	assert.Equal(t, pq.StringArray{"open"}, transitionsTo(lostpets.StatusReunited))
+	assert.Equal(t, pq.StringArray{"pending"}, transitionsTo(lostpets.StatusOpen))
+}
+
+func kindOf(err error) lostpets.ErrorKind {
+	var domainErr *lostpets.Error
+	if errors.As(err, &domainErr) {
+		return domainErr.Kind
+	}
+	return ""
+}
+
+func TestDBError(t *testing.T) {
+	assert.NoError(t, dbError(nil))
+
+	plain := errors.New("connection refused")
+	assert.Equal(t, plain, dbError(plain))
+
+	err := dbError(&pq.Error{Code: foreignKeyViolation, Constraint: "missing_fk"})
+	assert.Equal(t, lostpets.KindInvalidInput, kindOf(err))
+	var pqErr *pq.Error
+	assert.ErrorAs(t, err, &pqErr, "the pq error is kept for the logs")
+
+	assert.Equal(t, lostpets.KindConflict, kindOf(dbError(&pq.Error{Code: uniqueViolation})))
+	assert.Equal(t, lostpets.KindInvalidInput, kindOf(dbError(&pq.Error{Code: checkViolation})))
+
+	deadlock := &pq.Error{Code: "40P01"}
+	assert.Equal(t, deadlock, dbError(deadlock))
+}
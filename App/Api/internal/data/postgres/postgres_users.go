@@ -0,0 +1,84 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	domain "lostpets"
+
+	"github.com/lib/pq"
+)
+
+const uniqueViolation = "23505"
+
+const userSelect = `SELECT id, email, name, password_hash, role, created_on FROM users `
+
+func (db *DB) AddUser(user *domain.User) error {
+	query := `INSERT INTO users(
+		email, name, password_hash, role)
+		VALUES ($1, $2, $3, $4) RETURNING id, created_on;`
+
+	err := db.QueryRow(query, user.Email, user.Name, user.PasswordHash, user.Role).Scan(&user.ID, &user.CreatedOn)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return domain.ErrEmailTaken
+	}
+	return err
+}
+
+func (db *DB) GetUserByID(id int) (*domain.User, error) {
+	return db.getUser(userSelect+"WHERE id = $1", id)
+}
+
+func (db *DB) GetUserByEmail(email string) (*domain.User, error) {
+	return db.getUser(userSelect+"WHERE lower(email) = lower($1)", email)
+}
+
+func (db *DB) getUser(query string, arg interface{}) (*domain.User, error) {
+	user := &domain.User{}
+	err := db.Get(user, query, arg)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (db *DB) AddRefreshToken(token *domain.RefreshToken) error {
+	query := `INSERT INTO refresh_tokens(
+		user_id, token_hash, expires_on)
+		VALUES ($1, $2, $3) RETURNING id;`
+
+	return dbError(db.QueryRow(query, token.UserID, token.TokenHash, token.ExpiresOn).Scan(&token.ID))
+}
+
+func (db *DB) GetRefreshToken(tokenHash string) (*domain.RefreshToken, error) {
+	query := `SELECT id, user_id, token_hash, expires_on, revoked_on FROM refresh_tokens WHERE token_hash = $1`
+
+	token := &domain.RefreshToken{}
+	err := db.Get(token, query, tokenHash)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (db *DB) RevokeRefreshToken(id int) error {
+	// only revokes once, a second use of the same token doesn't change a row
+	query := `UPDATE refresh_tokens SET revoked_on = now() WHERE id = $1 AND revoked_on IS NULL`
+
+	result, err := db.Exec(query, id)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return domain.ErrInvalidCredentials
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+//go:build integration
+
+package postgres
+
+import (
+	"lostpets"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClosePendingRecords(t *testing.T) {
+	db := openTestDB(t)
+
+	posting := newTestPosting()
+	posting.Status = lostpets.StatusPending
+	require.NoError(t, db.AddPosting(posting))
+	t.Cleanup(func() { db.DeletePosting(posting.ID) })
+
+	//a pending record can't be reunited, only closed or expired
+	assert.ErrorIs(t, db.UpdatePostingStatus(posting.ID, lostpets.StatusReunited, 0), lostpets.ErrStatusTransition)
+	require.NoError(t, db.UpdatePostingStatus(posting.ID, lostpets.StatusClosed, 0))
+
+	stored, err := db.GetPostingByID(posting.ID)
+	require.NoError(t, err)
+	assert.Equal(t, lostpets.StatusClosed, stored.Status)
+
+	sighting := &lostpets.Sighting{Posting: *newTestPosting()}
+	sighting.Status = lostpets.StatusPending
+	require.NoError(t, db.AddSighting(sighting))
+	t.Cleanup(func() { db.DeleteSighting(sighting.ID) })
+
+	require.NoError(t, db.UpdateSightingStatus(sighting.ID, lostpets.StatusClosed, 0))
+	storedSighting, err := db.GetSightingByID(sighting.ID)
+	require.NoError(t, err)
+	assert.Equal(t, lostpets.StatusClosed, storedSighting.Status)
+}
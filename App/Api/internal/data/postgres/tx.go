@@ -0,0 +1,113 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	domain "lostpets"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Tx is a unit of work, every statement run on it is committed or rolled back together
+type Tx struct {
+	*sqlx.Tx
+}
+
+/*
+InTx runs fn in a single transaction, committing if it returns nil and rolling back otherwise.
+Any write that takes more then one statement should go through here so a failure part way doesn't leave orphaned rows.
+*/
+func (db *DB) InTx(fn func(tx *Tx) error) (err error) {
+	sqlTx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			sqlTx.Rollback()
+			panic(r)
+		}
+		if err != nil {
+			err = dbError(err)
+			if rbErr := sqlTx.Rollback(); rbErr != nil {
+				err = fmt.Errorf("%w (rollback failed: %s)", err, rbErr)
+			}
+			return
+		}
+		err = sqlTx.Commit()
+	}()
+
+	return fn(&Tx{Tx: sqlTx})
+}
+
+// postgres error codes caused by the values written rather than the server
+const (
+	foreignKeyViolation       = "23503"
+	checkViolation            = "23514"
+	invalidTextRepresentation = "22P02"
+	stringDataTooLong         = "22001"
+)
+
+// references that can be broken by the request, by constraint name
+var referenceMessages = map[string]string{
+	"type_fk":    "unknown pet type",
+	"picture_fk": "unknown picture",
+}
+
+/*
+dbError turns a write rejected by a constraint into a domain error so the api can tell the client what was wrong,
+anything else is returned as is
+*/
+func dbError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+
+	switch pqErr.Code {
+	case foreignKeyViolation:
+		message, ok := referenceMessages[pqErr.Constraint]
+		if !ok {
+			message = "refers to a record that doesn't exist"
+		}
+		return domain.InvalidInput("%s", message).Wrap(err)
+	case uniqueViolation:
+		return domain.Conflict("already exists").Wrap(err)
+	case checkViolation, invalidTextRepresentation, stringDataTooLong:
+		return domain.InvalidInput("invalid value").Wrap(err)
+	}
+	return err
+}
+
+// the statements run on the DB outside a transaction go through dbError the same way, so no write returns a raw pq error
+
+func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	result, err := db.DB.Exec(query, args...)
+	return result, dbError(err)
+}
+
+func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	rows, err := db.DB.Query(query, args...)
+	return rows, dbError(err)
+}
+
+func (db *DB) NamedQuery(query string, arg interface{}) (*sqlx.Rows, error) {
+	rows, err := db.DB.NamedQuery(query, arg)
+	return rows, dbError(err)
+}
+
+func (db *DB) NamedExec(query string, arg interface{}) (sql.Result, error) {
+	result, err := db.DB.NamedExec(query, arg)
+	return result, dbError(err)
+}
+
+func (db *DB) Get(dest interface{}, query string, args ...interface{}) error {
+	return dbError(db.DB.Get(dest, query, args...))
+}
+
+func (db *DB) Select(dest interface{}, query string, args ...interface{}) error {
+	return dbError(db.DB.Select(dest, query, args...))
+}
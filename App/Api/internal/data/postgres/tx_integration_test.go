@@ -0,0 +1,167 @@
+//go:build integration
+
+package postgres
+
+import (
+	"errors"
+	"lostpets"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	goose "github.com/pressly/goose"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// run with `LOSTPETS_TEST_DB="<connection string>" go test -tags integration ./internal/data/postgres`, the database is migrated up first
+const testDBEnv = "LOSTPETS_TEST_DB"
+
+const injectedFailure = "injected failure"
+
+func openTestDB(t *testing.T) *DB {
+	connStr := os.Getenv(testDBEnv)
+	if connStr == "" {
+		t.Skipf("%s not set", testDBEnv)
+	}
+
+	db, err := sqlx.Connect("postgres", connStr)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	require.NoError(t, goose.SetDialect("postgres"))
+	require.NoError(t, goose.Up(db.DB, "migrations"))
+
+	db.MapperFunc(underscore)
+	return &DB{DB: db}
+}
+
+/*
+failWrites runs fn with a trigger that fails every insert and update on the table, so a write can be made to fail
+part way without the code under test knowing. The trigger is dropped again before returning.
+*/
+func failWrites(t *testing.T, db *DB, table string, fn func()) {
+	_, err := db.DB.Exec(`CREATE OR REPLACE FUNCTION fail_write() RETURNS trigger AS $$
+		BEGIN RAISE EXCEPTION '` + injectedFailure + `'; END $$ LANGUAGE plpgsql`)
+	require.NoError(t, err)
+	_, err = db.DB.Exec(`CREATE TRIGGER fail_write BEFORE INSERT OR UPDATE ON ` + table + ` FOR EACH ROW EXECUTE PROCEDURE fail_write()`)
+	require.NoError(t, err)
+	defer func() {
+		_, err := db.DB.Exec(`DROP TRIGGER fail_write ON ` + table)
+		require.NoError(t, err)
+	}()
+
+	fn()
+}
+
+// assertInjected checks the write failed on the trigger rather than something else
+func assertInjected(t *testing.T, err error, table string) {
+	require.Error(t, err, table)
+	assert.Contains(t, err.Error(), injectedFailure, table)
+}
+
+func countRows(t *testing.T, db *DB) map[string]int {
+	counts := map[string]int{}
+	for _, table := range []string{"pets", "pet_breeds", "tags", "postings", "sightings"} {
+		var count int
+		require.NoError(t, db.Get(&count, "SELECT COUNT(*) FROM "+table))
+		counts[table] = count
+	}
+	return counts
+}
+
+func newTestPosting() *lostpets.Posting {
+	return &lostpets.Posting{
+		Date:     time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC),
+		Location: "Main St",
+		Name:     "Owner",
+		Email:    "owner@test.com",
+		Pet: lostpets.Pet{
+			TypeID: 1,
+			Name:   "Rex",
+			Color:  "black",
+			Breeds: []string{"lab", "collie"},
+			Tag:    lostpets.Tag{Shape: "bone", Text: "REX"},
+		},
+	}
+}
+
+func TestAddPostingIsAllOrNothing(t *testing.T) {
+	db := openTestDB(t)
+
+	for _, table := range []string{"pets", "pet_breeds", "tags", "postings"} {
+		before := countRows(t, db)
+		failWrites(t, db, table, func() {
+			assertInjected(t, db.AddPosting(newTestPosting()), table)
+		})
+		assert.Equal(t, before, countRows(t, db), "failing at %s should leave no rows", table)
+	}
+
+	before := countRows(t, db)
+	posting := newTestPosting()
+	require.NoError(t, db.AddPosting(posting))
+
+	after := countRows(t, db)
+	assert.Equal(t, before["pets"]+1, after["pets"])
+	assert.Equal(t, before["pet_breeds"]+2, after["pet_breeds"])
+	assert.Equal(t, before["tags"]+1, after["tags"])
+	assert.Equal(t, before["postings"]+1, after["postings"])
+
+	require.NoError(t, db.DeletePosting(posting.ID))
+}
+
+func TestAddSightingIsAllOrNothing(t *testing.T) {
+	db := openTestDB(t)
+
+	for _, table := range []string{"pets", "pet_breeds", "tags", "sightings"} {
+		before := countRows(t, db)
+		failWrites(t, db, table, func() {
+			assertInjected(t, db.AddSighting(&lostpets.Sighting{Posting: *newTestPosting(), InCustody: true}), table)
+		})
+		assert.Equal(t, before, countRows(t, db), "failing at %s should leave no rows", table)
+	}
+}
+
+func TestUpdatePostingIsAllOrNothing(t *testing.T) {
+	db := openTestDB(t)
+
+	posting := newTestPosting()
+	require.NoError(t, db.AddPosting(posting))
+	t.Cleanup(func() { db.DeletePosting(posting.ID) })
+
+	stored, err := db.GetPostingByID(posting.ID)
+	require.NoError(t, err)
+
+	for _, table := range []string{"pets", "pet_breeds", "tags", "postings"} {
+		update := *stored
+		update.Location = "Lakeshore Dr"
+		update.Pet.Color = "brown"
+		update.Pet.Breeds = []string{"poodle"}
+		update.Pet.Tag.Text = "FLUFFY"
+
+		failWrites(t, db, table, func() {
+			assertInjected(t, db.UpdatePosting(&update), table)
+		})
+
+		after, err := db.GetPostingByID(posting.ID)
+		require.NoError(t, err)
+		assert.Equal(t, stored, after, "failing at %s should leave the posting unchanged", table)
+	}
+}
+
+func TestUnknownPetTypeIsInvalidInput(t *testing.T) {
+	db := openTestDB(t)
+	before := countRows(t, db)
+
+	posting := newTestPosting()
+	posting.Pet.TypeID = -1
+	err := db.AddPosting(posting)
+
+	require.Error(t, err)
+	var domainErr *lostpets.Error
+	require.True(t, errors.As(err, &domainErr))
+	assert.Equal(t, lostpets.KindInvalidInput, domainErr.Kind)
+	assert.Equal(t, "unknown pet type", domainErr.Message)
+	assert.Equal(t, before, countRows(t, db))
+}
@@ -0,0 +1,73 @@
+package geocoding
+
+import (
+	domain "lostpets"
+)
+
+// BackfillResult counts the records that were given coordinates and the ones the geocoder couldn't place
+type BackfillResult struct {
+	Postings  int
+	Sightings int
+	Unknown   int
+}
+
+/*
+Backfill geocodes the postings and sightings that don't have coordinates yet, keeping the place's standard name
+next to the owner's location text. Records that already have coordinates are left alone.
+*/
+func Backfill(repo domain.LostPetsRepo, geocoder domain.Geocoder) (BackfillResult, error) {
+	result := BackfillResult{}
+
+	postings, err := repo.GetAllPostings()
+	if err != nil {
+		return result, err
+	}
+	for _, p := range postings {
+		if p.Coordinates != nil {
+			continue
+		}
+		place, err := geocoder.Geocode(p.Location)
+		if err != nil {
+			return result, err
+		}
+		if place == nil {
+			result.Unknown++
+			continue
+		}
+		if err := repo.UpdatePostingCoordinates(p.ID, placeCoordinates(*place)); err != nil {
+			return result, err
+		}
+		result.Postings++
+	}
+
+	sightings, err := repo.GetAllSightings()
+	if err != nil {
+		return result, err
+	}
+	for _, s := range sightings {
+		if s.Coordinates != nil {
+			continue
+		}
+		place, err := geocoder.Geocode(s.Location)
+		if err != nil {
+			return result, err
+		}
+		if place == nil {
+			result.Unknown++
+			continue
+		}
+		if err := repo.UpdateSightingCoordinates(s.ID, placeCoordinates(*place)); err != nil {
+			return result, err
+		}
+		result.Sightings++
+	}
+
+	return result, nil
+}
+
+// placeCoordinates are the place's coordinates with its name
+func placeCoordinates(place domain.Place) *domain.Coordinates {
+	coordinates := place.Coordinates
+	coordinates.Place = place.Name
+	return &coordinates
+}
@@ -0,0 +1,59 @@
+package geocoding
+
+import (
+	"lostpets"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRepo keeps postings and sightings in memory, only the methods used by Backfill are implemented
+type fakeRepo struct {
+	lostpets.LostPetsRepo
+	postings  []lostpets.Posting
+	sightings []lostpets.Sighting
+	// updated is the place name the coordinates were set with, by id
+	updated map[int]string
+}
+
+func (r *fakeRepo) GetAllPostings(filters ...lostpets.FilterMap) ([]lostpets.Posting, error) {
+	return r.postings, nil
+}
+
+func (r *fakeRepo) GetAllSightings(filters ...lostpets.FilterMap) ([]lostpets.Sighting, error) {
+	return r.sightings, nil
+}
+
+func (r *fakeRepo) UpdatePostingCoordinates(id int, coordinates *lostpets.Coordinates) error {
+	r.updated[id] = coordinates.Place
+	return nil
+}
+
+func (r *fakeRepo) UpdateSightingCoordinates(id int, coordinates *lostpets.Coordinates) error {
+	r.updated[id] = coordinates.Place
+	return nil
+}
+
+func TestBackfill(t *testing.T) {
+	g, err := ReadCSV(strings.NewReader(testCSV))
+	require.NoError(t, err)
+
+	repo := &fakeRepo{
+		updated: map[int]string{},
+		postings: []lostpets.Posting{
+			{ID: 1, Location: "main st"},
+			{ID: 2, Location: "somewhere else"},
+			{ID: 3, Location: "riverdale", Coordinates: &lostpets.Coordinates{Lat: 1, Lng: 1}},
+		},
+		sightings: []lostpets.Sighting{
+			{Posting: lostpets.Posting{ID: 10, Location: "by riverdale park"}},
+		},
+	}
+
+	result, err := Backfill(repo, g)
+	assert.NoError(t, err)
+	assert.Equal(t, BackfillResult{Postings: 1, Sightings: 1, Unknown: 1}, result)
+	assert.Equal(t, map[int]string{1: "Main Street", 10: "Riverdale"}, repo.updated)
+}
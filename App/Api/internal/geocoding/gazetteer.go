@@ -0,0 +1,457 @@
+package geocoding
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	domain "lostpets"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type (
+	Config struct {
+		GazetteerPath string `json:"gazetteerPath"` // csv or geojson file of known places, locations aren't geocoded if empty
+	}
+
+	// Gazetteer geocodes locations against a list of known streets, neighbourhoods and postcodes held in memory
+	Gazetteer struct {
+		places    []place
+		postcodes map[string]place
+	}
+
+	place struct {
+		name string
+		kind string
+		// words all have to be in a location to match, optional words (street types) only help pick between matches
+		words       map[string]bool
+		optional    map[string]bool
+		coordinates domain.Coordinates
+	}
+
+	// match is a place found in a location, position is where its first word is in the location so intersections keep their order
+	match struct {
+		place
+		position int
+		optional int
+	}
+)
+
+// place kinds, more specific kinds win when a location names more then one place
+const (
+	KindPostcode      = "postcode"
+	KindStreet        = "street"
+	KindNeighbourhood = "neighbourhood"
+)
+
+var kindRank = map[string]int{
+	KindPostcode:      3,
+	KindStreet:        2,
+	KindNeighbourhood: 1,
+}
+
+// abbreviations so "Main Street" and "main st" are the same place, words mapped to "" are dropped
+var abbreviations = map[string]string{
+	"street":    "st",
+	"avenue":    "ave",
+	"av":        "ave",
+	"road":      "rd",
+	"drive":     "dr",
+	"boulevard": "blvd",
+	"lane":      "ln",
+	"court":     "ct",
+	"place":     "pl",
+	"crescent":  "cres",
+	"north":     "n",
+	"south":     "s",
+	"east":      "e",
+	"west":      "w",
+	"and":       "",
+	"the":       "",
+	"of":        "",
+	"at":        "",
+	"on":        "",
+	"by":        "",
+	"near":      "",
+	"corner":    "",
+}
+
+// street types are often left out ("5th and Main") so they aren't needed for a street to match
+var streetTypes = map[string]bool{
+	"st":   true,
+	"ave":  true,
+	"rd":   true,
+	"dr":   true,
+	"blvd": true,
+	"ln":   true,
+	"ct":   true,
+	"pl":   true,
+	"cres": true,
+}
+
+var wordRegex = regexp.MustCompile(`[a-z0-9]+`)
+
+// NewGeocoder loads the gazetteer in the config, it returns nil if no gazetteer is set
+func NewGeocoder(config Config) (domain.Geocoder, error) {
+	if config.GazetteerPath == "" {
+		return nil, nil
+	}
+	return Load(config.GazetteerPath)
+}
+
+// Load reads a gazetteer from a .csv or .geojson/.json file
+func Load(path string) (*Gazetteer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return ReadCSV(file)
+	case ".geojson", ".json":
+		return ReadGeoJSON(file)
+	default:
+		return nil, fmt.Errorf("unknown gazetteer format: %s, expected .csv or .geojson", path)
+	}
+}
+
+/*
+ReadCSV reads a gazetteer from csv with a header row of name, kind, lat, lng and optionally accuracy_km.
+Columns can be in any order, other columns are ignored.
+*/
+func ReadCSV(r io.Reader) (*Gazetteer, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("gazetteer csv: %w", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"name", "kind", "lat", "lng"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("gazetteer csv: missing %s column", required)
+		}
+	}
+
+	g := newGazetteer()
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("gazetteer csv: %w", err)
+		}
+
+		coordinates := domain.Coordinates{}
+		coordinates.Lat, err = strconv.ParseFloat(record[columns["lat"]], 64)
+		if err != nil {
+			return nil, fmt.Errorf("gazetteer csv line %d: invalid lat: %w", line, err)
+		}
+		coordinates.Lng, err = strconv.ParseFloat(record[columns["lng"]], 64)
+		if err != nil {
+			return nil, fmt.Errorf("gazetteer csv line %d: invalid lng: %w", line, err)
+		}
+		if i, ok := columns["accuracy_km"]; ok && record[i] != "" {
+			coordinates.AccuracyKm, err = strconv.ParseFloat(record[i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("gazetteer csv line %d: invalid accuracy_km: %w", line, err)
+			}
+		}
+
+		if err := g.add(record[columns["name"]], record[columns["kind"]], coordinates); err != nil {
+			return nil, fmt.Errorf("gazetteer csv line %d: %w", line, err)
+		}
+	}
+
+	return g, nil
+}
+
+/*
+ReadGeoJSON reads a gazetteer from a geojson feature collection, each feature needs name and kind properties.
+Lines and polygons are placed at the average of their points with an accuracy that covers all of them,
+an accuracyKm property overrides the accuracy.
+*/
+func ReadGeoJSON(r io.Reader) (*Gazetteer, error) {
+	var collection struct {
+		Features []struct {
+			Geometry struct {
+				Coordinates interface{} `json:"coordinates"`
+			} `json:"geometry"`
+			Properties struct {
+				Name       string   `json:"name"`
+				Kind       string   `json:"kind"`
+				AccuracyKm *float64 `json:"accuracyKm"`
+			} `json:"properties"`
+		} `json:"features"`
+	}
+	if err := json.NewDecoder(r).Decode(&collection); err != nil {
+		return nil, fmt.Errorf("gazetteer geojson: %w", err)
+	}
+
+	g := newGazetteer()
+	for i, feature := range collection.Features {
+		points := positions(feature.Geometry.Coordinates)
+		if len(points) == 0 {
+			return nil, fmt.Errorf("gazetteer geojson feature %d: no coordinates", i)
+		}
+
+		coordinates := centre(points)
+		if feature.Properties.AccuracyKm != nil {
+			coordinates.AccuracyKm = *feature.Properties.AccuracyKm
+		}
+
+		if err := g.add(feature.Properties.Name, feature.Properties.Kind, coordinates); err != nil {
+			return nil, fmt.Errorf("gazetteer geojson feature %d: %w", i, err)
+		}
+	}
+
+	return g, nil
+}
+
+func newGazetteer() *Gazetteer {
+	return &Gazetteer{postcodes: map[string]place{}}
+}
+
+func (g *Gazetteer) add(name, kind string, coordinates domain.Coordinates) error {
+	name = strings.TrimSpace(name)
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	if _, ok := kindRank[kind]; !ok {
+		return fmt.Errorf("unknown kind %q for %s", kind, name)
+	}
+
+	p := place{name: name, kind: kind, coordinates: coordinates, words: map[string]bool{}, optional: map[string]bool{}}
+
+	if kind == KindPostcode {
+		g.postcodes[compact(name)] = p
+		return nil
+	}
+
+	for _, word := range normalize(name) {
+		if kind == KindStreet && streetTypes[word] {
+			p.optional[word] = true
+		} else {
+			p.words[word] = true
+		}
+	}
+	if len(p.words) == 0 {
+		return fmt.Errorf("place has no name")
+	}
+	g.places = append(g.places, p)
+	return nil
+}
+
+// Len is the number of places in the gazetteer
+func (g *Gazetteer) Len() int {
+	return len(g.places) + len(g.postcodes)
+}
+
+/*
+Geocode finds the most specific known place in the location.
+Postcodes win over everything else, two streets are taken as their intersection, then streets win over neighbourhoods.
+*/
+func (g *Gazetteer) Geocode(location string) (*domain.Place, error) {
+	words := normalize(location)
+	if len(words) == 0 {
+		return nil, nil
+	}
+
+	//postcodes are often written with a space in the middle so try each word and each pair
+	for i := range words {
+		if p, ok := g.postcodes[words[i]]; ok {
+			return p.toDomain(), nil
+		}
+		if i+1 < len(words) {
+			if p, ok := g.postcodes[words[i]+words[i+1]]; ok {
+				return p.toDomain(), nil
+			}
+		}
+	}
+
+	matches := g.find(words)
+	if len(matches) == 0 {
+		return nil, nil
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if kindRank[a.kind] != kindRank[b.kind] {
+			return kindRank[a.kind] > kindRank[b.kind]
+		}
+		if len(a.words) != len(b.words) {
+			return len(a.words) > len(b.words)
+		}
+		if a.optional != b.optional {
+			return a.optional > b.optional
+		}
+		return a.position < b.position
+	})
+
+	best := matches[0]
+	if best.kind == KindStreet {
+		//streets sharing a name (Main St and Main Rd) are the same street written differently, not an intersection
+		for _, other := range matches[1:] {
+			if other.kind == KindStreet && !overlaps(best.words, other.words) {
+				return intersection(best, other), nil
+			}
+		}
+	}
+	return best.toDomain(), nil
+}
+
+// find returns the places with every word in the location, leaving out places that are part of a longer match
+func (g *Gazetteer) find(words []string) []match {
+	positions := map[string]int{}
+	for i, word := range words {
+		if _, ok := positions[word]; !ok {
+			positions[word] = i
+		}
+	}
+
+	found := []match{}
+	for _, p := range g.places {
+		position := len(words)
+		all := true
+		for word := range p.words {
+			i, ok := positions[word]
+			if !ok {
+				all = false
+				break
+			}
+			if i < position {
+				position = i
+			}
+		}
+		if !all {
+			continue
+		}
+
+		optional := 0
+		for word := range p.optional {
+			if _, ok := positions[word]; ok {
+				optional++
+			}
+		}
+		found = append(found, match{place: p, position: position, optional: optional})
+	}
+
+	result := []match{}
+	for i, m := range found {
+		covered := false
+		for j, other := range found {
+			if i != j && len(other.words) > len(m.words) && subset(m.words, other.words) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// intersection is half way between the two streets, written in the order they were given
+func intersection(a, b match) *domain.Place {
+	if b.position < a.position {
+		a, b = b, a
+	}
+
+	mid := domain.Coordinates{
+		Lat: (a.coordinates.Lat + b.coordinates.Lat) / 2,
+		Lng: (a.coordinates.Lng + b.coordinates.Lng) / 2,
+	}
+	mid.AccuracyKm = math.Max(math.Max(a.coordinates.AccuracyKm, b.coordinates.AccuracyKm), mid.DistanceKm(a.coordinates))
+
+	return &domain.Place{Name: a.name + " & " + b.name, Coordinates: mid}
+}
+
+func (p place) toDomain() *domain.Place {
+	return &domain.Place{Name: p.name, Coordinates: p.coordinates}
+}
+
+// normalize splits text into lower case words, applying abbreviations
+func normalize(text string) []string {
+	words := []string{}
+	for _, word := range wordRegex.FindAllString(strings.ToLower(text), -1) {
+		if short, ok := abbreviations[word]; ok {
+			word = short
+		}
+		if word != "" {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+func compact(text string) string {
+	return strings.Join(wordRegex.FindAllString(strings.ToLower(text), -1), "")
+}
+
+func overlaps(a, b map[string]bool) bool {
+	for word := range a {
+		if b[word] {
+			return true
+		}
+	}
+	return false
+}
+
+func subset(a, b map[string]bool) bool {
+	for word := range a {
+		if !b[word] {
+			return false
+		}
+	}
+	return true
+}
+
+// positions collects the [lng, lat] pairs from any geojson geometry
+func positions(coordinates interface{}) []domain.Coordinates {
+	list, ok := coordinates.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	if len(list) >= 2 {
+		lng, lngOk := list[0].(float64)
+		lat, latOk := list[1].(float64)
+		if lngOk && latOk {
+			return []domain.Coordinates{{Lat: lat, Lng: lng}}
+		}
+	}
+
+	points := []domain.Coordinates{}
+	for _, item := range list {
+		points = append(points, positions(item)...)
+	}
+	return points
+}
+
+// centre is the average of the points, with an accuracy that reaches the furthest point
+func centre(points []domain.Coordinates) domain.Coordinates {
+	c := domain.Coordinates{}
+	for _, p := range points {
+		c.Lat += p.Lat
+		c.Lng += p.Lng
+	}
+	c.Lat /= float64(len(points))
+	c.Lng /= float64(len(points))
+
+	for _, p := range points {
+		c.AccuracyKm = math.Max(c.AccuracyKm, c.DistanceKm(p))
+	}
+	return c
+}
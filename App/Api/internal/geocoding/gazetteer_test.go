@@ -0,0 +1,135 @@
+package geocoding
+
+import (
+	"lostpets"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testCSV = `name,kind,lat,lng,accuracy_km
+Main Street,street,43.6500,-79.3800,0.5
+5th Avenue,street,43.6600,-79.3900,0.5
+Main Road,street,43.7000,-79.4000,0.5
+Main Street East,street,43.6550,-79.3500,1
+Riverdale,neighbourhood,43.6700,-79.3500,2
+M5V 2T6,postcode,43.6426,-79.3871,0.2
+`
+
+const testGeoJSON = `{
+  "type": "FeatureCollection",
+  "features": [
+    {
+      "type": "Feature",
+      "geometry": {"type": "Point", "coordinates": [-79.35, 43.67]},
+      "properties": {"name": "Riverdale", "kind": "neighbourhood", "accuracyKm": 2}
+    },
+    {
+      "type": "Feature",
+      "geometry": {"type": "LineString", "coordinates": [[-79.40, 43.65], [-79.36, 43.65]]},
+      "properties": {"name": "Lakeshore Drive", "kind": "street"}
+    }
+  ]
+}`
+
+func TestGeocode(t *testing.T) {
+	g, err := ReadCSV(strings.NewReader(testCSV))
+	require.NoError(t, err)
+	assert.Equal(t, 6, g.Len())
+
+	type test struct {
+		name     string
+		location string
+		expected string
+	}
+
+	tests := []test{
+		test{
+			name:     "Should find a street written differently",
+			location: "near the corner of main st",
+			expected: "Main Street",
+		},
+		test{
+			name:     "Should prefer the street with the same street type",
+			location: "main rd",
+			expected: "Main Road",
+		},
+		test{
+			name:     "Should prefer the longer street name",
+			location: "Main St E by the school",
+			expected: "Main Street East",
+		},
+		test{
+			name:     "Should take two streets as the intersection in the order given",
+			location: "5th and Main",
+			expected: "5th Avenue & Main Street",
+		},
+		test{
+			name:     "Should prefer a street to a neighbourhood",
+			location: "Main St in Riverdale",
+			expected: "Main Street",
+		},
+		test{
+			name:     "Should prefer a postcode to everything",
+			location: "Main St, m5v 2t6",
+			expected: "M5V 2T6",
+		},
+		test{
+			name:     "Should find nothing for unknown places",
+			location: "Lakeshore Drive",
+		},
+	}
+
+	for _, test := range tests {
+		place, err := g.Geocode(test.location)
+		assert.NoError(t, err, test.name)
+		if test.expected == "" {
+			assert.Nil(t, place, test.name)
+			continue
+		}
+		if assert.NotNil(t, place, test.name) {
+			assert.Equal(t, test.expected, place.Name, test.name)
+		}
+	}
+}
+
+func TestIntersectionCoordinates(t *testing.T) {
+	g, err := ReadCSV(strings.NewReader(testCSV))
+	require.NoError(t, err)
+
+	place, err := g.Geocode("Main Street & 5th Avenue")
+	require.NoError(t, err)
+	assert.InDelta(t, 43.655, place.Coordinates.Lat, 0.0001)
+	assert.InDelta(t, -79.385, place.Coordinates.Lng, 0.0001)
+	// the streets are about 1.4km apart so the intersection could be 0.7km either way
+	assert.InDelta(t, 0.68, place.Coordinates.AccuracyKm, 0.01)
+}
+
+func TestReadGeoJSON(t *testing.T) {
+	g, err := ReadGeoJSON(strings.NewReader(testGeoJSON))
+	require.NoError(t, err)
+
+	place, err := g.Geocode("lakeshore dr")
+	require.NoError(t, err)
+	assert.Equal(t, "Lakeshore Drive", place.Name)
+	assert.InDelta(t, -79.38, place.Coordinates.Lng, 0.0001)
+	// the line is about 3.2km long so the centre is about 1.6km from each end
+	assert.InDelta(t, 1.6, place.Coordinates.AccuracyKm, 0.05)
+
+	place, err = g.Geocode("Riverdale")
+	require.NoError(t, err)
+	assert.Equal(t, lostpets.Coordinates{Lat: 43.67, Lng: -79.35, AccuracyKm: 2}, place.Coordinates)
+}
+
+func TestReadCSVErrors(t *testing.T) {
+	_, err := ReadCSV(strings.NewReader("name,lat,lng\nMain,1,2\n"))
+	assert.EqualError(t, err, "gazetteer csv: missing kind column")
+
+	_, err = ReadCSV(strings.NewReader("name,kind,lat,lng\nMain,street,north,2\n"))
+	assert.Contains(t, err.Error(), "gazetteer csv line 2: invalid lat")
+
+	_, err = ReadCSV(strings.NewReader("name,kind,lat,lng\nMain,city,1,2\n"))
+	assert.EqualError(t, err, `gazetteer csv line 2: unknown kind "city" for Main`)
+}
@@ -0,0 +1,622 @@
+package http
+
+import (
+	"fmt"
+	domain "lostpets"
+	"lostpets/internal/notifications"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type (
+	adminHandler struct {
+		logger     domain.StructuredLogger
+		router     *echo.Group
+		repo       domain.LostPetsRepo
+		moderation domain.ModerationRepo
+		reports    domain.ReportRepo
+		outbox     domain.NotificationRepo
+		templates  *notifications.Registry
+		fileRepo   domain.FileRepo
+		fileStore  domain.FileStore
+		jobs       domain.JobQueue
+	}
+
+	apiModeration struct {
+		Action string `json:"action"`
+		Reason string `json:"reason"`
+	}
+
+	apiModerationEntry struct {
+		ID        int       `json:"id"`
+		UserID    int       `json:"userId,omitempty"`
+		Target    string    `json:"target"`
+		TargetID  int       `json:"targetId"`
+		Action    string    `json:"action"`
+		Reason    string    `json:"reason"`
+		CreatedOn time.Time `json:"createdOn"`
+	}
+
+	apiAdminPosting struct {
+		apiPostPosting
+		CreatedOn time.Time  `json:"createdOn"`
+		HiddenOn  *time.Time `json:"hiddenOn,omitempty"`
+	}
+
+	apiAdminSighting struct {
+		apiPostSighting
+		CreatedOn time.Time  `json:"createdOn"`
+		HiddenOn  *time.Time `json:"hiddenOn,omitempty"`
+	}
+
+	apiReportedRecord struct {
+		Target          string    `json:"target"`
+		TargetID        int       `json:"targetId"`
+		Reports         int       `json:"reports"`
+		Reasons         []string  `json:"reasons"`
+		Hidden          bool      `json:"hidden"`
+		FirstReportedOn time.Time `json:"firstReportedOn"`
+		LastReportedOn  time.Time `json:"lastReportedOn"`
+	}
+
+	apiAdminReport struct {
+		ID         int        `json:"id"`
+		Reporter   string     `json:"reporter"`
+		Reason     string     `json:"reason"`
+		Text       string     `json:"text,omitempty"`
+		CreatedOn  time.Time  `json:"createdOn"`
+		ResolvedOn *time.Time `json:"resolvedOn,omitempty"`
+	}
+
+	// apiMatchFeedback counts the matches scored from minScore up to maxScore and what owners said about them
+	apiMatchFeedback struct {
+		MinScore  float64 `json:"minScore"`
+		MaxScore  float64 `json:"maxScore"`
+		Matches   int     `json:"matches"`
+		Confirmed int     `json:"confirmed"`
+		Dismissed int     `json:"dismissed"`
+	}
+
+	// apiEmailPreview is an email template rendered with sample data
+	apiEmailPreview struct {
+		Template string `json:"template"`
+		Locale   string `json:"locale,omitempty"`
+		Subject  string `json:"subject"`
+		Text     string `json:"text"`
+		HTML     string `json:"html,omitempty"`
+	}
+
+	apiNotification struct {
+		ID          int        `json:"id"`
+		Kind        string     `json:"kind"`
+		Channel     string     `json:"channel"`
+		Recipient   string     `json:"recipient"`
+		Subject     string     `json:"subject"`
+		Status      string     `json:"status"`
+		Attempts    int        `json:"attempts"`
+		MaxAttempts int        `json:"maxAttempts"`
+		RunAt       time.Time  `json:"runAt"`
+		LastError   string     `json:"lastError,omitempty"`
+		CreatedOn   time.Time  `json:"createdOn"`
+		SentOn      *time.Time `json:"sentOn,omitempty"`
+	}
+
+	apiPicture struct {
+		ID          int        `json:"id"`
+		ContentType string     `json:"contentType"`
+		CreatedOn   time.Time  `json:"createdOn"`
+		HiddenOn    *time.Time `json:"hiddenOn,omitempty"`
+	}
+)
+
+const (
+	adminPath = "/admin"
+
+	// hidden=true lists only hidden records, hidden=false only visible ones
+	paramHidden = "hidden"
+)
+
+// admins can also filter on the private fields and see records of every status
+var adminPostingQueryFields = postingQueryFields.with(queryFields{
+	"name":      kindString,
+	"email":     kindString,
+	"createdon": kindDate,
+})
+
+var adminSightingQueryFields = sightingQueryFields.with(queryFields{
+	"name":      kindString,
+	"email":     kindString,
+	"createdon": kindDate,
+})
+
+var pictureQueryFields = queryFields{
+	"id":        kindInt,
+	"createdon": kindDate,
+}
+
+var moderationQueryFields = queryFields{
+	"userid":    kindInt,
+	"target":    kindString,
+	"targetid":  kindInt,
+	"action":    kindString,
+	"createdon": kindDate,
+}
+
+var notificationQueryFields = queryFields{
+	"kind":      kindString,
+	"channel":   kindString,
+	"recipient": kindString,
+	"status":    kindString,
+	"createdon": kindDate,
+	"senton":    kindDate,
+}
+
+var adminListParams = append([]string{paramHidden}, listParams...)
+
+var moderationActions = []string{string(domain.ActionHide), string(domain.ActionUnhide), string(domain.ActionDelete)}
+
+func (h *adminHandler) initRoute() {
+	h.router.GET(postingsPath, h.handleGetPostings())
+	h.router.GET(sightingsPath, h.handleGetSightings())
+	h.router.GET(filePath, h.handleGetPictures())
+	h.router.GET(filePath+"/:id", h.handleServePicture())
+	h.router.GET("/moderation-log", h.handleGetModerationLog())
+	h.router.GET("/reports", h.handleGetReportQueue())
+	h.router.GET("/match-feedback", h.handleGetMatchFeedback())
+	h.router.GET("/notifications", h.handleGetNotifications())
+	h.router.GET("/email-preview/:template", h.handleEmailPreview())
+	h.router.GET(postingsPath+"/:id/reports", h.handleGetReports(domain.TargetPosting))
+	h.router.GET(sightingsPath+"/:id/reports", h.handleGetReports(domain.TargetSighting))
+
+	h.router.POST(postingsPath+"/:id/moderation", h.handleModerate(domain.TargetPosting))
+	h.router.POST(sightingsPath+"/:id/moderation", h.handleModerate(domain.TargetSighting))
+	h.router.POST(filePath+"/:id/moderation", h.handleModerate(domain.TargetPicture))
+}
+
+// handleGetPostings lists postings of any status, hidden or not, newest first unless another sort is asked for
+func (h *adminHandler) handleGetPostings() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		filters, page, err := parseAdminList(c.QueryParams(), adminPostingQueryFields, recordSortFields)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+
+		postings, total, err := h.repo.GetPostingsPage(page, filters...)
+		if err != nil {
+			return err
+		}
+
+		apiPostings := []apiAdminPosting{}
+		for _, p := range postings {
+			apiPostings = append(apiPostings, apiAdminPosting{
+				apiPostPosting: *toAPIPostPosting(p),
+				CreatedOn:      p.CreatedOn,
+				HiddenOn:       p.HiddenOn,
+			})
+		}
+
+		resp := response{
+			Data: apiPostings,
+			Meta: newPageMeta(c.Request().URL, page, total),
+		}
+		return c.JSON(http.StatusOK, resp)
+	}
+}
+
+func (h *adminHandler) handleGetSightings() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		filters, page, err := parseAdminList(c.QueryParams(), adminSightingQueryFields, recordSortFields)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+
+		sightings, total, err := h.repo.GetSightingsPage(page, filters...)
+		if err != nil {
+			return err
+		}
+
+		apiSightings := []apiAdminSighting{}
+		for _, s := range sightings {
+			apiSightings = append(apiSightings, apiAdminSighting{
+				apiPostSighting: *toAPIPostSighting(s),
+				CreatedOn:       s.CreatedOn,
+				HiddenOn:        s.HiddenOn,
+			})
+		}
+
+		resp := response{
+			Data: apiSightings,
+			Meta: newPageMeta(c.Request().URL, page, total),
+		}
+		return c.JSON(http.StatusOK, resp)
+	}
+}
+
+func (h *adminHandler) handleGetPictures() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		filters, page, err := parseAdminList(c.QueryParams(), pictureQueryFields, createdSortFields)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+
+		files, total, err := h.fileRepo.GetFileMetaPage(page, filters...)
+		if err != nil {
+			return err
+		}
+
+		pictures := []apiPicture{}
+		for _, f := range files {
+			pictures = append(pictures, apiPicture{ID: f.ID, ContentType: f.ContentType, CreatedOn: f.CreatedOn, HiddenOn: f.HiddenOn})
+		}
+
+		resp := response{
+			Data: pictures,
+			Meta: newPageMeta(c.Request().URL, page, total),
+		}
+		return c.JSON(http.StatusOK, resp)
+	}
+}
+
+// handleServePicture serves pictures whether they are hidden or not, so moderators can check them
+func (h *adminHandler) handleServePicture() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid id")
+		}
+
+		fileMeta, err := h.fileRepo.GetFileMeta(id)
+		if err != nil {
+			return err
+		}
+		if fileMeta == nil {
+			return c.NoContent(http.StatusNotFound)
+		}
+
+		filePath, err := h.fileStore.GetFile(fileMeta.GUID)
+		if err != nil {
+			return err
+		}
+		return c.File(filePath)
+	}
+}
+
+func (h *adminHandler) handleGetModerationLog() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		filters, err := parseFilters(c.QueryParams(), moderationQueryFields, pageParams...)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+
+		page, err := parseAdminPage(c.QueryParams(), createdSortFields)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+
+		entries, total, err := h.moderation.GetModerationLog(page, filters...)
+		if err != nil {
+			return err
+		}
+
+		apiEntries := []apiModerationEntry{}
+		for _, e := range entries {
+			apiEntries = append(apiEntries, toAPIModerationEntry(e))
+		}
+
+		resp := response{
+			Data: apiEntries,
+			Meta: newPageMeta(c.Request().URL, page, total),
+		}
+		return c.JSON(http.StatusOK, resp)
+	}
+}
+
+// handleGetNotifications lists the outbox newest first, filter on status to see what is pending or failed
+func (h *adminHandler) handleGetNotifications() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		filters, err := parseFilters(c.QueryParams(), notificationQueryFields, pageParams...)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+
+		page, err := parseAdminPage(c.QueryParams(), createdSortFields)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+
+		notifications, total, err := h.outbox.GetNotificationsPage(page, filters...)
+		if err != nil {
+			return err
+		}
+
+		apiNotifications := []apiNotification{}
+		for _, n := range notifications {
+			apiNotifications = append(apiNotifications, apiNotification{
+				ID:          n.ID,
+				Kind:        n.Kind,
+				Channel:     string(n.Channel),
+				Recipient:   n.Recipient,
+				Subject:     n.Subject,
+				Status:      string(n.Status),
+				Attempts:    n.Attempts,
+				MaxAttempts: n.MaxAttempts,
+				RunAt:       n.RunAt,
+				LastError:   n.LastError,
+				CreatedOn:   n.CreatedOn,
+				SentOn:      n.SentOn,
+			})
+		}
+
+		resp := response{
+			Data: apiNotifications,
+			Meta: newPageMeta(c.Request().URL, page, total),
+		}
+		return c.JSON(http.StatusOK, resp)
+	}
+}
+
+/*
+handleEmailPreview renders a template with sample data so designers can check it without sending anything.
+?locale= picks the locale, ?format=html or text returns that part on its own for viewing in a browser.
+*/
+func (h *adminHandler) handleEmailPreview() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		event := notifications.Event(c.Param("template"))
+		sample, ok := notifications.Sample(event)
+		if !ok {
+			valid := []string{}
+			for _, e := range notifications.Events() {
+				valid = append(valid, string(e))
+			}
+			return echo.NewHTTPError(http.StatusNotFound, &queryError{Message: "unknown template", Param: "template", Valid: valid})
+		}
+
+		locale := c.QueryParam("locale")
+		message, err := h.templates.Render(event, locale, sample)
+		if err != nil {
+			return err
+		}
+
+		switch c.QueryParam("format") {
+		case "html":
+			if message.HTML == "" {
+				return echo.NewHTTPError(http.StatusNotFound, "template has no html part")
+			}
+			return c.HTML(http.StatusOK, message.HTML)
+		case "text":
+			return c.String(http.StatusOK, message.Text)
+		}
+		return c.JSON(http.StatusOK, response{Data: apiEmailPreview{
+			Template: string(event),
+			Locale:   locale,
+			Subject:  message.Subject,
+			Text:     message.Text,
+			HTML:     message.HTML,
+		}})
+	}
+}
+
+// handleGetMatchFeedback counts the confirmed and dismissed matches in each band of score, for tuning the match threshold
+func (h *adminHandler) handleGetMatchFeedback() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		feedback, err := h.repo.GetMatchFeedback()
+		if err != nil {
+			return err
+		}
+
+		apiFeedback := []apiMatchFeedback{}
+		for _, f := range feedback {
+			apiFeedback = append(apiFeedback, apiMatchFeedback{
+				MinScore:  f.MinScore,
+				MaxScore:  f.MaxScore,
+				Matches:   f.Matches,
+				Confirmed: f.Confirmed,
+				Dismissed: f.Dismissed,
+			})
+		}
+		return c.JSON(http.StatusOK, response{Data: apiFeedback})
+	}
+}
+
+// handleGetReportQueue lists the records with open reports, most reported first
+func (h *adminHandler) handleGetReportQueue() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		page, err := parsePage(c.QueryParams(), nil)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+
+		records, total, err := h.reports.GetReportQueue(page)
+		if err != nil {
+			return err
+		}
+
+		queue := []apiReportedRecord{}
+		for _, r := range records {
+			queue = append(queue, apiReportedRecord{
+				Target:          string(r.Target),
+				TargetID:        r.TargetID,
+				Reports:         r.Reports,
+				Reasons:         r.Reasons,
+				Hidden:          r.Hidden,
+				FirstReportedOn: r.FirstReportedOn,
+				LastReportedOn:  r.LastReportedOn,
+			})
+		}
+
+		resp := response{
+			Data: queue,
+			Meta: newPageMeta(c.Request().URL, page, total),
+		}
+		return c.JSON(http.StatusOK, resp)
+	}
+}
+
+// handleGetReports lists every report on the record, resolved ones included
+func (h *adminHandler) handleGetReports(target domain.ModerationTarget) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid id")
+		}
+
+		reports, err := h.reports.GetReports(target, id)
+		if err != nil {
+			return err
+		}
+
+		apiReports := []apiAdminReport{}
+		for _, r := range reports {
+			apiReports = append(apiReports, apiAdminReport{
+				ID:         r.ID,
+				Reporter:   r.Reporter,
+				Reason:     string(r.Reason),
+				Text:       r.Text,
+				CreatedOn:  r.CreatedOn,
+				ResolvedOn: r.ResolvedOn,
+			})
+		}
+		return c.JSON(http.StatusOK, response{Data: apiReports})
+	}
+}
+
+/*
+handleModerate hides, unhides or deletes the target, the action and reason are written to the moderation log
+and the open reports on the target are resolved.
+Deleting a posting or sighting deletes its picture, the picture's file is removed once the rows are gone.
+Unhidden postings and sightings are matched again as they were skipped while hidden.
+*/
+func (h *adminHandler) handleModerate(target domain.ModerationTarget) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid id")
+		}
+
+		moderation := new(apiModeration)
+		if err := c.Bind(moderation); err != nil {
+			return err
+		}
+
+		action := domain.ModerationAction(strings.ToLower(moderation.Action))
+		if !contains(moderationActions, string(action)) {
+			return echo.NewHTTPError(http.StatusBadRequest, &queryError{Message: "unknown action", Param: "action", Valid: moderationActions})
+		}
+		reason := strings.TrimSpace(moderation.Reason)
+		if reason == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "a reason is required")
+		}
+
+		var picture *domain.FileMeta
+		if action == domain.ActionDelete {
+			picture, err = h.pictureOf(target, id)
+			if err != nil {
+				return err
+			}
+		}
+
+		entry := &domain.ModerationEntry{
+			UserID:   principal(c).UserID,
+			Target:   target,
+			TargetID: id,
+			Action:   action,
+			Reason:   reason,
+		}
+		if err := h.moderation.Moderate(entry); err != nil {
+			return err
+		}
+		h.logger.Info("user %d: %s %s %d: %s", entry.UserID, entry.Action, entry.Target, entry.TargetID, entry.Reason)
+
+		if picture != nil {
+			removePictureFile(h.fileRepo, h.fileStore, h.logger, picture)
+		}
+
+		if action == domain.ActionUnhide {
+			switch target {
+			case domain.TargetPosting:
+				queueMatch(h.jobs, h.logger, jobMatchPosting, id)
+			case domain.TargetSighting:
+				queueMatch(h.jobs, h.logger, jobMatchSighting, id)
+			}
+		}
+
+		return c.JSON(http.StatusOK, response{Data: toAPIModerationEntry(*entry)})
+	}
+}
+
+// pictureOf returns the picture that goes when the target is deleted, nil if there isn't one
+func (h *adminHandler) pictureOf(target domain.ModerationTarget, id int) (*domain.FileMeta, error) {
+	pictureID := 0
+	switch target {
+	case domain.TargetPicture:
+		pictureID = id
+	case domain.TargetPosting:
+		posting, err := h.repo.GetPostingByID(id)
+		if err != nil || posting == nil {
+			return nil, err
+		}
+		pictureID = posting.Pet.PictureID
+	case domain.TargetSighting:
+		sighting, err := h.repo.GetSightingByID(id)
+		if err != nil || sighting == nil {
+			return nil, err
+		}
+		pictureID = sighting.Pet.PictureID
+	}
+
+	return petPicture(h.fileRepo, pictureID)
+}
+
+/*
+parseAdminList reads the filters and page for an admin list. Unlike the public lists every status
+is included and hidden records are shown unless hidden=false is set.
+*/
+func parseAdminList(params url.Values, fields queryFields, sortFields []string) ([]domain.FilterMap, domain.Page, error) {
+	filters, err := parseFilters(params, fields, adminListParams...)
+	if err != nil {
+		return nil, domain.Page{}, err
+	}
+
+	radius, err := parseNear(params)
+	if err != nil {
+		return nil, domain.Page{}, err
+	}
+	filters = withNear(filters, radius)
+
+	if value := params.Get(paramHidden); value != "" {
+		hidden, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, domain.Page{}, &queryError{Message: fmt.Sprintf("invalid boolean: %s", value), Param: paramHidden}
+		}
+		filters = withHidden(filters, hidden)
+	}
+
+	page, err := parseAdminPage(params, sortFields)
+	return filters, page, err
+}
+
+// parseAdminPage is parsePage with the most recently created records first by default
+func parseAdminPage(params url.Values, sortFields []string) (domain.Page, error) {
+	page, err := parsePage(params, sortFields)
+	if params.Get(paramSort) == "" {
+		page.Sort = domain.SortCreated
+	}
+	return page, err
+}
+
+func toAPIModerationEntry(d domain.ModerationEntry) apiModerationEntry {
+	return apiModerationEntry{
+		ID:        d.ID,
+		UserID:    d.UserID,
+		Target:    string(d.Target),
+		TargetID:  d.TargetID,
+		Action:    string(d.Action),
+		Reason:    d.Reason,
+		CreatedOn: d.CreatedOn,
+	}
+}
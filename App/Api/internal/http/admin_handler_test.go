@@ -0,0 +1,114 @@
+package http
+
+import (
+	"encoding/json"
+	"lostpets"
+	"lostpets/internal/notifications"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// moderationOptions has a posting with a picture and one without
+func moderationOptions() testOptions {
+	return testOptions{
+		postings: map[int]lostpets.Posting{
+			1: {ID: 1, Pet: lostpets.Pet{PictureID: 7}},
+			2: {ID: 2},
+		},
+		pictures: map[int]lostpets.FileMeta{7: {ID: 7, GUID: "picture-guid"}},
+	}
+}
+
+func moderate(e *echo.Echo, token string, id string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, adminPath+postingsPath+"/"+id+"/moderation", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestModerateDeleteRemovesPictureFile(t *testing.T) {
+	s := newTestServer(moderationOptions())
+
+	rec := moderate(s.Echo, "admin", "1", `{"action": "delete", "reason": "spam"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []string{"picture-guid"}, s.store.deleted)
+	assert.Equal(t, []lostpets.ModerationEntry{{
+		ID: 1, UserID: 3, Target: lostpets.TargetPosting, TargetID: 1, Action: lostpets.ActionDelete, Reason: "spam",
+	}}, s.moderation.entries)
+}
+
+func TestModerateUnhideMatchesAgain(t *testing.T) {
+	s := newTestServer(moderationOptions())
+
+	rec := moderate(s.Echo, "admin", "2", `{"action": "unhide", "reason": "reviewed, not spam"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, s.store.deleted)
+	assert.Equal(t, []string{jobMatchPosting}, s.queue.kinds)
+}
+
+func TestModerateRejectsBadRequests(t *testing.T) {
+	s := newTestServer(moderationOptions())
+
+	tests := []struct {
+		name   string
+		token  string
+		id     string
+		body   string
+		status int
+	}{
+		{name: "staff can't moderate", token: "staff", id: "1", body: `{"action": "hide", "reason": "spam"}`, status: http.StatusForbidden},
+		{name: "unknown action", token: "admin", id: "1", body: `{"action": "ban", "reason": "spam"}`, status: http.StatusBadRequest},
+		{name: "missing reason", token: "admin", id: "1", body: `{"action": "hide", "reason": " "}`, status: http.StatusBadRequest},
+		{name: "unknown posting", token: "admin", id: "99", body: `{"action": "hide", "reason": "spam"}`, status: http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := moderate(s.Echo, test.token, test.id, test.body)
+			assert.Equal(t, test.status, rec.Code)
+		})
+	}
+	assert.Empty(t, s.moderation.entries)
+}
+
+func TestEmailPreview(t *testing.T) {
+	templates, err := notifications.NewRegistry(filepath.Join("..", "..", "config", "templates"), "en", "")
+	require.NoError(t, err)
+
+	s := newTestServer(testOptions{templates: templates})
+
+	preview := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, adminPath+path, nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer admin")
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := preview("/email-preview/new-match?locale=fr")
+	require.Equal(t, http.StatusOK, rec.Code)
+	resp := struct {
+		Data apiEmailPreview `json:"data"`
+	}{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, "Des correspondances ont été trouvées pour votre annonce", resp.Data.Subject)
+	assert.Contains(t, resp.Data.HTML, "http://localhost:4200/postings/private/sample-guid")
+
+	rec = preview("/email-preview/verification?format=html")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get(echo.HeaderContentType), "text/html")
+
+	//posting-expiring only has a text part
+	assert.Equal(t, http.StatusNotFound, preview("/email-preview/posting-expiring?format=html").Code)
+	assert.Equal(t, http.StatusOK, preview("/email-preview/posting-expiring?format=text").Code)
+	assert.Equal(t, http.StatusNotFound, preview("/email-preview/unknown").Code)
+}
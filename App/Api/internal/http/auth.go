@@ -0,0 +1,227 @@
+package http
+
+import (
+	"errors"
+	domain "lostpets"
+	"lostpets/internal/auth"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+type (
+	authHandler struct {
+		logger domain.StructuredLogger
+		router *echo.Echo
+		auth   domain.AuthService
+	}
+
+	apiCredentials struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	apiNewUser struct {
+		apiCredentials
+		Name string `json:"name"`
+		Role string `json:"role,omitempty"`
+	}
+
+	apiRefresh struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+
+	apiTokens struct {
+		AccessToken  string `json:"accessToken"`
+		RefreshToken string `json:"refreshToken"`
+		TokenType    string `json:"tokenType"`
+		ExpiresIn    int    `json:"expiresIn"`
+	}
+
+	apiUser struct {
+		ID    int    `json:"id"`
+		Email string `json:"email"`
+		Name  string `json:"name,omitempty"`
+		Role  string `json:"role"`
+	}
+)
+
+const (
+	authPath = "/auth"
+
+	// context key the authenticated principal is stored under
+	principalKey = "principal"
+	bearerPrefix = "Bearer "
+)
+
+func (h *authHandler) initRoute(path string) {
+	h.router.POST(path+"/register", h.handleRegister())
+	h.router.POST(path+"/login", h.handleLogin())
+	h.router.POST(path+"/refresh", h.handleRefresh())
+	h.router.POST(path+"/logout", h.handleLogout())
+	h.router.GET(path+"/me", h.handleGetMe(), requireRole(domain.RoleReporter))
+	h.router.POST(path+"/users", h.handleAddUser(), requireRole(domain.RoleAdmin))
+}
+
+// handleRegister signs up a member of the public, staff and admin accounts are added by an admin
+func (h *authHandler) handleRegister() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		newUser := new(apiNewUser)
+		if err := c.Bind(newUser); err != nil {
+			return err
+		}
+
+		user, err := h.auth.Register(newUser.Email, newUser.Name, newUser.Password, domain.RoleReporter)
+		if err != nil {
+			return authError(err)
+		}
+		return c.JSON(http.StatusCreated, response{Data: toAPIUser(*user)})
+	}
+}
+
+// handleAddUser adds an account with any role
+func (h *authHandler) handleAddUser() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		newUser := new(apiNewUser)
+		if err := c.Bind(newUser); err != nil {
+			return err
+		}
+
+		user, err := h.auth.Register(newUser.Email, newUser.Name, newUser.Password, domain.Role(newUser.Role))
+		if err != nil {
+			return authError(err)
+		}
+		h.logger.Info("user %d added %s account %d", principal(c).UserID, user.Role, user.ID)
+		return c.JSON(http.StatusCreated, response{Data: toAPIUser(*user)})
+	}
+}
+
+func (h *authHandler) handleLogin() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		credentials := new(apiCredentials)
+		if err := c.Bind(credentials); err != nil {
+			return err
+		}
+
+		tokens, err := h.auth.Login(credentials.Email, credentials.Password)
+		if err != nil {
+			return authError(err)
+		}
+		return c.JSON(http.StatusOK, response{Data: toAPITokens(*tokens)})
+	}
+}
+
+func (h *authHandler) handleRefresh() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		refresh := new(apiRefresh)
+		if err := c.Bind(refresh); err != nil {
+			return err
+		}
+
+		tokens, err := h.auth.Refresh(refresh.RefreshToken)
+		if err != nil {
+			return authError(err)
+		}
+		return c.JSON(http.StatusOK, response{Data: toAPITokens(*tokens)})
+	}
+}
+
+// handleLogout revokes the refresh token, the access token stays valid until it expires
+func (h *authHandler) handleLogout() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		refresh := new(apiRefresh)
+		if err := c.Bind(refresh); err != nil {
+			return err
+		}
+
+		if err := h.auth.Logout(refresh.RefreshToken); err != nil {
+			return authError(err)
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+func (h *authHandler) handleGetMe() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		p := principal(c)
+		return c.JSON(http.StatusOK, response{Data: apiUser{ID: p.UserID, Email: p.Email, Role: string(p.Role)}})
+	}
+}
+
+/*
+authenticate attaches the principal for a valid bearer token to the request.
+Requests without an Authorization header carry on anonymously, a bad or expired token is rejected
+so clients know to refresh rather then silently losing access.
+*/
+func authenticate(service domain.AuthService) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			header := c.Request().Header.Get(echo.HeaderAuthorization)
+			if header == "" {
+				return next(c)
+			}
+
+			if !strings.HasPrefix(header, bearerPrefix) {
+				return echo.NewHTTPError(http.StatusUnauthorized, "expected a bearer token")
+			}
+
+			p, err := service.Authenticate(strings.TrimPrefix(header, bearerPrefix))
+			if err != nil {
+				return echo.NewHTTPError(http.StatusUnauthorized, "invalid or expired access token")
+			}
+
+			c.Set(principalKey, p)
+			return next(c)
+		}
+	}
+}
+
+// requireRole rejects requests that aren't authenticated or whose role is below min
+func requireRole(min domain.Role) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			p := principal(c)
+			if p == nil {
+				return echo.NewHTTPError(http.StatusUnauthorized, "login required")
+			}
+			if !p.Role.AtLeast(min) {
+				return echo.NewHTTPError(http.StatusForbidden, "not allowed")
+			}
+			return next(c)
+		}
+	}
+}
+
+// principal is the authenticated user for the request, nil for anonymous requests
+func principal(c echo.Context) *domain.Principal {
+	p, _ := c.Get(principalKey).(*domain.Principal)
+	return p
+}
+
+// authError converts account errors into http errors, anything else is returned as is
+func authError(err error) error {
+	var inputErr *auth.InputError
+	switch {
+	case errors.As(err, &inputErr):
+		return echo.NewHTTPError(http.StatusBadRequest, inputErr.Error())
+	case errors.Is(err, domain.ErrEmailTaken):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	case errors.Is(err, domain.ErrInvalidCredentials):
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	return err
+}
+
+func toAPIUser(d domain.User) apiUser {
+	return apiUser{ID: d.ID, Email: d.Email, Name: d.Name, Role: string(d.Role)}
+}
+
+func toAPITokens(d domain.Tokens) apiTokens {
+	return apiTokens{
+		AccessToken:  d.AccessToken,
+		RefreshToken: d.RefreshToken,
+		TokenType:    strings.TrimSpace(bearerPrefix),
+		ExpiresIn:    d.ExpiresIn,
+	}
+}
@@ -0,0 +1,51 @@
+package http
+
+import (
+	"lostpets"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticateAndRequireRole(t *testing.T) {
+	e := echo.New()
+	e.HTTPErrorHandler = errorHandler(nopLogger{})
+	e.Use(authenticate(&fakeAuth{principals: map[string]*lostpets.Principal{
+		"reporter": {UserID: 1, Role: lostpets.RoleReporter},
+		"staff":    {UserID: 2, Role: lostpets.RoleStaff},
+		"admin":    {UserID: 3, Role: lostpets.RoleAdmin},
+	}}))
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	e.GET("/public", ok)
+	e.GET("/staff", ok, requireRole(lostpets.RoleStaff))
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		status int
+	}{
+		{name: "anonymous public", path: "/public", status: http.StatusOK},
+		{name: "anonymous staff only", path: "/staff", status: http.StatusUnauthorized},
+		{name: "invalid token", path: "/public", header: "Bearer forged", status: http.StatusUnauthorized},
+		{name: "not a bearer token", path: "/public", header: "Basic c3RhZmY=", status: http.StatusUnauthorized},
+		{name: "role too low", path: "/staff", header: "Bearer reporter", status: http.StatusForbidden},
+		{name: "exact role", path: "/staff", header: "Bearer staff", status: http.StatusOK},
+		{name: "higher role", path: "/staff", header: "Bearer admin", status: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, test.path, nil)
+			if test.header != "" {
+				req.Header.Set(echo.HeaderAuthorization, test.header)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			assert.Equal(t, test.status, rec.Code)
+		})
+	}
+}
@@ -0,0 +1,376 @@
+package http
+
+import (
+	"crypto/subtle"
+	"fmt"
+	domain "lostpets"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+)
+
+type (
+	contactHandler struct {
+		logger   domain.StructuredLogger
+		router   *echo.Echo
+		repo     domain.LostPetsRepo
+		contacts domain.ContactRepo
+		jobs     domain.JobQueue
+		config   RelayConfig
+		// addressKey is server.reports.secret, requesters' IP addresses are stored hashed the same way as reporters'
+		addressKey string
+	}
+
+	// relay sends the stored contact messages on to the other side of the conversation
+	relay struct {
+		send     func(m email) error
+		domain   string
+		repo     domain.LostPetsRepo
+		contacts domain.ContactRepo
+		logger   domain.StructuredLogger
+		now      func() time.Time
+	}
+
+	// apiContact is a message to the owner of a posting or sighting. Someone with a record on the other side
+	// sends its private guid so replies go to the email on that record, anyone else gives their name and email
+	apiContact struct {
+		Name    string `json:"name,omitempty"`
+		Email   string `json:"email,omitempty"`
+		GUID    string `json:"guid,omitempty"`
+		Message string `json:"message"`
+	}
+
+	// apiInbound is a reply posted by the mail provider's inbound hook
+	apiInbound struct {
+		To   string `json:"to"`
+		From string `json:"from"`
+		Text string `json:"text"`
+	}
+)
+
+const (
+	contactPath       = "/contact"
+	aliasPrefix       = "contact+"
+	headerRelaySecret = "X-Relay-Secret"
+
+	maxContactMessage = 2000
+	// contactWindow is the period the relay's limits are counted over
+	contactWindow = time.Hour
+	// replies usually quote the earlier messages, so they are allowed to be longer and cut short after this
+	maxInboundMessage = 10000
+)
+
+func (h *contactHandler) initRoute() {
+	h.router.POST(postingsPath+"/:id"+contactPath, h.handleContact(domain.TargetPosting))
+	h.router.POST(sightingsPath+"/:id"+contactPath, h.handleContact(domain.TargetSighting))
+	if h.config.InboundSecret != "" {
+		h.router.POST(contactPath+"/inbound", h.handleInbound())
+	}
+}
+
+/*
+handleContact stores a message to the owner of the posting or sighting and queues it to be relayed.
+Writing from a record on the other side ties the conversation to that pair, so their match shows when they were last in contact.
+Like the outbox's limit on each recipient, an address or record over its limit for the hour gets a 429.
+*/
+func (h *contactHandler) handleContact(target domain.ModerationTarget) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid id")
+		}
+
+		request := new(apiContact)
+		if err := c.Bind(request); err != nil {
+			return err
+		}
+
+		message := strings.TrimSpace(request.Message)
+		if message == "" || utf8.RuneCountInString(message) > maxContactMessage {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("message must be 1 to %d characters", maxContactMessage))
+		}
+
+		visible, err := isVisible(h.repo, target, id)
+		if err != nil {
+			return err
+		}
+		if !visible {
+			return c.NoContent(http.StatusNotFound)
+		}
+
+		address := hashAddress(h.addressKey, c)
+		if err := h.checkLimits(target, id, address); err != nil {
+			return err
+		}
+
+		contact := &domain.Contact{Target: target, TargetID: id, RequesterName: strings.TrimSpace(request.Name)}
+		if request.GUID != "" {
+			from, err := h.otherSide(target, request.GUID)
+			if err != nil {
+				return err
+			}
+			if from == nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "unknown guid")
+			}
+			contact.MatchedWith = from.ID
+			contact.RequesterEmail = from.Email
+			if contact.RequesterName == "" {
+				contact.RequesterName = from.Name
+			}
+		} else {
+			address, err := mail.ParseAddress(strings.TrimSpace(request.Email))
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "an email address or guid is required")
+			}
+			contact.RequesterEmail = address.Address
+		}
+
+		if err := h.contacts.AddContact(contact); err != nil {
+			return err
+		}
+
+		contactMessage := &domain.ContactMessage{ContactID: contact.ID, Message: message, Address: address}
+		if err := h.contacts.AddContactMessage(contactMessage); err != nil {
+			return err
+		}
+
+		if err := h.jobs.Enqueue(jobRelayContact, messageJob{ID: contactMessage.ID}); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusAccepted)
+	}
+}
+
+// checkLimits refuses the message if the address has sent, or the record has been sent, too many in the last hour
+func (h *contactHandler) checkLimits(target domain.ModerationTarget, id int, address string) error {
+	since := time.Now().Add(-contactWindow)
+	if h.config.PerAddressPerHour > 0 {
+		sent, err := h.contacts.CountContactMessagesFrom(address, since)
+		if err != nil {
+			return err
+		}
+		if sent >= h.config.PerAddressPerHour {
+			return echo.NewHTTPError(http.StatusTooManyRequests, "too many messages, try again later")
+		}
+	}
+	if h.config.PerRecordPerHour > 0 {
+		sent, err := h.contacts.CountContactMessagesTo(target, id, since)
+		if err != nil {
+			return err
+		}
+		if sent >= h.config.PerRecordPerHour {
+			return echo.NewHTTPError(http.StatusTooManyRequests, "too many messages, try again later")
+		}
+	}
+	return nil
+}
+
+// otherSide loads the requester's own record by its private guid, a sighting when writing about a posting and the other way round
+func (h *contactHandler) otherSide(target domain.ModerationTarget, guid string) (*domain.Posting, error) {
+	if target == domain.TargetPosting {
+		sighting, err := h.repo.GetSightingByGUID(guid)
+		if err != nil || sighting == nil {
+			return nil, err
+		}
+		return &sighting.Posting, nil
+	}
+	return h.repo.GetPostingByGUID(guid)
+}
+
+/*
+handleInbound takes a reply sent to one of the aliases and queues it for the other side.
+Replies that can't be placed are dropped with a 204 so the mail provider doesn't retry them,
+that includes replies from an address other than the one the alias was sent to.
+*/
+func (h *contactHandler) handleInbound() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		secret := c.Request().Header.Get(headerRelaySecret)
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(h.config.InboundSecret)) != 1 {
+			return echo.NewHTTPError(http.StatusUnauthorized, "invalid relay secret")
+		}
+
+		inbound := new(apiInbound)
+		if err := c.Bind(inbound); err != nil {
+			return err
+		}
+
+		alias := parseAlias(inbound.To, h.config.Domain)
+		if alias == "" {
+			h.logger.Info("dropped inbound email to %q, not a contact alias", inbound.To)
+			return c.NoContent(http.StatusNoContent)
+		}
+
+		contact, err := h.contacts.GetContactByAlias(alias)
+		if err != nil {
+			return err
+		}
+		if contact == nil {
+			h.logger.Info("dropped inbound email to unknown alias %s", alias)
+			return c.NoContent(http.StatusNoContent)
+		}
+
+		// the requester's alias is only ever given to the owner, so mail to it is from the owner
+		fromOwner := strings.EqualFold(alias, contact.RequesterAlias)
+		expected := contact.RequesterEmail
+		if fromOwner {
+			owner, err := ownerOf(h.repo, contact)
+			if err != nil {
+				return err
+			}
+			if owner == nil {
+				h.logger.Info("dropped inbound email for contact %d, the record is gone", contact.ID)
+				return c.NoContent(http.StatusNoContent)
+			}
+			expected = owner.Email
+		}
+
+		from, err := mail.ParseAddress(inbound.From)
+		if err != nil || !strings.EqualFold(from.Address, expected) {
+			h.logger.Info("dropped inbound email for contact %d from an unexpected sender", contact.ID)
+			return c.NoContent(http.StatusNoContent)
+		}
+
+		text := strings.TrimSpace(inbound.Text)
+		if text == "" {
+			return c.NoContent(http.StatusNoContent)
+		}
+		if utf8.RuneCountInString(text) > maxInboundMessage {
+			text = string([]rune(text)[:maxInboundMessage])
+		}
+
+		message := &domain.ContactMessage{ContactID: contact.ID, FromOwner: fromOwner, Message: text}
+		if err := h.contacts.AddContactMessage(message); err != nil {
+			return err
+		}
+		if err := h.jobs.Enqueue(jobRelayContact, messageJob{ID: message.ID}); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// parseAlias returns the alias from a contact+<alias>@domain address, or "" for any other address
+func parseAlias(to string, domainName string) string {
+	address, err := mail.ParseAddress(to)
+	if err != nil {
+		return ""
+	}
+	at := strings.LastIndex(address.Address, "@")
+	if at < 0 || !strings.EqualFold(address.Address[at+1:], domainName) {
+		return ""
+	}
+	local := strings.ToLower(address.Address[:at])
+	if !strings.HasPrefix(local, aliasPrefix) {
+		return ""
+	}
+	return strings.TrimPrefix(local, aliasPrefix)
+}
+
+// ownerOf loads the record the conversation is about, nil if it was deleted or hidden
+func ownerOf(repo domain.LostPetsRepo, contact *domain.Contact) (*domain.Posting, error) {
+	var record *domain.Posting
+	if contact.Target == domain.TargetSighting {
+		sighting, err := repo.GetSightingByID(contact.TargetID)
+		if err != nil || sighting == nil {
+			return nil, err
+		}
+		record = &sighting.Posting
+	} else {
+		posting, err := repo.GetPostingByID(contact.TargetID)
+		if err != nil || posting == nil {
+			return nil, err
+		}
+		record = posting
+	}
+
+	if record.HiddenOn != nil {
+		return nil, nil
+	}
+	return record, nil
+}
+
+/*
+relayMessage emails a stored message to the other side of its conversation, with the sender's alias to reply to.
+Messages already sent or about records that have since gone are skipped, so a retried job doesn't send twice.
+When the conversation is between a matched posting and sighting the match is marked as contacted.
+*/
+func (r relay) relayMessage(id int) error {
+	message, err := r.contacts.GetContactMessage(id)
+	if err != nil {
+		return err
+	}
+	if message == nil || message.SentOn != nil {
+		return nil
+	}
+
+	contact, err := r.contacts.GetContact(message.ContactID)
+	if err != nil {
+		return err
+	}
+	if contact == nil {
+		return nil
+	}
+
+	owner, err := ownerOf(r.repo, contact)
+	if err != nil {
+		return err
+	}
+	if owner == nil {
+		r.logger.Info("not relaying message %d, %s %d is gone", message.ID, contact.Target, contact.TargetID)
+		return nil
+	}
+
+	m := email{
+		Kind:    kindContact,
+		To:      owner.Email,
+		ReplyTo: r.address(contact.RequesterAlias),
+		Subject: fmt.Sprintf("A message about your %s", contact.Target),
+		Body:    relayBody(contact.RequesterName, message.Message),
+	}
+	if message.FromOwner {
+		m = email{
+			Kind:    kindContact,
+			To:      contact.RequesterEmail,
+			ReplyTo: r.address(contact.OwnerAlias),
+			Subject: fmt.Sprintf("A reply about the %s you wrote about", contact.Target),
+			Body:    relayBody(ownerLabel, message.Message),
+		}
+	}
+
+	if err := r.send(m); err != nil {
+		return err
+	}
+
+	now := r.now()
+	if err := r.contacts.MarkContactMessageSent(message.ID, now); err != nil {
+		return err
+	}
+
+	if contact.MatchedWith != 0 {
+		postingID, sightingID := contact.TargetID, contact.MatchedWith
+		if contact.Target == domain.TargetSighting {
+			postingID, sightingID = sightingID, postingID
+		}
+		return r.repo.UpdateMatch(postingID, sightingID, now)
+	}
+	return nil
+}
+
+func (r relay) address(alias string) string {
+	return aliasPrefix + alias + "@" + r.domain
+}
+
+// ownerLabel signs the owner's replies, so their name is kept from the requester like their email address
+const ownerLabel = "The owner"
+
+func relayBody(name string, message string) string {
+	if name == "" {
+		name = "Someone"
+	}
+	return fmt.Sprintf("%s wrote:\n\n%s\n\n--\nReply to this email to answer. Your email address is only shared if you write it in your reply.\n", name, message)
+}
@@ -0,0 +1,124 @@
+package http
+
+import (
+	"lostpets"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// contactOptions has a finder in contact with the owner of posting 1, relayed through relay.example.org
+func contactOptions(relay RelayConfig) testOptions {
+	relay.Domain = "relay.example.org"
+	return testOptions{
+		config:   Config{Email: EmailConfig{Relay: relay}},
+		postings: map[int]lostpets.Posting{1: {ID: 1, Name: "Sam", Email: "owner@example.org"}},
+		contacts: []lostpets.Contact{{
+			ID: 1, Target: lostpets.TargetPosting, TargetID: 1, MatchedWith: 10,
+			RequesterName: "Alex", RequesterEmail: "finder@example.org", RequesterAlias: "finderalias", OwnerAlias: "owneralias",
+		}},
+	}
+}
+
+func TestParseAlias(t *testing.T) {
+	tests := map[string]string{
+		"contact+abc123@relay.example.org":              "abc123",
+		"Lost Pets <Contact+ABC123@Relay.Example.org>":  "abc123",
+		"contact+abc123@other.example.org":              "",
+		"someone@relay.example.org":                     "",
+		"not an address":                                "",
+		"contact+abc123@relay.example.org.evil.example": "",
+	}
+	for to, alias := range tests {
+		assert.Equal(t, alias, parseAlias(to, "relay.example.org"), to)
+	}
+}
+
+func TestRelayMessage(t *testing.T) {
+	s := newTestServer(contactOptions(RelayConfig{}))
+	sent := []email{}
+	relay := relay{
+		send:     func(m email) error { sent = append(sent, m); return nil },
+		domain:   "relay.example.org",
+		repo:     s.repo,
+		contacts: s.contacts,
+		logger:   nopLogger{},
+		now:      time.Now,
+	}
+
+	require.NoError(t, s.contacts.AddContactMessage(&lostpets.ContactMessage{ContactID: 1, Message: "I think I saw your dog"}))
+	require.NoError(t, relay.relayMessage(1))
+	require.Len(t, sent, 1)
+	assert.Equal(t, "owner@example.org", sent[0].To)
+	assert.Equal(t, "contact+finderalias@relay.example.org", sent[0].ReplyTo)
+	assert.Contains(t, sent[0].Body, "Alex wrote:")
+	assert.NotContains(t, sent[0].Body, "finder@example.org")
+	assert.Equal(t, [][2]int{{1, 10}}, s.repo.contacted)
+
+	//a retried job doesn't send the message again
+	require.NoError(t, relay.relayMessage(1))
+	assert.Len(t, sent, 1)
+
+	require.NoError(t, s.contacts.AddContactMessage(&lostpets.ContactMessage{ContactID: 1, FromOwner: true, Message: "Thank you!"}))
+	require.NoError(t, relay.relayMessage(2))
+	require.Len(t, sent, 2)
+	assert.Equal(t, "finder@example.org", sent[1].To)
+	assert.Equal(t, "contact+owneralias@relay.example.org", sent[1].ReplyTo)
+	assert.Contains(t, sent[1].Body, "The owner wrote:")
+	assert.NotContains(t, sent[1].Body, "Sam")
+}
+
+func TestContactLimits(t *testing.T) {
+	s := newTestServer(contactOptions(RelayConfig{PerAddressPerHour: 2, PerRecordPerHour: 3}))
+	s.repo.postings[2] = lostpets.Posting{ID: 2, Email: "other@example.org"}
+
+	contact := func(id string, ip string) int {
+		req := httptest.NewRequest(http.MethodPost, postingsPath+"/"+id+contactPath, strings.NewReader(`{"email": "finder@example.org", "message": "I think I saw your dog"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusAccepted, contact("1", "10.0.0.1"))
+	assert.Equal(t, http.StatusAccepted, contact("2", "10.0.0.1"))
+	//the address has sent its two for the hour
+	assert.Equal(t, http.StatusTooManyRequests, contact("1", "10.0.0.1"))
+	assert.NotContains(t, s.contacts.messages[0].Address, "10.0.0.1")
+
+	assert.Equal(t, http.StatusAccepted, contact("1", "10.0.0.2"))
+	assert.Equal(t, http.StatusAccepted, contact("1", "10.0.0.3"))
+	//and the owner of posting 1 has been sent three
+	assert.Equal(t, http.StatusTooManyRequests, contact("1", "10.0.0.4"))
+	assert.Equal(t, http.StatusAccepted, contact("2", "10.0.0.4"))
+	assert.Len(t, s.contacts.messages, 5)
+}
+
+func TestInboundReply(t *testing.T) {
+	s := newTestServer(contactOptions(RelayConfig{InboundSecret: "secret"}))
+
+	inbound := func(secret string, body string) int {
+		req := httptest.NewRequest(http.MethodPost, contactPath+"/inbound", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(headerRelaySecret, secret)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, inbound("wrong", `{"to": "contact+finderalias@relay.example.org", "from": "owner@example.org", "text": "hi"}`))
+	//only the owner can reply through the alias given to them
+	assert.Equal(t, http.StatusNoContent, inbound("secret", `{"to": "contact+finderalias@relay.example.org", "from": "someone@example.org", "text": "hi"}`))
+	assert.Empty(t, s.contacts.messages)
+
+	assert.Equal(t, http.StatusNoContent, inbound("secret", `{"to": "contact+finderalias@relay.example.org", "from": "Sam <Owner@example.org>", "text": "Is she still with you?"}`))
+	assert.Equal(t, []lostpets.ContactMessage{{ID: 1, ContactID: 1, FromOwner: true, Message: "Is she still with you?"}}, s.contacts.messages)
+	assert.Equal(t, []string{jobRelayContact}, s.queue.kinds)
+}
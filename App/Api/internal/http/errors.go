@@ -0,0 +1,121 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	domain "lostpets"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type (
+	/*
+		problem is an RFC 7807 error body. The type is always about:blank so the title is the status text,
+		Param, Valid and Errors carry the details of bad queries and bodies.
+	*/
+	problem struct {
+		Type     string       `json:"type"`
+		Title    string       `json:"title"`
+		Status   int          `json:"status"`
+		Detail   string       `json:"detail,omitempty"`
+		Instance string       `json:"instance,omitempty"`
+		Param    string       `json:"param,omitempty"`
+		Valid    []string     `json:"valid,omitempty"`
+		Errors   []fieldError `json:"errors,omitempty"`
+	}
+)
+
+const problemContentType = "application/problem+json"
+
+// the status for each kind of domain error
+var kindStatus = map[domain.ErrorKind]int{
+	domain.KindNotFound:     http.StatusNotFound,
+	domain.KindInvalidInput: http.StatusBadRequest,
+	domain.KindConflict:     http.StatusConflict,
+	domain.KindForbidden:    http.StatusForbidden,
+}
+
+/*
+errorHandler writes every error returned by a handler as problem+json. Domain errors get the status for their kind,
+anything unknown is a 500 whose message stays in the logs so database and file errors never reach the client.
+*/
+func errorHandler(logger domain.StructuredLogger) echo.HTTPErrorHandler {
+	return func(err error, c echo.Context) {
+		if c.Response().Committed {
+			return
+		}
+
+		p := toProblem(err)
+		p.Instance = c.Request().URL.Path
+
+		log := logger.WithFields(map[string]interface{}{
+			"method": c.Request().Method,
+			"path":   c.Request().URL.Path,
+			"status": p.Status,
+		})
+		if p.Status >= http.StatusInternalServerError {
+			log.Error("%s", err)
+		} else {
+			log.Debug("%s", err)
+		}
+
+		var writeErr error
+		if c.Request().Method == http.MethodHead {
+			writeErr = c.NoContent(p.Status)
+		} else {
+			c.Response().Header().Set(echo.HeaderContentType, problemContentType)
+			writeErr = c.JSON(p.Status, p)
+		}
+		if writeErr != nil {
+			log.Error("writing error response: %s", writeErr)
+		}
+	}
+}
+
+// toProblem picks the status and the message that is safe to show for the error
+func toProblem(err error) problem {
+	var httpErr *echo.HTTPError
+	var domainErr *domain.Error
+	var filterErr *domain.FilterError
+
+	switch {
+	case errors.As(err, &httpErr):
+		p := newProblem(httpErr.Code)
+		switch m := httpErr.Message.(type) {
+		case string:
+			p.Detail = m
+		case *queryError:
+			p.Detail, p.Param, p.Valid = m.Message, m.Param, m.Valid
+		case *validationError:
+			p.Detail, p.Errors = m.Message, m.Errors
+		case error:
+			p.Detail = m.Error()
+		case nil:
+		default:
+			p.Detail = fmt.Sprint(m)
+		}
+		return p
+	case errors.As(err, &domainErr):
+		status, ok := kindStatus[domainErr.Kind]
+		if !ok {
+			status = http.StatusInternalServerError
+		}
+		p := newProblem(status)
+		p.Detail = domainErr.Message
+		return p
+	case errors.As(err, &filterErr):
+		p := newProblem(http.StatusBadRequest)
+		p.Detail = filterErr.Error()
+		return p
+	case errors.Is(err, domain.ErrInvalidCredentials):
+		p := newProblem(http.StatusUnauthorized)
+		p.Detail = err.Error()
+		return p
+	}
+	return newProblem(http.StatusInternalServerError)
+}
+
+func newProblem(status int) problem {
+	return problem{Type: "about:blank", Title: http.StatusText(status), Status: status}
+}
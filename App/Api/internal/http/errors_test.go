@@ -0,0 +1,99 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lostpets"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingLogger keeps what was logged as errors
+type recordingLogger struct {
+	nopLogger
+	errors *[]string
+}
+
+func (l recordingLogger) Error(message string, args ...interface{}) {
+	*l.errors = append(*l.errors, fmt.Sprintf(message, args...))
+}
+
+func (l recordingLogger) WithFields(fields map[string]interface{}) lostpets.Logger { return l }
+
+func TestToProblem(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		detail string
+	}{
+		{name: "not found", err: lostpets.NotFound("posting not found"), status: http.StatusNotFound, detail: "posting not found"},
+		{name: "wrapped", err: fmt.Errorf("loading: %w", lostpets.InvalidInput("unknown pet type").Wrap(errors.New("pq: fk"))), status: http.StatusBadRequest, detail: "unknown pet type"},
+		{name: "conflict", err: lostpets.ErrStatusTransition, status: http.StatusConflict, detail: "status transition not allowed"},
+		{name: "forbidden", err: lostpets.Forbidden("not yours"), status: http.StatusForbidden, detail: "not yours"},
+		{name: "filter", err: &lostpets.FilterError{Field: "x", Reason: "unknown field"}, status: http.StatusBadRequest, detail: "invalid filter x: unknown field"},
+		{name: "credentials", err: lostpets.ErrInvalidCredentials, status: http.StatusUnauthorized, detail: "invalid credentials"},
+		{name: "http", err: echo.NewHTTPError(http.StatusGone, "gone"), status: http.StatusGone, detail: "gone"},
+		{name: "internal", err: errors.New("pq: connection refused"), status: http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := toProblem(test.err)
+			assert.Equal(t, test.status, p.Status)
+			assert.Equal(t, test.detail, p.Detail)
+			assert.Equal(t, http.StatusText(test.status), p.Title)
+			assert.Equal(t, "about:blank", p.Type)
+		})
+	}
+
+	p := toProblem(echo.NewHTTPError(http.StatusBadRequest, &queryError{Message: "unknown field", Param: "colour", Valid: []string{"color"}}))
+	assert.Equal(t, problem{Type: "about:blank", Title: "Bad Request", Status: http.StatusBadRequest, Detail: "unknown field", Param: "colour", Valid: []string{"color"}}, p)
+}
+
+func TestErrorHandlerWritesProblems(t *testing.T) {
+	logged := []string{}
+	e := echo.New()
+	e.HTTPErrorHandler = errorHandler(recordingLogger{errors: &logged})
+	e.GET("/internal", func(c echo.Context) error { return errors.New("pq: password authentication failed") })
+	e.GET("/missing", func(c echo.Context) error { return lostpets.NotFound("posting not found") })
+
+	req := httptest.NewRequest(http.MethodGet, "/internal", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, problemContentType, rec.Header().Get(echo.HeaderContentType))
+	assert.NotContains(t, rec.Body.String(), "pq:")
+	assert.Equal(t, []string{"pq: password authentication failed"}, logged)
+
+	req = httptest.NewRequest(http.MethodGet, "/missing", nil)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	p := problem{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &p))
+	assert.Equal(t, problem{Type: "about:blank", Title: "Not Found", Status: http.StatusNotFound, Detail: "posting not found", Instance: "/missing"}, p)
+	assert.Len(t, logged, 1)
+
+	//unknown routes are problems too
+	req = httptest.NewRequest(http.MethodGet, "/nowhere", nil)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, problemContentType, rec.Header().Get(echo.HeaderContentType))
+}
+
+func TestMatchesForUnknownGUID(t *testing.T) {
+	s := newTestServer(testOptions{})
+
+	assert.Equal(t, http.StatusNotFound, doRequest(s.Echo, http.MethodGet, "/postings/private/missing/matches", "").Code)
+	assert.Equal(t, http.StatusNotFound, doRequest(s.Echo, http.MethodGet, "/sightings/private/missing/matches", "").Code)
+	assert.Equal(t, http.StatusBadRequest, doRequest(s.Echo, http.MethodGet, "/postings/abc", "").Code)
+}
@@ -0,0 +1,481 @@
+package http
+
+import (
+	"io"
+	"lostpets"
+	"lostpets/internal/notifications"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type fakeLostPetsRepo struct {
+	lostpets.LostPetsRepo
+	postings  map[int]lostpets.Posting
+	sightings map[int]lostpets.Sighting
+	matches   map[[2]int]lostpets.Match
+	contacted [][2]int
+	// files loses a deleted posting's picture like the pictures table does
+	files *fakeFileRepo
+}
+
+// GetAllSightings ignores the filters, tests only hold sightings the caller would get
+func (r *fakeLostPetsRepo) GetAllSightings(filters ...lostpets.FilterMap) ([]lostpets.Sighting, error) {
+	sightings := []lostpets.Sighting{}
+	for _, s := range r.sightings {
+		sightings = append(sightings, s)
+	}
+	return sightings, nil
+}
+
+func (r *fakeLostPetsRepo) AddMatch(pID int, sID int, score lostpets.MatchScore) (bool, error) {
+	if r.matches == nil {
+		r.matches = map[[2]int]lostpets.Match{}
+	}
+	_, existed := r.matches[[2]int{pID, sID}]
+	r.matches[[2]int{pID, sID}] = lostpets.Match{PostingID: pID, SightingID: sID, Score: score.Total}
+	return !existed, nil
+}
+
+func (r *fakeLostPetsRepo) UpdateMatch(pID int, sID int, contactedOn time.Time) error {
+	r.contacted = append(r.contacted, [2]int{pID, sID})
+	return nil
+}
+
+func (r *fakeLostPetsRepo) GetPostingByID(id int) (*lostpets.Posting, error) {
+	if p, ok := r.postings[id]; ok {
+		return &p, nil
+	}
+	return nil, nil
+}
+
+func (r *fakeLostPetsRepo) GetPostingByGUID(guid string) (*lostpets.Posting, error) {
+	for _, p := range r.postings {
+		if p.GUID == guid {
+			return &p, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeLostPetsRepo) GetSightingByID(id int) (*lostpets.Sighting, error) {
+	if s, ok := r.sightings[id]; ok {
+		return &s, nil
+	}
+	return nil, nil
+}
+
+func (r *fakeLostPetsRepo) GetSightingByGUID(guid string) (*lostpets.Sighting, error) {
+	for _, s := range r.sightings {
+		if s.GUID == guid {
+			return &s, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeLostPetsRepo) GetMatch(pID int, sID int) (*lostpets.Match, error) {
+	if m, ok := r.matches[[2]int{pID, sID}]; ok {
+		return &m, nil
+	}
+	return nil, nil
+}
+
+func (r *fakeLostPetsRepo) DecideMatch(pID int, sID int, decision lostpets.MatchDecision, decidedBy lostpets.ModerationTarget) error {
+	m, ok := r.matches[[2]int{pID, sID}]
+	if !ok {
+		return lostpets.ErrNotFound
+	}
+	m.Decision, m.DecidedBy = decision, decidedBy
+	r.matches[[2]int{pID, sID}] = m
+	return nil
+}
+
+func (r *fakeLostPetsRepo) UpdatePostingNotifications(id int, preferences lostpets.NotificationPreferences) error {
+	p := r.postings[id]
+	p.NotificationPreferences = preferences
+	r.postings[id] = p
+	return nil
+}
+
+func (r *fakeLostPetsRepo) UpdateSightingNotifications(id int, preferences lostpets.NotificationPreferences) error {
+	s := r.sightings[id]
+	s.NotificationPreferences = preferences
+	r.sightings[id] = s
+	return nil
+}
+
+func (r *fakeLostPetsRepo) GetPetTypes() ([]lostpets.PetType, error) {
+	return []lostpets.PetType{{ID: 1, Name: "Dog"}, {ID: 2, Name: "Cat"}}, nil
+}
+
+func (r *fakeLostPetsRepo) AddPosting(posting *lostpets.Posting) error {
+	posting.ID = len(r.postings) + 1
+	posting.GUID = "new-guid"
+	r.postings[posting.ID] = *posting
+	return nil
+}
+
+func (r *fakeLostPetsRepo) DeletePosting(id int) error {
+	p := r.postings[id]
+	delete(r.postings, id)
+	if r.files != nil {
+		delete(r.files.files, p.Pet.PictureID)
+	}
+	return nil
+}
+
+func (r *fakeLostPetsRepo) VerifyPosting(id int) error {
+	p := r.postings[id]
+	if p.Status != lostpets.StatusPending {
+		return lostpets.ErrStatusTransition
+	}
+	p.Status = lostpets.StatusOpen
+	r.postings[id] = p
+	return nil
+}
+
+func (r *fakeLostPetsRepo) VerifySighting(id int) error {
+	s := r.sightings[id]
+	if s.Status != lostpets.StatusPending {
+		return lostpets.ErrStatusTransition
+	}
+	s.Status = lostpets.StatusOpen
+	r.sightings[id] = s
+	return nil
+}
+
+// fakeModeration deletes postings and pictures from the other fakes so the handler sees what the database would
+type fakeModeration struct {
+	lostpets.ModerationRepo
+	repo    *fakeLostPetsRepo
+	files   *fakeFileRepo
+	entries []lostpets.ModerationEntry
+}
+
+func (m *fakeModeration) Moderate(entry *lostpets.ModerationEntry) error {
+	posting, ok := m.repo.postings[entry.TargetID]
+	if entry.Target != lostpets.TargetPosting || !ok {
+		return lostpets.ErrNotFound
+	}
+	if entry.Action == lostpets.ActionDelete {
+		delete(m.repo.postings, entry.TargetID)
+		delete(m.files.files, posting.Pet.PictureID)
+	}
+	entry.ID = len(m.entries) + 1
+	m.entries = append(m.entries, *entry)
+	return nil
+}
+
+type fakeFileRepo struct {
+	lostpets.FileRepo
+	files map[int]lostpets.FileMeta
+}
+
+func (r *fakeFileRepo) GetFileMeta(id int) (*lostpets.FileMeta, error) {
+	if f, ok := r.files[id]; ok {
+		return &f, nil
+	}
+	return nil, nil
+}
+
+type fakeFileStore struct {
+	lostpets.FileStore
+	deleted []string
+}
+
+func (s *fakeFileStore) DeleteFile(guid string) error {
+	s.deleted = append(s.deleted, guid)
+	return nil
+}
+
+type fakeQueue struct {
+	kinds []string
+}
+
+func (q *fakeQueue) Enqueue(kind string, payload interface{}) error {
+	q.kinds = append(q.kinds, kind)
+	return nil
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(message string, args ...interface{})                  {}
+func (nopLogger) Info(message string, args ...interface{})                   {}
+func (nopLogger) Error(message string, args ...interface{})                  {}
+func (nopLogger) UnwrapError(err error)                                      {}
+func (l nopLogger) WithFields(fields map[string]interface{}) lostpets.Logger { return l }
+
+// fakeAuth accepts the tokens it was given, everything else is invalid
+type fakeAuth struct {
+	lostpets.AuthService
+	principals map[string]*lostpets.Principal
+}
+
+func (a *fakeAuth) Authenticate(accessToken string) (*lostpets.Principal, error) {
+	if p, ok := a.principals[accessToken]; ok {
+		return p, nil
+	}
+	return nil, lostpets.ErrInvalidCredentials
+}
+
+// fakeReports keeps one open report per reporter and address on each record like the reports table
+type fakeReports struct {
+	lostpets.ReportRepo
+	reports []lostpets.Report
+}
+
+func (r *fakeReports) AddReport(report *lostpets.Report) (int, error) {
+	count, repeat := 0, false
+	for _, existing := range r.reports {
+		if existing.Target == report.Target && existing.TargetID == report.TargetID {
+			count++
+			repeat = repeat || existing.Reporter == report.Reporter || existing.Address == report.Address
+		}
+	}
+	if repeat {
+		return count, nil
+	}
+	r.reports = append(r.reports, *report)
+	return count + 1, nil
+}
+
+func (r *fakeReports) CountReportsFrom(address string, since time.Time) (int, error) {
+	count := 0
+	for _, existing := range r.reports {
+		if existing.Address == address {
+			count++
+		}
+	}
+	return count, nil
+}
+
+type fakeContacts struct {
+	lostpets.ContactRepo
+	contacts []lostpets.Contact
+	messages []lostpets.ContactMessage
+}
+
+func (r *fakeContacts) GetContact(id int) (*lostpets.Contact, error) {
+	for _, c := range r.contacts {
+		if c.ID == id {
+			return &c, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeContacts) GetContactByAlias(alias string) (*lostpets.Contact, error) {
+	for _, c := range r.contacts {
+		if c.RequesterAlias == alias || c.OwnerAlias == alias {
+			return &c, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeContacts) AddContact(contact *lostpets.Contact) error {
+	contact.ID = len(r.contacts) + 1
+	r.contacts = append(r.contacts, *contact)
+	return nil
+}
+
+func (r *fakeContacts) CountContactMessagesFrom(address string, since time.Time) (int, error) {
+	count := 0
+	for _, m := range r.messages {
+		if m.Address == address && !m.FromOwner {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *fakeContacts) CountContactMessagesTo(target lostpets.ModerationTarget, targetID int, since time.Time) (int, error) {
+	count := 0
+	for _, m := range r.messages {
+		c, _ := r.GetContact(m.ContactID)
+		if c != nil && c.Target == target && c.TargetID == targetID && !m.FromOwner {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *fakeContacts) AddContactMessage(message *lostpets.ContactMessage) error {
+	message.ID = len(r.messages) + 1
+	r.messages = append(r.messages, *message)
+	return nil
+}
+
+func (r *fakeContacts) GetContactMessage(id int) (*lostpets.ContactMessage, error) {
+	if id > len(r.messages) {
+		return nil, nil
+	}
+	m := r.messages[id-1]
+	return &m, nil
+}
+
+func (r *fakeContacts) MarkContactMessageSent(id int, sentOn time.Time) error {
+	r.messages[id-1].SentOn = &sentOn
+	return nil
+}
+
+type fakeMessages struct {
+	lostpets.MessageRepo
+	messages []lostpets.MatchMessage
+}
+
+func (r *fakeMessages) AddMatchMessage(message *lostpets.MatchMessage) error {
+	message.ID = len(r.messages) + 1
+	r.messages = append(r.messages, *message)
+	return nil
+}
+
+func (r *fakeMessages) GetMatchMessage(id int) (*lostpets.MatchMessage, error) {
+	if id > len(r.messages) {
+		return nil, nil
+	}
+	m := r.messages[id-1]
+	return &m, nil
+}
+
+func (r *fakeMessages) GetMatchMessages(pID int, sID int) ([]lostpets.MatchMessage, error) {
+	messages := []lostpets.MatchMessage{}
+	for _, m := range r.messages {
+		if m.PostingID == pID && m.SightingID == sID {
+			messages = append(messages, m)
+		}
+	}
+	return messages, nil
+}
+
+func (r *fakeMessages) MarkMatchMessagesRead(pID int, sID int, readerIsPosting bool, readOn time.Time) error {
+	for i, m := range r.messages {
+		if m.PostingID == pID && m.SightingID == sID && m.FromPosting != readerIsPosting && m.ReadOn == nil {
+			r.messages[i].ReadOn = &readOn
+		}
+	}
+	return nil
+}
+
+func (r *fakeMessages) MarkMatchMessageNotified(id int, notifiedOn time.Time) error {
+	r.messages[id-1].NotifiedOn = &notifiedOn
+	return nil
+}
+
+type fakeOutbox struct {
+	notifications []lostpets.Notification
+}
+
+func (o *fakeOutbox) Enqueue(n *lostpets.Notification) error {
+	o.notifications = append(o.notifications, *n)
+	return nil
+}
+
+// fakeGeocoder knows the places by their lower case name
+type fakeGeocoder map[string]lostpets.Place
+
+func (g fakeGeocoder) Geocode(location string) (*lostpets.Place, error) {
+	place, ok := g[strings.ToLower(location)]
+	if !ok {
+		return nil, nil
+	}
+	return &place, nil
+}
+
+/*
+testServer is the router StartServer serves, with the fakes behind it so tests can see what the handlers did.
+Every handler is on it, as they are in the api, so each test only sends the requests it is about.
+*/
+type testServer struct {
+	*echo.Echo
+	repo       *fakeLostPetsRepo
+	files      *fakeFileRepo
+	store      *fakeFileStore
+	moderation *fakeModeration
+	reports    *fakeReports
+	contacts   *fakeContacts
+	messages   *fakeMessages
+	queue      *fakeQueue
+}
+
+// testOptions are the records the fakes start with and the config the server is set up with
+type testOptions struct {
+	config    Config
+	postings  map[int]lostpets.Posting
+	sightings map[int]lostpets.Sighting
+	matches   map[[2]int]lostpets.Match
+	pictures  map[int]lostpets.FileMeta
+	contacts  []lostpets.Contact
+	templates *notifications.Registry
+	// now is the validator's clock, the real one when it isn't set
+	now time.Time
+}
+
+// testReportSecret is used when the options don't set server.reports.secret, the config always has one
+const testReportSecret = "report secret"
+
+// testPrincipals are the bearer tokens the test server accepts
+var testPrincipals = map[string]*lostpets.Principal{
+	"reporter": {UserID: 1, Role: lostpets.RoleReporter},
+	"staff":    {UserID: 2, Role: lostpets.RoleStaff},
+	"admin":    {UserID: 3, Role: lostpets.RoleAdmin},
+}
+
+func newTestServer(opts testOptions) *testServer {
+	if opts.postings == nil {
+		opts.postings = map[int]lostpets.Posting{}
+	}
+	if opts.sightings == nil {
+		opts.sightings = map[int]lostpets.Sighting{}
+	}
+	if opts.pictures == nil {
+		opts.pictures = map[int]lostpets.FileMeta{}
+	}
+	if opts.config.Reports.Secret == "" {
+		opts.config.Reports.Secret = testReportSecret
+	}
+
+	files := &fakeFileRepo{files: opts.pictures}
+	repo := &fakeLostPetsRepo{postings: opts.postings, sightings: opts.sightings, matches: opts.matches, files: files}
+	s := &testServer{
+		repo:       repo,
+		files:      files,
+		store:      &fakeFileStore{},
+		moderation: &fakeModeration{repo: repo, files: files},
+		reports:    &fakeReports{},
+		contacts:   &fakeContacts{contacts: opts.contacts},
+		messages:   &fakeMessages{},
+		queue:      &fakeQueue{},
+	}
+
+	s.Echo = newRouter(opts.config, Deps{
+		Repo:       repo,
+		Files:      files,
+		FileStore:  s.store,
+		Jobs:       s.queue,
+		Auth:       &fakeAuth{principals: testPrincipals},
+		Moderation: s.moderation,
+		Reports:    s.reports,
+		Contacts:   s.contacts,
+		Messages:   s.messages,
+		Templates:  opts.templates,
+		Logger:     nopLogger{},
+	}, "test")
+	s.Logger.SetOutput(io.Discard)
+
+	if !opts.now.IsZero() {
+		s.Validator.(*requestValidator).now = func() time.Time { return opts.now }
+	}
+	return s
+}
+
+func doRequest(e *echo.Echo, method string, path string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
@@ -37,12 +37,15 @@ func (h *fileHandler) handleServeFile() echo.HandlerFunc {
 		fileID := c.Param("id")
 		id, err := strconv.Atoi(fileID)
 		if err != nil {
-			return err
+			return domain.InvalidInput("invalid id").Wrap(err)
 		}
 		fileMeta, err := h.fileRepo.GetFileMeta(id)
 		if err != nil {
 			return err
 		}
+		if fileMeta == nil || fileMeta.HiddenOn != nil {
+			return c.NoContent(http.StatusNotFound)
+		}
 
 		filePath, err := h.fileStore.GetFile(fileMeta.GUID)
 		if err != nil {
@@ -93,3 +96,30 @@ func (h *fileHandler) handleUploadFile(location string) echo.HandlerFunc {
 		return nil
 	}
 }
+
+// petPicture returns the picture a pet uses, nil if it has none
+func petPicture(fileRepo domain.FileRepo, pictureID int) (*domain.FileMeta, error) {
+	if pictureID == 0 {
+		return nil, nil
+	}
+	return fileRepo.GetFileMeta(pictureID)
+}
+
+/*
+removePictureFile deletes the picture's file once a deleted record took its row with it, a picture shared with another pet is kept.
+The rows are already gone so a failure is only logged, the file is orphaned rather then served.
+*/
+func removePictureFile(fileRepo domain.FileRepo, fileStore domain.FileStore, logger domain.StructuredLogger, picture *domain.FileMeta) {
+	remaining, err := fileRepo.GetFileMeta(picture.ID)
+	if err != nil {
+		logger.Error("checking picture %d: %s", picture.ID, err)
+		return
+	}
+	if remaining != nil {
+		return
+	}
+
+	if err := fileStore.DeleteFile(picture.GUID); err != nil {
+		logger.Error("deleting file %s of picture %d: %s", picture.GUID, picture.ID, err)
+	}
+}
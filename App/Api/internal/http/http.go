@@ -1,15 +1,13 @@
 package http
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"html/template"
 	"log"
 	domain "lostpets"
+	"lostpets/internal/notifications"
 	"net/http"
-	"net/smtp"
-	"strconv"
 	"strings"
 	"time"
 
@@ -19,25 +17,80 @@ import (
 
 type (
 	Config struct {
-		Host         string      `json:"host"`
-		Port         int         `json:"port"`
-		FileLocation string      `json:"fileLocation"`
-		Debug        bool        `json:"debug"`
-		Email        EmailConfig `json:"emailSettings"`
+		Host string `json:"host"`
+		Port int    `json:"port"`
+		// Socket is a unix socket path to listen on instead of host and port
+		Socket       string             `json:"socket"`
+		TLS          TLSConfig          `json:"tls"`
+		Timeouts     TimeoutConfig      `json:"timeouts"`
+		FileLocation string             `json:"fileLocation"`
+		Debug        bool               `json:"debug"`
+		Email        EmailConfig        `json:"emailSettings"`
+		Reports      ReportConfig       `json:"reports"`
+		Verification VerificationConfig `json:"verification"`
+		// TrustProxy takes the client IP from X-Forwarded-For, only turn it on behind a proxy that sets the header
+		TrustProxy bool `json:"trustProxy"`
+		// Webhook is notifications.webhook, webhook urls are checked against it when owners set them
+		Webhook notifications.WebhookConfig `json:"-"`
+	}
+
+	/*
+		Deps are the stores and services the server and its jobs use, the postgres DB implements most of them.
+		Notifications is the outbox the admin api lists, Outbox is the sender that queues new notifications in it.
+	*/
+	Deps struct {
+		Repo          domain.LostPetsRepo
+		Files         domain.FileRepo
+		FileStore     domain.FileStore
+		Jobs          domain.JobQueue
+		Geocoder      domain.Geocoder
+		Auth          domain.AuthService
+		Moderation    domain.ModerationRepo
+		Reports       domain.ReportRepo
+		Contacts      domain.ContactRepo
+		Messages      domain.MessageRepo
+		Notifications domain.NotificationRepo
+		Outbox        domain.Outbox
+		Matches       domain.MatchService
+		Templates     *notifications.Registry
+		Logger        domain.StructuredLogger
 	}
 
 	EmailConfig struct {
-		Host     string           `json:"host"`
-		Port     int              `json:"port"`
-		User     string           `json:"user"`
-		Password string           `json:"password"`
+		notifications.SMTPConfig
 		LinkBase string           `json:"linkBase"`
 		Template TemplateSettings `json:"template"`
+		Relay    RelayConfig      `json:"relay"`
+	}
+
+	RelayConfig struct {
+		// Domain receives the replies to relayed messages, sent to contact+<alias>@Domain. Contact is turned off without it
+		Domain string `json:"domain"`
+		// InboundSecret is sent by the mail provider in the X-Relay-Secret header when it posts a reply to the inbound hook
+		InboundSecret string `json:"inboundSecret" secret:"true"`
+		// PerAddressPerHour is the most messages one IP address can send to owners in an hour, 0 for no limit
+		PerAddressPerHour int `json:"perAddressPerHour"`
+		// PerRecordPerHour is the most messages the owner of one posting or sighting can be sent in an hour, 0 for no limit
+		PerRecordPerHour int `json:"perRecordPerHour"`
+	}
+
+	/*
+		VerificationConfig turns on email verification, new postings and sightings stay pending until the owner follows the emailed link.
+		It is off without a Secret. LinkBase is the api's verify url the token is added to, e.g. http://localhost:8080/verify/
+	*/
+	VerificationConfig struct {
+		Secret   string `json:"secret" secret:"true"`
+		LinkBase string `json:"linkBase"`
+		// ValidFor is lifecycle.verifyWithinHours, pending records are expired after it
+		ValidFor time.Duration `json:"-"`
 	}
 
+	// TemplateSettings is where the email templates are, see notifications.Registry for the layout of Dir
 	TemplateSettings struct {
-		FilePath string `json:"filePath"`
-		Default  string `json:"default"`
+		Dir           string `json:"dir"`
+		DefaultLocale string `json:"defaultLocale"`
+		// Default is the text template for emails without one of their own
+		Default string `json:"default"`
 	}
 
 	response struct {
@@ -45,9 +98,26 @@ type (
 		Meta interface{} `json:"meta,omitempty"`
 	}
 
+	// emailer renders emails and puts them in the outbox, with a copy for each other channel the owner picked
 	emailer struct {
-		config EmailConfig
-		logger domain.StructuredLogger
+		config    EmailConfig
+		outbox    domain.Outbox
+		templates *notifications.Registry
+		logger    domain.StructuredLogger
+	}
+
+	/*
+		email is a plain text email unless it has a ContentType, Kind says what it is about in the outbox and ReplyTo is left out when empty.
+		Text is the short version sent by SMS and webhook, it defaults to the body.
+	*/
+	email struct {
+		Kind        string
+		To          string
+		ReplyTo     string
+		Subject     string
+		Body        string
+		ContentType string
+		Text        string
 	}
 
 	apiPetType struct {
@@ -62,16 +132,47 @@ const (
 	paramShipmentID = "shipment_id"
 	paramShareLink  = "share_link"
 
+	// notification kinds, what the emails in the outbox are about
+	kindMatches      = "matches"
+	kindContact      = "contact"
+	kindMatchMessage = "match-message"
+	kindVerification = "verification"
+	kindExpiring     = "posting-expiring"
+
 	//url paths
 	filePath      = "/pet-pictures"
 	postingsPath  = "/postings"
 	sightingsPath = "/sightings"
 )
 
-/*StartServer configures and starts a new http server*/
-func StartServer(config Config, db domain.LostPetsRepo, fileDb domain.FileRepo, fileStore domain.FileStore, logger domain.StructuredLogger, version string) {
+/*StartServer configures the http server and serves until ctx is done, it returns once the requests in flight have finished*/
+func StartServer(ctx context.Context, config Config, deps Deps, version string) error {
+	e := newRouter(config, deps, version)
+
+	if config.Debug {
+		data, _ := json.MarshalIndent(e.Routes(), "", "  ")
+		log.Print(string(data))
+	}
+
+	return serve(ctx, e, config, deps.Logger)
+}
+
+// newRouter sets up the middleware and every route, the tests build their server with it too
+func newRouter(config Config, deps Deps, version string) *echo.Echo {
+	db, fileDb, fileStore, jobs, logger := deps.Repo, deps.Files, deps.FileStore, deps.Jobs, deps.Logger
 
 	e := echo.New()
+	// client IPs tell anonymous reporters apart, so they can't come from a header anyone can set
+	if config.TrustProxy {
+		e.IPExtractor = echo.ExtractIPFromXFFHeader()
+	} else {
+		e.IPExtractor = echo.ExtractIPDirect()
+	}
+
+	// errors are written as problem+json, see errors.go
+	e.HTTPErrorHandler = errorHandler(logger)
+	// request bodies are checked with c.Validate, see validation.go
+	e.Validator = newRequestValidator(db, fileDb)
 
 	// Middleware
 	e.Use(middleware.Logger())
@@ -81,32 +182,61 @@ func StartServer(config Config, db domain.LostPetsRepo, fileDb domain.FileRepo,
 		Skipper:      middleware.DefaultSkipper,
 		AllowOrigins: []string{"*"},
 		AllowMethods: []string{http.MethodGet, http.MethodHead, http.MethodPut, http.MethodPatch, http.MethodPost, http.MethodDelete},
-		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderLocation},
+		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderLocation, echo.HeaderAuthorization},
 	}))
 
-	emailer := emailer{config: config.Email, logger: logger}
+	e.Use(authenticate(deps.Auth))
+
+	authHandler := authHandler{logger: logger, router: e, auth: deps.Auth}
+	authHandler.initRoute(authPath)
 
 	fileHandler := fileHandler{logger: logger, fileRepo: fileDb, fileStore: fileStore, router: e}
 	fileHandler.initRoute(filePath)
 
-	postingHandler := postingsHandler{logger: logger, router: e, repo: db, emailer: emailer}
+	verify := config.Verification.Secret != ""
+
+	postingHandler := postingsHandler{logger: logger, router: e, repo: db, fileRepo: fileDb, fileStore: fileStore, jobs: jobs, geocoder: deps.Geocoder, verify: verify}
 	postingHandler.initRoute(postingsPath)
 
-	sightingHandler := sightingsHandler{logger: logger, router: e, repo: db, emailer: emailer}
+	sightingHandler := sightingsHandler{logger: logger, router: e, repo: db, fileRepo: fileDb, fileStore: fileStore, jobs: jobs, geocoder: deps.Geocoder, verify: verify}
 	sightingHandler.initRoute(sightingsPath)
 
-	e.GET("/pet-types", getPetTypesHandler(db))
+	// postings and sightings are public as soon as they are created without verification
+	if verify {
+		verificationHandler := verificationHandler{logger: logger, router: e, repo: db, jobs: jobs, config: config.Verification, linkBase: config.Email.LinkBase}
+		verificationHandler.initRoute()
+	} else {
+		logger.Info("email verification is off, set server.verification.secret to turn it on")
+	}
 
-	e.GET("/", apiInfoHandler(version, config.Debug))
+	matchesHandler := matchesHandler{logger: logger, router: e, repo: db}
+	matchesHandler.initRoute()
 
-	if config.Debug {
-		data, _ := json.MarshalIndent(e.Routes(), "", "  ")
-		log.Print(string(data))
-	}
+	preferencesHandler := preferencesHandler{logger: logger, router: e, repo: db, webhooks: config.Webhook}
+	preferencesHandler.initRoute()
 
-	if err := e.Start(":8080"); err != http.ErrServerClosed {
-		log.Fatal(err)
+	messagesHandler := messagesHandler{logger: logger, router: e, repo: db, messages: deps.Messages, jobs: jobs, now: time.Now}
+	messagesHandler.initRoute()
+
+	reportsHandler := reportsHandler{logger: logger, router: e, repo: db, reports: deps.Reports, moderation: deps.Moderation, config: config.Reports}
+	reportsHandler.initRoute()
+
+	// contact needs somewhere for the replies to go
+	if config.Email.Relay.Domain != "" {
+		contactHandler := contactHandler{logger: logger, router: e, repo: db, contacts: deps.Contacts, jobs: jobs, config: config.Email.Relay, addressKey: config.Reports.Secret}
+		contactHandler.initRoute()
+	} else {
+		logger.Info("contact relay is off, set emailSettings.relay.domain to turn it on")
 	}
+
+	adminHandler := adminHandler{logger: logger, router: e.Group(adminPath, requireRole(domain.RoleAdmin)), repo: db, moderation: deps.Moderation, reports: deps.Reports, outbox: deps.Notifications, templates: deps.Templates, fileRepo: fileDb, fileStore: fileStore, jobs: jobs}
+	adminHandler.initRoute()
+
+	e.GET("/pet-types", getPetTypesHandler(db))
+
+	e.GET("/", apiInfoHandler(version, config.Debug))
+
+	return e
 }
 
 func apiInfoHandler(version string, debug bool) echo.HandlerFunc {
@@ -146,33 +276,208 @@ func getPetTypesHandler(db domain.LostPetsRepo) echo.HandlerFunc {
 
 }
 
-func (e emailer) emailMatches(mType, toEmail, guid string) error {
-	t, err := template.ParseFiles(e.config.Template.FilePath)
+// isVisible is true if the posting or sighting exists, isn't hidden and has been verified
+func isVisible(repo domain.LostPetsRepo, target domain.ModerationTarget, id int) (bool, error) {
+	if target == domain.TargetSighting {
+		sighting, err := repo.GetSightingByID(id)
+		return sighting != nil && sighting.HiddenOn == nil && sighting.Status != domain.StatusPending, err
+	}
+	posting, err := repo.GetPostingByID(id)
+	return posting != nil && posting.HiddenOn == nil && posting.Status != domain.StatusPending, err
+}
+
+// matchableChanged is true if any field matching.Matcher scores is different, so the record is matched again
+func matchableChanged(before, after domain.Posting) bool {
+	return !before.Date.Equal(after.Date) ||
+		before.Location != after.Location ||
+		!sameCoordinates(before.Coordinates, after.Coordinates) ||
+		before.Pet.TypeID != after.Pet.TypeID ||
+		before.Pet.Type != after.Pet.Type ||
+		!sameBreeds(before.Pet.Breeds, after.Pet.Breeds) ||
+		before.Pet.Color != after.Pet.Color ||
+		before.Pet.Marks != after.Pet.Marks ||
+		before.Pet.Tag.Shape != after.Pet.Tag.Shape ||
+		before.Pet.Tag.Color != after.Pet.Tag.Color ||
+		before.Pet.Tag.Text != after.Pet.Tag.Text
+}
+
+// sameBreeds ignores the order, the matcher compares breeds as a set
+func sameBreeds(a, b []string) bool {
+	set := map[string]int{}
+	for _, breed := range a {
+		set[breed]++
+	}
+	for _, breed := range b {
+		set[breed]--
+	}
+	for _, count := range set {
+		if count != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+geocode fills in the coordinates from the location text when they weren't given. The location is kept as the owner
+wrote it, the place's standard name is stored with the coordinates as the gazetteer can match a different place
+*/
+func geocode(geocoder domain.Geocoder, logger domain.StructuredLogger, posting *domain.Posting) {
+	if geocoder == nil || posting.Coordinates != nil || posting.Location == "" {
+		return
+	}
+
+	place, err := geocoder.Geocode(posting.Location)
 	if err != nil {
-		e.logger.Error(err.Error())
+		logger.Error("failed to geocode %q: %s", posting.Location, err)
+		return
+	}
+	if place == nil {
+		return
+	}
+
+	coordinates := place.Coordinates
+	coordinates.Place = place.Name
+	posting.Coordinates = &coordinates
+}
+
+/*
+geocodeUpdate keeps the geocoded coordinates of an updated record in step with its location. Coordinates the body
+didn't change and that were geocoded from the old location are dropped and looked up again for the new one,
+coordinates the owner gave are kept.
+*/
+func geocodeUpdate(geocoder domain.Geocoder, logger domain.StructuredLogger, before domain.Posting, after *domain.Posting) {
+	geocoded := before.Coordinates != nil && before.Coordinates.Place != ""
+	if geocoded && after.Coordinates != nil && samePoint(*before.Coordinates, *after.Coordinates) {
+		if before.Location == after.Location {
+			after.Coordinates = before.Coordinates
+			return
+		}
+		after.Coordinates = nil
 	}
-	data := struct {
-		Type string
-		Link string
-	}{
-		mType,
-		e.config.LinkBase + guid,
+	geocode(geocoder, logger, after)
+}
+
+// samePoint compares the coordinates without the place name, which the api doesn't take from the body
+func samePoint(a, b domain.Coordinates) bool {
+	return a.Lat == b.Lat && a.Lng == b.Lng && a.AccuracyKm == b.AccuracyKm
+}
+
+func sameCoordinates(a, b *domain.Coordinates) bool {
+	if a == nil || b == nil {
+		return a == b
 	}
-	buf := new(bytes.Buffer)
-	if err = t.Execute(buf, data); err != nil {
+	return *a == *b
+}
+
+// emailMatches tells the owner matches were found for their posting or sighting, with a link to their private page
+func (e emailer) emailMatches(mType string, owner domain.Posting) error {
+	data := notifications.MatchData{Type: mType, Link: e.config.LinkBase + owner.GUID}
+	m, err := renderEmail(e.templates, notifications.EventNewMatch, owner.Locale, data)
+	if err != nil {
 		return err
 	}
 
-	auth := smtp.PlainAuth("", e.config.User, e.config.Password, e.config.Host)
+	m.Kind = kindMatches
+	m.Text = "Matches have been found for your lost pets post: " + data.Link
+	return e.sendTo(owner, m)
+}
 
-	if err := smtp.SendMail(e.config.Host+":"+strconv.Itoa(e.config.Port), auth, e.config.User, []string{toEmail}, buf.Bytes()); err != nil {
+// emailExpiring warns the owner the posting is about to expire, by the channels they picked like the matches
+func (e emailer) emailExpiring(owner domain.Posting, expiresOn time.Time) error {
+	data := notifications.ExpiringData{Type: "posting", Link: e.config.LinkBase + owner.GUID, ExpiresOn: expiresOn}
+	m, err := renderEmail(e.templates, notifications.EventPostingExpiring, owner.Locale, data)
+	if err != nil {
 		return err
 	}
 
+	m.Kind = kindExpiring
+	m.Text = "Your lost pets post will expire on " + expiresOn.Format("2 January 2006") + ": " + data.Link
+	return e.sendTo(owner, m)
+}
+
+// renderEmail renders the event's templates in the locale into an email, multipart when there is an html part
+func renderEmail(templates *notifications.Registry, event notifications.Event, locale string, data interface{}) (email, error) {
+	message, err := templates.Render(event, locale, data)
+	if err != nil {
+		return email{}, fmt.Errorf("rendering %s email: %w", event, err)
+	}
+
+	contentType, body, err := message.MIME()
+	if err != nil {
+		return email{}, err
+	}
+	return email{Subject: message.Subject, Body: body, ContentType: contentType}, nil
+}
+
+/*
+sendTo puts a copy of the email in the outbox for each channel the owner is notified by, email when they haven't picked.
+Channels missing their phone number or webhook url are skipped.
+*/
+func (e emailer) sendTo(owner domain.Posting, m email) error {
+	channels := owner.NotifyBy
+	if len(channels) == 0 {
+		channels = []domain.Channel{domain.ChannelEmail}
+	}
+
+	text := m.Text
+	if text == "" {
+		text = m.Body
+	}
+	for _, channel := range channels {
+		recipient, secret := "", ""
+		switch channel {
+		case domain.ChannelEmail:
+			m.To = owner.Email
+			if err := e.send(m); err != nil {
+				return err
+			}
+			continue
+		case domain.ChannelSMS:
+			recipient = owner.Phone
+		case domain.ChannelWebhook:
+			recipient = owner.WebhookURL
+			secret = owner.WebhookSecret
+		}
+		if recipient == "" {
+			e.logger.Info("not sending %s by %s, there is nowhere to send it", m.Kind, channel)
+			continue
+		}
+
+		err := e.outbox.Enqueue(&domain.Notification{
+			Kind:        m.Kind,
+			Channel:     channel,
+			Recipient:   recipient,
+			Secret:      secret,
+			Subject:     m.Subject,
+			Body:        text,
+			ContentType: "text/plain; charset=UTF-8",
+		})
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-//custom time type to unmarshal time formats
+// send puts the email in the outbox, whatever the recipient's channels
+func (e emailer) send(m email) error {
+	contentType := m.ContentType
+	if contentType == "" {
+		contentType = "text/plain; charset=UTF-8"
+	}
+	return e.outbox.Enqueue(&domain.Notification{
+		Kind:        m.Kind,
+		Channel:     domain.ChannelEmail,
+		Recipient:   m.To,
+		ReplyTo:     m.ReplyTo,
+		Subject:     m.Subject,
+		Body:        m.Body,
+		ContentType: contentType,
+	})
+}
+
+// custom time type to unmarshal time formats
 type Datetime struct {
 	time.Time
 }
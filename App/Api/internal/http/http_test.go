@@ -0,0 +1,86 @@
+package http
+
+import (
+	"lostpets"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchableChanged(t *testing.T) {
+	before := lostpets.Posting{
+		Date:     time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC),
+		Location: "Main St",
+		Name:     "Owner",
+		Pet: lostpets.Pet{
+			TypeID: 1,
+			Name:   "Rex",
+			Color:  "black",
+			Breeds: []string{"lab", "collie"},
+			Tag:    lostpets.Tag{ID: 3, Shape: "bone", Text: "REX"},
+		},
+	}
+
+	changes := map[string]func(p *lostpets.Posting){
+		"date":      func(p *lostpets.Posting) { p.Date = p.Date.Add(24 * time.Hour) },
+		"location":  func(p *lostpets.Posting) { p.Location = "5th Ave" },
+		"coords":    func(p *lostpets.Posting) { p.Coordinates = &lostpets.Coordinates{Lat: 43.65, Lng: -79.38} },
+		"type":      func(p *lostpets.Posting) { p.Pet.TypeID = 2 },
+		"breeds":    func(p *lostpets.Posting) { p.Pet.Breeds = []string{"lab"} },
+		"color":     func(p *lostpets.Posting) { p.Pet.Color = "brown" },
+		"marks":     func(p *lostpets.Posting) { p.Pet.Marks = "white paw" },
+		"tag shape": func(p *lostpets.Posting) { p.Pet.Tag.Shape = "circle" },
+		"tag color": func(p *lostpets.Posting) { p.Pet.Tag.Color = "red" },
+		"tag text":  func(p *lostpets.Posting) { p.Pet.Tag.Text = "MAX" },
+	}
+	for name, change := range changes {
+		after := before
+		after.Pet.Breeds = append([]string{}, before.Pet.Breeds...)
+		change(&after)
+		assert.True(t, matchableChanged(before, after), name)
+	}
+
+	//fields the matcher doesn't score don't match the record again
+	after := before
+	after.Name = "New Owner"
+	after.Pet.Name = "Max"
+	after.Pet.Tag.ID = 4
+	after.Pet.Breeds = []string{"collie", "lab"}
+	assert.False(t, matchableChanged(before, after))
+}
+
+func TestGeocode(t *testing.T) {
+	geocoder := fakeGeocoder{
+		"main":    {Name: "Main Street", Coordinates: lostpets.Coordinates{Lat: 43.65, Lng: -79.38, AccuracyKm: 0.5}},
+		"5th ave": {Name: "5th Avenue", Coordinates: lostpets.Coordinates{Lat: 43.66, Lng: -79.39, AccuracyKm: 0.5}},
+	}
+	mainSt := &lostpets.Coordinates{Lat: 43.65, Lng: -79.38, AccuracyKm: 0.5, Place: "Main Street"}
+
+	//the owner's text is kept, the gazetteer's name goes with the coordinates
+	posting := lostpets.Posting{Location: "main"}
+	geocode(geocoder, nopLogger{}, &posting)
+	assert.Equal(t, "main", posting.Location)
+	assert.Equal(t, mainSt, posting.Coordinates)
+
+	//the body has the stored coordinates without the place name when only other fields change
+	after := lostpets.Posting{Location: "main", Coordinates: &lostpets.Coordinates{Lat: 43.65, Lng: -79.38, AccuracyKm: 0.5}}
+	geocodeUpdate(geocoder, nopLogger{}, posting, &after)
+	assert.Equal(t, mainSt, after.Coordinates)
+
+	//a new location is looked up again
+	after = lostpets.Posting{Location: "5th ave", Coordinates: &lostpets.Coordinates{Lat: 43.65, Lng: -79.38, AccuracyKm: 0.5}}
+	geocodeUpdate(geocoder, nopLogger{}, posting, &after)
+	assert.Equal(t, "5th Avenue", after.Coordinates.Place)
+
+	//a location the gazetteer doesn't know doesn't keep the old place's coordinates
+	after = lostpets.Posting{Location: "the park", Coordinates: &lostpets.Coordinates{Lat: 43.65, Lng: -79.38, AccuracyKm: 0.5}}
+	geocodeUpdate(geocoder, nopLogger{}, posting, &after)
+	assert.Nil(t, after.Coordinates)
+
+	//coordinates the owner gave are kept
+	owners := lostpets.Posting{Location: "main", Coordinates: &lostpets.Coordinates{Lat: 1, Lng: 2}}
+	after = lostpets.Posting{Location: "5th ave", Coordinates: &lostpets.Coordinates{Lat: 1, Lng: 2}}
+	geocodeUpdate(geocoder, nopLogger{}, owners, &after)
+	assert.Equal(t, &lostpets.Coordinates{Lat: 1, Lng: 2}, after.Coordinates)
+}
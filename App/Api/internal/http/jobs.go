@@ -0,0 +1,184 @@
+package http
+
+import (
+	"encoding/json"
+	domain "lostpets"
+	"lostpets/internal/jobs"
+	"lostpets/internal/lifecycle"
+	"time"
+)
+
+type (
+	// matchJob is the payload for the match jobs, the record is loaded when the job runs so it sees the latest changes
+	matchJob struct {
+		ID int `json:"id"`
+	}
+
+	// messageJob is the payload for relaying a contact message or emailing about a match message
+	messageJob struct {
+		ID int `json:"id"`
+	}
+
+	// verificationJob emails the link that verifies the record's email
+	verificationJob struct {
+		Target domain.ModerationTarget `json:"target"`
+		ID     int                     `json:"id"`
+	}
+
+	// emailMatchesJob has no email address, it is loaded when the job runs so a deleted record's owner isn't emailed
+	emailMatchesJob struct {
+		Type string `json:"type"`
+		GUID string `json:"guid"`
+	}
+)
+
+// job kinds
+const (
+	jobMatchPosting      = "match-posting"
+	jobMatchSighting     = "match-sighting"
+	jobEmailMatches      = "email-matches"
+	jobRelayContact      = "relay-contact"
+	jobEmailMatchMessage = "email-match-message"
+	jobEmailVerification = "email-verification"
+)
+
+/*
+RegisterJobs adds the handlers for the jobs queued by the api to the pool.
+Matching jobs queue an email job when matches are found so a failed email is retried without matching again.
+Emails go into the outbox, its sender delivers them and retries the ones that fail to send.
+*/
+func RegisterJobs(pool *jobs.Pool, config Config, deps Deps) {
+	repo, templates, logger := deps.Repo, deps.Templates, deps.Logger
+	emailer := emailer{config: config.Email, outbox: deps.Outbox, templates: templates, logger: logger}
+	relay := relay{send: emailer.send, domain: config.Email.Relay.Domain, repo: repo, contacts: deps.Contacts, logger: logger, now: time.Now}
+	verifier := verificationMailer{send: emailer.send, templates: templates, config: config.Verification, repo: repo, logger: logger}
+	notifier := messageNotifier{send: emailer.sendTo, templates: templates, linkBase: config.Email.LinkBase, repo: repo, messages: deps.Messages, logger: logger, now: time.Now}
+
+	rematch := recordMatcher{repo: repo, matches: deps.Matches, queue: pool}
+	pool.Register(jobMatchPosting, rematch.matchPosting)
+	pool.Register(jobMatchSighting, rematch.matchSighting)
+
+	pool.Register(jobEmailMatches, func(payload []byte) error {
+		var job emailMatchesJob
+		if err := json.Unmarshal(payload, &job); err != nil {
+			return err
+		}
+		owner, err := matchesOwner(repo, job)
+		if err != nil || owner == nil {
+			return err
+		}
+		return emailer.emailMatches(job.Type, *owner)
+	})
+
+	pool.Register(jobRelayContact, func(payload []byte) error {
+		var job messageJob
+		if err := json.Unmarshal(payload, &job); err != nil {
+			return err
+		}
+		return relay.relayMessage(job.ID)
+	})
+
+	pool.Register(jobEmailMatchMessage, func(payload []byte) error {
+		var job messageJob
+		if err := json.Unmarshal(payload, &job); err != nil {
+			return err
+		}
+		return notifier.notify(job.ID)
+	})
+
+	pool.Register(jobEmailVerification, func(payload []byte) error {
+		var job verificationJob
+		if err := json.Unmarshal(payload, &job); err != nil {
+			return err
+		}
+		return verifier.emailLink(job)
+	})
+
+	pool.Register(lifecycle.JobEmailExpiring, func(payload []byte) error {
+		var job lifecycle.ExpiringJob
+		if err := json.Unmarshal(payload, &job); err != nil {
+			return err
+		}
+
+		posting, err := repo.GetPostingByID(job.ID)
+		if err != nil {
+			return err
+		}
+		if posting == nil || posting.Status != domain.StatusOpen {
+			// closed or reunited since it was warned
+			return nil
+		}
+		return emailer.emailExpiring(*posting, job.ExpiresOn)
+	})
+}
+
+// recordMatcher runs the match jobs, owners are only emailed when the job found matches that weren't there before
+type recordMatcher struct {
+	repo    domain.LostPetsRepo
+	matches domain.MatchService
+	queue   domain.JobQueue
+}
+
+func (m recordMatcher) matchPosting(payload []byte) error {
+	var job matchJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return err
+	}
+
+	posting, err := m.repo.GetPostingByID(job.ID)
+	if err != nil {
+		return err
+	}
+	if posting == nil || posting.Status == domain.StatusPending {
+		// deleted before the job ran, or matched once it's verified
+		return nil
+	}
+
+	found, err := m.matches.MatchPosting(*posting)
+	if err != nil || found == 0 {
+		return err
+	}
+	//email will have link to 'private' page in UI which will query for found matches
+	return m.queue.Enqueue(jobEmailMatches, emailMatchesJob{Type: "Postings", GUID: posting.GUID})
+}
+
+func (m recordMatcher) matchSighting(payload []byte) error {
+	var job matchJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return err
+	}
+
+	sighting, err := m.repo.GetSightingByID(job.ID)
+	if err != nil {
+		return err
+	}
+	if sighting == nil || sighting.Status == domain.StatusPending {
+		return nil
+	}
+
+	found, err := m.matches.MatchSighting(*sighting)
+	if err != nil || found == 0 {
+		return err
+	}
+	return m.queue.Enqueue(jobEmailMatches, emailMatchesJob{Type: "Sighting", GUID: sighting.GUID})
+}
+
+// queueMatch queues a match job for the record, matching isn't needed to finish the request so failures are only logged
+func queueMatch(queue domain.JobQueue, logger domain.StructuredLogger, kind string, id int) {
+	if err := queue.Enqueue(kind, matchJob{ID: id}); err != nil {
+		logger.Error("failed to queue %s for %d: %s", kind, id, err)
+	}
+}
+
+// matchesOwner is the record the matches were found for, nil if it was deleted since and the job is dropped
+func matchesOwner(repo domain.LostPetsRepo, job emailMatchesJob) (*domain.Posting, error) {
+	if job.Type == "Postings" {
+		return repo.GetPostingByGUID(job.GUID)
+	}
+
+	sighting, err := repo.GetSightingByGUID(job.GUID)
+	if err != nil || sighting == nil {
+		return nil, err
+	}
+	return &sighting.Posting, nil
+}
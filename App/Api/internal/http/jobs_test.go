@@ -0,0 +1,163 @@
+package http
+
+import (
+	"io"
+	"lostpets"
+	"lostpets/internal/matching"
+	"lostpets/internal/notifications"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmailMatchesGoesToOutbox(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "en"), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "en", "new-match.html"), []byte(`Matches for your {{.Type}}: <a href="{{.Link}}">view</a>`), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "en", "new-match.txt"), []byte(`Matches for your {{.Type}}: {{.Link}}`), 0o600))
+	templates, err := notifications.NewRegistry(dir, "en", "")
+	require.NoError(t, err)
+
+	outbox := &fakeOutbox{}
+	emailer := emailer{
+		config:    EmailConfig{LinkBase: "http://localhost/postings/private/"},
+		outbox:    outbox,
+		templates: templates,
+		logger:    nopLogger{},
+	}
+
+	require.NoError(t, emailer.emailMatches("Postings", lostpets.Posting{Email: "owner@example.org", GUID: "guid"}))
+	require.Len(t, outbox.notifications, 1)
+	n := outbox.notifications[0]
+	assert.Equal(t, kindMatches, n.Kind)
+	assert.Equal(t, "owner@example.org", n.Recipient)
+	assert.Equal(t, "Matches have been found for your lost pets post", n.Subject)
+
+	mediaType, params, err := mime.ParseMediaType(n.ContentType)
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/alternative", mediaType)
+	parts := multipart.NewReader(strings.NewReader(n.Body), params["boundary"])
+	for _, expected := range []string{
+		`Matches for your Postings: http://localhost/postings/private/guid`,
+		`Matches for your Postings: <a href="http://localhost/postings/private/guid">view</a>`,
+	} {
+		part, err := parts.NextPart()
+		require.NoError(t, err)
+		body, _ := io.ReadAll(part)
+		assert.Equal(t, expected, string(body))
+	}
+
+	//an owner's locale without templates gets the default one
+	require.NoError(t, emailer.emailMatches("Postings", lostpets.Posting{
+		Email: "owner@example.org", GUID: "guid", NotificationPreferences: lostpets.NotificationPreferences{Locale: "fr"}}))
+	assert.Equal(t, n.Subject, outbox.notifications[1].Subject)
+
+	//a template that doesn't parse is an error for the job to retry
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "en", "new-match.subject"), []byte(`{{.Type`), 0o600))
+	assert.Error(t, emailer.emailMatches("Postings", lostpets.Posting{Email: "owner@example.org", GUID: "guid"}))
+	assert.Len(t, outbox.notifications, 2)
+}
+
+func TestEmailExpiring(t *testing.T) {
+	templates, err := notifications.NewRegistry(filepath.Join("..", "..", "config", "templates"), "en", "")
+	require.NoError(t, err)
+
+	outbox := &fakeOutbox{}
+	emailer := emailer{config: EmailConfig{LinkBase: "http://localhost/postings/private/"}, outbox: outbox, templates: templates, logger: nopLogger{}}
+
+	expiresOn := time.Date(2026, 11, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, emailer.emailExpiring(lostpets.Posting{Email: "owner@example.org", GUID: "guid"}, expiresOn))
+	require.Len(t, outbox.notifications, 1)
+	n := outbox.notifications[0]
+	assert.Equal(t, kindExpiring, n.Kind)
+	assert.Equal(t, "owner@example.org", n.Recipient)
+	assert.Equal(t, "Your lost pets post is about to expire", n.Subject)
+	assert.Contains(t, n.Body, "Your posting will expire on 1 November 2026")
+	assert.Contains(t, n.Body, "http://localhost/postings/private/guid")
+}
+
+func TestMatchesOwner(t *testing.T) {
+	repo := &fakeLostPetsRepo{
+		postings:  map[int]lostpets.Posting{1: {ID: 1, GUID: "posting-guid", Email: "owner@example.org"}},
+		sightings: map[int]lostpets.Sighting{10: {Posting: lostpets.Posting{ID: 10, GUID: "sighting-guid", Email: "finder@example.org"}}},
+	}
+
+	owner, err := matchesOwner(repo, emailMatchesJob{Type: "Postings", GUID: "posting-guid"})
+	require.NoError(t, err)
+	assert.Equal(t, "owner@example.org", owner.Email)
+
+	owner, err = matchesOwner(repo, emailMatchesJob{Type: "Sighting", GUID: "sighting-guid"})
+	require.NoError(t, err)
+	assert.Equal(t, "finder@example.org", owner.Email)
+
+	//deleted since the matches were found, there's no one left to email
+	owner, err = matchesOwner(repo, emailMatchesJob{Type: "Postings", GUID: "deleted-guid"})
+	require.NoError(t, err)
+	assert.Nil(t, owner)
+}
+
+func TestRematchOnlyEmailsNewMatches(t *testing.T) {
+	dog := lostpets.Pet{TypeID: 1, Color: "black", Breeds: []string{"lab"}}
+	seen := time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+	repo := &fakeLostPetsRepo{
+		postings: map[int]lostpets.Posting{1: {ID: 1, GUID: "posting-guid", Status: lostpets.StatusOpen, Date: seen, Location: "Main St", Pet: dog}},
+		sightings: map[int]lostpets.Sighting{10: {Posting: lostpets.Posting{
+			ID: 10, Status: lostpets.StatusOpen, Date: seen, Location: "Main St", Pet: dog}}},
+	}
+	queue := &fakeQueue{}
+	rematch := recordMatcher{repo: repo, matches: matching.NewService(repo, matching.NewMatcher(matching.Config{}), nopLogger{}), queue: queue}
+
+	require.NoError(t, rematch.matchPosting([]byte(`{"id": 1}`)))
+	assert.Equal(t, []string{jobEmailMatches}, queue.kinds)
+	assert.Contains(t, repo.matches, [2]int{1, 10})
+
+	//an edit, unhide or verification replay matches the unchanged pair again, the owner has already been told about it
+	require.NoError(t, rematch.matchPosting([]byte(`{"id": 1}`)))
+	assert.Equal(t, []string{jobEmailMatches}, queue.kinds)
+}
+
+func TestSendToOwnerChannels(t *testing.T) {
+	outbox := &fakeOutbox{}
+	emailer := emailer{outbox: outbox, logger: nopLogger{}}
+	owner := lostpets.Posting{Email: "owner@example.org"}
+	m := email{Kind: kindMatchMessage, Subject: "A new message", Body: "The whole message", Text: "Read it here"}
+
+	//owners who haven't picked get email
+	require.NoError(t, emailer.sendTo(owner, m))
+	require.Len(t, outbox.notifications, 1)
+	assert.Equal(t, lostpets.ChannelEmail, outbox.notifications[0].Channel)
+	assert.Equal(t, "The whole message", outbox.notifications[0].Body)
+
+	//the webhook is skipped without a url
+	outbox.notifications = nil
+	owner.NotificationPreferences = lostpets.NotificationPreferences{
+		NotifyBy: []lostpets.Channel{lostpets.ChannelSMS, lostpets.ChannelWebhook},
+		Phone:    "+15551234567",
+	}
+	require.NoError(t, emailer.sendTo(owner, m))
+	require.Len(t, outbox.notifications, 1)
+	n := outbox.notifications[0]
+	assert.Equal(t, lostpets.ChannelSMS, n.Channel)
+	assert.Equal(t, "+15551234567", n.Recipient)
+	assert.Equal(t, "Read it here", n.Body)
+	assert.Equal(t, kindMatchMessage, n.Kind)
+
+	//webhooks carry the owner's own secret to be signed with
+	outbox.notifications = nil
+	owner.NotificationPreferences = lostpets.NotificationPreferences{
+		NotifyBy:      []lostpets.Channel{lostpets.ChannelWebhook},
+		WebhookURL:    "https://hooks.example.org/lostpets",
+		WebhookSecret: "owner-secret",
+	}
+	require.NoError(t, emailer.sendTo(owner, m))
+	require.Len(t, outbox.notifications, 1)
+	assert.Equal(t, "https://hooks.example.org/lostpets", outbox.notifications[0].Recipient)
+	assert.Equal(t, "owner-secret", outbox.notifications[0].Secret)
+}
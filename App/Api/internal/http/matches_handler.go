@@ -0,0 +1,149 @@
+package http
+
+import (
+	domain "lostpets"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type (
+	matchesHandler struct {
+		logger domain.StructuredLogger
+		router *echo.Echo
+		repo   domain.LostPetsRepo
+	}
+
+	// matchView is a match as seen by the owner of one side of it, isPosting is true for the posting's owner
+	matchView struct {
+		postingID  int
+		sightingID int
+		isPosting  bool
+	}
+
+	apiMatchResponse struct {
+		Match *apiMatch `json:"match,omitempty"`
+	}
+
+	apiMatch struct {
+		PostingID     int        `json:"postingId"`
+		SightingID    int        `json:"sightingId"`
+		Score         float64    `json:"score"`
+		LastContacted *time.Time `json:"lastContacted,omitempty"`
+		Decision      string     `json:"decision,omitempty"`
+		DecidedBy     string     `json:"decidedBy,omitempty"`
+		DecidedOn     *time.Time `json:"decidedOn,omitempty"`
+	}
+
+	apiMatchDecision struct {
+		Decision string `json:"decision"`
+	}
+)
+
+var matchDecisions = []string{string(domain.MatchConfirmed), string(domain.MatchDismissed)}
+
+func (h *matchesHandler) initRoute() {
+	h.router.PUT(postingsPath+"/private/:guid/matches/:sid/decision", h.handleDecideMatch(domain.TargetPosting))
+	h.router.PUT(sightingsPath+"/private/:guid/matches/:pid/decision", h.handleDecideMatch(domain.TargetSighting))
+}
+
+/*
+handleDecideMatch lets either owner confirm or dismiss a match, the latest decision stands.
+Dismissed matches are no longer listed and aren't matched again, a dismissal made by mistake can still be confirmed.
+*/
+func (h *matchesHandler) handleDecideMatch(side domain.ModerationTarget) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		request := new(apiMatchDecision)
+		if err := c.Bind(request); err != nil {
+			return err
+		}
+
+		decision := domain.MatchDecision(request.Decision)
+		if decision != domain.MatchConfirmed && decision != domain.MatchDismissed {
+			return echo.NewHTTPError(http.StatusBadRequest, &queryError{Message: "unknown decision", Param: "decision", Valid: matchDecisions})
+		}
+
+		view, _, err := privateMatch(h.repo, c, side)
+		if err != nil {
+			return err
+		}
+		if view == nil {
+			return c.NoContent(http.StatusNotFound)
+		}
+
+		if err := h.repo.DecideMatch(view.postingID, view.sightingID, decision, side); err != nil {
+			return err
+		}
+
+		match, err := h.repo.GetMatch(view.postingID, view.sightingID)
+		if err != nil {
+			return err
+		}
+		if match == nil {
+			return c.NoContent(http.StatusNotFound)
+		}
+		return c.JSON(http.StatusOK, apiMatchResponse{Match: toAPIMatch(*match)})
+	}
+}
+
+/*
+privateMatch finds the match between the record with the private guid and the other side's id from the path.
+It is nil when the two aren't matched or either is hidden, so a match can't be used to reach a record that was taken down.
+*/
+func privateMatch(repo domain.LostPetsRepo, c echo.Context, side domain.ModerationTarget) (*matchView, *domain.Match, error) {
+	guid := c.Param("guid")
+	view := &matchView{isPosting: side == domain.TargetPosting}
+	var own *domain.Posting
+	other := domain.TargetPosting
+	otherID := 0
+
+	if view.isPosting {
+		posting, err := repo.GetPostingByGUID(guid)
+		if err != nil || posting == nil {
+			return nil, nil, err
+		}
+		if view.sightingID, err = strconv.Atoi(c.Param("sid")); err != nil {
+			return nil, nil, echo.NewHTTPError(http.StatusBadRequest, "invalid id")
+		}
+		own, view.postingID = posting, posting.ID
+		other, otherID = domain.TargetSighting, view.sightingID
+	} else {
+		sighting, err := repo.GetSightingByGUID(guid)
+		if err != nil || sighting == nil {
+			return nil, nil, err
+		}
+		if view.postingID, err = strconv.Atoi(c.Param("pid")); err != nil {
+			return nil, nil, echo.NewHTTPError(http.StatusBadRequest, "invalid id")
+		}
+		own, view.sightingID = &sighting.Posting, sighting.ID
+		otherID = view.postingID
+	}
+	if own.HiddenOn != nil {
+		return nil, nil, nil
+	}
+
+	match, err := repo.GetMatch(view.postingID, view.sightingID)
+	if err != nil || match == nil {
+		return nil, nil, err
+	}
+
+	visible, err := isVisible(repo, other, otherID)
+	if err != nil || !visible {
+		return nil, nil, err
+	}
+	return view, match, nil
+}
+
+func toAPIMatch(m domain.Match) *apiMatch {
+	return &apiMatch{
+		PostingID:     m.PostingID,
+		SightingID:    m.SightingID,
+		Score:         m.Score,
+		LastContacted: m.LastContacted,
+		Decision:      string(m.Decision),
+		DecidedBy:     string(m.DecidedBy),
+		DecidedOn:     m.DecidedOn,
+	}
+}
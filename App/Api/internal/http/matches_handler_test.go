@@ -0,0 +1,52 @@
+package http
+
+import (
+	"encoding/json"
+	"lostpets"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decide(e *echo.Echo, path string, decision string) (*httptest.ResponseRecorder, apiMatchResponse) {
+	req := httptest.NewRequest(http.MethodPut, path, strings.NewReader(`{"decision": "`+decision+`"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	resp := apiMatchResponse{}
+	if rec.Code == http.StatusOK {
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+	}
+	return rec, resp
+}
+
+func TestDecideMatch(t *testing.T) {
+	s := newTestServer(matchedOptions())
+
+	rec, resp := decide(s.Echo, "/postings/private/posting-guid/matches/10/decision", "dismissed")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "dismissed", resp.Match.Decision)
+	assert.Equal(t, "posting", resp.Match.DecidedBy)
+
+	//a dismissed match has no thread
+	rec, _ = threadRequest(s.Echo, http.MethodGet, "/postings/private/posting-guid/matches/10/messages", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	//either side can change the decision
+	rec, resp = decide(s.Echo, "/sightings/private/sighting-guid/matches/1/decision", "confirmed")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, lostpets.Match{PostingID: 1, SightingID: 10, Decision: lostpets.MatchConfirmed, DecidedBy: lostpets.TargetSighting}, s.repo.matches[[2]int{1, 10}])
+
+	rec, _ = decide(s.Echo, "/postings/private/posting-guid/matches/10/decision", "maybe")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec, _ = decide(s.Echo, "/postings/private/posting-guid/matches/11/decision", "dismissed")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec, _ = decide(s.Echo, "/postings/private/posting-guid/matches/12/decision", "dismissed")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,194 @@
+package http
+
+import (
+	"fmt"
+	domain "lostpets"
+	"lostpets/internal/notifications"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+)
+
+type (
+	messagesHandler struct {
+		logger   domain.StructuredLogger
+		router   *echo.Echo
+		repo     domain.LostPetsRepo
+		messages domain.MessageRepo
+		jobs     domain.JobQueue
+		now      func() time.Time
+	}
+
+	// messageNotifier emails the other side of a thread when a message is written to them
+	messageNotifier struct {
+		send      func(owner domain.Posting, m email) error
+		templates *notifications.Registry
+		linkBase  string
+		repo      domain.LostPetsRepo
+		messages  domain.MessageRepo
+		logger    domain.StructuredLogger
+		now       func() time.Time
+	}
+
+	apiMessagesResponse struct {
+		Message  *apiMatchMessage   `json:"message,omitempty"`
+		Messages *[]apiMatchMessage `json:"messages,omitempty"`
+	}
+
+	// apiMatchMessage is a message as the reader sees it, Mine is true for the ones they wrote
+	apiMatchMessage struct {
+		ID        int        `json:"id"`
+		Mine      bool       `json:"mine"`
+		Message   string     `json:"message"`
+		CreatedOn time.Time  `json:"createdOn"`
+		ReadOn    *time.Time `json:"readOn,omitempty"`
+	}
+
+	apiPostMessage struct {
+		Message string `json:"message"`
+	}
+)
+
+func (h *messagesHandler) initRoute() {
+	h.router.GET(postingsPath+"/private/:guid/matches/:sid/messages", h.handleGetMessages(domain.TargetPosting))
+	h.router.POST(postingsPath+"/private/:guid/matches/:sid/messages", h.handleAddMessage(domain.TargetPosting))
+	h.router.GET(sightingsPath+"/private/:guid/matches/:pid/messages", h.handleGetMessages(domain.TargetSighting))
+	h.router.POST(sightingsPath+"/private/:guid/matches/:pid/messages", h.handleAddMessage(domain.TargetSighting))
+}
+
+// handleGetMessages returns the thread, reading it marks the messages from the other side as read
+func (h *messagesHandler) handleGetMessages(side domain.ModerationTarget) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		t, err := h.thread(c, side)
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			return c.NoContent(http.StatusNotFound)
+		}
+
+		if err := h.messages.MarkMatchMessagesRead(t.postingID, t.sightingID, t.isPosting, h.now()); err != nil {
+			return err
+		}
+
+		messages, err := h.messages.GetMatchMessages(t.postingID, t.sightingID)
+		if err != nil {
+			return err
+		}
+
+		apiMessages := []apiMatchMessage{}
+		for _, m := range messages {
+			apiMessages = append(apiMessages, toAPIMatchMessage(m, t.isPosting))
+		}
+		return c.JSON(http.StatusOK, apiMessagesResponse{Messages: &apiMessages})
+	}
+}
+
+// handleAddMessage adds a message to the thread and queues the email telling the other side about it
+func (h *messagesHandler) handleAddMessage(side domain.ModerationTarget) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		request := new(apiPostMessage)
+		if err := c.Bind(request); err != nil {
+			return err
+		}
+
+		text := strings.TrimSpace(request.Message)
+		if text == "" || utf8.RuneCountInString(text) > maxContactMessage {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("message must be 1 to %d characters", maxContactMessage))
+		}
+
+		t, err := h.thread(c, side)
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			return c.NoContent(http.StatusNotFound)
+		}
+
+		message := &domain.MatchMessage{PostingID: t.postingID, SightingID: t.sightingID, FromPosting: t.isPosting, Message: text}
+		if err := h.messages.AddMatchMessage(message); err != nil {
+			return err
+		}
+
+		// the message is saved, a missing email shouldn't fail the request
+		if err := h.jobs.Enqueue(jobEmailMatchMessage, messageJob{ID: message.ID}); err != nil {
+			h.logger.Error("failed to queue email for match message %d: %s", message.ID, err)
+		}
+
+		apiMessage := toAPIMatchMessage(*message, t.isPosting)
+		return c.JSON(http.StatusCreated, apiMessagesResponse{Message: &apiMessage})
+	}
+}
+
+// thread is the match's conversation, dismissed matches don't have one
+func (h *messagesHandler) thread(c echo.Context, side domain.ModerationTarget) (*matchView, error) {
+	view, match, err := privateMatch(h.repo, c, side)
+	if err != nil || view == nil {
+		return nil, err
+	}
+	if match.Decision == domain.MatchDismissed {
+		return nil, nil
+	}
+	return view, nil
+}
+
+func toAPIMatchMessage(m domain.MatchMessage, readerIsPosting bool) apiMatchMessage {
+	return apiMatchMessage{
+		ID:        m.ID,
+		Mine:      m.FromPosting == readerIsPosting,
+		Message:   m.Message,
+		CreatedOn: m.CreatedOn,
+		ReadOn:    m.ReadOn,
+	}
+}
+
+/*
+notify sends the recipient of a message a link to their private page to read and answer it.
+Messages already read or notified about are skipped, so a retried job or a reader who was quicker than the email isn't sent one.
+*/
+func (n messageNotifier) notify(id int) error {
+	message, err := n.messages.GetMatchMessage(id)
+	if err != nil {
+		return err
+	}
+	if message == nil || message.ReadOn != nil || message.NotifiedOn != nil {
+		return nil
+	}
+
+	target, other := domain.TargetPosting, domain.TargetSighting
+	var recipient *domain.Posting
+	if message.FromPosting {
+		target, other = other, target
+		sighting, err := n.repo.GetSightingByID(message.SightingID)
+		if err != nil {
+			return err
+		}
+		if sighting != nil {
+			recipient = &sighting.Posting
+		}
+	} else {
+		if recipient, err = n.repo.GetPostingByID(message.PostingID); err != nil {
+			return err
+		}
+	}
+	if recipient == nil || recipient.HiddenOn != nil {
+		n.logger.Info("not emailing about match message %d, the %s is gone", message.ID, target)
+		return nil
+	}
+
+	data := notifications.MessageData{Target: string(target), Other: string(other), Message: message.Message, Link: n.linkBase + recipient.GUID}
+	m, err := renderEmail(n.templates, notifications.EventNewMessage, recipient.Locale, data)
+	if err != nil {
+		return err
+	}
+
+	m.Kind = kindMatchMessage
+	m.Text = fmt.Sprintf("The owner of a matching %s sent you a message, read it on your %s's page: %s", other, target, data.Link)
+	if err := n.send(*recipient, m); err != nil {
+		return err
+	}
+	return n.messages.MarkMatchMessageNotified(message.ID, n.now())
+}
@@ -0,0 +1,141 @@
+package http
+
+import (
+	"encoding/json"
+	"lostpets"
+	"lostpets/internal/notifications"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// matchedOptions has posting 1 matched with sighting 10 and hidden sighting 12, sighting 11 is another one's
+func matchedOptions() testOptions {
+	hiddenOn := time.Now()
+	return testOptions{
+		postings: map[int]lostpets.Posting{
+			1: {ID: 1, GUID: "posting-guid", Email: "owner@example.org"},
+		},
+		sightings: map[int]lostpets.Sighting{
+			10: {Posting: lostpets.Posting{ID: 10, GUID: "sighting-guid", Email: "finder@example.org"}},
+			11: {Posting: lostpets.Posting{ID: 11, GUID: "other-guid"}},
+			12: {Posting: lostpets.Posting{ID: 12, GUID: "hidden-guid", HiddenOn: &hiddenOn}},
+		},
+		matches: map[[2]int]lostpets.Match{
+			{1, 10}: {PostingID: 1, SightingID: 10},
+			{1, 12}: {PostingID: 1, SightingID: 12},
+		},
+	}
+}
+
+func threadRequest(e *echo.Echo, method string, path string, body string) (*httptest.ResponseRecorder, apiMessagesResponse) {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	resp := apiMessagesResponse{}
+	if rec.Code < http.StatusMultipleChoices {
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+	}
+	return rec, resp
+}
+
+func TestMatchThread(t *testing.T) {
+	s := newTestServer(matchedOptions())
+
+	rec, resp := threadRequest(s.Echo, http.MethodPost, "/postings/private/posting-guid/matches/10/messages", `{"message": "Is that my cat?"}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	assert.True(t, resp.Message.Mine)
+	assert.Equal(t, []string{jobEmailMatchMessage}, s.queue.kinds)
+
+	//the sighting's owner reads the message, which marks it read
+	rec, resp = threadRequest(s.Echo, http.MethodGet, "/sightings/private/sighting-guid/matches/1/messages", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Len(t, *resp.Messages, 1)
+	assert.False(t, (*resp.Messages)[0].Mine)
+	assert.NotNil(t, s.messages.messages[0].ReadOn)
+
+	rec, _ = threadRequest(s.Echo, http.MethodPost, "/sightings/private/sighting-guid/matches/1/messages", `{"message": "Looks like it, she's with me"}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	//the posting's owner sees their message was read and the reply isn't read until they fetch the thread
+	assert.Nil(t, s.messages.messages[1].ReadOn)
+	rec, resp = threadRequest(s.Echo, http.MethodGet, "/postings/private/posting-guid/matches/10/messages", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Len(t, *resp.Messages, 2)
+	assert.True(t, (*resp.Messages)[0].Mine)
+	assert.NotNil(t, (*resp.Messages)[0].ReadOn)
+	assert.NotNil(t, s.messages.messages[1].ReadOn)
+}
+
+func TestMatchThreadRejects(t *testing.T) {
+	s := newTestServer(matchedOptions())
+
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		status int
+	}{
+		{name: "unknown guid", path: "/postings/private/nope/matches/10/messages", body: `{"message": "hi"}`, status: http.StatusNotFound},
+		{name: "not matched", path: "/postings/private/posting-guid/matches/11/messages", body: `{"message": "hi"}`, status: http.StatusNotFound},
+		{name: "other side hidden", path: "/postings/private/posting-guid/matches/12/messages", body: `{"message": "hi"}`, status: http.StatusNotFound},
+		{name: "other side's guid", path: "/sightings/private/posting-guid/matches/1/messages", body: `{"message": "hi"}`, status: http.StatusNotFound},
+		{name: "invalid id", path: "/postings/private/posting-guid/matches/x/messages", body: `{"message": "hi"}`, status: http.StatusBadRequest},
+		{name: "empty message", path: "/postings/private/posting-guid/matches/10/messages", body: `{"message": " "}`, status: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec, _ := threadRequest(s.Echo, http.MethodPost, test.path, test.body)
+			assert.Equal(t, test.status, rec.Code)
+		})
+	}
+	assert.Empty(t, s.messages.messages)
+}
+
+func TestNotifyMatchMessage(t *testing.T) {
+	s := newTestServer(matchedOptions())
+	templates, err := notifications.NewRegistry("", "", "{{.Message}}\n{{.Link}}")
+	require.NoError(t, err)
+	sent := []email{}
+	notifier := messageNotifier{
+		templates: templates,
+		send: func(owner lostpets.Posting, m email) error {
+			m.To = owner.Email
+			sent = append(sent, m)
+			return nil
+		},
+		linkBase: "http://localhost/private/",
+		repo:     s.repo,
+		messages: s.messages,
+		logger:   nopLogger{},
+		now:      time.Now,
+	}
+
+	require.NoError(t, s.messages.AddMatchMessage(&lostpets.MatchMessage{PostingID: 1, SightingID: 10, FromPosting: true, Message: "Is that my cat?"}))
+	require.NoError(t, notifier.notify(1))
+	require.Len(t, sent, 1)
+	assert.Equal(t, "finder@example.org", sent[0].To)
+	assert.Equal(t, "A new message about your sighting", sent[0].Subject)
+	assert.Contains(t, sent[0].Body, "Is that my cat?")
+	assert.Contains(t, sent[0].Body, "http://localhost/private/sighting-guid")
+	assert.NotContains(t, sent[0].Body, "owner@example.org")
+
+	//a retried job doesn't email again
+	require.NoError(t, notifier.notify(1))
+	assert.Len(t, sent, 1)
+
+	//a message read before the job ran needs no email
+	readOn := time.Now()
+	require.NoError(t, s.messages.AddMatchMessage(&lostpets.MatchMessage{PostingID: 1, SightingID: 10, Message: "Yes", ReadOn: &readOn}))
+	require.NoError(t, notifier.notify(2))
+	assert.Len(t, sent, 1)
+}
@@ -0,0 +1,125 @@
+package http
+
+import (
+	"fmt"
+	domain "lostpets"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type pageMeta struct {
+	Total  int    `json:"total"`
+	Limit  int    `json:"limit"`
+	Offset int    `json:"offset"`
+	Next   string `json:"next,omitempty"`
+	Prev   string `json:"prev,omitempty"`
+}
+
+const (
+	paramLimit  = "limit"
+	paramOffset = "offset"
+	paramSort   = "sort"
+	paramOrder  = "order"
+
+	defaultLimit = 25
+	maxLimit     = 100
+)
+
+// params used for paging, these are skipped when parsing filters
+var pageParams = []string{paramLimit, paramOffset, paramSort, paramOrder}
+
+// the fields each list can be sorted on, the repo for the list has to have each of them in its sort map
+var (
+	recordSortFields  = []string{domain.SortDate, domain.SortID, domain.SortLocation, domain.SortPetType, domain.SortCreated}
+	createdSortFields = []string{domain.SortDate, domain.SortID, domain.SortCreated}
+)
+
+var sortOrders = []string{domain.OrderAsc, domain.OrderDesc}
+
+/*
+parsePage reads limit, offset, sort and order from the query params, sort has to be one of the sortFields.
+Lists with a fixed order pass no sortFields, asking them for a sort or order is then an error.
+Defaults to the newest 25 records, limit is capped at 100.
+*/
+func parsePage(params url.Values, sortFields []string) (domain.Page, error) {
+	page := domain.Page{
+		Limit: defaultLimit,
+		Sort:  domain.SortDate,
+		Order: domain.OrderDesc,
+	}
+
+	if limit := params.Get(paramLimit); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l < 1 || l > maxLimit {
+			return page, &queryError{Message: fmt.Sprintf("limit must be a number between 1 and %d", maxLimit), Param: paramLimit}
+		}
+		page.Limit = l
+	}
+
+	if offset := params.Get(paramOffset); offset != "" {
+		o, err := strconv.Atoi(offset)
+		if err != nil || o < 0 {
+			return page, &queryError{Message: "offset must be a positive number", Param: paramOffset}
+		}
+		page.Offset = o
+	}
+
+	if len(sortFields) == 0 {
+		for _, param := range []string{paramSort, paramOrder} {
+			if params.Get(param) != "" {
+				return page, &queryError{Message: "this list can't be sorted", Param: param}
+			}
+		}
+		return page, nil
+	}
+
+	if sort := params.Get(paramSort); sort != "" {
+		sort = strings.ToLower(sort)
+		if !contains(sortFields, sort) {
+			return page, &queryError{Message: "unknown sort field", Param: paramSort, Valid: sortFields}
+		}
+		page.Sort = sort
+	}
+
+	if order := params.Get(paramOrder); order != "" {
+		order = strings.ToLower(order)
+		if !contains(sortOrders, order) {
+			return page, &queryError{Message: "unknown sort order", Param: paramOrder, Valid: sortOrders}
+		}
+		page.Order = order
+	}
+
+	return page, nil
+}
+
+// newPageMeta creates the meta for a page, next and prev link back to the request with the offset moved
+func newPageMeta(requestURL *url.URL, page domain.Page, total int) pageMeta {
+	meta := pageMeta{
+		Total:  total,
+		Limit:  page.Limit,
+		Offset: page.Offset,
+	}
+
+	if page.Offset+page.Limit < total {
+		meta.Next = pageLink(requestURL, page.Offset+page.Limit)
+	}
+
+	if page.Offset > 0 {
+		prev := page.Offset - page.Limit
+		if prev < 0 {
+			prev = 0
+		}
+		meta.Prev = pageLink(requestURL, prev)
+	}
+
+	return meta
+}
+
+func pageLink(requestURL *url.URL, offset int) string {
+	link := *requestURL
+	query := link.Query()
+	query.Set(paramOffset, strconv.Itoa(offset))
+	link.RawQuery = query.Encode()
+	return link.RequestURI()
+}
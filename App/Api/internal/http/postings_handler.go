@@ -1,20 +1,27 @@
 package http
 
 import (
+	"errors"
 	domain "lostpets"
 	"net/http"
 	"path"
 	"strconv"
+	"strings"
 
 	"github.com/labstack/echo/v4"
 )
 
 type (
 	postingsHandler struct {
-		logger  domain.StructuredLogger
-		router  *echo.Echo
-		repo    domain.LostPetsRepo
-		emailer emailer
+		logger    domain.StructuredLogger
+		router    *echo.Echo
+		repo      domain.LostPetsRepo
+		fileRepo  domain.FileRepo
+		fileStore domain.FileStore
+		jobs      domain.JobQueue
+		geocoder  domain.Geocoder
+		// verify keeps new records pending until their email is verified
+		verify bool
 	}
 
 	apiPostingResponse struct {
@@ -29,10 +36,21 @@ type (
 	}
 
 	apiPosting struct {
-		ID       int      `json:"id,omitempty"`
-		Pet      apiPet   `json:"pet,omitempty"`
-		Date     Datetime `json:"date,omitempty"`
-		Location string   `json:"location,omitempty"`
+		ID          int             `json:"id,omitempty"`
+		Pet         apiPet          `json:"pet,omitempty"`
+		Date        Datetime        `json:"date,omitempty"`
+		Location    string          `json:"location,omitempty"`
+		Coordinates *apiCoordinates `json:"coordinates,omitempty"`
+		Status      string          `json:"status,omitempty"`
+		Hidden      bool            `json:"hidden,omitempty"`
+	}
+
+	apiCoordinates struct {
+		Lat        float64 `json:"lat"`
+		Lng        float64 `json:"lng"`
+		AccuracyKm float64 `json:"accuracyKm,omitempty"`
+		// Place is the gazetteer's name for geocoded coordinates, it is only ever set by the api
+		Place string `json:"place,omitempty"`
 	}
 
 	apiPet struct {
@@ -58,6 +76,10 @@ type (
 func (h *postingsHandler) initRoute(path string) {
 	h.router.GET(path+"/:id", h.handleGetByID())
 	h.router.GET(path+"/private/:guid", h.handleGetByGUID())
+	h.router.PUT(path+"/private/:guid", h.handleUpdatePosting(true))
+	h.router.PATCH(path+"/private/:guid", h.handleUpdatePosting(false))
+	h.router.DELETE(path+"/private/:guid", h.handleDeletePosting())
+	h.router.PUT(path+"/private/:guid/status", h.handleUpdateStatus())
 	h.router.GET(path+"/private/:guid/matches", h.handleGetAllMatches())
 	h.router.GET(path, h.handleGetAll())
 	h.router.POST(path, h.handleCreatePosting(path+"/private/"))
@@ -68,14 +90,14 @@ func (h *postingsHandler) handleGetByID() echo.HandlerFunc {
 		postingID := c.Param("id")
 		id, err := strconv.Atoi(postingID)
 		if err != nil {
-			return err
+			return domain.InvalidInput("invalid id").Wrap(err)
 		}
 
 		posting, err := h.repo.GetPostingByID(id)
 		if err != nil {
 			return err
 		}
-		if posting == nil {
+		if posting == nil || posting.HiddenOn != nil || posting.Status == domain.StatusPending {
 			return c.NoContent(http.StatusNotFound)
 		}
 
@@ -103,9 +125,29 @@ func (h *postingsHandler) handleGetByGUID() echo.HandlerFunc {
 	}
 }
 func (h *postingsHandler) handleGetAll() echo.HandlerFunc {
-	//TODO add query support
 	return func(c echo.Context) error {
-		postings, err := h.repo.GetAllPostings()
+		filters, err := parseFilters(c.QueryParams(), postingQueryFields, listParams...)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+		//only open postings are listed unless a status is asked for
+		filters = withStatus(filters, domain.StatusOpen)
+		//hidden records are never listed publicly
+		filters = withHidden(filters, false)
+		filters = withoutPending(filters)
+
+		radius, err := parseNear(c.QueryParams())
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+		filters = withNear(filters, radius)
+
+		page, err := parsePage(c.QueryParams(), recordSortFields)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+
+		postings, total, err := h.repo.GetPostingsPage(page, filters...)
 		if err != nil {
 			return err
 		}
@@ -115,8 +157,11 @@ func (h *postingsHandler) handleGetAll() echo.HandlerFunc {
 			apiPostings = append(apiPostings, *toAPIPosting(p))
 		}
 
-		resp := apiPostingResponse{
-			Postings: &apiPostings,
+		resp := response{
+			Data: apiPostingResponse{
+				Postings: &apiPostings,
+			},
+			Meta: newPageMeta(c.Request().URL, page, total),
 		}
 		return c.JSON(http.StatusOK, resp)
 	}
@@ -128,6 +173,9 @@ func (h *postingsHandler) handleGetAllMatches() echo.HandlerFunc {
 		if err != nil {
 			return err
 		}
+		if posting == nil {
+			return domain.NotFound("posting not found")
+		}
 		matches, err := h.repo.GetMatchingSightings(posting.ID)
 		if err != nil {
 			return err
@@ -145,33 +193,195 @@ func (h *postingsHandler) handleGetAllMatches() echo.HandlerFunc {
 }
 
 func (h *postingsHandler) handleCreatePosting(location string) echo.HandlerFunc {
-	//TODO add validation
 	return func(c echo.Context) error {
 		newPosting := new(apiPostPosting)
 		if err := c.Bind(newPosting); err != nil {
 			return err
 		}
+		if err := c.Validate(newPosting); err != nil {
+			return err
+		}
 		dPosting := toDomainPosting(*newPosting)
+		if h.verify {
+			dPosting.Status = domain.StatusPending
+		}
+		geocode(h.geocoder, h.logger, dPosting)
 		err := h.repo.AddPosting(dPosting)
 		if err != nil {
 			return err
 		}
 
-		//run search in 'background'
-		go h.searchForMatches(*dPosting)
+		//unverified records are matched once the owner follows the link
+		if h.verify {
+			queueVerification(h.jobs, h.logger, domain.TargetPosting, dPosting.ID)
+		} else {
+			queueMatch(h.jobs, h.logger, jobMatchPosting, dPosting.ID)
+		}
 
 		c.Response().Header().Set(echo.HeaderLocation, path.Join(location, dPosting.GUID))
 		return c.NoContent(http.StatusCreated)
 	}
 }
 
+/*
+handleUpdatePosting updates the posting for the private guid.
+If replace is true the body replaces the posting (PUT), otherwise only the fields in the body are changed (PATCH)
+*/
+func (h *postingsHandler) handleUpdatePosting(replace bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		postingGUID := c.Param("guid")
+		posting, err := h.repo.GetPostingByGUID(postingGUID)
+		if err != nil {
+			return err
+		}
+		if posting == nil {
+			return c.NoContent(http.StatusNotFound)
+		}
+
+		update := new(apiPostPosting)
+		if !replace {
+			*update = *toAPIPostPosting(*posting)
+		}
+		if err := c.Bind(update); err != nil {
+			return err
+		}
+		if err := c.Validate(update); err != nil {
+			return err
+		}
+
+		//ids come from the stored posting, not the body
+		dPosting := toDomainPosting(*update)
+		dPosting.ID = posting.ID
+		dPosting.GUID = posting.GUID
+		dPosting.Pet.ID = posting.Pet.ID
+		dPosting.Pet.Tag.ID = posting.Pet.Tag.ID
+		geocodeUpdate(h.geocoder, h.logger, *posting, dPosting)
+
+		err = h.repo.UpdatePosting(dPosting)
+		if err != nil {
+			return err
+		}
+
+		updated, err := h.repo.GetPostingByID(posting.ID)
+		if err != nil {
+			return err
+		}
+
+		if h.verify && !strings.EqualFold(posting.Email, updated.Email) {
+			//a new address has to be verified again before the record is public
+			updated, err = h.reverify(*updated)
+			if err != nil {
+				return err
+			}
+		} else if matchableChanged(*posting, *updated) {
+			queueMatch(h.jobs, h.logger, jobMatchPosting, updated.ID)
+		}
+
+		resp := apiPostingResponse{
+			Posting: toAPIPosting(*updated),
+		}
+		return c.JSON(http.StatusOK, resp)
+	}
+}
+
+func (h *postingsHandler) handleDeletePosting() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		postingGUID := c.Param("guid")
+		posting, err := h.repo.GetPostingByGUID(postingGUID)
+		if err != nil {
+			return err
+		}
+		if posting == nil {
+			return c.NoContent(http.StatusNotFound)
+		}
+
+		picture, err := petPicture(h.fileRepo, posting.Pet.PictureID)
+		if err != nil {
+			return err
+		}
+
+		err = h.repo.DeletePosting(posting.ID)
+		if err != nil {
+			return err
+		}
+
+		if picture != nil {
+			removePictureFile(h.fileRepo, h.fileStore, h.logger, picture)
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+/*
+handleUpdateStatus lets the owner mark the posting as reunited or closed.
+A reunion can name the sighting that led to it, which is marked reunited as well
+*/
+func (h *postingsHandler) handleUpdateStatus() echo.HandlerFunc {
+	type statusUpdate struct {
+		Status     domain.Status `json:"status"`
+		SightingID int           `json:"sightingId,omitempty"`
+	}
+	return func(c echo.Context) error {
+		postingGUID := c.Param("guid")
+		posting, err := h.repo.GetPostingByGUID(postingGUID)
+		if err != nil {
+			return err
+		}
+		if posting == nil {
+			return c.NoContent(http.StatusNotFound)
+		}
+
+		update := new(statusUpdate)
+		if err := c.Bind(update); err != nil {
+			return err
+		}
+
+		if update.Status != domain.StatusReunited && update.Status != domain.StatusClosed {
+			return domain.InvalidInput("status must be reunited or closed")
+		}
+
+		if update.SightingID != 0 {
+			if update.Status != domain.StatusReunited {
+				return domain.InvalidInput("sightingId can only be set when reunited")
+			}
+			sighting, err := h.repo.GetSightingByID(update.SightingID)
+			if err != nil {
+				return err
+			}
+			if sighting == nil {
+				return domain.InvalidInput("unknown sightingId")
+			}
+		}
+
+		if !posting.Status.CanTransition(update.Status) {
+			return domain.Conflict("%s can not be changed to %s", posting.Status, update.Status)
+		}
+
+		err = h.repo.UpdatePostingStatus(posting.ID, update.Status, update.SightingID)
+		if err != nil {
+			return err
+		}
+
+		if update.SightingID != 0 {
+			err = h.repo.UpdateSightingStatus(update.SightingID, domain.StatusReunited, posting.ID)
+			if err != nil && !errors.Is(err, domain.ErrStatusTransition) {
+				h.logger.Error(err.Error())
+			}
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
 func toDomainPosting(api apiPostPosting) *domain.Posting {
 	return &domain.Posting{
-		ID:       api.ID,
-		Date:     api.Date.Time,
-		Location: api.Location,
-		Name:     api.Name,
-		Email:    api.Email,
+		ID:          api.ID,
+		Date:        api.Date.Time,
+		Location:    api.Location,
+		Coordinates: toDomainCoordinates(api.Coordinates),
+		Name:        api.Name,
+		Email:       api.Email,
 		Pet: domain.Pet{
 			ID:        api.Pet.ID,
 			PictureID: api.Pet.PictureID,
@@ -193,9 +403,12 @@ func toDomainPosting(api apiPostPosting) *domain.Posting {
 
 func toAPIPosting(d domain.Posting) *apiPosting {
 	return &apiPosting{
-		ID:       d.ID,
-		Date:     Datetime{Time: d.Date},
-		Location: d.Location,
+		ID:          d.ID,
+		Date:        Datetime{Time: d.Date},
+		Location:    d.Location,
+		Coordinates: toAPICoordinates(d.Coordinates),
+		Status:      string(d.Status),
+		Hidden:      d.HiddenOn != nil,
 		Pet: apiPet{
 			ID:        d.Pet.ID,
 			PictureID: d.Pet.PictureID,
@@ -215,69 +428,37 @@ func toAPIPosting(d domain.Posting) *apiPosting {
 	}
 }
 
-//TODO Clean up and come up with a better way to search
-func (h *postingsHandler) searchForMatches(posting domain.Posting) {
-	//create filters
-	filters := []domain.FilterMap{}
-
-	locationFilter := domain.FilterMap{}
-	locationFilter["location"] = []domain.Filter{
-		{
-			Comparator: "=",
-			Value:      posting.Location,
-		},
-	}
-	filters = append(filters, locationFilter)
-
-	typeFilter := domain.FilterMap{}
-	typeFilter["pettype"] = []domain.Filter{
-		{
-			Comparator: "=",
-			Value:      posting.Pet.Type,
-		},
+func toAPIPostPosting(d domain.Posting) *apiPostPosting {
+	return &apiPostPosting{
+		apiPosting: *toAPIPosting(d),
+		Name:       d.Name,
+		Email:      d.Email,
 	}
-	filters = append(filters, typeFilter)
+}
 
-	petNameFilter := domain.FilterMap{}
-	petNameFilter["petname"] = []domain.Filter{
-		{
-			Comparator: "=",
-			Value:      posting.Pet.Name,
-		},
+func toDomainCoordinates(api *apiCoordinates) *domain.Coordinates {
+	if api == nil {
+		return nil
 	}
-	filters = append(filters, petNameFilter)
+	return &domain.Coordinates{Lat: api.Lat, Lng: api.Lng, AccuracyKm: api.AccuracyKm}
+}
 
-	petColorFilter := domain.FilterMap{}
-	petColorFilter["petcolor"] = []domain.Filter{
-		{
-			Comparator: "=",
-			Value:      posting.Pet.Color,
-		},
+func toAPICoordinates(d *domain.Coordinates) *apiCoordinates {
+	if d == nil {
+		return nil
 	}
-	filters = append(filters, petColorFilter)
+	return &apiCoordinates{Lat: d.Lat, Lng: d.Lng, AccuracyKm: d.AccuracyKm, Place: d.Place}
+}
 
-	petMarksFilter := domain.FilterMap{}
-	petMarksFilter["petmarks"] = []domain.Filter{
-		{
-			Comparator: "=",
-			Value:      posting.Pet.Marks,
-		},
+// reverify puts an open posting back to pending and emails a new link, closed postings aren't public anyway so they are left as they are
+func (h *postingsHandler) reverify(posting domain.Posting) (*domain.Posting, error) {
+	err := h.repo.UnverifyPosting(posting.ID)
+	if errors.Is(err, domain.ErrStatusTransition) {
+		return &posting, nil
 	}
-	filters = append(filters, petMarksFilter)
-
-	//search, filters should be separated by ORs
-	matches, err := h.repo.GetAllSightings(filters...)
 	if err != nil {
-		h.logger.Error(err.Error())
-	}
-
-	for _, m := range matches {
-		h.logger.Info("Adding Match p:%d, s:%d", posting.ID, m.ID)
-		err := h.repo.AddMatch(posting.ID, m.ID)
-		if err != nil {
-			h.logger.Error(err.Error())
-		}
+		return nil, err
 	}
-	//email will have link to 'private' page in UI which will query for found matches
-	h.emailer.emailMatches("Postings", posting.Email, posting.GUID)
+	queueVerification(h.jobs, h.logger, domain.TargetPosting, posting.ID)
+	return h.repo.GetPostingByID(posting.ID)
 }
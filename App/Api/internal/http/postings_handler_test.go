@@ -0,0 +1,54 @@
+package http
+
+import (
+	"encoding/json"
+	"lostpets"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeletePostingRemovesPictureFile(t *testing.T) {
+	s := newTestServer(testOptions{
+		postings: map[int]lostpets.Posting{1: {ID: 1, GUID: "posting-guid", Pet: lostpets.Pet{PictureID: 7}}},
+		pictures: map[int]lostpets.FileMeta{7: {ID: 7, GUID: "picture-guid"}},
+	})
+
+	rec := doRequest(s.Echo, http.MethodDelete, postingsPath+"/private/posting-guid", "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Empty(t, s.repo.postings)
+	assert.Equal(t, []string{"picture-guid"}, s.store.deleted)
+}
+
+func TestUpdateStatusErrors(t *testing.T) {
+	s := newTestServer(testOptions{postings: map[int]lostpets.Posting{
+		1: {ID: 1, GUID: "closed-guid", Status: lostpets.StatusClosed},
+		2: {ID: 2, GUID: "open-guid", Status: lostpets.StatusOpen},
+	}})
+
+	tests := []struct {
+		name   string
+		guid   string
+		body   string
+		status int
+		detail string
+	}{
+		{name: "unknown status", guid: "open-guid", body: `{"status": "expired"}`, status: http.StatusBadRequest, detail: "status must be reunited or closed"},
+		{name: "sighting when closing", guid: "open-guid", body: `{"status": "closed", "sightingId": 10}`, status: http.StatusBadRequest, detail: "sightingId can only be set when reunited"},
+		{name: "unknown sighting", guid: "open-guid", body: `{"status": "reunited", "sightingId": 10}`, status: http.StatusBadRequest, detail: "unknown sightingId"},
+		{name: "already closed", guid: "closed-guid", body: `{"status": "reunited"}`, status: http.StatusConflict, detail: "closed can not be changed to reunited"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := doRequest(s.Echo, http.MethodPut, postingsPath+"/private/"+test.guid+"/status", test.body)
+			require.Equal(t, test.status, rec.Code)
+
+			resp := problem{}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+			assert.Equal(t, test.detail, resp.Detail)
+		})
+	}
+}
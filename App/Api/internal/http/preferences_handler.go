@@ -0,0 +1,189 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	domain "lostpets"
+	"lostpets/internal/notifications"
+	"net/http"
+	"net/url"
+	"regexp"
+
+	"github.com/labstack/echo/v4"
+)
+
+type (
+	preferencesHandler struct {
+		logger   domain.StructuredLogger
+		router   *echo.Echo
+		repo     domain.LostPetsRepo
+		webhooks notifications.WebhookConfig
+	}
+
+	apiPreferences struct {
+		Channels   []string `json:"channels"`
+		Phone      string   `json:"phone,omitempty"`
+		WebhookURL string   `json:"webhookUrl,omitempty"`
+		// WebhookSecret is only sent back when a new one is made, it can't be read again
+		WebhookSecret string `json:"webhookSecret,omitempty"`
+		Locale        string `json:"locale,omitempty"`
+	}
+)
+
+const webhookSecretBytes = 32
+
+// phone numbers are stored in E.164 form so the SMS gateway doesn't have to guess the country
+var phonePattern = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+// a language with an optional region, such as "fr" or "fr-CA"
+var localePattern = regexp.MustCompile(`^[a-z]{2,3}(-[A-Za-z0-9]{2,8})?$`)
+
+func (h *preferencesHandler) initRoute() {
+	h.router.GET(postingsPath+"/private/:guid/notifications", h.handleGetPreferences(domain.TargetPosting))
+	h.router.PUT(postingsPath+"/private/:guid/notifications", h.handleUpdatePreferences(domain.TargetPosting))
+	h.router.GET(sightingsPath+"/private/:guid/notifications", h.handleGetPreferences(domain.TargetSighting))
+	h.router.PUT(sightingsPath+"/private/:guid/notifications", h.handleUpdatePreferences(domain.TargetSighting))
+}
+
+func (h *preferencesHandler) handleGetPreferences(side domain.ModerationTarget) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		owner, err := h.owner(c, side)
+		if err != nil {
+			return err
+		}
+		if owner == nil {
+			return c.NoContent(http.StatusNotFound)
+		}
+		return c.JSON(http.StatusOK, toAPIPreferences(owner.NotificationPreferences))
+	}
+}
+
+/*
+handleUpdatePreferences replaces how the owner hears about matches and messages.
+The phone number and webhook url are only required for the channels that use them.
+A new webhook url gets a new secret to check its webhooks with, it is in the response this once.
+*/
+func (h *preferencesHandler) handleUpdatePreferences(side domain.ModerationTarget) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		request := new(apiPreferences)
+		if err := c.Bind(request); err != nil {
+			return err
+		}
+
+		preferences, err := request.toDomain(c.Request().Context(), h.webhooks)
+		if err != nil {
+			return err
+		}
+
+		owner, err := h.owner(c, side)
+		if err != nil {
+			return err
+		}
+		if owner == nil {
+			return c.NoContent(http.StatusNotFound)
+		}
+
+		newSecret := false
+		if preferences.WebhookURL != "" {
+			preferences.WebhookSecret = owner.WebhookSecret
+			if preferences.WebhookURL != owner.WebhookURL || owner.WebhookSecret == "" {
+				if preferences.WebhookSecret, err = newWebhookSecret(); err != nil {
+					return err
+				}
+				newSecret = true
+			}
+		}
+
+		if side == domain.TargetPosting {
+			err = h.repo.UpdatePostingNotifications(owner.ID, preferences)
+		} else {
+			err = h.repo.UpdateSightingNotifications(owner.ID, preferences)
+		}
+		if err != nil {
+			return err
+		}
+
+		resp := toAPIPreferences(preferences)
+		if newSecret {
+			resp.WebhookSecret = preferences.WebhookSecret
+		}
+		return c.JSON(http.StatusOK, resp)
+	}
+}
+
+func newWebhookSecret() (string, error) {
+	raw := make([]byte, webhookSecretBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+// owner is the posting or sighting with the private guid from the path
+func (h *preferencesHandler) owner(c echo.Context, side domain.ModerationTarget) (*domain.Posting, error) {
+	if side == domain.TargetPosting {
+		return h.repo.GetPostingByGUID(c.Param("guid"))
+	}
+
+	sighting, err := h.repo.GetSightingByGUID(c.Param("guid"))
+	if err != nil || sighting == nil {
+		return nil, err
+	}
+	return &sighting.Posting, nil
+}
+
+// toDomain checks the preferences, the webhook url is checked the same way the notifier does so it isn't only found to be unusable when sending
+func (p apiPreferences) toDomain(ctx context.Context, webhooks notifications.WebhookConfig) (domain.NotificationPreferences, error) {
+	valid := []string{}
+	for _, channel := range domain.Channels {
+		valid = append(valid, string(channel))
+	}
+
+	preferences := domain.NotificationPreferences{NotifyBy: []domain.Channel{}, Phone: p.Phone, WebhookURL: p.WebhookURL, Locale: p.Locale}
+	for _, c := range p.Channels {
+		if !contains(valid, c) {
+			return preferences, echo.NewHTTPError(http.StatusBadRequest, &queryError{Message: "unknown channel", Param: "channels", Valid: valid})
+		}
+		if !containsChannel(preferences.NotifyBy, domain.Channel(c)) {
+			preferences.NotifyBy = append(preferences.NotifyBy, domain.Channel(c))
+		}
+	}
+	if len(preferences.NotifyBy) == 0 {
+		return preferences, echo.NewHTTPError(http.StatusBadRequest, &queryError{Message: "at least one channel is required", Param: "channels", Valid: valid})
+	}
+
+	if (containsChannel(preferences.NotifyBy, domain.ChannelSMS) || p.Phone != "") && !phonePattern.MatchString(p.Phone) {
+		return preferences, echo.NewHTTPError(http.StatusBadRequest, &queryError{Message: "phone must be in international format, e.g. +15551234567", Param: "phone"})
+	}
+	if containsChannel(preferences.NotifyBy, domain.ChannelWebhook) || p.WebhookURL != "" {
+		u, err := url.Parse(p.WebhookURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return preferences, echo.NewHTTPError(http.StatusBadRequest, &queryError{Message: "webhookUrl must be an absolute http or https url", Param: "webhookUrl"})
+		}
+		if err := webhooks.CheckURL(ctx, p.WebhookURL); err != nil {
+			return preferences, echo.NewHTTPError(http.StatusBadRequest, &queryError{Message: err.Error(), Param: "webhookUrl"})
+		}
+	}
+	if p.Locale != "" && !localePattern.MatchString(p.Locale) {
+		return preferences, echo.NewHTTPError(http.StatusBadRequest, &queryError{Message: "locale must be a language code such as fr or fr-CA", Param: "locale"})
+	}
+	return preferences, nil
+}
+
+func toAPIPreferences(p domain.NotificationPreferences) apiPreferences {
+	channels := []string{}
+	for _, c := range p.NotifyBy {
+		channels = append(channels, string(c))
+	}
+	return apiPreferences{Channels: channels, Phone: p.Phone, WebhookURL: p.WebhookURL, Locale: p.Locale}
+}
+
+func containsChannel(channels []domain.Channel, channel domain.Channel) bool {
+	for _, c := range channels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package http
+
+import (
+	"encoding/json"
+	"lostpets"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotificationPreferences(t *testing.T) {
+	s := newTestServer(matchedOptions())
+
+	put := func(path string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := put("/postings/private/posting-guid/notifications", `{"channels": ["sms", "email", "sms"], "phone": "+15551234567", "locale": "fr-CA"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, lostpets.NotificationPreferences{
+		NotifyBy: []lostpets.Channel{lostpets.ChannelSMS, lostpets.ChannelEmail},
+		Phone:    "+15551234567",
+		Locale:   "fr-CA",
+	}, s.repo.postings[1].NotificationPreferences)
+
+	webhook := func(body string) apiPreferences {
+		rec := put("/sightings/private/sighting-guid/notifications", body)
+		require.Equal(t, http.StatusOK, rec.Code)
+		resp := apiPreferences{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return resp
+	}
+
+	//a new url gets a secret, returned this once
+	resp := webhook(`{"channels": ["webhook"], "webhookUrl": "https://hooks.example.org/lostpets"}`)
+	assert.Equal(t, "https://hooks.example.org/lostpets", s.repo.sightings[10].WebhookURL)
+	assert.Len(t, resp.WebhookSecret, 2*webhookSecretBytes)
+	assert.Equal(t, resp.WebhookSecret, s.repo.sightings[10].WebhookSecret)
+	secret := resp.WebhookSecret
+
+	req := httptest.NewRequest(http.MethodGet, "/sightings/private/sighting-guid/notifications", nil)
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	resp = apiPreferences{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, apiPreferences{Channels: []string{"webhook"}, WebhookURL: "https://hooks.example.org/lostpets"}, resp)
+
+	//the same url keeps its secret, a different one is given a new one
+	resp = webhook(`{"channels": ["webhook", "email"], "webhookUrl": "https://hooks.example.org/lostpets", "webhookSecret": "chosen"}`)
+	assert.Empty(t, resp.WebhookSecret)
+	assert.Equal(t, secret, s.repo.sightings[10].WebhookSecret)
+
+	resp = webhook(`{"channels": ["webhook"], "webhookUrl": "https://hooks.example.org/other"}`)
+	assert.NotEmpty(t, resp.WebhookSecret)
+	assert.NotEqual(t, secret, resp.WebhookSecret)
+	assert.Equal(t, resp.WebhookSecret, s.repo.sightings[10].WebhookSecret)
+
+	//and removing the url removes the secret
+	webhook(`{"channels": ["email"]}`)
+	assert.Empty(t, s.repo.sightings[10].WebhookSecret)
+
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		status int
+	}{
+		{name: "no channels", path: "/postings/private/posting-guid/notifications", body: `{"channels": []}`, status: http.StatusBadRequest},
+		{name: "unknown channel", path: "/postings/private/posting-guid/notifications", body: `{"channels": ["pigeon"]}`, status: http.StatusBadRequest},
+		{name: "sms without phone", path: "/postings/private/posting-guid/notifications", body: `{"channels": ["sms"]}`, status: http.StatusBadRequest},
+		{name: "local phone", path: "/postings/private/posting-guid/notifications", body: `{"channels": ["sms"], "phone": "555 1234"}`, status: http.StatusBadRequest},
+		{name: "webhook to metadata service", path: "/postings/private/posting-guid/notifications", body: `{"channels": ["webhook"], "webhookUrl": "https://169.254.169.254/latest/meta-data"}`, status: http.StatusBadRequest},
+		{name: "webhook to localhost", path: "/postings/private/posting-guid/notifications", body: `{"channels": ["webhook"], "webhookUrl": "https://localhost:5432/"}`, status: http.StatusBadRequest},
+		{name: "plain http webhook", path: "/postings/private/posting-guid/notifications", body: `{"channels": ["webhook"], "webhookUrl": "http://hooks.example.org/lostpets"}`, status: http.StatusBadRequest},
+		{name: "webhook without url", path: "/postings/private/posting-guid/notifications", body: `{"channels": ["webhook"], "webhookUrl": "hooks"}`, status: http.StatusBadRequest},
+		{name: "bad locale", path: "/postings/private/posting-guid/notifications", body: `{"channels": ["email"], "locale": "../fr"}`, status: http.StatusBadRequest},
+		{name: "unknown guid", path: "/postings/private/missing/notifications", body: `{"channels": ["email"]}`, status: http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.status, put(test.path, test.body).Code)
+		})
+	}
+	assert.Equal(t, "+15551234567", s.repo.postings[1].Phone)
+}
@@ -0,0 +1,384 @@
+package http
+
+import (
+	"fmt"
+	domain "lostpets"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	fieldKind int
+
+	// queryFields maps the lower case name of a filterable field to its value kind
+	queryFields map[string]fieldKind
+
+	// queryError is returned when a query string can not be turned into filters.
+	// Valid lists the accepted fields or comparators so clients can correct the request.
+	queryError struct {
+		Message string   `json:"message"`
+		Param   string   `json:"param"`
+		Valid   []string `json:"valid,omitempty"`
+	}
+)
+
+const (
+	kindString fieldKind = iota
+	kindInt
+	kindBool
+	kindDate
+)
+
+const (
+	paramNear   = "near"
+	paramRadius = "radius"
+
+	defaultRadiusKm = 5
+	maxRadiusKm     = 500
+)
+
+// params on list endpoints that are not filters
+var listParams = append([]string{paramNear, paramRadius}, pageParams...)
+
+const (
+	comparatorSep = ":"
+	listSep       = ","
+	dateFormat    = "2006-01-02"
+)
+
+// query param comparators and the repo comparator they map to
+var queryComparators = map[string]string{
+	"eq":   "=",
+	"ne":   "!=",
+	"lt":   "<",
+	"lte":  "<=",
+	"gt":   ">",
+	"gte":  ">=",
+	"in":   "in",
+	"like": "like",
+}
+
+// comparators allowed for each kind of field
+var kindComparators = map[fieldKind][]string{
+	kindString: {"eq", "ne", "in", "like"},
+	kindInt:    {"eq", "ne", "lt", "lte", "gt", "gte", "in"},
+	kindBool:   {"eq", "ne"},
+	kindDate:   {"eq", "lt", "lte", "gt", "gte"},
+}
+
+// fields that can be queried on GET /postings, private fields (name, email, guid) are left out on purpose
+var postingQueryFields = queryFields{
+	"id":           kindInt,
+	"date":         kindDate,
+	"location":     kindString,
+	"status":       kindString,
+	"petid":        kindInt,
+	"petpictureid": kindInt,
+	"pettype":      kindString,
+	"petname":      kindString,
+	"petcolor":     kindString,
+	"petmarks":     kindString,
+	"petbreeds":    kindString,
+	"pettagshape":  kindString,
+	"pettagcolor":  kindString,
+	"pettagtext":   kindString,
+}
+
+var sightingQueryFields = queryFields{
+	"id":           kindInt,
+	"date":         kindDate,
+	"location":     kindString,
+	"status":       kindString,
+	"incustody":    kindBool,
+	"petid":        kindInt,
+	"petpictureid": kindInt,
+	"pettype":      kindString,
+	"petname":      kindString,
+	"petcolor":     kindString,
+	"petmarks":     kindString,
+	"petbreeds":    kindString,
+	"pettagshape":  kindString,
+	"pettagcolor":  kindString,
+	"pettagtext":   kindString,
+}
+
+// matches 'field' or 'field[group]'
+var queryKeyRegex = regexp.MustCompile(`^([a-zA-Z]+)(?:\[([0-9]+)\])?$`)
+
+var comparatorRegex = regexp.MustCompile(`^[a-z]+$`)
+
+func (e *queryError) Error() string {
+	return e.Message
+}
+
+// with returns a copy of the fields with the extra fields added
+func (f queryFields) with(extra queryFields) queryFields {
+	fields := queryFields{}
+	for name, kind := range f {
+		fields[name] = kind
+	}
+	for name, kind := range extra {
+		fields[name] = kind
+	}
+	return fields
+}
+
+func (f queryFields) names() []string {
+	names := make([]string, 0, len(f))
+	for name := range f {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+/*
+parseFilters turns query params into filter groups.
+
+Each param has the form field=[comparator:]value, with 'eq' used when no comparator is given.
+Params in the same group are ANDed together, groups are ORed. A param joins a group
+by adding an index to the field name, e.g. ?petType=dog&petColor=brown&petType[1]=cat
+is (petType = dog AND petColor = brown) OR (petType = cat).
+Params listed in skip are ignored.
+*/
+func parseFilters(params url.Values, fields queryFields, skip ...string) ([]domain.FilterMap, error) {
+	groups := map[int]domain.FilterMap{}
+
+	for key, values := range params {
+		if contains(skip, key) {
+			continue
+		}
+
+		parts := queryKeyRegex.FindStringSubmatch(key)
+		if parts == nil {
+			return nil, &queryError{Message: "invalid query param", Param: key, Valid: fields.names()}
+		}
+
+		field := strings.ToLower(parts[1])
+		kind, ok := fields[field]
+		if !ok {
+			return nil, &queryError{Message: "unknown field", Param: key, Valid: fields.names()}
+		}
+
+		group := 0
+		if parts[2] != "" {
+			group, _ = strconv.Atoi(parts[2])
+		}
+
+		for _, value := range values {
+			filter, err := parseFilter(kind, value)
+			if err != nil {
+				err.Param = key
+				return nil, err
+			}
+
+			if _, ok := groups[group]; !ok {
+				groups[group] = domain.FilterMap{}
+			}
+			groups[group][field] = append(groups[group][field], *filter)
+		}
+	}
+
+	//keep the groups in a stable order
+	indexes := []int{}
+	for i := range groups {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	filters := []domain.FilterMap{}
+	for _, i := range indexes {
+		filters = append(filters, groups[i])
+	}
+
+	return filters, nil
+}
+
+func parseFilter(kind fieldKind, raw string) (*domain.Filter, *queryError) {
+	comparator := "eq"
+	value := raw
+	//a lower case word before the separator is a comparator, anything else is part of the value (e.g. times)
+	if i := strings.Index(raw, comparatorSep); i >= 0 && comparatorRegex.MatchString(raw[:i]) {
+		comparator = raw[:i]
+		value = raw[i+1:]
+		if _, ok := queryComparators[comparator]; !ok {
+			return nil, &queryError{Message: "unknown comparator", Valid: kindComparators[kind]}
+		}
+	}
+
+	if !contains(kindComparators[kind], comparator) {
+		return nil, &queryError{Message: "unsupported comparator for field", Valid: kindComparators[kind]}
+	}
+
+	filter := &domain.Filter{Comparator: queryComparators[comparator]}
+
+	if comparator == "in" {
+		parts := strings.Split(value, listSep)
+		switch kind {
+		case kindInt:
+			ints := []int{}
+			for _, p := range parts {
+				i, err := strconv.Atoi(strings.TrimSpace(p))
+				if err != nil {
+					return nil, &queryError{Message: fmt.Sprintf("invalid number: %s", p)}
+				}
+				ints = append(ints, i)
+			}
+			filter.Value = ints
+		default:
+			strs := []string{}
+			for _, p := range parts {
+				strs = append(strs, strings.TrimSpace(p))
+			}
+			filter.Value = strs
+		}
+		return filter, nil
+	}
+
+	switch kind {
+	case kindInt:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, &queryError{Message: fmt.Sprintf("invalid number: %s", value)}
+		}
+		filter.Value = i
+	case kindBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, &queryError{Message: fmt.Sprintf("invalid boolean: %s", value)}
+		}
+		filter.Value = b
+	case kindDate:
+		t, err := parseQueryDate(value)
+		if err != nil {
+			return nil, &queryError{Message: fmt.Sprintf("invalid date: %s, expected %s or RFC3339", value, dateFormat)}
+		}
+		filter.Value = t
+	default:
+		if comparator == "like" {
+			value = "%" + value + "%"
+		}
+		filter.Value = value
+	}
+
+	return filter, nil
+}
+
+func parseQueryDate(value string) (time.Time, error) {
+	if t, err := time.Parse(dateFormat, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func contains(list []string, value string) bool {
+	for _, l := range list {
+		if l == value {
+			return true
+		}
+	}
+	return false
+}
+
+// withStatus limits every filter group to the given status unless the group already filters on status
+func withStatus(filters []domain.FilterMap, status domain.Status) []domain.FilterMap {
+	statusFilter := domain.Filter{Comparator: "=", Value: string(status)}
+	if len(filters) == 0 {
+		return []domain.FilterMap{{"status": {statusFilter}}}
+	}
+
+	for _, f := range filters {
+		if _, ok := f["status"]; !ok {
+			f["status"] = []domain.Filter{statusFilter}
+		}
+	}
+	return filters
+}
+
+// withoutPending keeps records that haven't been verified out of every filter group, whatever status was asked for
+func withoutPending(filters []domain.FilterMap) []domain.FilterMap {
+	pendingFilter := domain.Filter{Comparator: "!=", Value: string(domain.StatusPending)}
+	if len(filters) == 0 {
+		return []domain.FilterMap{{"status": {pendingFilter}}}
+	}
+
+	for _, f := range filters {
+		f["status"] = append(f["status"], pendingFilter)
+	}
+	return filters
+}
+
+// withHidden limits every filter group to hidden or to visible records
+func withHidden(filters []domain.FilterMap, hidden bool) []domain.FilterMap {
+	hiddenFilter := domain.Filter{Comparator: "is null"}
+	if hidden {
+		hiddenFilter = domain.Filter{Comparator: "!=", Value: nil}
+	}
+	if len(filters) == 0 {
+		return []domain.FilterMap{{"hiddenon": {hiddenFilter}}}
+	}
+
+	for _, f := range filters {
+		f["hiddenon"] = []domain.Filter{hiddenFilter}
+	}
+	return filters
+}
+
+/*
+parseNear reads a radius search from near=lat,lng and radius=km, radius defaults to 5km.
+Returns nil if near isn't set.
+*/
+func parseNear(params url.Values) (*domain.Radius, error) {
+	near := params.Get(paramNear)
+	if near == "" {
+		if params.Get(paramRadius) != "" {
+			return nil, &queryError{Message: "radius needs near", Param: paramRadius}
+		}
+		return nil, nil
+	}
+
+	parts := strings.Split(near, listSep)
+	if len(parts) != 2 {
+		return nil, &queryError{Message: "invalid near, expected lat,lng", Param: paramNear}
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return nil, &queryError{Message: fmt.Sprintf("invalid latitude: %s", parts[0]), Param: paramNear}
+	}
+	lng, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil || lng < -180 || lng > 180 {
+		return nil, &queryError{Message: fmt.Sprintf("invalid longitude: %s", parts[1]), Param: paramNear}
+	}
+
+	radius := &domain.Radius{Lat: lat, Lng: lng, Km: defaultRadiusKm}
+	if value := params.Get(paramRadius); value != "" {
+		km, err := strconv.ParseFloat(value, 64)
+		if err != nil || km <= 0 || km > maxRadiusKm {
+			return nil, &queryError{Message: fmt.Sprintf("invalid radius: %s, expected km between 0 and %d", value, maxRadiusKm), Param: paramRadius}
+		}
+		radius.Km = km
+	}
+
+	return radius, nil
+}
+
+// withNear adds the radius to every filter group, so only records in the radius are returned whichever group they match
+func withNear(filters []domain.FilterMap, radius *domain.Radius) []domain.FilterMap {
+	if radius == nil {
+		return filters
+	}
+
+	nearFilter := domain.Filter{Comparator: domain.ComparatorWithin, Value: *radius}
+	if len(filters) == 0 {
+		return []domain.FilterMap{{domain.FilterNear: {nearFilter}}}
+	}
+
+	for _, f := range filters {
+		f[domain.FilterNear] = []domain.Filter{nearFilter}
+	}
+	return filters
+}
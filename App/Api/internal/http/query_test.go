@@ -0,0 +1,276 @@
+package http
+
+import (
+	"lostpets"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFilters(t *testing.T) {
+	type test struct {
+		name     string
+		query    string
+		expected []lostpets.FilterMap
+		err      *queryError
+	}
+
+	tests := []test{
+		test{
+			name:     "Should return no filters for an empty query",
+			query:    "",
+			expected: []lostpets.FilterMap{},
+		},
+		test{
+			name:  "Should default to equals and lower case the field",
+			query: "petType=dog",
+			expected: []lostpets.FilterMap{
+				{"pettype": {{Comparator: "=", Value: "dog"}}},
+			},
+		},
+		test{
+			name:  "Should AND fields in the same group",
+			query: "petType=dog&petColor=in:black,brown&date=gte:2026-01-01",
+			expected: []lostpets.FilterMap{
+				{
+					"pettype":  {{Comparator: "=", Value: "dog"}},
+					"petcolor": {{Comparator: "in", Value: []string{"black", "brown"}}},
+					"date":     {{Comparator: ">=", Value: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}},
+				},
+			},
+		},
+		test{
+			name:  "Should OR groups in index order",
+			query: "petType[2]=cat&petType=dog&id[1]=in:1,2",
+			expected: []lostpets.FilterMap{
+				{"pettype": {{Comparator: "=", Value: "dog"}}},
+				{"id": {{Comparator: "in", Value: []int{1, 2}}}},
+				{"pettype": {{Comparator: "=", Value: "cat"}}},
+			},
+		},
+		test{
+			name:  "Should keep times with a separator as the value",
+			query: "date=2026-01-01T10:30:00Z",
+			expected: []lostpets.FilterMap{
+				{"date": {{Comparator: "=", Value: time.Date(2026, 1, 1, 10, 30, 0, 0, time.UTC)}}},
+			},
+		},
+		test{
+			name:  "Should wrap like values",
+			query: "location=like:Main",
+			expected: []lostpets.FilterMap{
+				{"location": {{Comparator: "like", Value: "%Main%"}}},
+			},
+		},
+		test{
+			name:  "Should reject unknown fields",
+			query: "email=someone@test.com",
+			err:   &queryError{Message: "unknown field", Param: "email", Valid: postingQueryFields.names()},
+		},
+		test{
+			name:  "Should reject unknown comparators",
+			query: "id=between:1",
+			err:   &queryError{Message: "unknown comparator", Param: "id", Valid: kindComparators[kindInt]},
+		},
+		test{
+			name:  "Should reject comparators the field does not support",
+			query: "petName=gt:a",
+			err:   &queryError{Message: "unsupported comparator for field", Param: "petName", Valid: kindComparators[kindString]},
+		},
+		test{
+			name:  "Should reject values of the wrong kind",
+			query: "id=abc",
+			err:   &queryError{Message: "invalid number: abc", Param: "id"},
+		},
+	}
+
+	for _, test := range tests {
+		params, err := url.ParseQuery(test.query)
+		assert.NoError(t, err, test.name)
+
+		filters, err := parseFilters(params, postingQueryFields)
+		if test.err != nil {
+			assert.Equal(t, test.err, err, test.name)
+			continue
+		}
+		assert.NoError(t, err, test.name)
+		assert.Equal(t, test.expected, filters, test.name)
+	}
+}
+
+func TestWithStatus(t *testing.T) {
+	open := lostpets.Filter{Comparator: "=", Value: "open"}
+	reunited := lostpets.Filter{Comparator: "=", Value: "reunited"}
+
+	filters := withStatus(nil, lostpets.StatusOpen)
+	assert.Equal(t, []lostpets.FilterMap{{"status": {open}}}, filters)
+
+	filters = withStatus([]lostpets.FilterMap{
+		{"pettype": {{Comparator: "=", Value: "dog"}}},
+		{"status": {reunited}},
+	}, lostpets.StatusOpen)
+	assert.Equal(t, []lostpets.FilterMap{
+		{"pettype": {{Comparator: "=", Value: "dog"}}, "status": {open}},
+		{"status": {reunited}},
+	}, filters)
+}
+
+func TestWithoutPending(t *testing.T) {
+	notPending := lostpets.Filter{Comparator: "!=", Value: "pending"}
+	pending := lostpets.Filter{Comparator: "=", Value: "pending"}
+
+	filters := withoutPending(nil)
+	assert.Equal(t, []lostpets.FilterMap{{"status": {notPending}}}, filters)
+
+	//asking for pending records finds none
+	filters = withoutPending([]lostpets.FilterMap{
+		{"pettype": {{Comparator: "=", Value: "dog"}}},
+		{"status": {pending}},
+	})
+	assert.Equal(t, []lostpets.FilterMap{
+		{"pettype": {{Comparator: "=", Value: "dog"}}, "status": {notPending}},
+		{"status": {pending, notPending}},
+	}, filters)
+}
+
+func TestWithHidden(t *testing.T) {
+	visible := lostpets.Filter{Comparator: "is null"}
+	hidden := lostpets.Filter{Comparator: "!=", Value: nil}
+
+	filters := withHidden(nil, false)
+	assert.Equal(t, []lostpets.FilterMap{{"hiddenon": {visible}}}, filters)
+
+	//a client can't ask for hidden records by filtering on hiddenon itself
+	filters = withHidden([]lostpets.FilterMap{
+		{"pettype": {{Comparator: "=", Value: "dog"}}},
+		{"hiddenon": {hidden}},
+	}, false)
+	assert.Equal(t, []lostpets.FilterMap{
+		{"pettype": {{Comparator: "=", Value: "dog"}}, "hiddenon": {visible}},
+		{"hiddenon": {visible}},
+	}, filters)
+
+	filters = withHidden(nil, true)
+	assert.Equal(t, []lostpets.FilterMap{{"hiddenon": {hidden}}}, filters)
+}
+
+func TestParseNear(t *testing.T) {
+	type test struct {
+		name     string
+		query    string
+		expected *lostpets.Radius
+		err      *queryError
+	}
+
+	tests := []test{
+		test{
+			name:  "Should return nothing without near",
+			query: "petType=dog",
+		},
+		test{
+			name:     "Should default the radius",
+			query:    "near=43.65,-79.38",
+			expected: &lostpets.Radius{Lat: 43.65, Lng: -79.38, Km: defaultRadiusKm},
+		},
+		test{
+			name:     "Should read the radius in km",
+			query:    "near=43.65,-79.38&radius=2.5",
+			expected: &lostpets.Radius{Lat: 43.65, Lng: -79.38, Km: 2.5},
+		},
+		test{
+			name:  "Should reject a radius without near",
+			query: "radius=2",
+			err:   &queryError{Message: "radius needs near", Param: "radius"},
+		},
+		test{
+			name:  "Should reject latitudes off the map",
+			query: "near=91,0",
+			err:   &queryError{Message: "invalid latitude: 91", Param: "near"},
+		},
+		test{
+			name:  "Should reject a radius over the max",
+			query: "near=0,0&radius=1000",
+			err:   &queryError{Message: "invalid radius: 1000, expected km between 0 and 500", Param: "radius"},
+		},
+	}
+
+	for _, test := range tests {
+		params, err := url.ParseQuery(test.query)
+		assert.NoError(t, err, test.name)
+
+		radius, err := parseNear(params)
+		if test.err != nil {
+			assert.Equal(t, test.err, err, test.name)
+			continue
+		}
+		assert.NoError(t, err, test.name)
+		assert.Equal(t, test.expected, radius, test.name)
+	}
+}
+
+func TestWithNear(t *testing.T) {
+	radius := &lostpets.Radius{Lat: 1, Lng: 2, Km: 3}
+	near := lostpets.Filter{Comparator: lostpets.ComparatorWithin, Value: *radius}
+
+	assert.Nil(t, withNear(nil, nil))
+	assert.Equal(t, []lostpets.FilterMap{{"near": {near}}}, withNear(nil, radius))
+
+	filters := withNear([]lostpets.FilterMap{{"pettype": {{Comparator: "=", Value: "dog"}}}, {}}, radius)
+	assert.Equal(t, []lostpets.FilterMap{
+		{"pettype": {{Comparator: "=", Value: "dog"}}, "near": {near}},
+		{"near": {near}},
+	}, filters)
+}
+
+func TestParsePage(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		sortFields []string
+		expected   lostpets.Page
+		err        *queryError
+	}{
+		{
+			name:       "Should default to the newest 25",
+			sortFields: recordSortFields,
+			expected:   lostpets.Page{Limit: 25, Sort: lostpets.SortDate, Order: lostpets.OrderDesc},
+		},
+		{
+			name:       "Should read the page",
+			query:      "limit=10&offset=20&sort=Location&order=asc",
+			sortFields: recordSortFields,
+			expected:   lostpets.Page{Limit: 10, Offset: 20, Sort: lostpets.SortLocation, Order: lostpets.OrderAsc},
+		},
+		{
+			name:       "Should only sort on the list's fields",
+			query:      "sort=location",
+			sortFields: createdSortFields,
+			err:        &queryError{Message: "unknown sort field", Param: paramSort, Valid: createdSortFields},
+		},
+		{
+			name:     "Should page lists with a fixed order",
+			query:    "limit=10",
+			expected: lostpets.Page{Limit: 10, Sort: lostpets.SortDate, Order: lostpets.OrderDesc},
+		},
+		{
+			name:  "Should not sort lists with a fixed order",
+			query: "order=asc",
+			err:   &queryError{Message: "this list can't be sorted", Param: paramOrder},
+		},
+	}
+
+	for _, test := range tests {
+		params, err := url.ParseQuery(test.query)
+		assert.NoError(t, err, test.name)
+
+		page, err := parsePage(params, test.sortFields)
+		if test.err != nil {
+			assert.Equal(t, test.err, err, test.name)
+			continue
+		}
+		assert.NoError(t, err, test.name)
+		assert.Equal(t, test.expected, page, test.name)
+	}
+}
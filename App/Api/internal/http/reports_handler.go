@@ -0,0 +1,164 @@
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	domain "lostpets"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+)
+
+type (
+	reportsHandler struct {
+		logger     domain.StructuredLogger
+		router     *echo.Echo
+		repo       domain.LostPetsRepo
+		reports    domain.ReportRepo
+		moderation domain.ModerationRepo
+		config     ReportConfig
+	}
+
+	ReportConfig struct {
+		// AutoHideAfter hides a record once this many different people have reported it, 0 never hides automatically
+		AutoHideAfter int `json:"autoHideAfter"`
+		// Secret keys the hash reporters' IP addresses are stored as, so the addresses can't be found by hashing every IP
+		Secret string `json:"secret" secret:"true"`
+		// PerAddressPerHour is the most reports one IP address can send in an hour, 0 for no limit
+		PerAddressPerHour int `json:"perAddressPerHour"`
+	}
+
+	apiReport struct {
+		Reason string `json:"reason"`
+		Text   string `json:"text,omitempty"`
+	}
+)
+
+const (
+	maxReportText = 1000
+	// reportWindow is the period PerAddressPerHour is counted over
+	reportWindow = time.Hour
+)
+
+func (h *reportsHandler) initRoute() {
+	h.router.POST(postingsPath+"/:id/reports", h.handleReport(domain.TargetPosting))
+	h.router.POST(sightingsPath+"/:id/reports", h.handleReport(domain.TargetSighting))
+}
+
+/*
+handleReport records an abuse report on a visible posting or sighting. Reports are accepted the same way
+whether or not the reporter already reported the record, so the response doesn't tell them what was counted.
+An address over PerAddressPerHour can't report anything until the hour is up.
+*/
+func (h *reportsHandler) handleReport(target domain.ModerationTarget) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid id")
+		}
+
+		report := new(apiReport)
+		if err := c.Bind(report); err != nil {
+			return err
+		}
+
+		reason := domain.ReportReason(strings.ToLower(report.Reason))
+		if !validReason(reason) {
+			return echo.NewHTTPError(http.StatusBadRequest, &queryError{Message: "unknown reason", Param: "reason", Valid: reportReasons()})
+		}
+		text := strings.TrimSpace(report.Text)
+		if utf8.RuneCountInString(text) > maxReportText {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("text can be at most %d characters", maxReportText))
+		}
+
+		visible, err := isVisible(h.repo, target, id)
+		if err != nil {
+			return err
+		}
+		if !visible {
+			return c.NoContent(http.StatusNotFound)
+		}
+
+		address := hashAddress(h.config.Secret, c)
+		if h.config.PerAddressPerHour > 0 {
+			sent, err := h.reports.CountReportsFrom(address, time.Now().Add(-reportWindow))
+			if err != nil {
+				return err
+			}
+			if sent >= h.config.PerAddressPerHour {
+				return echo.NewHTTPError(http.StatusTooManyRequests, "too many reports, try again later")
+			}
+		}
+
+		reporter := "ip:" + address
+		if p := principal(c); p != nil {
+			reporter = "user:" + strconv.Itoa(p.UserID)
+		}
+
+		count, err := h.reports.AddReport(&domain.Report{
+			Target:   target,
+			TargetID: id,
+			Reporter: reporter,
+			Address:  address,
+			Reason:   reason,
+			Text:     text,
+		})
+		if err != nil {
+			return err
+		}
+
+		if h.config.AutoHideAfter > 0 && count >= h.config.AutoHideAfter {
+			h.autoHide(target, id, count)
+		}
+
+		return c.NoContent(http.StatusAccepted)
+	}
+}
+
+// autoHide hides a record that has had too many reports, the report is already saved so a failure is only logged
+func (h *reportsHandler) autoHide(target domain.ModerationTarget, id int, count int) {
+	entry := &domain.ModerationEntry{
+		Target:   target,
+		TargetID: id,
+		Action:   domain.ActionHide,
+		Reason:   fmt.Sprintf("hidden automatically after %d reports", count),
+	}
+	if err := h.moderation.Moderate(entry); err != nil {
+		h.logger.Error("auto hiding %s %d: %s", target, id, err)
+		return
+	}
+	h.logger.Info("%s %d hidden after %d reports", target, id, count)
+}
+
+/*
+hashAddress is an HMAC of the IP address the request came from, so the address itself isn't stored.
+Reports are counted once per account or address, so one person can't be counted again by logging in or out.
+*/
+func hashAddress(secret string, c echo.Context) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(c.RealIP()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func validReason(reason domain.ReportReason) bool {
+	for _, r := range domain.ReportReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
+func reportReasons() []string {
+	reasons := []string{}
+	for _, r := range domain.ReportReasons {
+		reasons = append(reasons, string(r))
+	}
+	return reasons
+}
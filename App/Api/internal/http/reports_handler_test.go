@@ -0,0 +1,76 @@
+package http
+
+import (
+	"lostpets"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// reportsOptions has three postings to report, with the limits of the config
+func reportsOptions(config ReportConfig) testOptions {
+	return testOptions{
+		config:   Config{Reports: config},
+		postings: map[int]lostpets.Posting{1: {ID: 1}, 2: {ID: 2}, 3: {ID: 3}},
+	}
+}
+
+func report(e *echo.Echo, id string, ip string, token string, body string) int {
+	req := httptest.NewRequest(http.MethodPost, postingsPath+"/"+id+"/reports", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("X-Forwarded-For", "10.0.0.99")
+	req.RemoteAddr = ip + ":1234"
+	if token != "" {
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestReportAutoHidesAfterDistinctReporters(t *testing.T) {
+	s := newTestServer(reportsOptions(ReportConfig{AutoHideAfter: 3}))
+	scam := `{"reason": "scam", "text": "asked for a deposit"}`
+
+	assert.Equal(t, http.StatusAccepted, report(s.Echo, "1", "10.0.0.1", "", scam))
+	//the same person again, even with a different forwarded for header
+	assert.Equal(t, http.StatusAccepted, report(s.Echo, "1", "10.0.0.1", "", scam))
+	//or after logging in
+	assert.Equal(t, http.StatusAccepted, report(s.Echo, "1", "10.0.0.1", "reporter", scam))
+	assert.Equal(t, http.StatusAccepted, report(s.Echo, "1", "10.0.0.2", "reporter", scam))
+	//and the same account from another address
+	assert.Equal(t, http.StatusAccepted, report(s.Echo, "1", "10.0.0.3", "reporter", scam))
+	assert.Empty(t, s.moderation.entries)
+
+	assert.Equal(t, http.StatusAccepted, report(s.Echo, "1", "10.0.0.4", "", scam))
+	assert.Equal(t, []lostpets.ModerationEntry{{
+		ID: 1, Target: lostpets.TargetPosting, TargetID: 1, Action: lostpets.ActionHide, Reason: "hidden automatically after 3 reports",
+	}}, s.moderation.entries)
+}
+
+func TestReportLimitsEachAddress(t *testing.T) {
+	s := newTestServer(reportsOptions(ReportConfig{PerAddressPerHour: 2}))
+	spam := `{"reason": "spam"}`
+
+	assert.Equal(t, http.StatusAccepted, report(s.Echo, "1", "10.0.0.1", "", spam))
+	assert.Equal(t, http.StatusAccepted, report(s.Echo, "2", "10.0.0.1", "reporter", spam))
+	assert.Equal(t, http.StatusTooManyRequests, report(s.Echo, "3", "10.0.0.1", "", spam))
+	assert.Equal(t, http.StatusAccepted, report(s.Echo, "3", "10.0.0.2", "", spam))
+}
+
+func TestReportRejectsBadRequests(t *testing.T) {
+	s := newTestServer(reportsOptions(ReportConfig{}))
+
+	assert.Equal(t, http.StatusBadRequest, report(s.Echo, "1", "10.0.0.1", "", `{"reason": "dislike"}`))
+	assert.Equal(t, http.StatusBadRequest, report(s.Echo, "1", "10.0.0.1", "", `{"reason": "other", "text": "`+strings.Repeat("a", maxReportText+1)+`"}`))
+	assert.Equal(t, http.StatusNotFound, report(s.Echo, "9", "10.0.0.1", "", `{"reason": "spam"}`))
+
+	hidden := s.repo.postings[1]
+	hidden.HiddenOn = &hidden.CreatedOn
+	s.repo.postings[1] = hidden
+	assert.Equal(t, http.StatusNotFound, report(s.Echo, "1", "10.0.0.1", "", `{"reason": "spam"}`))
+}
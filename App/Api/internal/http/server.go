@@ -0,0 +1,126 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	domain "lostpets"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+type (
+	// TLSConfig serves https with the certificate and key files, both or neither have to be set
+	TLSConfig struct {
+		CertFile string `json:"certFile"`
+		KeyFile  string `json:"keyFile"`
+	}
+
+	// TimeoutConfig limits how long a connection can take, 0 uses the default
+	TimeoutConfig struct {
+		ReadHeaderSeconds int `json:"readHeaderSeconds"`
+		ReadSeconds       int `json:"readSeconds"`
+		WriteSeconds      int `json:"writeSeconds"`
+		IdleSeconds       int `json:"idleSeconds"`
+		// ShutdownSeconds is how long requests in flight, and then running jobs, are given to finish on shutdown
+		ShutdownSeconds int `json:"shutdownSeconds"`
+	}
+)
+
+const (
+	defaultPort              = 8080
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultReadTimeout       = 30 * time.Second
+	// long enough to send a picture over a slow connection
+	defaultWriteTimeout    = 60 * time.Second
+	defaultIdleTimeout     = 120 * time.Second
+	defaultShutdownTimeout = 30 * time.Second
+)
+
+func (t TLSConfig) enabled() bool {
+	return t.CertFile != "" || t.KeyFile != ""
+}
+
+// Shutdown is how long each part of the shutdown is given to finish
+func (t TimeoutConfig) Shutdown() time.Duration {
+	return seconds(t.ShutdownSeconds, defaultShutdownTimeout)
+}
+
+func seconds(s int, fallback time.Duration) time.Duration {
+	if s <= 0 {
+		return fallback
+	}
+	return time.Duration(s) * time.Second
+}
+
+/*
+serve runs the server until ctx is done, then stops accepting connections and waits up to the shutdown
+timeout for requests in flight. An error is returned if the server can't start or stops on its own.
+*/
+func serve(ctx context.Context, handler http.Handler, config Config, logger domain.StructuredLogger) error {
+	if config.TLS.enabled() && (config.TLS.CertFile == "" || config.TLS.KeyFile == "") {
+		return errors.New("tls needs both certFile and keyFile")
+	}
+
+	listener, err := listen(config)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: seconds(config.Timeouts.ReadHeaderSeconds, defaultReadHeaderTimeout),
+		ReadTimeout:       seconds(config.Timeouts.ReadSeconds, defaultReadTimeout),
+		WriteTimeout:      seconds(config.Timeouts.WriteSeconds, defaultWriteTimeout),
+		IdleTimeout:       seconds(config.Timeouts.IdleSeconds, defaultIdleTimeout),
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		if config.TLS.enabled() {
+			served <- server.ServeTLS(listener, config.TLS.CertFile, config.TLS.KeyFile)
+		} else {
+			served <- server.Serve(listener)
+		}
+	}()
+	logger.Info("listening on %s %s, tls %t", listener.Addr().Network(), listener.Addr(), config.TLS.enabled())
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	logger.Info("shutting down, waiting for requests in flight")
+	timeout, cancel := context.WithTimeout(context.Background(), config.Timeouts.Shutdown())
+	defer cancel()
+	if err := server.Shutdown(timeout); err != nil {
+		return fmt.Errorf("draining requests: %w", err)
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// listen opens the unix socket when one is set, otherwise host:port. An empty host listens on every interface
+func listen(config Config) (net.Listener, error) {
+	if config.Socket != "" {
+		//a socket left behind by a server that didn't shut down cleanly would stop this one listening
+		if info, err := os.Stat(config.Socket); err == nil && info.Mode()&os.ModeSocket != 0 {
+			if err := os.Remove(config.Socket); err != nil {
+				return nil, err
+			}
+		}
+		return net.Listen("unix", config.Socket)
+	}
+
+	port := config.Port
+	if port == 0 {
+		port = defaultPort
+	}
+	return net.Listen("tcp", net.JoinHostPort(config.Host, strconv.Itoa(port)))
+}
@@ -0,0 +1,139 @@
+package http
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// unixClient sends every request to the socket whatever the url's host
+func unixClient(socket string) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+}
+
+func waitForSocket(t *testing.T, socket string) {
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("unix", socket)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestServeDrainsRequestsOnShutdown(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "api.sock")
+	//left behind by a server that was killed
+	stale, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "done")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, handler, Config{Socket: socket, Timeouts: TimeoutConfig{ShutdownSeconds: 5}}, nopLogger{})
+	}()
+	waitForSocket(t, socket)
+
+	responses := make(chan string, 1)
+	go func() {
+		res, err := unixClient(socket).Get("http://api/slow")
+		if err != nil {
+			responses <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		responses <- string(body)
+	}()
+
+	<-started
+	cancel()
+	//the request in flight is finished before serve returns
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case err := <-served:
+		t.Fatalf("serve returned before the request finished: %v", err)
+	default:
+	}
+	close(release)
+
+	assert.Equal(t, "done", <-responses)
+	assert.NoError(t, <-served)
+	_, err = os.Stat(socket)
+	assert.True(t, os.IsNotExist(err), "the socket is removed on shutdown")
+}
+
+func TestServeNeedsCertAndKey(t *testing.T) {
+	err := serve(context.Background(), http.NotFoundHandler(), Config{Port: 1, TLS: TLSConfig{CertFile: "cert.pem"}}, nopLogger{})
+	assert.EqualError(t, err, "tls needs both certFile and keyFile")
+}
+
+func TestServeTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeTestCert(t, certFile, keyFile)
+	socket := filepath.Join(dir, "api.sock")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go serve(ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Proto)
+	}), Config{Socket: socket, TLS: TLSConfig{CertFile: certFile, KeyFile: keyFile}}, nopLogger{})
+	waitForSocket(t, socket)
+
+	client := unixClient(socket)
+	client.Transport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	res, err := client.Get("https://api/")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	assert.NotNil(t, res.TLS)
+}
+
+func writeTestCert(t *testing.T, certFile string, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "api"},
+		DNSNames:     []string{"api"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}), 0o600))
+}
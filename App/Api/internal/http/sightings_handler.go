@@ -1,20 +1,27 @@
 package http
 
 import (
+	"errors"
 	domain "lostpets"
 	"net/http"
 	"path"
 	"strconv"
+	"strings"
 
 	"github.com/labstack/echo/v4"
 )
 
 type (
 	sightingsHandler struct {
-		logger  domain.StructuredLogger
-		router  *echo.Echo
-		repo    domain.LostPetsRepo
-		emailer emailer
+		logger    domain.StructuredLogger
+		router    *echo.Echo
+		repo      domain.LostPetsRepo
+		fileRepo  domain.FileRepo
+		fileStore domain.FileStore
+		jobs      domain.JobQueue
+		geocoder  domain.Geocoder
+		// verify keeps new records pending until their email is verified
+		verify bool
 	}
 
 	apiSightingResponse struct {
@@ -29,17 +36,24 @@ type (
 	}
 
 	apiSighting struct {
-		ID        int      `json:"id,omitempty"`
-		Pet       apiPet   `json:"pet,omitempty"`
-		Date      Datetime `json:"date,omitempty"`
-		Location  string   `json:"location,omitempty"`
-		InCustody bool     `json:"inCustody"`
+		ID          int             `json:"id,omitempty"`
+		Pet         apiPet          `json:"pet,omitempty"`
+		Date        Datetime        `json:"date,omitempty"`
+		Location    string          `json:"location,omitempty"`
+		Coordinates *apiCoordinates `json:"coordinates,omitempty"`
+		InCustody   bool            `json:"inCustody"`
+		Status      string          `json:"status,omitempty"`
+		Hidden      bool            `json:"hidden,omitempty"`
 	}
 )
 
 func (h *sightingsHandler) initRoute(path string) {
 	h.router.GET(path+"/:id", h.handleGetByID())
 	h.router.GET(path+"/private/:guid", h.handleGetByGUID())
+	h.router.PUT(path+"/private/:guid", h.handleUpdateSighting(true))
+	h.router.PATCH(path+"/private/:guid", h.handleUpdateSighting(false))
+	h.router.DELETE(path+"/private/:guid", h.handleDeleteSighting())
+	h.router.PUT(path+"/private/:guid/status", h.handleUpdateStatus())
 	h.router.GET(path+"/private/:guid/matches", h.handleGetAllMatches())
 	h.router.GET(path, h.handleGetAll())
 	h.router.POST(path, h.handleCreateSighting(path+"/private/"))
@@ -50,14 +64,14 @@ func (h *sightingsHandler) handleGetByID() echo.HandlerFunc {
 		sightingID := c.Param("id")
 		id, err := strconv.Atoi(sightingID)
 		if err != nil {
-			return err
+			return domain.InvalidInput("invalid id").Wrap(err)
 		}
 
 		sighting, err := h.repo.GetSightingByID(id)
 		if err != nil {
 			return err
 		}
-		if sighting == nil {
+		if sighting == nil || sighting.HiddenOn != nil || sighting.Status == domain.StatusPending {
 			return c.NoContent(http.StatusNotFound)
 		}
 
@@ -85,9 +99,29 @@ func (h *sightingsHandler) handleGetByGUID() echo.HandlerFunc {
 	}
 }
 func (h *sightingsHandler) handleGetAll() echo.HandlerFunc {
-	//TODO add query support
 	return func(c echo.Context) error {
-		sightings, err := h.repo.GetAllSightings()
+		filters, err := parseFilters(c.QueryParams(), sightingQueryFields, listParams...)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+		//only open sightings are listed unless a status is asked for
+		filters = withStatus(filters, domain.StatusOpen)
+		//hidden records are never listed publicly
+		filters = withHidden(filters, false)
+		filters = withoutPending(filters)
+
+		radius, err := parseNear(c.QueryParams())
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+		filters = withNear(filters, radius)
+
+		page, err := parsePage(c.QueryParams(), recordSortFields)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+
+		sightings, total, err := h.repo.GetSightingsPage(page, filters...)
 		if err != nil {
 			return err
 		}
@@ -96,8 +130,11 @@ func (h *sightingsHandler) handleGetAll() echo.HandlerFunc {
 		for _, s := range sightings {
 			apiSightings = append(apiSightings, *toAPISighting(s))
 		}
-		resp := apiSightingResponse{
-			Sightings: &apiSightings,
+		resp := response{
+			Data: apiSightingResponse{
+				Sightings: &apiSightings,
+			},
+			Meta: newPageMeta(c.Request().URL, page, total),
 		}
 		return c.JSON(http.StatusOK, resp)
 	}
@@ -109,6 +146,9 @@ func (h *sightingsHandler) handleGetAllMatches() echo.HandlerFunc {
 		if err != nil {
 			return err
 		}
+		if sighting == nil {
+			return domain.NotFound("sighting not found")
+		}
 
 		matches, err := h.repo.GetMatchingPostings(sighting.ID)
 		if err != nil {
@@ -125,35 +165,197 @@ func (h *sightingsHandler) handleGetAllMatches() echo.HandlerFunc {
 	}
 }
 func (h *sightingsHandler) handleCreateSighting(location string) echo.HandlerFunc {
-	//TODO add validation
 	return func(c echo.Context) error {
 		newSighting := new(apiPostSighting)
 		if err := c.Bind(newSighting); err != nil {
 			return err
 		}
+		if err := c.Validate(newSighting); err != nil {
+			return err
+		}
 		dSighting := toDomainSighting(*newSighting)
+		if h.verify {
+			dSighting.Status = domain.StatusPending
+		}
+		geocode(h.geocoder, h.logger, &dSighting.Posting)
 		err := h.repo.AddSighting(dSighting)
 		if err != nil {
 			return err
 		}
 
-		//run search in 'background'
-		go h.searchForMatches(*dSighting)
+		//unverified records are matched once the owner follows the link
+		if h.verify {
+			queueVerification(h.jobs, h.logger, domain.TargetSighting, dSighting.ID)
+		} else {
+			queueMatch(h.jobs, h.logger, jobMatchSighting, dSighting.ID)
+		}
 
 		c.Response().Header().Set(echo.HeaderLocation, path.Join(location, dSighting.GUID))
 		return c.NoContent(http.StatusCreated)
 	}
 }
 
+/*
+handleUpdateSighting updates the sighting for the private guid.
+If replace is true the body replaces the sighting (PUT), otherwise only the fields in the body are changed (PATCH)
+*/
+func (h *sightingsHandler) handleUpdateSighting(replace bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		sGUID := c.Param("guid")
+		sighting, err := h.repo.GetSightingByGUID(sGUID)
+		if err != nil {
+			return err
+		}
+		if sighting == nil {
+			return c.NoContent(http.StatusNotFound)
+		}
+
+		update := new(apiPostSighting)
+		if !replace {
+			*update = *toAPIPostSighting(*sighting)
+		}
+		if err := c.Bind(update); err != nil {
+			return err
+		}
+		if err := c.Validate(update); err != nil {
+			return err
+		}
+
+		//ids come from the stored sighting, not the body
+		dSighting := toDomainSighting(*update)
+		dSighting.ID = sighting.ID
+		dSighting.GUID = sighting.GUID
+		dSighting.Pet.ID = sighting.Pet.ID
+		dSighting.Pet.Tag.ID = sighting.Pet.Tag.ID
+		geocodeUpdate(h.geocoder, h.logger, sighting.Posting, &dSighting.Posting)
+
+		err = h.repo.UpdateSighting(dSighting)
+		if err != nil {
+			return err
+		}
+
+		updated, err := h.repo.GetSightingByID(sighting.ID)
+		if err != nil {
+			return err
+		}
+
+		if h.verify && !strings.EqualFold(sighting.Email, updated.Email) {
+			//a new address has to be verified again before the record is public
+			updated, err = h.reverify(*updated)
+			if err != nil {
+				return err
+			}
+		} else if matchableChanged(sighting.Posting, updated.Posting) {
+			queueMatch(h.jobs, h.logger, jobMatchSighting, updated.ID)
+		}
+
+		resp := apiSightingResponse{
+			Sighting: toAPISighting(*updated),
+		}
+		return c.JSON(http.StatusOK, resp)
+	}
+}
+
+func (h *sightingsHandler) handleDeleteSighting() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		sGUID := c.Param("guid")
+		sighting, err := h.repo.GetSightingByGUID(sGUID)
+		if err != nil {
+			return err
+		}
+		if sighting == nil {
+			return c.NoContent(http.StatusNotFound)
+		}
+
+		picture, err := petPicture(h.fileRepo, sighting.Pet.PictureID)
+		if err != nil {
+			return err
+		}
+
+		err = h.repo.DeleteSighting(sighting.ID)
+		if err != nil {
+			return err
+		}
+
+		if picture != nil {
+			removePictureFile(h.fileRepo, h.fileStore, h.logger, picture)
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+/*
+handleUpdateStatus lets the owner mark the sighting as reunited or closed.
+A reunion can name the posting that led to it, which is marked reunited as well
+*/
+func (h *sightingsHandler) handleUpdateStatus() echo.HandlerFunc {
+	type statusUpdate struct {
+		Status    domain.Status `json:"status"`
+		PostingID int           `json:"postingId,omitempty"`
+	}
+	return func(c echo.Context) error {
+		sGUID := c.Param("guid")
+		sighting, err := h.repo.GetSightingByGUID(sGUID)
+		if err != nil {
+			return err
+		}
+		if sighting == nil {
+			return c.NoContent(http.StatusNotFound)
+		}
+
+		update := new(statusUpdate)
+		if err := c.Bind(update); err != nil {
+			return err
+		}
+
+		if update.Status != domain.StatusReunited && update.Status != domain.StatusClosed {
+			return domain.InvalidInput("status must be reunited or closed")
+		}
+
+		if update.PostingID != 0 {
+			if update.Status != domain.StatusReunited {
+				return domain.InvalidInput("postingId can only be set when reunited")
+			}
+			posting, err := h.repo.GetPostingByID(update.PostingID)
+			if err != nil {
+				return err
+			}
+			if posting == nil {
+				return domain.InvalidInput("unknown postingId")
+			}
+		}
+
+		if !sighting.Status.CanTransition(update.Status) {
+			return domain.Conflict("%s can not be changed to %s", sighting.Status, update.Status)
+		}
+
+		err = h.repo.UpdateSightingStatus(sighting.ID, update.Status, update.PostingID)
+		if err != nil {
+			return err
+		}
+
+		if update.PostingID != 0 {
+			err = h.repo.UpdatePostingStatus(update.PostingID, domain.StatusReunited, sighting.ID)
+			if err != nil && !errors.Is(err, domain.ErrStatusTransition) {
+				h.logger.Error(err.Error())
+			}
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
 func toDomainSighting(api apiPostSighting) *domain.Sighting {
 	return &domain.Sighting{
 		InCustody: api.InCustody,
 		Posting: domain.Posting{
-			ID:       api.ID,
-			Date:     api.Date.Time,
-			Location: api.Location,
-			Name:     api.Name,
-			Email:    api.Email,
+			ID:          api.ID,
+			Date:        api.Date.Time,
+			Location:    api.Location,
+			Coordinates: toDomainCoordinates(api.Coordinates),
+			Name:        api.Name,
+			Email:       api.Email,
 			Pet: domain.Pet{
 				ID:        api.Pet.ID,
 				PictureID: api.Pet.PictureID,
@@ -176,10 +378,13 @@ func toDomainSighting(api apiPostSighting) *domain.Sighting {
 
 func toAPISighting(d domain.Sighting) *apiSighting {
 	return &apiSighting{
-		InCustody: d.InCustody,
-		ID:        d.ID,
-		Date:      Datetime{Time: d.Date},
-		Location:  d.Location,
+		InCustody:   d.InCustody,
+		ID:          d.ID,
+		Date:        Datetime{Time: d.Date},
+		Location:    d.Location,
+		Coordinates: toAPICoordinates(d.Coordinates),
+		Status:      string(d.Status),
+		Hidden:      d.HiddenOn != nil,
 		Pet: apiPet{
 			ID:        d.Pet.ID,
 			PictureID: d.Pet.PictureID,
@@ -199,69 +404,23 @@ func toAPISighting(d domain.Sighting) *apiSighting {
 	}
 }
 
-//TODO Clean up and come up with a better way to search
-func (h *sightingsHandler) searchForMatches(sighting domain.Sighting) {
-	//create filters
-	filters := []domain.FilterMap{}
-
-	locationFilter := domain.FilterMap{}
-	locationFilter["location"] = []domain.Filter{
-		{
-			Comparator: "=",
-			Value:      sighting.Location,
-		},
-	}
-	filters = append(filters, locationFilter)
-
-	typeFilter := domain.FilterMap{}
-	typeFilter["pettype"] = []domain.Filter{
-		{
-			Comparator: "=",
-			Value:      sighting.Pet.Type,
-		},
-	}
-	filters = append(filters, typeFilter)
-
-	petNameFilter := domain.FilterMap{}
-	petNameFilter["petname"] = []domain.Filter{
-		{
-			Comparator: "=",
-			Value:      sighting.Pet.Name,
-		},
-	}
-	filters = append(filters, petNameFilter)
-
-	petColorFilter := domain.FilterMap{}
-	petColorFilter["petcolor"] = []domain.Filter{
-		{
-			Comparator: "=",
-			Value:      sighting.Pet.Color,
-		},
+func toAPIPostSighting(d domain.Sighting) *apiPostSighting {
+	return &apiPostSighting{
+		apiSighting: *toAPISighting(d),
+		Name:        d.Name,
+		Email:       d.Email,
 	}
-	filters = append(filters, petColorFilter)
+}
 
-	petMarksFilter := domain.FilterMap{}
-	petMarksFilter["petmarks"] = []domain.Filter{
-		{
-			Comparator: "=",
-			Value:      sighting.Pet.Marks,
-		},
+// reverify puts an open sighting back to pending and emails a new link
+func (h *sightingsHandler) reverify(sighting domain.Sighting) (*domain.Sighting, error) {
+	err := h.repo.UnverifySighting(sighting.ID)
+	if errors.Is(err, domain.ErrStatusTransition) {
+		return &sighting, nil
 	}
-	filters = append(filters, petMarksFilter)
-
-	//search, filters should be separated by ORs
-	matches, err := h.repo.GetAllPostings(filters...)
 	if err != nil {
-		h.logger.Error(err.Error())
-	}
-
-	for _, m := range matches {
-		h.logger.Info("Adding Match p:%d, s:%d", m.ID, sighting.ID)
-		err := h.repo.AddMatch(m.ID, sighting.ID)
-		if err != nil {
-			h.logger.Error(err.Error())
-		}
+		return nil, err
 	}
-	//email will have link to 'private' page in UI which will query for found matches
-	h.emailer.emailMatches("Sighting", sighting.Email, sighting.GUID)
+	queueVerification(h.jobs, h.logger, domain.TargetSighting, sighting.ID)
+	return h.repo.GetSightingByID(sighting.ID)
 }
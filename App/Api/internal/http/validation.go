@@ -0,0 +1,219 @@
+package http
+
+import (
+	"fmt"
+	domain "lostpets"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+)
+
+type (
+	/*
+		requestValidator is the echo Validator, c.Validate checks requests that implement validatable and lets anything else through.
+		Every field is checked so clients get all the problems with a request at once.
+	*/
+	requestValidator struct {
+		repo  domain.LostPetsRepo
+		files domain.FileRepo
+		now   func() time.Time
+	}
+
+	// validatable requests check their own fields, the validation looks up what needs the database
+	validatable interface {
+		validate(v *validation)
+	}
+
+	// validation collects the field errors for one request, err is set if a lookup failed
+	validation struct {
+		validator *requestValidator
+		errors    []fieldError
+		err       error
+		petTypes  []domain.PetType
+	}
+
+	// fieldError is one invalid field, Field is its path in the json body such as pet.typeId
+	fieldError struct {
+		Field   string `json:"field"`
+		Message string `json:"message"`
+	}
+
+	// validationError is the body of a 422 response
+	validationError struct {
+		Message string       `json:"message"`
+		Errors  []fieldError `json:"errors"`
+	}
+)
+
+const (
+	maxNameLength     = 100
+	maxEmailLength    = 254
+	maxLocationLength = 200
+	maxPetText        = 100
+	maxMarksLength    = 1000
+	maxBreeds         = 10
+
+	// dates a little ahead of the server's clock are allowed for clients with fast clocks
+	maxClockSkew = 5 * time.Minute
+)
+
+func newRequestValidator(repo domain.LostPetsRepo, files domain.FileRepo) *requestValidator {
+	return &requestValidator{repo: repo, files: files, now: time.Now}
+}
+
+// Validate returns a 422 listing the invalid fields, or the error from a failed lookup
+func (r *requestValidator) Validate(i interface{}) error {
+	request, ok := i.(validatable)
+	if !ok {
+		return nil
+	}
+
+	v := &validation{validator: r}
+	request.validate(v)
+	if v.err != nil {
+		return v.err
+	}
+	if len(v.errors) > 0 {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, &validationError{Message: "invalid request", Errors: v.errors})
+	}
+	return nil
+}
+
+func (v *validation) fail(field string, message string) {
+	v.errors = append(v.errors, fieldError{Field: field, Message: message})
+}
+
+func (v *validation) required(field string, value string) bool {
+	if strings.TrimSpace(value) == "" {
+		v.fail(field, "is required")
+		return false
+	}
+	return true
+}
+
+func (v *validation) maxLength(field string, value string, max int) {
+	if utf8.RuneCountInString(value) > max {
+		v.fail(field, fmt.Sprintf("must be at most %d characters", max))
+	}
+}
+
+func (v *validation) email(field string, value string) {
+	if !v.required(field, value) {
+		return
+	}
+	//only a bare address, not "Name <address>", is stored
+	address, err := mail.ParseAddress(value)
+	if err != nil || address.Address != value {
+		v.fail(field, "must be an email address")
+		return
+	}
+	v.maxLength(field, value, maxEmailLength)
+}
+
+func (v *validation) pastDate(field string, value time.Time) {
+	if value.IsZero() {
+		v.fail(field, "is required")
+	} else if value.After(v.validator.now().Add(maxClockSkew)) {
+		v.fail(field, "can not be in the future")
+	}
+}
+
+func (v *validation) coordinates(field string, c *apiCoordinates) {
+	if c == nil {
+		return
+	}
+	if c.Lat < -90 || c.Lat > 90 {
+		v.fail(field+".lat", "must be between -90 and 90")
+	}
+	if c.Lng < -180 || c.Lng > 180 {
+		v.fail(field+".lng", "must be between -180 and 180")
+	}
+	if c.AccuracyKm < 0 {
+		v.fail(field+".accuracyKm", "can not be negative")
+	}
+}
+
+// petType checks the id against the types in the database, they are only loaded once per request
+func (v *validation) petType(field string, id int) {
+	if id == 0 {
+		v.fail(field, "is required")
+		return
+	}
+	if v.petTypes == nil {
+		types, err := v.validator.repo.GetPetTypes()
+		if err != nil {
+			v.err = err
+			return
+		}
+		v.petTypes = types
+	}
+	for _, t := range v.petTypes {
+		if t.ID == id {
+			return
+		}
+	}
+	v.fail(field, "unknown pet type, see GET /pet-types")
+}
+
+// picture checks an uploaded picture exists, no picture is fine
+func (v *validation) picture(field string, id int) {
+	if id == 0 {
+		return
+	}
+	if id < 0 {
+		v.fail(field, "unknown picture")
+		return
+	}
+	meta, err := v.validator.files.GetFileMeta(id)
+	if err != nil {
+		v.err = err
+		return
+	}
+	if meta == nil {
+		v.fail(field, "unknown picture")
+	}
+}
+
+func (v *validation) pet(field string, p apiPet) {
+	v.petType(field+".typeId", p.TypeID)
+	v.picture(field+".pictureId", p.PictureID)
+	v.maxLength(field+".name", p.Name, maxPetText)
+	v.maxLength(field+".color", p.Color, maxPetText)
+	v.maxLength(field+".marks", p.Marks, maxMarksLength)
+	if len(p.Breeds) > maxBreeds {
+		v.fail(field+".breeds", fmt.Sprintf("must have at most %d breeds", maxBreeds))
+	}
+	for i, b := range p.Breeds {
+		breed := fmt.Sprintf("%s.breeds[%d]", field, i)
+		if v.required(breed, b) {
+			v.maxLength(breed, b, maxPetText)
+		}
+	}
+	v.maxLength(field+".tag.shape", p.Tag.Shape, maxPetText)
+	v.maxLength(field+".tag.color", p.Tag.Color, maxPetText)
+	v.maxLength(field+".tag.text", p.Tag.Text, maxPetText)
+}
+
+// record checks the fields postings and sightings share
+func (v *validation) record(name string, email string, date Datetime, location string, coordinates *apiCoordinates, pet apiPet) {
+	v.maxLength("name", name, maxNameLength)
+	v.email("email", email)
+	v.pastDate("date", date.Time)
+	if v.required("location", location) {
+		v.maxLength("location", location, maxLocationLength)
+	}
+	v.coordinates("coordinates", coordinates)
+	v.pet("pet", pet)
+}
+
+func (p *apiPostPosting) validate(v *validation) {
+	v.record(p.Name, p.Email, p.Date, p.Location, p.Coordinates, p.Pet)
+}
+
+func (s *apiPostSighting) validate(v *validation) {
+	v.record(s.Name, s.Email, s.Date, s.Location, s.Coordinates, s.Pet)
+}
@@ -0,0 +1,102 @@
+package http
+
+import (
+	"encoding/json"
+	"lostpets"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// validationOptions has a picture to use and a clock so dates in the future can be tested
+func validationOptions() testOptions {
+	return testOptions{
+		pictures: map[int]lostpets.FileMeta{3: {ID: 3, GUID: "picture-guid"}},
+		now:      time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestValidPostingIsCreated(t *testing.T) {
+	s := newTestServer(validationOptions())
+
+	rec := doRequest(s.Echo, http.MethodPost, "/postings", `{"name": "Sam", "email": "sam@example.org", "date": "2026-10-18T12:04:00.000Z",
+		"location": "park", "coordinates": {"lat": 45.5, "lng": -73.6}, "pet": {"typeId": 2, "pictureId": 3, "breeds": ["tabby"]}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	assert.Len(t, s.repo.postings, 1)
+	assert.Equal(t, []string{jobMatchPosting}, s.queue.kinds)
+}
+
+func TestInvalidPostingListsEveryField(t *testing.T) {
+	s := newTestServer(validationOptions())
+
+	rec := doRequest(s.Echo, http.MethodPost, "/postings", `{"name": "`+strings.Repeat("a", maxNameLength+1)+`", "email": "Sam <sam@example.org>",
+		"date": "2026-10-19T12:00:00.000Z", "coordinates": {"lat": 91, "lng": 0}, "pet": {"typeId": 7, "pictureId": 4, "breeds": [""]}}`)
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	resp := validationError{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	fields := []string{}
+	for _, e := range resp.Errors {
+		fields = append(fields, e.Field)
+	}
+	assert.Equal(t, []string{"name", "email", "date", "location", "coordinates.lat", "pet.typeId", "pet.pictureId", "pet.breeds[0]"}, fields)
+	assert.Empty(t, s.repo.postings)
+	assert.Empty(t, s.queue.kinds)
+}
+
+func TestValidationRules(t *testing.T) {
+	valid := func() *apiPostSighting {
+		return &apiPostSighting{
+			apiSighting: apiSighting{
+				Date:     Datetime{Time: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)},
+				Location: "park",
+				Pet:      apiPet{TypeID: 1},
+			},
+			Email: "finder@example.org",
+		}
+	}
+
+	tests := []struct {
+		name   string
+		change func(s *apiPostSighting)
+		field  string
+	}{
+		{name: "missing email", change: func(s *apiPostSighting) { s.Email = "" }, field: "email"},
+		{name: "bad email", change: func(s *apiPostSighting) { s.Email = "finder" }, field: "email"},
+		{name: "long email", change: func(s *apiPostSighting) { s.Email = strings.Repeat("a", maxEmailLength) + "@example.org" }, field: "email"},
+		{name: "missing date", change: func(s *apiPostSighting) { s.Date = Datetime{} }, field: "date"},
+		{name: "future date", change: func(s *apiPostSighting) { s.Date = Datetime{Time: time.Date(2026, 10, 18, 12, 6, 0, 0, time.UTC)} }, field: "date"},
+		{name: "blank location", change: func(s *apiPostSighting) { s.Location = "  " }, field: "location"},
+		{name: "long location", change: func(s *apiPostSighting) { s.Location = strings.Repeat("é", maxLocationLength+1) }, field: "location"},
+		{name: "missing type", change: func(s *apiPostSighting) { s.Pet.TypeID = 0 }, field: "pet.typeId"},
+		{name: "too many breeds", change: func(s *apiPostSighting) { s.Pet.Breeds = make([]string, maxBreeds+1) }, field: "pet.breeds"},
+		{name: "long marks", change: func(s *apiPostSighting) { s.Pet.Marks = strings.Repeat("a", maxMarksLength+1) }, field: "pet.marks"},
+		{name: "long tag", change: func(s *apiPostSighting) { s.Pet.Tag.Text = strings.Repeat("a", maxPetText+1) }, field: "pet.tag.text"},
+		{name: "negative accuracy", change: func(s *apiPostSighting) { s.Coordinates = &apiCoordinates{AccuracyKm: -1} }, field: "coordinates.accuracyKm"},
+	}
+
+	validator := newRequestValidator(&fakeLostPetsRepo{}, &fakeFileRepo{})
+	validator.now = func() time.Time { return time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC) }
+	require.NoError(t, validator.Validate(valid()))
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := valid()
+			test.change(request)
+			err := validator.Validate(request)
+
+			httpErr, ok := err.(*echo.HTTPError)
+			require.True(t, ok, "expected an http error, got %v", err)
+			assert.Equal(t, http.StatusUnprocessableEntity, httpErr.Code)
+			assert.Equal(t, test.field, httpErr.Message.(*validationError).Errors[0].Field)
+		})
+	}
+
+	//anything that isn't a request body is let through
+	assert.NoError(t, validator.Validate("not a request"))
+}
@@ -0,0 +1,240 @@
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	domain "lostpets"
+	"lostpets/internal/notifications"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+type (
+	verificationHandler struct {
+		logger   domain.StructuredLogger
+		router   *echo.Echo
+		repo     domain.LostPetsRepo
+		jobs     domain.JobQueue
+		config   VerificationConfig
+		linkBase string
+	}
+
+	// verificationMailer sends verification links, only ever by email as that is what is being verified
+	verificationMailer struct {
+		send      func(m email) error
+		templates *notifications.Registry
+		config    VerificationConfig
+		repo      domain.LostPetsRepo
+		logger    domain.StructuredLogger
+	}
+)
+
+var errInvalidToken = errors.New("invalid verification link")
+
+// confirmPage is shown for a verification link, the record is only verified when the form is posted so link scanners can't verify it
+var confirmPage = template.Must(template.New("confirm").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>Confirm your email</title></head>
+<body>
+<form method="post" action="{{.Action}}">
+<p>Confirm that {{.Email}} is your email to publish your {{.Target}}.</p>
+<button type="submit">Confirm</button>
+</form>
+</body>
+</html>
+`))
+
+func (h *verificationHandler) initRoute() {
+	h.router.GET("/verify/:token", h.handleConfirmPage())
+	h.router.POST("/verify/:token", h.handleVerify())
+	h.router.POST(postingsPath+"/private/:guid/verification", h.handleResend(domain.TargetPosting))
+	h.router.POST(sightingsPath+"/private/:guid/verification", h.handleResend(domain.TargetSighting))
+}
+
+/*
+handleConfirmPage shows the owner a button to confirm the address. Mail scanners open the links in emails,
+so following the link doesn't change anything, the record is verified by the form's POST.
+*/
+func (h *verificationHandler) handleConfirmPage() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		target, _, owner, err := h.pending(c)
+		if err != nil {
+			return err
+		}
+
+		page := new(strings.Builder)
+		err = confirmPage.Execute(page, map[string]string{
+			"Action": c.Request().URL.Path,
+			"Email":  owner.Email,
+			"Target": string(target),
+		})
+		if err != nil {
+			return err
+		}
+		return c.HTML(http.StatusOK, page.String())
+	}
+}
+
+/*
+handleVerify opens the pending record the link was sent for and takes the owner to its private page.
+A link only works while the record is pending with the address it was sent to, so it can't be used twice.
+*/
+func (h *verificationHandler) handleVerify() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		target, id, owner, err := h.pending(c)
+		if err != nil {
+			return err
+		}
+
+		jobKind := jobMatchPosting
+		if target == domain.TargetPosting {
+			err = h.repo.VerifyPosting(id)
+		} else {
+			err = h.repo.VerifySighting(id)
+			jobKind = jobMatchSighting
+		}
+		if errors.Is(err, domain.ErrStatusTransition) {
+			return echo.NewHTTPError(http.StatusGone, "the link has already been used or has expired")
+		} else if err != nil {
+			return err
+		}
+
+		//records are only matched once they are public
+		queueMatch(h.jobs, h.logger, jobKind, id)
+		return c.Redirect(http.StatusSeeOther, h.linkBase+owner.GUID)
+	}
+}
+
+// pending is the record the token in the path was sent for, it is an error unless the token is valid and the record still pending
+func (h *verificationHandler) pending(c echo.Context) (domain.ModerationTarget, int, *domain.Posting, error) {
+	target, id, err := parseVerificationToken(c.Param("token"))
+	if err != nil {
+		return target, id, nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	owner, err := verificationOwner(h.repo, target, id)
+	if err != nil {
+		return target, id, nil, err
+	}
+	if owner == nil || !hmac.Equal([]byte(c.Param("token")), []byte(verificationToken(h.config.Secret, target, id, owner.Email))) {
+		return target, id, nil, echo.NewHTTPError(http.StatusBadRequest, errInvalidToken.Error())
+	}
+	if owner.Status != domain.StatusPending {
+		return target, id, nil, echo.NewHTTPError(http.StatusGone, "the link has already been used or has expired")
+	}
+	return target, id, owner, nil
+}
+
+// handleResend emails a new link for a pending record, the outbox's rate limit keeps it from being used to flood an address
+func (h *verificationHandler) handleResend(target domain.ModerationTarget) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var owner *domain.Posting
+		if target == domain.TargetPosting {
+			posting, err := h.repo.GetPostingByGUID(c.Param("guid"))
+			if err != nil {
+				return err
+			}
+			owner = posting
+		} else {
+			sighting, err := h.repo.GetSightingByGUID(c.Param("guid"))
+			if err != nil {
+				return err
+			}
+			if sighting != nil {
+				owner = &sighting.Posting
+			}
+		}
+		if owner == nil {
+			return c.NoContent(http.StatusNotFound)
+		}
+		if owner.Status != domain.StatusPending {
+			return echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("%s is %s, only pending records are verified", target, owner.Status))
+		}
+
+		if err := h.jobs.Enqueue(jobEmailVerification, verificationJob{Target: target, ID: owner.ID}); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusAccepted)
+	}
+}
+
+// queueVerification queues the verification email for a new or changed record, the owner can ask again if it fails
+func queueVerification(queue domain.JobQueue, logger domain.StructuredLogger, target domain.ModerationTarget, id int) {
+	if err := queue.Enqueue(jobEmailVerification, verificationJob{Target: target, ID: id}); err != nil {
+		logger.Error("failed to queue verification for %s %d: %s", target, id, err)
+	}
+}
+
+// emailLink emails the verification link for the record if it is still pending
+func (v verificationMailer) emailLink(job verificationJob) error {
+	owner, err := verificationOwner(v.repo, job.Target, job.ID)
+	if err != nil {
+		return err
+	}
+	if owner == nil || owner.Status != domain.StatusPending {
+		v.logger.Info("not emailing a verification link for %s %d, it isn't pending", job.Target, job.ID)
+		return nil
+	}
+
+	data := notifications.VerificationData{
+		Type: string(job.Target),
+		Link: v.config.LinkBase + verificationToken(v.config.Secret, job.Target, job.ID, owner.Email),
+	}
+	if owner.PendingOn != nil && v.config.ValidFor > 0 {
+		data.ExpiresOn = owner.PendingOn.Add(v.config.ValidFor)
+	}
+
+	m, err := renderEmail(v.templates, notifications.EventVerification, owner.Locale, data)
+	if err != nil {
+		return err
+	}
+	m.Kind = kindVerification
+	m.To = owner.Email
+	return v.send(m)
+}
+
+// verificationOwner is the posting or sighting the link is for
+func verificationOwner(repo domain.LostPetsRepo, target domain.ModerationTarget, id int) (*domain.Posting, error) {
+	if target == domain.TargetPosting {
+		return repo.GetPostingByID(id)
+	}
+	sighting, err := repo.GetSightingByID(id)
+	if err != nil || sighting == nil {
+		return nil, err
+	}
+	return &sighting.Posting, nil
+}
+
+/*
+verificationToken is <target>.<id>.<signature>, signed with the record's email so a link stops working
+when the email is changed. Verifying opens the record, which is what makes a link usable only once.
+*/
+func verificationToken(secret string, target domain.ModerationTarget, id int, email string) string {
+	payload := fmt.Sprintf("%s.%d", target, id)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload + "." + strings.ToLower(email)))
+	return payload + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+func parseVerificationToken(token string) (domain.ModerationTarget, int, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", 0, errInvalidToken
+	}
+	target := domain.ModerationTarget(parts[0])
+	if target != domain.TargetPosting && target != domain.TargetSighting {
+		return "", 0, errInvalidToken
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return "", 0, errInvalidToken
+	}
+	return target, id, nil
+}
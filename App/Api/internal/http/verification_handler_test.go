@@ -0,0 +1,140 @@
+package http
+
+import (
+	"io"
+	"lostpets"
+	"lostpets/internal/notifications"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testVerificationSecret = "verification-secret"
+
+// verificationOptions turns verification on, with a pending posting and an open sighting
+func verificationOptions() testOptions {
+	return testOptions{
+		config: Config{
+			Verification: VerificationConfig{Secret: testVerificationSecret},
+			Email:        EmailConfig{LinkBase: "http://localhost:4200/postings/private/"},
+		},
+		postings: map[int]lostpets.Posting{1: {ID: 1, GUID: "posting-guid", Email: "Owner@example.org", Status: lostpets.StatusPending}},
+		sightings: map[int]lostpets.Sighting{10: {Posting: lostpets.Posting{
+			ID: 10, GUID: "sighting-guid", Email: "finder@example.org", Status: lostpets.StatusOpen}}},
+	}
+}
+
+func TestVerificationToken(t *testing.T) {
+	token := verificationToken(testVerificationSecret, lostpets.TargetSighting, 10, "Finder@Example.org")
+	target, id, err := parseVerificationToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, lostpets.TargetSighting, target)
+	assert.Equal(t, 10, id)
+
+	//the address is compared the way email providers do, without case
+	assert.Equal(t, token, verificationToken(testVerificationSecret, lostpets.TargetSighting, 10, "finder@example.org"))
+	assert.NotEqual(t, token, verificationToken(testVerificationSecret, lostpets.TargetSighting, 10, "someone@example.org"))
+	assert.NotEqual(t, token, verificationToken("other-secret", lostpets.TargetSighting, 10, "finder@example.org"))
+
+	for _, bad := range []string{"", "sighting.10", "match.10.abc", "sighting.ten.abc", "sighting.10.abc.def"} {
+		_, _, err := parseVerificationToken(bad)
+		assert.ErrorIs(t, err, errInvalidToken, bad)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	s := newTestServer(verificationOptions())
+	token := verificationToken(testVerificationSecret, lostpets.TargetPosting, 1, "owner@example.org")
+
+	//a signature for another record or address doesn't verify this one
+	tampered := "posting.1." + strings.Split(verificationToken(testVerificationSecret, lostpets.TargetPosting, 2, "owner@example.org"), ".")[2]
+	assert.Equal(t, http.StatusBadRequest, doRequest(s.Echo, http.MethodGet, "/verify/"+tampered, "").Code)
+	changed := verificationToken(testVerificationSecret, lostpets.TargetPosting, 1, "old@example.org")
+	assert.Equal(t, http.StatusBadRequest, doRequest(s.Echo, http.MethodPost, "/verify/"+changed, "").Code)
+	assert.Equal(t, lostpets.StatusPending, s.repo.postings[1].Status)
+
+	//opening the link, as a mail scanner would, only shows the confirm page
+	rec := doRequest(s.Echo, http.MethodGet, "/verify/"+token, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `<form method="post" action="/verify/`+token+`">`)
+	assert.Contains(t, rec.Body.String(), "Owner@example.org")
+	assert.Equal(t, lostpets.StatusPending, s.repo.postings[1].Status)
+	assert.Empty(t, s.queue.kinds)
+
+	rec = doRequest(s.Echo, http.MethodPost, "/verify/"+token, "")
+	require.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "http://localhost:4200/postings/private/posting-guid", rec.Header().Get(echo.HeaderLocation))
+	assert.Equal(t, lostpets.StatusOpen, s.repo.postings[1].Status)
+	assert.Equal(t, []string{jobMatchPosting}, s.queue.kinds)
+
+	//links work once
+	assert.Equal(t, http.StatusGone, doRequest(s.Echo, http.MethodGet, "/verify/"+token, "").Code)
+	assert.Equal(t, http.StatusGone, doRequest(s.Echo, http.MethodPost, "/verify/"+token, "").Code)
+	assert.Len(t, s.queue.kinds, 1)
+}
+
+func TestResendVerification(t *testing.T) {
+	s := newTestServer(verificationOptions())
+
+	assert.Equal(t, http.StatusAccepted, doRequest(s.Echo, http.MethodPost, "/postings/private/posting-guid/verification", "").Code)
+	assert.Equal(t, []string{jobEmailVerification}, s.queue.kinds)
+
+	assert.Equal(t, http.StatusConflict, doRequest(s.Echo, http.MethodPost, "/sightings/private/sighting-guid/verification", "").Code)
+	assert.Equal(t, http.StatusNotFound, doRequest(s.Echo, http.MethodPost, "/postings/private/missing/verification", "").Code)
+	assert.Len(t, s.queue.kinds, 1)
+}
+
+func TestCreatedPostingWaitsForVerification(t *testing.T) {
+	s := newTestServer(verificationOptions())
+
+	rec := doRequest(s.Echo, http.MethodPost, "/postings", `{"email": "new@example.org", "date": "2026-10-17T10:00:00.000Z", "location": "park", "pet": {"name": "Rex", "typeId": 1}}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, lostpets.StatusPending, s.repo.postings[2].Status)
+	//matching waits until the email is verified
+	assert.Equal(t, []string{jobEmailVerification}, s.queue.kinds)
+
+	assert.Equal(t, http.StatusNotFound, doRequest(s.Echo, http.MethodGet, "/postings/2", "").Code)
+	assert.Equal(t, http.StatusOK, doRequest(s.Echo, http.MethodGet, "/postings/private/new-guid", "").Code)
+}
+
+func TestEmailVerificationLink(t *testing.T) {
+	s := newTestServer(verificationOptions())
+	templates, err := notifications.NewRegistry(filepath.Join("..", "..", "config", "templates"), "en", "")
+	require.NoError(t, err)
+
+	pendingOn := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	p := s.repo.postings[1]
+	p.PendingOn = &pendingOn
+	s.repo.postings[1] = p
+
+	outbox := &fakeOutbox{}
+	emailer := emailer{outbox: outbox, templates: templates, logger: nopLogger{}}
+	mailer := verificationMailer{send: emailer.send, templates: templates, repo: s.repo, logger: nopLogger{},
+		config: VerificationConfig{Secret: testVerificationSecret, LinkBase: "http://localhost:8080/verify/", ValidFor: 48 * time.Hour}}
+
+	require.NoError(t, mailer.emailLink(verificationJob{Target: lostpets.TargetPosting, ID: 1}))
+	require.Len(t, outbox.notifications, 1)
+	n := outbox.notifications[0]
+	assert.Equal(t, kindVerification, n.Kind)
+	assert.Equal(t, "Owner@example.org", n.Recipient)
+
+	_, params, err := mime.ParseMediaType(n.ContentType)
+	require.NoError(t, err)
+	part, err := multipart.NewReader(strings.NewReader(n.Body), params["boundary"]).NextPart()
+	require.NoError(t, err)
+	text, _ := io.ReadAll(part)
+	assert.Contains(t, string(text), "http://localhost:8080/verify/"+verificationToken(testVerificationSecret, lostpets.TargetPosting, 1, "owner@example.org"))
+	assert.Contains(t, string(text), "expires on 20 October 2026 12:00 UTC")
+
+	//open records aren't sent a link
+	require.NoError(t, mailer.emailLink(verificationJob{Target: lostpets.TargetSighting, ID: 10}))
+	assert.Len(t, outbox.notifications, 1)
+}
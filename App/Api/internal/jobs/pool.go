@@ -0,0 +1,218 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	domain "lostpets"
+	"math"
+	"sync"
+	"time"
+)
+
+type (
+	Config struct {
+		Workers             int `json:"workers"`             // number of jobs run at the same time
+		PollIntervalSeconds int `json:"pollIntervalSeconds"` // how long an idle worker waits before looking for jobs again
+		MaxAttempts         int `json:"maxAttempts"`         // attempts before a job is dead-lettered
+		BackoffSeconds      int `json:"backoffSeconds"`      // wait before the first retry, doubled for each retry after
+		LeaseSeconds        int `json:"leaseSeconds"`        // a running job is picked up by another worker if it takes longer then this
+	}
+
+	// Handler runs a job, returning an error retries the job until it runs out of attempts
+	Handler func(payload []byte) error
+
+	// Pool runs queued jobs on a fixed number of workers
+	Pool struct {
+		repo        domain.JobRepo
+		logger      domain.StructuredLogger
+		handlers    map[string]Handler
+		workers     int
+		interval    time.Duration
+		maxAttempts int
+		backoff     time.Duration
+		lease       time.Duration
+
+		stop context.CancelFunc
+		wg   sync.WaitGroup
+	}
+)
+
+const (
+	defaultWorkers     = 2
+	defaultInterval    = 5 * time.Second
+	defaultMaxAttempts = 5
+	defaultBackoff     = 30 * time.Second
+	defaultLease       = 5 * time.Minute
+	maxBackoff         = 6 * time.Hour
+)
+
+func NewPool(config Config, repo domain.JobRepo, logger domain.StructuredLogger) *Pool {
+	pool := &Pool{
+		repo:        repo,
+		logger:      logger,
+		handlers:    map[string]Handler{},
+		workers:     config.Workers,
+		interval:    time.Duration(config.PollIntervalSeconds) * time.Second,
+		maxAttempts: config.MaxAttempts,
+		backoff:     time.Duration(config.BackoffSeconds) * time.Second,
+		lease:       time.Duration(config.LeaseSeconds) * time.Second,
+	}
+
+	if pool.workers <= 0 {
+		pool.workers = defaultWorkers
+	}
+	if pool.interval <= 0 {
+		pool.interval = defaultInterval
+	}
+	if pool.maxAttempts <= 0 {
+		pool.maxAttempts = defaultMaxAttempts
+	}
+	if pool.backoff <= 0 {
+		pool.backoff = defaultBackoff
+	}
+	if pool.lease <= 0 {
+		pool.lease = defaultLease
+	}
+
+	return pool
+}
+
+// Register sets the handler for a kind of job, handlers must be registered before Start
+func (p *Pool) Register(kind string, handler Handler) {
+	p.handlers[kind] = handler
+}
+
+// Enqueue stores a job to be run as soon as a worker is free
+func (p *Pool) Enqueue(kind string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	return p.repo.AddJob(&domain.Job{
+		Kind:        kind,
+		Payload:     data,
+		MaxAttempts: p.maxAttempts,
+	})
+}
+
+// Start starts the workers, they keep running until Shutdown is called
+func (p *Pool) Start() {
+	ctx, stop := context.WithCancel(context.Background())
+	p.stop = stop
+
+	for i := 0; i < p.workers; i++ {
+		p.wg.Add(1)
+		go p.work(ctx)
+	}
+}
+
+/*
+Shutdown stops the workers from claiming new jobs and waits for running jobs to finish.
+If ctx is done first the running jobs are left to be picked up again once their lease runs out.
+*/
+func (p *Pool) Shutdown(ctx context.Context) error {
+	if p.stop != nil {
+		p.stop()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Pool) work(ctx context.Context) {
+	defer p.wg.Done()
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		job, err := p.repo.ClaimJob(p.lease)
+		if err != nil {
+			p.logger.Error("failed to claim job: %s", err)
+		}
+
+		if job != nil {
+			p.run(job)
+			continue
+		}
+
+		// nothing to do, wait before looking again
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.interval):
+		}
+	}
+}
+
+// run runs the job and records the outcome, failed jobs are retried with backoff until they run out of attempts
+func (p *Pool) run(job *domain.Job) {
+	logger := p.logger.WithFields(map[string]interface{}{"job": job.ID, "kind": job.Kind, "attempt": job.Attempts})
+
+	handler, ok := p.handlers[job.Kind]
+	if !ok {
+		p.deadLetter(logger, job, fmt.Sprintf("no handler for job kind: %s", job.Kind))
+		return
+	}
+
+	err := safeRun(handler, job.Payload)
+	if err == nil {
+		if err := p.repo.CompleteJob(job.ID); err != nil {
+			logger.Error("failed to complete job: %s", err)
+		}
+		return
+	}
+
+	if job.Attempts >= job.MaxAttempts {
+		p.deadLetter(logger, job, fmt.Sprintf("out of attempts: %s", err))
+		return
+	}
+
+	runAt := time.Now().Add(backoff(p.backoff, job.Attempts))
+	logger.Info("job failed, retrying at %s: %s", runAt.Format(time.RFC3339), err)
+	if err := p.repo.RetryJob(job.ID, runAt, err.Error()); err != nil {
+		logger.Error("failed to retry job: %s", err)
+	}
+}
+
+func (p *Pool) deadLetter(logger domain.Logger, job *domain.Job, reason string) {
+	logger.Error("job dead-lettered: %s", reason)
+	if err := p.repo.DeadLetterJob(job.ID, reason); err != nil {
+		logger.Error("failed to dead-letter job: %s", err)
+	}
+}
+
+// safeRun turns a panic in a handler into an error so it doesn't take the worker down
+func safeRun(handler Handler, payload []byte) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job handler panic: %v", r)
+		}
+	}()
+	return handler(payload)
+}
+
+// backoff doubles the base wait for each attempt already made, capped at maxBackoff
+func backoff(base time.Duration, attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	wait := float64(base) * math.Pow(2, float64(attempts-1))
+	if wait > float64(maxBackoff) {
+		return maxBackoff
+	}
+	return time.Duration(wait)
+}
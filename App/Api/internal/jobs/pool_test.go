@@ -0,0 +1,129 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"lostpets"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRepo hands out the queued jobs in order and records what happened to them
+type fakeRepo struct {
+	mu        sync.Mutex
+	queued    []*lostpets.Job
+	completed []int
+	retried   map[int]time.Time
+	dead      map[int]string
+}
+
+func newFakeRepo(jobs ...*lostpets.Job) *fakeRepo {
+	return &fakeRepo{queued: jobs, retried: map[int]time.Time{}, dead: map[int]string{}}
+}
+
+func (r *fakeRepo) AddJob(job *lostpets.Job) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job.ID = len(r.queued) + 1
+	r.queued = append(r.queued, job)
+	return nil
+}
+
+func (r *fakeRepo) ClaimJob(lease time.Duration) (*lostpets.Job, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.queued) == 0 {
+		return nil, nil
+	}
+	job := r.queued[0]
+	r.queued = r.queued[1:]
+	job.Attempts++
+	return job, nil
+}
+
+func (r *fakeRepo) CompleteJob(id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.completed = append(r.completed, id)
+	return nil
+}
+
+func (r *fakeRepo) RetryJob(id int, runAt time.Time, lastError string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.retried[id] = runAt
+	return nil
+}
+
+func (r *fakeRepo) DeadLetterJob(id int, lastError string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dead[id] = lastError
+	return nil
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(message string, args ...interface{})                  {}
+func (nopLogger) Info(message string, args ...interface{})                   {}
+func (nopLogger) Error(message string, args ...interface{})                  {}
+func (nopLogger) UnwrapError(err error)                                      {}
+func (l nopLogger) WithFields(fields map[string]interface{}) lostpets.Logger { return l }
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, 30*time.Second, backoff(30*time.Second, 0))
+	assert.Equal(t, 30*time.Second, backoff(30*time.Second, 1))
+	assert.Equal(t, 60*time.Second, backoff(30*time.Second, 2))
+	assert.Equal(t, 4*time.Minute, backoff(30*time.Second, 4))
+	assert.Equal(t, maxBackoff, backoff(30*time.Second, 20))
+}
+
+func TestRun(t *testing.T) {
+	failed := errors.New("smtp down")
+
+	repo := newFakeRepo()
+	pool := NewPool(Config{MaxAttempts: 2}, repo, nopLogger{})
+	pool.Register("ok", func(payload []byte) error { return nil })
+	pool.Register("fail", func(payload []byte) error { return failed })
+	pool.Register("panic", func(payload []byte) error { panic("boom") })
+
+	pool.run(&lostpets.Job{ID: 1, Kind: "ok", Attempts: 1, MaxAttempts: 2})
+	assert.Equal(t, []int{1}, repo.completed)
+
+	before := time.Now()
+	pool.run(&lostpets.Job{ID: 2, Kind: "fail", Attempts: 1, MaxAttempts: 2})
+	assert.WithinDuration(t, before.Add(defaultBackoff), repo.retried[2], time.Second)
+
+	pool.run(&lostpets.Job{ID: 3, Kind: "fail", Attempts: 2, MaxAttempts: 2})
+	assert.Contains(t, repo.dead[3], failed.Error())
+
+	pool.run(&lostpets.Job{ID: 4, Kind: "unknown", Attempts: 1, MaxAttempts: 2})
+	assert.Contains(t, repo.dead[4], "no handler")
+
+	pool.run(&lostpets.Job{ID: 5, Kind: "panic", Attempts: 1, MaxAttempts: 2})
+	assert.Contains(t, repo.retried, 5)
+}
+
+func TestShutdownDrainsRunningJobs(t *testing.T) {
+	repo := newFakeRepo()
+	pool := NewPool(Config{Workers: 1}, repo, nopLogger{})
+
+	started := make(chan struct{})
+	pool.Register("slow", func(payload []byte) error {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		return nil
+	})
+	assert.NoError(t, pool.Enqueue("slow", map[string]int{"id": 1}))
+
+	pool.Start()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, pool.Shutdown(ctx))
+	assert.Equal(t, []int{1}, repo.completed)
+}
@@ -0,0 +1,153 @@
+package lifecycle
+
+import (
+	"context"
+	domain "lostpets"
+	"time"
+)
+
+type (
+	Config struct {
+		ExpireAfterDays      int `json:"expireAfterDays"`      // open postings and sightings older than this are expired, 0 disables expiry
+		CheckIntervalMinutes int `json:"checkIntervalMinutes"` // how often to look for records to expire
+		VerifyWithinHours    int `json:"verifyWithinHours"`    // pending records whose email isn't verified in this time are expired, 0 keeps them pending
+		WarnDaysBefore       int `json:"warnDaysBefore"`       // owners are emailed this many days before their posting expires, 0 sends no warning
+	}
+
+	// ExpiringJob is the payload of the job that warns the owner their posting is about to expire
+	ExpiringJob struct {
+		ID        int       `json:"id"`
+		ExpiresOn time.Time `json:"expiresOn"`
+	}
+
+	// Expirer moves open postings and sightings to expired once they reach the configured age, and pending ones left unverified
+	Expirer struct {
+		repo       domain.LostPetsRepo
+		jobs       domain.JobQueue
+		logger     domain.StructuredLogger
+		maxAge     time.Duration
+		maxPending time.Duration
+		warnBefore time.Duration
+		interval   time.Duration
+	}
+)
+
+const (
+	defaultCheckInterval = time.Hour
+
+	// JobEmailExpiring is the kind of job queued for each posting about to expire
+	JobEmailExpiring = "email-posting-expiring"
+)
+
+func NewExpirer(config Config, repo domain.LostPetsRepo, jobs domain.JobQueue, logger domain.StructuredLogger) *Expirer {
+	interval := time.Duration(config.CheckIntervalMinutes) * time.Minute
+	if interval <= 0 {
+		interval = defaultCheckInterval
+	}
+
+	return &Expirer{
+		repo:       repo,
+		jobs:       jobs,
+		logger:     logger,
+		maxAge:     time.Duration(config.ExpireAfterDays) * 24 * time.Hour,
+		maxPending: time.Duration(config.VerifyWithinHours) * time.Hour,
+		warnBefore: time.Duration(config.WarnDaysBefore) * 24 * time.Hour,
+		interval:   interval,
+	}
+}
+
+// Run expires records every interval until the context is done, it returns straight away if expiry is disabled
+func (e *Expirer) Run(ctx context.Context) {
+	if e.maxAge <= 0 && e.maxPending <= 0 {
+		e.logger.Info("expiry disabled")
+		return
+	}
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		e.Expire(time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+/*
+Expire expires all open records created more then maxAge before now and pending ones older than maxPending.
+Postings that will expire within warnBefore are warned first, so a posting is warned even if it is checked late.
+*/
+func (e *Expirer) Expire(now time.Time) {
+	if e.maxPending > 0 {
+		e.expirePending(now.Add(-e.maxPending))
+	}
+	if e.maxAge <= 0 {
+		return
+	}
+	if e.warnBefore > 0 {
+		e.warn(now.Add(e.warnBefore - e.maxAge))
+	}
+
+	cutoff := now.Add(-e.maxAge)
+
+	postings, err := e.repo.ExpirePostings(cutoff)
+	if err != nil {
+		e.logger.Error("failed to expire postings: %s", err)
+	}
+
+	sightings, err := e.repo.ExpireSightings(cutoff)
+	if err != nil {
+		e.logger.Error("failed to expire sightings: %s", err)
+	}
+
+	if postings > 0 || sightings > 0 {
+		e.logger.Info("expired %d postings and %d sightings created before %s", postings, sightings, cutoff.Format(time.RFC3339))
+	}
+}
+
+func (e *Expirer) expirePending(cutoff time.Time) {
+	postings, err := e.repo.ExpirePendingPostings(cutoff)
+	if err != nil {
+		e.logger.Error("failed to expire pending postings: %s", err)
+	}
+
+	sightings, err := e.repo.ExpirePendingSightings(cutoff)
+	if err != nil {
+		e.logger.Error("failed to expire pending sightings: %s", err)
+	}
+
+	if postings > 0 || sightings > 0 {
+		e.logger.Info("expired %d postings and %d sightings left unverified since before %s", postings, sightings, cutoff.Format(time.RFC3339))
+	}
+}
+
+// warn queues an email for each open posting created before the cutoff that hasn't been warned, with when it will expire
+func (e *Expirer) warn(cutoff time.Time) {
+	ids, err := e.repo.WarnExpiringPostings(cutoff)
+	if err != nil {
+		e.logger.Error("failed to find expiring postings: %s", err)
+		return
+	}
+
+	for _, id := range ids {
+		posting, err := e.repo.GetPostingByID(id)
+		if err != nil {
+			e.logger.Error("failed to load expiring posting %d: %s", id, err)
+			continue
+		}
+		if posting == nil {
+			continue
+		}
+		if err := e.jobs.Enqueue(JobEmailExpiring, ExpiringJob{ID: id, ExpiresOn: posting.CreatedOn.Add(e.maxAge)}); err != nil {
+			e.logger.Error("failed to queue expiry warning for posting %d: %s", id, err)
+		}
+	}
+
+	if len(ids) > 0 {
+		e.logger.Info("warned %d postings created before %s they are about to expire", len(ids), cutoff.Format(time.RFC3339))
+	}
+}
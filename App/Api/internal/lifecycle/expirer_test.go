@@ -0,0 +1,181 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"lostpets"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRepo records the cutoff each expire method was called with, only the methods used by the expirer are implemented
+type fakeRepo struct {
+	lostpets.LostPetsRepo
+	cutoffs  map[string]time.Time
+	expiring []lostpets.Posting
+	err      error
+}
+
+func (r *fakeRepo) called(method string, cutoff time.Time) (int, error) {
+	if r.cutoffs == nil {
+		r.cutoffs = map[string]time.Time{}
+	}
+	r.cutoffs[method] = cutoff
+	return 1, r.err
+}
+
+func (r *fakeRepo) ExpirePostings(createdBefore time.Time) (int, error) {
+	return r.called("ExpirePostings", createdBefore)
+}
+
+func (r *fakeRepo) ExpireSightings(createdBefore time.Time) (int, error) {
+	return r.called("ExpireSightings", createdBefore)
+}
+
+func (r *fakeRepo) ExpirePendingPostings(pendingBefore time.Time) (int, error) {
+	return r.called("ExpirePendingPostings", pendingBefore)
+}
+
+func (r *fakeRepo) ExpirePendingSightings(pendingBefore time.Time) (int, error) {
+	return r.called("ExpirePendingSightings", pendingBefore)
+}
+
+func (r *fakeRepo) WarnExpiringPostings(createdBefore time.Time) ([]int, error) {
+	r.called("WarnExpiringPostings", createdBefore)
+	ids := []int{}
+	for _, p := range r.expiring {
+		ids = append(ids, p.ID)
+	}
+	return ids, r.err
+}
+
+func (r *fakeRepo) GetPostingByID(id int) (*lostpets.Posting, error) {
+	for _, p := range r.expiring {
+		if p.ID == id {
+			return &p, nil
+		}
+	}
+	return nil, nil
+}
+
+type fakeQueue struct {
+	kinds    []string
+	payloads []interface{}
+}
+
+func (q *fakeQueue) Enqueue(kind string, payload interface{}) error {
+	q.kinds = append(q.kinds, kind)
+	q.payloads = append(q.payloads, payload)
+	return nil
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(message string, args ...interface{})                  {}
+func (nopLogger) Info(message string, args ...interface{})                   {}
+func (nopLogger) Error(message string, args ...interface{})                  {}
+func (nopLogger) UnwrapError(err error)                                      {}
+func (l nopLogger) WithFields(fields map[string]interface{}) lostpets.Logger { return l }
+
+func TestExpire(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	created := now.Add(-90 * 24 * time.Hour)
+	pending := now.Add(-48 * time.Hour)
+
+	tests := []struct {
+		name     string
+		config   Config
+		expected map[string]time.Time
+	}{
+		{
+			name:     "Should do nothing when both windows are off",
+			config:   Config{},
+			expected: map[string]time.Time{},
+		},
+		{
+			name:   "Should only expire open records with maxAge",
+			config: Config{ExpireAfterDays: 90},
+			expected: map[string]time.Time{
+				"ExpirePostings":  created,
+				"ExpireSightings": created,
+			},
+		},
+		{
+			name:   "Should only expire pending records with maxPending",
+			config: Config{VerifyWithinHours: 48},
+			expected: map[string]time.Time{
+				"ExpirePendingPostings":  pending,
+				"ExpirePendingSightings": pending,
+			},
+		},
+		{
+			name:   "Should expire both with both windows",
+			config: Config{ExpireAfterDays: 90, VerifyWithinHours: 48},
+			expected: map[string]time.Time{
+				"ExpirePostings":         created,
+				"ExpireSightings":        created,
+				"ExpirePendingPostings":  pending,
+				"ExpirePendingSightings": pending,
+			},
+		},
+		{
+			name:   "Should not warn without maxAge",
+			config: Config{VerifyWithinHours: 48, WarnDaysBefore: 7},
+			expected: map[string]time.Time{
+				"ExpirePendingPostings":  pending,
+				"ExpirePendingSightings": pending,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repo := &fakeRepo{cutoffs: map[string]time.Time{}}
+			NewExpirer(test.config, repo, &fakeQueue{}, nopLogger{}).Expire(now)
+			assert.Equal(t, test.expected, repo.cutoffs)
+		})
+	}
+}
+
+func TestExpireWarns(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	createdOn := now.Add(-85 * 24 * time.Hour)
+	repo := &fakeRepo{expiring: []lostpets.Posting{{ID: 3, CreatedOn: createdOn}}}
+	queue := &fakeQueue{}
+
+	NewExpirer(Config{ExpireAfterDays: 90, WarnDaysBefore: 7}, repo, queue, nopLogger{}).Expire(now)
+
+	assert.Equal(t, now.Add(-83*24*time.Hour), repo.cutoffs["WarnExpiringPostings"], "postings expiring within 7 days are warned")
+	assert.Equal(t, []string{JobEmailExpiring}, queue.kinds)
+	assert.Equal(t, []interface{}{ExpiringJob{ID: 3, ExpiresOn: createdOn.Add(90 * 24 * time.Hour)}}, queue.payloads)
+}
+
+func TestExpireKeepsGoingOnErrors(t *testing.T) {
+	repo := &fakeRepo{err: errors.New("connection refused")}
+	queue := &fakeQueue{}
+
+	NewExpirer(Config{ExpireAfterDays: 90, VerifyWithinHours: 48, WarnDaysBefore: 7}, repo, queue, nopLogger{}).Expire(time.Now())
+
+	assert.Len(t, repo.cutoffs, 5, "a failing step doesn't stop the others")
+	assert.Empty(t, queue.kinds)
+}
+
+func TestRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	//disabled expiry returns without touching the repo
+	repo := &fakeRepo{}
+	NewExpirer(Config{CheckIntervalMinutes: 1}, repo, &fakeQueue{}, nopLogger{}).Run(ctx)
+	assert.Empty(t, repo.cutoffs)
+
+	//otherwise records are expired straight away, then on each tick until the context is done
+	for _, config := range []Config{{ExpireAfterDays: 90}, {VerifyWithinHours: 48}} {
+		repo := &fakeRepo{}
+		NewExpirer(config, repo, &fakeQueue{}, nopLogger{}).Run(ctx)
+		require.Len(t, repo.cutoffs, 2)
+	}
+}
@@ -0,0 +1,317 @@
+package matching
+
+import (
+	domain "lostpets"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type (
+	Config struct {
+		Threshold      float64 `json:"threshold"`      // pairs scoring below this (0 - 1) are not recorded
+		DateWindowDays int     `json:"dateWindowDays"` // dates further apart then this score 0 for date proximity
+		MaxDistanceKm  float64 `json:"maxDistanceKm"`  // coordinates further apart then this score 0 for location
+	}
+
+	// Matcher scores how likely it is that a posting and a sighting are the same pet
+	Matcher struct {
+		threshold   float64
+		dateWindow  time.Duration
+		maxDistance float64
+	}
+)
+
+// score breakdown fields
+const (
+	FieldPetType  = "petType"
+	FieldBreeds   = "breeds"
+	FieldColor    = "color"
+	FieldMarks    = "marks"
+	FieldTag      = "tag"
+	FieldDate     = "date"
+	FieldLocation = "location"
+)
+
+const (
+	defaultThreshold      = 0.6
+	defaultDateWindowDays = 30
+	defaultMaxDistanceKm  = 10
+)
+
+/*
+how much each field counts towards the total. The pet type isn't weighted, a different type rules the pair out
+but most postings and sightings share a type so it says nothing about it being the same pet
+*/
+var weights = map[string]float64{
+	FieldBreeds:   0.15,
+	FieldColor:    0.15,
+	FieldMarks:    0.1,
+	FieldTag:      0.1,
+	FieldDate:     0.15,
+	FieldLocation: 0.15,
+}
+
+// words that are the same color or say nothing about it
+var colorSynonyms = map[string]string{
+	"gray":      "grey",
+	"tan":       "brown",
+	"chocolate": "brown",
+	"ginger":    "orange",
+	"red":       "orange",
+	"cream":     "white",
+	"dark":      "",
+	"light":     "",
+	"and":       "",
+	"with":      "",
+}
+
+var locationSynonyms = map[string]string{
+	"street":    "st",
+	"avenue":    "ave",
+	"road":      "rd",
+	"drive":     "dr",
+	"boulevard": "blvd",
+	"lane":      "ln",
+	"and":       "",
+	"the":       "",
+	"of":        "",
+	"at":        "",
+	"near":      "",
+	"corner":    "",
+}
+
+var stopWords = map[string]string{
+	"a":    "",
+	"an":   "",
+	"and":  "",
+	"the":  "",
+	"on":   "",
+	"of":   "",
+	"with": "",
+	"has":  "",
+	"in":   "",
+	"her":  "",
+	"his":  "",
+	"its":  "",
+}
+
+var wordRegex = regexp.MustCompile(`[a-z0-9]+`)
+
+func NewMatcher(config Config) *Matcher {
+	threshold := config.Threshold
+	if threshold <= 0 {
+		threshold = defaultThreshold
+	}
+
+	days := config.DateWindowDays
+	if days <= 0 {
+		days = defaultDateWindowDays
+	}
+
+	maxDistance := config.MaxDistanceKm
+	if maxDistance <= 0 {
+		maxDistance = defaultMaxDistanceKm
+	}
+
+	return &Matcher{
+		threshold:   threshold,
+		dateWindow:  time.Duration(days) * 24 * time.Hour,
+		maxDistance: maxDistance,
+	}
+}
+
+// IsMatch reports if the score is high enough to be recorded
+func (m *Matcher) IsMatch(score domain.MatchScore) bool {
+	return score.Total >= m.threshold
+}
+
+/*
+Score compares a posting and a sighting field by field.
+Each field both sides have scores between 0 and 1 and the total is their weighted average, fields a side
+doesn't have are left out of the breakdown and the total so missing data doesn't count for or against a match.
+Different pet types can never be the same pet so they always total 0.
+*/
+func (m *Matcher) Score(posting domain.Posting, sighting domain.Sighting) domain.MatchScore {
+	scorers := map[string]func() (float64, bool){
+		FieldPetType: func() (float64, bool) { return scorePetType(posting.Pet, sighting.Pet) },
+		FieldBreeds: func() (float64, bool) {
+			return overlap(normalizeList(posting.Pet.Breeds), normalizeList(sighting.Pet.Breeds))
+		},
+		FieldColor: func() (float64, bool) {
+			return overlap(words(posting.Pet.Color, colorSynonyms), words(sighting.Pet.Color, colorSynonyms))
+		},
+		FieldMarks: func() (float64, bool) {
+			return overlap(words(posting.Pet.Marks, stopWords), words(sighting.Pet.Marks, stopWords))
+		},
+		FieldTag:      func() (float64, bool) { return scoreTag(posting.Pet.Tag, sighting.Pet.Tag) },
+		FieldDate:     func() (float64, bool) { return m.scoreDate(posting.Date, sighting.Date) },
+		FieldLocation: func() (float64, bool) { return m.scoreLocation(posting, sighting) },
+	}
+
+	fields := map[string]float64{}
+	for field, scorer := range scorers {
+		if value, known := scorer(); known {
+			fields[field] = value
+		}
+	}
+
+	score := domain.MatchScore{Fields: fields}
+	if petType, ok := fields[FieldPetType]; ok && petType == 0 {
+		return score
+	}
+
+	total := 0.0
+	weightSum := 0.0
+	for field, weight := range weights {
+		if value, ok := fields[field]; ok {
+			total += value * weight
+			weightSum += weight
+		}
+	}
+	if weightSum > 0 {
+		score.Total = round(total / weightSum)
+	}
+
+	return score
+}
+
+// scorePetType is 1 for the same type and 0 for different ones, it isn't known if a side has no type
+func scorePetType(a, b domain.Pet) (float64, bool) {
+	if a.TypeID != 0 && b.TypeID != 0 {
+		if a.TypeID == b.TypeID {
+			return 1, true
+		}
+		return 0, true
+	}
+
+	typeA := strings.ToLower(strings.TrimSpace(a.Type))
+	typeB := strings.ToLower(strings.TrimSpace(b.Type))
+	if typeA == "" || typeB == "" {
+		return 0, false
+	}
+	if typeA == typeB {
+		return 1, true
+	}
+	return 0, true
+}
+
+// scoreTag averages the tag parts both sides know about, text that contains the other text counts as a partial match
+func scoreTag(a, b domain.Tag) (float64, bool) {
+	scores := []float64{}
+
+	for _, pair := range [][2]string{{a.Shape, b.Shape}, {a.Color, b.Color}} {
+		x := strings.ToLower(strings.TrimSpace(pair[0]))
+		y := strings.ToLower(strings.TrimSpace(pair[1]))
+		if x == "" || y == "" {
+			continue
+		}
+		if x == y {
+			scores = append(scores, 1)
+		} else {
+			scores = append(scores, 0)
+		}
+	}
+
+	textA := strings.Join(wordRegex.FindAllString(strings.ToLower(a.Text), -1), "")
+	textB := strings.Join(wordRegex.FindAllString(strings.ToLower(b.Text), -1), "")
+	if textA != "" && textB != "" {
+		switch {
+		case textA == textB:
+			scores = append(scores, 1)
+		case strings.Contains(textA, textB) || strings.Contains(textB, textA):
+			scores = append(scores, 0.75)
+		default:
+			scores = append(scores, 0)
+		}
+	}
+
+	if len(scores) == 0 {
+		return 0, false
+	}
+
+	sum := 0.0
+	for _, s := range scores {
+		sum += s
+	}
+	return sum / float64(len(scores)), true
+}
+
+// scoreDate drops from 1 for the same day to 0 at the edge of the date window
+func (m *Matcher) scoreDate(a, b time.Time) (float64, bool) {
+	if a.IsZero() || b.IsZero() {
+		return 0, false
+	}
+
+	diff := math.Abs(float64(a.Sub(b)))
+	if diff >= float64(m.dateWindow) {
+		return 0, true
+	}
+	return 1 - diff/float64(m.dateWindow), true
+}
+
+/*
+scoreLocation uses the distance between the coordinates when both sides have them, falling back to the location text.
+The distance drops from 1 when the areas (point plus accuracy) touch to 0 at the max distance.
+*/
+func (m *Matcher) scoreLocation(posting domain.Posting, sighting domain.Sighting) (float64, bool) {
+	a, b := posting.Coordinates, sighting.Coordinates
+	if a == nil || b == nil {
+		return overlap(words(posting.Location, locationSynonyms), words(sighting.Location, locationSynonyms))
+	}
+
+	distance := a.DistanceKm(*b) - a.AccuracyKm - b.AccuracyKm
+	if distance <= 0 {
+		return 1, true
+	}
+	if distance >= m.maxDistance {
+		return 0, true
+	}
+	return 1 - distance/m.maxDistance, true
+}
+
+// overlap is the jaccard index of the two sets, how much they share out of everything in both. It isn't known if a set is empty
+func overlap(a, b map[string]bool) (float64, bool) {
+	if len(a) == 0 || len(b) == 0 {
+		return 0, false
+	}
+
+	shared := 0
+	for word := range a {
+		if b[word] {
+			shared++
+		}
+	}
+
+	return float64(shared) / float64(len(a)+len(b)-shared), true
+}
+
+// words splits free text into a set of lower case words, replacing or dropping words found in synonyms
+func words(text string, synonyms map[string]string) map[string]bool {
+	set := map[string]bool{}
+	for _, word := range wordRegex.FindAllString(strings.ToLower(text), -1) {
+		if replacement, ok := synonyms[word]; ok {
+			word = replacement
+		}
+		if word != "" {
+			set[word] = true
+		}
+	}
+	return set
+}
+
+func normalizeList(list []string) map[string]bool {
+	set := map[string]bool{}
+	for _, item := range list {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item != "" {
+			set[item] = true
+		}
+	}
+	return set
+}
+
+func round(value float64) float64 {
+	return math.Round(value*1000) / 1000
+}
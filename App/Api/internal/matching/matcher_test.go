@@ -0,0 +1,171 @@
+package matching
+
+import (
+	"lostpets"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScore(t *testing.T) {
+	lost := time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	posting := lostpets.Posting{
+		Date:     lost,
+		Location: "Main Street and 5th Avenue",
+		Pet: lostpets.Pet{
+			TypeID: 1,
+			Color:  "Black and White",
+			Marks:  "white patch on the chest",
+			Breeds: []string{"Border Collie"},
+			Tag:    lostpets.Tag{Shape: "bone", Color: "red", Text: "REX 555-1234"},
+		},
+	}
+
+	type test struct {
+		name     string
+		sighting lostpets.Sighting
+		match    bool
+		fields   map[string]float64
+	}
+
+	tests := []test{
+		test{
+			name: "Should match the same pet seen nearby the next day",
+			sighting: lostpets.Sighting{Posting: lostpets.Posting{
+				Date:     lost.Add(24 * time.Hour),
+				Location: "5th ave & main st",
+				Pet: lostpets.Pet{
+					TypeID: 1,
+					Color:  "white and black",
+					Marks:  "White patch on chest",
+					Breeds: []string{"border collie"},
+					Tag:    lostpets.Tag{Shape: "Bone", Text: "555-1234"},
+				},
+			}},
+			match: true,
+		},
+		test{
+			name: "Should never match a different pet type",
+			sighting: lostpets.Sighting{Posting: lostpets.Posting{
+				Date:     lost,
+				Location: "Main Street and 5th Avenue",
+				Pet: lostpets.Pet{
+					TypeID: 2,
+					Color:  "Black and White",
+				},
+			}},
+			match: false,
+		},
+		test{
+			name: "Should not match a pet of the same type that shares nothing else",
+			sighting: lostpets.Sighting{Posting: lostpets.Posting{
+				Date:     lost.Add(60 * 24 * time.Hour),
+				Location: "Lakeshore Drive",
+				Pet: lostpets.Pet{
+					TypeID: 1,
+					Color:  "brown",
+					Marks:  "missing an ear",
+					Breeds: []string{"Poodle"},
+					Tag:    lostpets.Tag{Shape: "circle", Color: "blue", Text: "Fluffy"},
+				},
+			}},
+			match: false,
+		},
+	}
+
+	matcher := NewMatcher(Config{})
+
+	for _, test := range tests {
+		score := matcher.Score(posting, test.sighting)
+		assert.Equal(t, test.match, matcher.IsMatch(score), "%s: %v", test.name, score)
+	}
+}
+
+func TestScoreLeavesOutMissingFields(t *testing.T) {
+	lost := time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC)
+	matcher := NewMatcher(Config{})
+
+	// only the type, the day and the place are known and the places don't overlap
+	posting := lostpets.Posting{Date: lost, Location: "Main Street", Pet: lostpets.Pet{TypeID: 1}}
+	sighting := lostpets.Sighting{Posting: lostpets.Posting{Date: lost, Location: "Lakeshore Drive", Pet: lostpets.Pet{TypeID: 1}}}
+
+	score := matcher.Score(posting, sighting)
+	assert.False(t, matcher.IsMatch(score), "%v", score)
+	assert.Equal(t, map[string]float64{FieldPetType: 1, FieldDate: 1, FieldLocation: 0}, score.Fields)
+	assert.Equal(t, 0.5, score.Total)
+
+	// the same sparse pair in the same place is a match, the missing fields don't pull it down
+	sighting.Location = "main st"
+	score = matcher.Score(posting, sighting)
+	assert.True(t, matcher.IsMatch(score), "%v", score)
+	assert.Equal(t, 1.0, score.Total)
+
+	// nothing to compare isn't a match
+	assert.Equal(t, 0.0, matcher.Score(lostpets.Posting{}, lostpets.Sighting{}).Total)
+}
+
+func TestScoreTag(t *testing.T) {
+	score := func(a, b lostpets.Tag) float64 {
+		value, known := scoreTag(a, b)
+		assert.True(t, known)
+		return value
+	}
+	_, known := scoreTag(lostpets.Tag{}, lostpets.Tag{Shape: "bone"})
+	assert.False(t, known)
+	assert.Equal(t, 1.0, score(lostpets.Tag{Shape: "Bone", Text: "Rex"}, lostpets.Tag{Shape: "bone", Text: "rex"}))
+	assert.Equal(t, 0.75, score(lostpets.Tag{Text: "Rex 555-1234"}, lostpets.Tag{Text: "5551234"}))
+	assert.Equal(t, 0.5, score(lostpets.Tag{Shape: "bone", Color: "red"}, lostpets.Tag{Shape: "bone", Color: "blue"}))
+}
+
+func TestScoreLocation(t *testing.T) {
+	matcher := NewMatcher(Config{MaxDistanceKm: 10})
+	score := func(posting lostpets.Posting, sighting lostpets.Sighting) float64 {
+		value, known := matcher.scoreLocation(posting, sighting)
+		assert.True(t, known)
+		return value
+	}
+	at := func(location string, c *lostpets.Coordinates) lostpets.Posting {
+		return lostpets.Posting{Location: location, Coordinates: c}
+	}
+	seen := func(location string, c *lostpets.Coordinates) lostpets.Sighting {
+		return lostpets.Sighting{Posting: at(location, c)}
+	}
+
+	// text that shares nothing is still close on the map
+	downtown := &lostpets.Coordinates{Lat: 43.6532, Lng: -79.3832}
+	nearby := &lostpets.Coordinates{Lat: 43.6562, Lng: -79.3802}
+	assert.InDelta(t, 0.96, score(at("Main St & 5th", downtown), seen("5th and Main", nearby)), 0.01)
+
+	// 0.1 degrees of latitude is about 11km
+	far := &lostpets.Coordinates{Lat: 43.7532, Lng: -79.3832}
+	assert.Equal(t, 0.0, score(at("", downtown), seen("", far)))
+
+	// accuracy can cover the distance
+	vague := &lostpets.Coordinates{Lat: 43.7532, Lng: -79.3832, AccuracyKm: 12}
+	assert.Equal(t, 1.0, score(at("", downtown), seen("", vague)))
+
+	// falls back to the text when a side has no coordinates
+	assert.Equal(t, 1.0, score(at("Main Street", downtown), seen("main st", nil)))
+}
+
+func TestDistanceKm(t *testing.T) {
+	toronto := lostpets.Coordinates{Lat: 43.6532, Lng: -79.3832}
+	montreal := lostpets.Coordinates{Lat: 45.5017, Lng: -73.5673}
+	assert.InDelta(t, 504, toronto.DistanceKm(montreal), 2)
+	assert.Equal(t, 0.0, toronto.DistanceKm(toronto))
+}
+
+func TestOverlap(t *testing.T) {
+	score := func(a, b map[string]bool) float64 {
+		value, known := overlap(a, b)
+		assert.True(t, known)
+		return value
+	}
+	_, known := overlap(words("", colorSynonyms), words("black", colorSynonyms))
+	assert.False(t, known)
+	assert.Equal(t, 1.0, score(words("Gray and White", colorSynonyms), words("white/grey", colorSynonyms)))
+	assert.Equal(t, 2.0/3.0, score(words("Main Street", locationSynonyms), words("Main St and 5th", locationSynonyms)))
+	assert.Equal(t, 0.0, score(words("black", colorSynonyms), words("white", colorSynonyms)))
+}
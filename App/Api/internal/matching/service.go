@@ -0,0 +1,110 @@
+package matching
+
+import (
+	"fmt"
+	domain "lostpets"
+)
+
+// Service finds and records the matches for new or changed postings and sightings
+type Service struct {
+	repo    domain.LostPetsRepo
+	matcher *Matcher
+	logger  domain.StructuredLogger
+}
+
+func NewService(repo domain.LostPetsRepo, matcher *Matcher, logger domain.StructuredLogger) *Service {
+	return &Service{repo: repo, matcher: matcher, logger: logger}
+}
+
+// MatchPosting scores the open sightings against the posting and records the matches
+func (s *Service) MatchPosting(posting domain.Posting) (int, error) {
+	return s.match(domain.Sighting{Posting: posting}, true)
+}
+
+// MatchSighting scores the open postings against the sighting and records the matches
+func (s *Service) MatchSighting(sighting domain.Sighting) (int, error) {
+	return s.match(sighting, false)
+}
+
+/*
+match is the one path for both directions. Postings are wrapped as sightings so
+the candidates and the record share a type, isPosting says which side the record is on.
+Only open, visible records of the same pet type are candidates, the matcher decides on the rest.
+Returns the number of new matches recorded. Pairs that no longer clear the threshold,
+e.g. after the record was edited, are removed unless an owner has acted on them.
+*/
+func (s *Service) match(record domain.Sighting, isPosting bool) (int, error) {
+	if record.Status != domain.StatusOpen || record.HiddenOn != nil {
+		return 0, nil
+	}
+
+	filters := domain.FilterMap{
+		"status":   {{Comparator: "=", Value: string(domain.StatusOpen)}},
+		"hiddenon": {{Comparator: "is null"}},
+	}
+	if record.Pet.TypeID != 0 {
+		filters["pettypeid"] = []domain.Filter{{Comparator: "=", Value: record.Pet.TypeID}}
+	} else if record.Pet.Type != "" {
+		filters["pettype"] = []domain.Filter{{Comparator: "=", Value: record.Pet.Type}}
+	}
+
+	candidates, err := s.candidates(filters, isPosting)
+	if err != nil {
+		return 0, err
+	}
+
+	found := 0
+	stale := []domain.Match{}
+	var addErr error
+	for _, c := range candidates {
+		posting, sighting := record.Posting, c
+		if !isPosting {
+			posting, sighting = c.Posting, record
+		}
+
+		score := s.matcher.Score(posting, sighting)
+		if !s.matcher.IsMatch(score) {
+			stale = append(stale, domain.Match{PostingID: posting.ID, SightingID: sighting.ID})
+			continue
+		}
+
+		s.logger.Info("Adding Match p:%d, s:%d score:%.3f", posting.ID, sighting.ID, score.Total)
+		added, err := s.repo.AddMatch(posting.ID, sighting.ID, score)
+		if err != nil {
+			if addErr == nil {
+				addErr = fmt.Errorf("adding match p:%d s:%d: %w", posting.ID, sighting.ID, err)
+			}
+			continue
+		}
+		// only new pairs are counted, owners aren't emailed again about matches they have seen or dismissed
+		if added {
+			found++
+		}
+	}
+
+	if len(stale) > 0 {
+		if err := s.repo.RemoveStaleMatches(stale); err != nil && addErr == nil {
+			addErr = fmt.Errorf("removing stale matches: %w", err)
+		}
+	}
+
+	return found, addErr
+}
+
+// candidates loads the other side of the match, postings are returned wrapped as sightings
+func (s *Service) candidates(filters domain.FilterMap, isPosting bool) ([]domain.Sighting, error) {
+	if isPosting {
+		return s.repo.GetAllSightings(filters)
+	}
+
+	postings, err := s.repo.GetAllPostings(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped := []domain.Sighting{}
+	for _, p := range postings {
+		wrapped = append(wrapped, domain.Sighting{Posting: p})
+	}
+	return wrapped, nil
+}
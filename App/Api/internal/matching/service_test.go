@@ -0,0 +1,194 @@
+package matching
+
+import (
+	"errors"
+	"lostpets"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRepo keeps postings and sightings in memory, only the methods used by the service are implemented
+type fakeRepo struct {
+	lostpets.LostPetsRepo
+	postings  []lostpets.Posting
+	sightings []lostpets.Sighting
+	matches   map[[2]int]lostpets.MatchScore
+	dismissed map[[2]int]bool
+	addErr    error
+}
+
+func (r *fakeRepo) GetAllPostings(filters ...lostpets.FilterMap) ([]lostpets.Posting, error) {
+	result := []lostpets.Posting{}
+	for _, p := range r.postings {
+		if matchesFilters(p, filters) {
+			result = append(result, p)
+		}
+	}
+	return result, nil
+}
+
+func (r *fakeRepo) GetAllSightings(filters ...lostpets.FilterMap) ([]lostpets.Sighting, error) {
+	result := []lostpets.Sighting{}
+	for _, s := range r.sightings {
+		if matchesFilters(s.Posting, filters) {
+			result = append(result, s)
+		}
+	}
+	return result, nil
+}
+
+func (r *fakeRepo) AddMatch(pID int, sID int, score lostpets.MatchScore) (bool, error) {
+	if r.addErr != nil {
+		return false, r.addErr
+	}
+	if r.dismissed[[2]int{pID, sID}] {
+		return false, nil
+	}
+	_, existed := r.matches[[2]int{pID, sID}]
+	r.matches[[2]int{pID, sID}] = score
+	return !existed, nil
+}
+
+func (r *fakeRepo) RemoveStaleMatches(stale []lostpets.Match) error {
+	for _, m := range stale {
+		if !r.dismissed[[2]int{m.PostingID, m.SightingID}] {
+			delete(r.matches, [2]int{m.PostingID, m.SightingID})
+		}
+	}
+	return nil
+}
+
+// matchesFilters supports the status and pettypeid equals and hiddenon is null filters the service uses
+func matchesFilters(p lostpets.Posting, filters []lostpets.FilterMap) bool {
+	for _, f := range filters {
+		for _, s := range f["status"] {
+			if string(p.Status) != s.Value {
+				return false
+			}
+		}
+		for _, t := range f["pettypeid"] {
+			if p.Pet.TypeID != t.Value {
+				return false
+			}
+		}
+		if _, ok := f["hiddenon"]; ok && p.HiddenOn != nil {
+			return false
+		}
+	}
+	return true
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(message string, args ...interface{})                  {}
+func (nopLogger) Info(message string, args ...interface{})                   {}
+func (nopLogger) Error(message string, args ...interface{})                  {}
+func (nopLogger) UnwrapError(err error)                                      {}
+func (l nopLogger) WithFields(fields map[string]interface{}) lostpets.Logger { return l }
+
+func newTestRepo() *fakeRepo {
+	seen := time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC)
+	dog := lostpets.Pet{TypeID: 1, Color: "black", Breeds: []string{"lab"}}
+
+	return &fakeRepo{
+		matches: map[[2]int]lostpets.MatchScore{},
+		postings: []lostpets.Posting{
+			{ID: 1, Status: lostpets.StatusOpen, Date: seen, Location: "Main St", Pet: dog},
+			{ID: 2, Status: lostpets.StatusReunited, Date: seen, Location: "Main St", Pet: dog},
+			{ID: 3, Status: lostpets.StatusOpen, Date: seen, Location: "Main St", Pet: lostpets.Pet{TypeID: 2, Color: "black"}},
+		},
+		sightings: []lostpets.Sighting{
+			{Posting: lostpets.Posting{ID: 10, Status: lostpets.StatusOpen, Date: seen, Location: "Main Street", Pet: dog}},
+			{Posting: lostpets.Posting{ID: 11, Status: lostpets.StatusClosed, Date: seen, Location: "Main St", Pet: dog}},
+			{Posting: lostpets.Posting{ID: 12, Status: lostpets.StatusOpen, Date: seen, Location: "Main St", Pet: lostpets.Pet{TypeID: 2}}},
+		},
+	}
+}
+
+func TestMatchBothDirections(t *testing.T) {
+	repo := newTestRepo()
+	service := NewService(repo, NewMatcher(Config{}), nopLogger{})
+
+	found, err := service.MatchPosting(repo.postings[0])
+	assert.NoError(t, err)
+	assert.Equal(t, 1, found)
+	assert.Contains(t, repo.matches, [2]int{1, 10})
+
+	//same pair from the other side records the same score
+	fromPosting := repo.matches[[2]int{1, 10}]
+	repo.matches = map[[2]int]lostpets.MatchScore{}
+
+	found, err = service.MatchSighting(repo.sightings[0])
+	assert.NoError(t, err)
+	assert.Equal(t, 1, found)
+	assert.Equal(t, fromPosting, repo.matches[[2]int{1, 10}])
+}
+
+func TestMatchSkipsHiddenRecords(t *testing.T) {
+	repo := newTestRepo()
+	service := NewService(repo, NewMatcher(Config{}), nopLogger{})
+	hidden := time.Now()
+	repo.sightings[0].HiddenOn = &hidden
+
+	found, err := service.MatchPosting(repo.postings[0])
+	assert.NoError(t, err)
+	assert.Equal(t, 0, found)
+
+	found, err = service.MatchSighting(repo.sightings[0])
+	assert.NoError(t, err)
+	assert.Equal(t, 0, found)
+}
+
+func TestMatchSkipsClosedRecords(t *testing.T) {
+	repo := newTestRepo()
+	service := NewService(repo, NewMatcher(Config{}), nopLogger{})
+
+	found, err := service.MatchPosting(repo.postings[1])
+	assert.NoError(t, err)
+	assert.Equal(t, 0, found)
+
+	found, err = service.MatchSighting(repo.sightings[1])
+	assert.NoError(t, err)
+	assert.Equal(t, 0, found)
+	assert.Empty(t, repo.matches)
+}
+
+func TestMatchDoesNotCountDismissedMatches(t *testing.T) {
+	repo := newTestRepo()
+	repo.dismissed = map[[2]int]bool{{1, 10}: true}
+	service := NewService(repo, NewMatcher(Config{}), nopLogger{})
+
+	found, err := service.MatchPosting(repo.postings[0])
+	assert.NoError(t, err)
+	assert.Equal(t, 0, found)
+	assert.Empty(t, repo.matches)
+}
+
+func TestMatchRemovesStaleMatches(t *testing.T) {
+	repo := newTestRepo()
+	repo.matches[[2]int{1, 10}] = lostpets.MatchScore{Total: 0.9}
+	service := NewService(repo, NewMatcher(Config{}), nopLogger{})
+
+	//the owner corrected the posting, it no longer looks like the sighting
+	edited := repo.postings[0]
+	edited.Pet = lostpets.Pet{TypeID: 1, Color: "white", Breeds: []string{"poodle"}}
+	edited.Location = "Harbour Rd"
+	edited.Date = edited.Date.Add(60 * 24 * time.Hour)
+
+	found, err := service.MatchPosting(edited)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, found)
+	assert.Empty(t, repo.matches)
+}
+
+func TestMatchReturnsAddErrors(t *testing.T) {
+	repo := newTestRepo()
+	repo.addErr = errors.New("db down")
+	service := NewService(repo, NewMatcher(Config{}), nopLogger{})
+
+	found, err := service.MatchPosting(repo.postings[0])
+	assert.ErrorIs(t, err, repo.addErr)
+	assert.Equal(t, 0, found)
+}
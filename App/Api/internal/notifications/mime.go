@@ -0,0 +1,45 @@
+package notifications
+
+import (
+	"bytes"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+)
+
+const multipartContentType = "multipart/alternative; boundary="
+
+// MIME is the content type and body to send the message with, multipart/alternative when it has an html part
+func (m Message) MIME() (string, string, error) {
+	if m.HTML == "" {
+		return defaultContentType, m.Text, nil
+	}
+
+	buf := new(bytes.Buffer)
+	writer := multipart.NewWriter(buf)
+	//mail clients show the last part they can, so the html goes after the text
+	for _, part := range []struct{ contentType, body string }{
+		{defaultContentType, m.Text},
+		{"text/html; charset=UTF-8", m.HTML},
+	} {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", part.contentType)
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+		w, err := writer.CreatePart(header)
+		if err != nil {
+			return "", "", err
+		}
+
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.body)); err != nil {
+			return "", "", err
+		}
+		if err := qp.Close(); err != nil {
+			return "", "", err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return "", "", err
+	}
+	return multipartContentType + writer.Boundary(), buf.String(), nil
+}
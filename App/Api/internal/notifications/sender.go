@@ -0,0 +1,242 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	domain "lostpets"
+	"math"
+	"sync"
+	"time"
+)
+
+type (
+	Config struct {
+		PollIntervalSeconds int `json:"pollIntervalSeconds"` // how long the sender waits before looking for notifications again when the outbox is empty
+		MaxAttempts         int `json:"maxAttempts"`         // attempts before a notification is marked failed
+		BackoffSeconds      int `json:"backoffSeconds"`      // wait before the first retry, doubled for each retry after
+		LeaseSeconds        int `json:"leaseSeconds"`        // a notification being sent is picked up again if sending takes longer then this
+		PerRecipientPerHour int `json:"perRecipientPerHour"` // most notifications sent to one address in an hour, the rest wait, 0 for no limit
+
+		Webhook WebhookConfig `json:"webhook"`
+		SMS     SMSConfig     `json:"sms"`
+	}
+
+	// Sender is the outbox, notifications are stored when enqueued and sent one at a time by its worker
+	Sender struct {
+		repo         domain.NotificationRepo
+		notifiers    map[domain.Channel]domain.Notifier
+		logger       domain.StructuredLogger
+		interval     time.Duration
+		maxAttempts  int
+		backoff      time.Duration
+		lease        time.Duration
+		perRecipient int
+		now          func() time.Time
+
+		stop context.CancelFunc
+		wg   sync.WaitGroup
+	}
+)
+
+const (
+	defaultInterval    = 5 * time.Second
+	defaultMaxAttempts = 5
+	defaultBackoff     = time.Minute
+	defaultLease       = 2 * time.Minute
+	maxBackoff         = 6 * time.Hour
+
+	// rateWindow is the period PerRecipientPerHour is counted over
+	rateWindow = time.Hour
+)
+
+// NewSender creates the outbox, notifications for a channel without a notifier are marked failed
+func NewSender(config Config, repo domain.NotificationRepo, notifiers map[domain.Channel]domain.Notifier, logger domain.StructuredLogger) *Sender {
+	sender := &Sender{
+		repo:         repo,
+		notifiers:    notifiers,
+		logger:       logger,
+		interval:     time.Duration(config.PollIntervalSeconds) * time.Second,
+		maxAttempts:  config.MaxAttempts,
+		backoff:      time.Duration(config.BackoffSeconds) * time.Second,
+		lease:        time.Duration(config.LeaseSeconds) * time.Second,
+		perRecipient: config.PerRecipientPerHour,
+		now:          time.Now,
+	}
+
+	if sender.interval <= 0 {
+		sender.interval = defaultInterval
+	}
+	if sender.maxAttempts <= 0 {
+		sender.maxAttempts = defaultMaxAttempts
+	}
+	if sender.backoff <= 0 {
+		sender.backoff = defaultBackoff
+	}
+	if sender.lease <= 0 {
+		sender.lease = defaultLease
+	}
+
+	return sender
+}
+
+/*
+Notifiers has a notifier for each configured channel, email is always on and webhooks and SMS need their config.
+An email is given at most half the lease, so it has failed well before another worker could pick it up and send it twice.
+*/
+func Notifiers(config Config, smtp SMTPConfig) (map[domain.Channel]domain.Notifier, error) {
+	lease := time.Duration(config.LeaseSeconds) * time.Second
+	if lease <= 0 {
+		lease = defaultLease
+	}
+	email, err := NewSMTPNotifier(smtp)
+	if err != nil {
+		return nil, err
+	}
+	if email.timeout > lease/2 {
+		email.timeout = lease / 2
+	}
+
+	notifiers := map[domain.Channel]domain.Notifier{domain.ChannelEmail: email}
+	if config.Webhook.Enabled {
+		notifiers[domain.ChannelWebhook] = NewWebhookNotifier(config.Webhook)
+	}
+	if config.SMS.URL != "" {
+		notifiers[domain.ChannelSMS] = NewSMSNotifier(config.SMS)
+	}
+	return notifiers, nil
+}
+
+// Enqueue stores the notification as pending, the worker sends it as soon as it is due
+func (s *Sender) Enqueue(notification *domain.Notification) error {
+	notification.MaxAttempts = s.maxAttempts
+	return s.repo.AddNotification(notification)
+}
+
+// Start starts the worker, it keeps running until Shutdown is called
+func (s *Sender) Start() {
+	ctx, stop := context.WithCancel(context.Background())
+	s.stop = stop
+
+	s.wg.Add(1)
+	go s.work(ctx)
+}
+
+/*
+Shutdown stops the worker from claiming notifications and waits for the one being sent.
+If ctx is done first that notification is sent again once its lease runs out.
+*/
+func (s *Sender) Shutdown(ctx context.Context) error {
+	if s.stop != nil {
+		s.stop()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Sender) work(ctx context.Context) {
+	defer s.wg.Done()
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		notification, err := s.repo.ClaimNotification(s.lease)
+		if err != nil {
+			s.logger.Error("failed to claim notification: %s", err)
+		}
+
+		if notification != nil {
+			s.send(notification)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.interval):
+		}
+	}
+}
+
+/*
+send delivers the notification through its channel's notifier and records the outcome. A recipient who has had
+their share for the hour has the notification put back for later without using up an attempt, failed sends are
+retried with backoff until they run out of attempts and are marked failed.
+*/
+func (s *Sender) send(n *domain.Notification) {
+	logger := s.logger.WithFields(map[string]interface{}{"notification": n.ID, "kind": n.Kind, "channel": n.Channel, "attempt": n.Attempts})
+	now := s.now()
+
+	notifier, ok := s.notifiers[n.Channel]
+	if !ok {
+		//retrying won't help, the channel isn't configured
+		n.Attempts = n.MaxAttempts
+		s.retry(logger, n, fmt.Errorf("no notifier for channel %q", n.Channel))
+		return
+	}
+
+	if s.perRecipient > 0 {
+		sent, err := s.repo.CountSentTo(n.Recipient, now.Add(-rateWindow))
+		if err != nil {
+			s.retry(logger, n, fmt.Errorf("counting sent notifications: %w", err))
+			return
+		}
+		if sent >= s.perRecipient {
+			runAt := now.Add(rateWindow / time.Duration(s.perRecipient))
+			logger.Info("recipient over the rate limit, notification deferred to %s", runAt.Format(time.RFC3339))
+			if err := s.repo.DeferNotification(n.ID, runAt); err != nil {
+				logger.Error("failed to defer notification: %s", err)
+			}
+			return
+		}
+	}
+
+	if err := notifier.Notify(*n); err != nil {
+		s.retry(logger, n, err)
+		return
+	}
+
+	if err := s.repo.MarkNotificationSent(n.ID, s.now()); err != nil {
+		logger.Error("failed to mark notification sent: %s", err)
+	}
+}
+
+func (s *Sender) retry(logger domain.Logger, n *domain.Notification, err error) {
+	if n.Attempts >= n.MaxAttempts {
+		logger.Error("notification failed: %s", err)
+		if err := s.repo.FailNotification(n.ID, err.Error()); err != nil {
+			logger.Error("failed to mark notification failed: %s", err)
+		}
+		return
+	}
+
+	runAt := s.now().Add(backoff(s.backoff, n.Attempts))
+	logger.Info("notification failed, retrying at %s: %s", runAt.Format(time.RFC3339), err)
+	if err := s.repo.RetryNotification(n.ID, runAt, err.Error()); err != nil {
+		logger.Error("failed to retry notification: %s", err)
+	}
+}
+
+// backoff doubles the base wait for each attempt already made, capped at maxBackoff
+func backoff(base time.Duration, attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	wait := float64(base) * math.Pow(2, float64(attempts-1))
+	if wait > float64(maxBackoff) {
+		return maxBackoff
+	}
+	return time.Duration(wait)
+}
@@ -0,0 +1,136 @@
+package notifications
+
+import (
+	"errors"
+	"lostpets"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRepo records what happened to each notification, sentTo is what CountSentTo returns
+type fakeRepo struct {
+	lostpets.NotificationRepo
+	added    []lostpets.Notification
+	sent     []int
+	retried  map[int]time.Time
+	deferred map[int]time.Time
+	failed   map[int]string
+	sentTo   int
+}
+
+func newFakeRepo() *fakeRepo {
+	return &fakeRepo{retried: map[int]time.Time{}, deferred: map[int]time.Time{}, failed: map[int]string{}}
+}
+
+func (r *fakeRepo) AddNotification(n *lostpets.Notification) error {
+	n.ID = len(r.added) + 1
+	r.added = append(r.added, *n)
+	return nil
+}
+
+func (r *fakeRepo) MarkNotificationSent(id int, sentOn time.Time) error {
+	r.sent = append(r.sent, id)
+	return nil
+}
+
+func (r *fakeRepo) RetryNotification(id int, runAt time.Time, lastError string) error {
+	r.retried[id] = runAt
+	return nil
+}
+
+func (r *fakeRepo) DeferNotification(id int, runAt time.Time) error {
+	r.deferred[id] = runAt
+	return nil
+}
+
+func (r *fakeRepo) FailNotification(id int, lastError string) error {
+	r.failed[id] = lastError
+	return nil
+}
+
+func (r *fakeRepo) CountSentTo(recipient string, since time.Time) (int, error) {
+	return r.sentTo, nil
+}
+
+type fakeNotifier struct {
+	sent []lostpets.Notification
+	err  error
+}
+
+func (m *fakeNotifier) Notify(n lostpets.Notification) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.sent = append(m.sent, n)
+	return nil
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(message string, args ...interface{})                  {}
+func (nopLogger) Info(message string, args ...interface{})                   {}
+func (nopLogger) Error(message string, args ...interface{})                  {}
+func (nopLogger) UnwrapError(err error)                                      {}
+func (l nopLogger) WithFields(fields map[string]interface{}) lostpets.Logger { return l }
+
+func newTestSender(config Config) (*Sender, *fakeRepo, *fakeNotifier, time.Time) {
+	repo := newFakeRepo()
+	mailer := &fakeNotifier{}
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	sender := NewSender(config, repo, map[lostpets.Channel]lostpets.Notifier{lostpets.ChannelEmail: mailer}, nopLogger{})
+	sender.now = func() time.Time { return now }
+	return sender, repo, mailer, now
+}
+
+func TestEnqueueSetsMaxAttempts(t *testing.T) {
+	sender, repo, _, _ := newTestSender(Config{MaxAttempts: 3})
+
+	require.NoError(t, sender.Enqueue(&lostpets.Notification{Recipient: "owner@example.org"}))
+	assert.Equal(t, 3, repo.added[0].MaxAttempts)
+}
+
+func TestSendMarksSent(t *testing.T) {
+	sender, repo, mailer, _ := newTestSender(Config{})
+
+	sender.send(&lostpets.Notification{ID: 1, Channel: lostpets.ChannelEmail, Recipient: "owner@example.org", Attempts: 1, MaxAttempts: 5})
+	assert.Len(t, mailer.sent, 1)
+	assert.Equal(t, []int{1}, repo.sent)
+}
+
+func TestSendRetriesThenFails(t *testing.T) {
+	sender, repo, mailer, now := newTestSender(Config{BackoffSeconds: 60})
+	mailer.err = errors.New("connection refused")
+
+	sender.send(&lostpets.Notification{ID: 1, Channel: lostpets.ChannelEmail, Attempts: 2, MaxAttempts: 5})
+	assert.Equal(t, now.Add(2*time.Minute), repo.retried[1])
+
+	sender.send(&lostpets.Notification{ID: 2, Channel: lostpets.ChannelEmail, Attempts: 5, MaxAttempts: 5})
+	assert.Equal(t, "connection refused", repo.failed[2])
+	assert.NotContains(t, repo.retried, 2)
+	assert.Empty(t, repo.sent)
+}
+
+func TestSendDefersOverRateLimit(t *testing.T) {
+	sender, repo, mailer, now := newTestSender(Config{PerRecipientPerHour: 4})
+	repo.sentTo = 4
+
+	sender.send(&lostpets.Notification{ID: 1, Channel: lostpets.ChannelEmail, Recipient: "owner@example.org", Attempts: 1, MaxAttempts: 5})
+	assert.Empty(t, mailer.sent)
+	assert.Equal(t, now.Add(15*time.Minute), repo.deferred[1])
+
+	repo.sentTo = 3
+	sender.send(&lostpets.Notification{ID: 2, Channel: lostpets.ChannelEmail, Recipient: "owner@example.org", Attempts: 1, MaxAttempts: 5})
+	assert.Len(t, mailer.sent, 1)
+}
+
+func TestSendFailsWithoutNotifier(t *testing.T) {
+	sender, repo, mailer, _ := newTestSender(Config{})
+
+	sender.send(&lostpets.Notification{ID: 1, Channel: lostpets.ChannelSMS, Recipient: "+15551234567", Attempts: 1, MaxAttempts: 5})
+	assert.Empty(t, mailer.sent)
+	assert.Equal(t, `no notifier for channel "sms"`, repo.failed[1])
+	assert.Empty(t, repo.retried)
+}
@@ -0,0 +1,73 @@
+package notifications
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	domain "lostpets"
+	"net/http"
+	"time"
+)
+
+type (
+	/*
+		SMSConfig points at an HTTP SMS gateway, messages are posted as JSON {"to", "from", "text"} with the api key
+		as a bearer token. SMS is off without a URL, cmd/sms-stub stands in for a gateway locally.
+	*/
+	SMSConfig struct {
+		URL            string `json:"url"`
+		APIKey         string `json:"apiKey" secret:"true"`
+		From           string `json:"from"`
+		TimeoutSeconds int    `json:"timeoutSeconds"`
+	}
+
+	// SMSNotifier sends notifications as text messages through the gateway
+	SMSNotifier struct {
+		config SMSConfig
+		client *http.Client
+	}
+
+	smsMessage struct {
+		To   string `json:"to"`
+		From string `json:"from"`
+		Text string `json:"text"`
+	}
+)
+
+const defaultSMSTimeout = 10 * time.Second
+
+func NewSMSNotifier(config SMSConfig) *SMSNotifier {
+	timeout := time.Duration(config.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultSMSTimeout
+	}
+	return &SMSNotifier{config: config, client: &http.Client{Timeout: timeout}}
+}
+
+// Notify sends the notification body to the recipient's phone number
+func (s *SMSNotifier) Notify(n domain.Notification) error {
+	body, err := json.Marshal(smsMessage{To: n.Recipient, From: s.config.From, Text: n.Body})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.config.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+s.config.APIKey)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("sms gateway returned %s", res.Status)
+	}
+	return nil
+}
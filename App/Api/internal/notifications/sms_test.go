@@ -0,0 +1,33 @@
+package notifications
+
+import (
+	"encoding/json"
+	"lostpets"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSMSGoesToGateway(t *testing.T) {
+	var auth string
+	message := smsMessage{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&message)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	sms := NewSMSNotifier(SMSConfig{URL: server.URL, APIKey: "key", From: "LostPets"})
+	require.NoError(t, sms.Notify(lostpets.Notification{Channel: lostpets.ChannelSMS, Recipient: "+15551234567", Body: "Matches found"}))
+	assert.Equal(t, "Bearer key", auth)
+	assert.Equal(t, smsMessage{To: "+15551234567", From: "LostPets", Text: "Matches found"}, message)
+
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	assert.EqualError(t, sms.Notify(lostpets.Notification{Recipient: "+15551234567"}), "sms gateway returned 502 Bad Gateway")
+}
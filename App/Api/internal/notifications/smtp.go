@@ -0,0 +1,156 @@
+package notifications
+
+import (
+	"crypto/tls"
+	"fmt"
+	domain "lostpets"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	SMTPConfig struct {
+		Host     string `json:"host"`
+		Port     int    `json:"port"`
+		User     string `json:"user"`
+		Password string `json:"password" secret:"true"`
+		// From is the address emails are sent from, defaults to User
+		From string `json:"from"`
+		// TimeoutSeconds is how long sending one email can take, from connecting to the server until it has the message
+		TimeoutSeconds int `json:"timeoutSeconds"`
+	}
+
+	// SMTPNotifier sends email notifications through an SMTP server
+	SMTPNotifier struct {
+		config SMTPConfig
+		// from is the parsed sender, the server is given only its address and the From header has the name too
+		from    *mail.Address
+		timeout time.Duration
+		now     func() time.Time
+	}
+)
+
+const (
+	defaultContentType = "text/plain; charset=UTF-8"
+	defaultSMTPTimeout = 30 * time.Second
+)
+
+// NewSMTPNotifier returns an error if the from address, or the user when from isn't set, isn't an email address
+func NewSMTPNotifier(config SMTPConfig) (*SMTPNotifier, error) {
+	timeout := time.Duration(config.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultSMTPTimeout
+	}
+
+	from := &mail.Address{}
+	if config.From != "" || config.User != "" {
+		raw := config.From
+		if raw == "" {
+			raw = config.User
+		}
+		address, err := mail.ParseAddress(raw)
+		if err != nil {
+			return nil, fmt.Errorf("email from address %q: %w", raw, err)
+		}
+		from = address
+	}
+	return &SMTPNotifier{config: config, from: from, timeout: timeout, now: time.Now}, nil
+}
+
+/*
+Notify sends the notification with the headers mail clients need to show it and reply to it.
+It does what smtp.SendMail does with a deadline over the whole conversation, so a server that stops answering
+fails the send instead of holding the sender past its lease.
+*/
+func (m *SMTPNotifier) Notify(n domain.Notification) error {
+	conn, err := (&net.Dialer{Timeout: m.timeout}).Dial("tcp", net.JoinHostPort(m.config.Host, strconv.Itoa(m.config.Port)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(m.now().Add(m.timeout)); err != nil {
+		return err
+	}
+
+	client, err := smtp.NewClient(conn, m.config.Host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: m.config.Host}); err != nil {
+			return err
+		}
+	}
+	if ok, _ := client.Extension("AUTH"); ok && m.config.User != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.config.User, m.config.Password, m.config.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(m.from.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(n.Recipient); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(m.message(n)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// message is the notification as an email, headers and body
+func (m *SMTPNotifier) message(n domain.Notification) []byte {
+	contentType := n.ContentType
+	if contentType == "" {
+		contentType = defaultContentType
+	}
+
+	headers := []string{
+		"From: " + m.from.String(),
+		"To: " + headerValue(n.Recipient),
+	}
+	if n.ReplyTo != "" {
+		headers = append(headers, "Reply-To: "+headerValue(n.ReplyTo))
+	}
+	headers = append(headers,
+		"Subject: "+mime.QEncoding.Encode("utf-8", headerValue(n.Subject)),
+		"Date: "+m.now().Format(time.RFC1123Z),
+		"Message-ID: "+m.messageID(n),
+		"MIME-Version: 1.0",
+		"Content-Type: "+headerValue(contentType),
+	)
+	//multipart bodies give each part its own encoding
+	if !strings.HasPrefix(contentType, "multipart/") {
+		headers = append(headers, "Content-Transfer-Encoding: 8bit")
+	}
+	return []byte(strings.Join(headers, "\r\n") + "\r\n\r\n" + n.Body)
+}
+
+// messageID is unique to the notification, on the sender's domain as mail servers expect
+func (m *SMTPNotifier) messageID(n domain.Notification) string {
+	host := "localhost"
+	if i := strings.LastIndex(m.from.Address, "@"); i >= 0 {
+		host = m.from.Address[i+1:]
+	}
+	return fmt.Sprintf("<lostpets.%d.%d@%s>", n.ID, m.now().UnixNano(), host)
+}
+
+// headerValue drops line breaks so a value can't add headers of its own
+func headerValue(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
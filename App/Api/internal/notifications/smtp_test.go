@@ -0,0 +1,155 @@
+package notifications
+
+import (
+	"bufio"
+	"lostpets"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSMTPHeaders(t *testing.T) {
+	notifier, err := NewSMTPNotifier(SMTPConfig{From: "Lost Pets <noreply@lostpets.example>"})
+	require.NoError(t, err)
+	notifier.now = func() time.Time { return time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC) }
+
+	msg := string(notifier.message(lostpets.Notification{
+		ID:        3,
+		Recipient: "owner@example.org",
+		Subject:   "Correspondances trouvées\r\nBcc: someone@example.org",
+		Body:      "body",
+	}))
+	headers := strings.Split(strings.SplitN(msg, "\r\n\r\n", 2)[0], "\r\n")
+
+	assert.Contains(t, headers, `From: "Lost Pets" <noreply@lostpets.example>`)
+	assert.Contains(t, headers, "Subject: =?utf-8?q?Correspondances_trouv=C3=A9esBcc:_someone@example.org?=")
+	assert.Contains(t, headers, "Date: Sun, 18 Oct 2026 12:00:00 +0000")
+	assert.Contains(t, headers, "Message-ID: <lostpets.3.1792324800000000000@lostpets.example>")
+	assert.Contains(t, headers, "Content-Transfer-Encoding: 8bit")
+	assert.True(t, strings.HasSuffix(msg, "\r\n\r\nbody"))
+
+	//multipart bodies declare the encoding in each part
+	msg = string(notifier.message(lostpets.Notification{Recipient: "owner@example.org", ContentType: "multipart/alternative; boundary=abc"}))
+	assert.NotContains(t, msg, "Content-Transfer-Encoding")
+}
+
+// smtpServer answers each SMTP connection with serve, it returns the config to reach it
+func smtpServer(t *testing.T, serve func(conn net.Conn)) SMTPConfig {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				serve(conn)
+			}()
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	return SMTPConfig{Host: addr.IP.String(), Port: addr.Port, From: "noreply@lostpets.example"}
+}
+
+func TestSMTPFromMustBeAnAddress(t *testing.T) {
+	_, err := NewSMTPNotifier(SMTPConfig{From: "Lost Pets noreply@lostpets.example"})
+	assert.Error(t, err)
+	_, err = NewSMTPNotifier(SMTPConfig{User: "apikey"})
+	assert.Error(t, err, "the user is the sender when from isn't set")
+
+	notifier, err := NewSMTPNotifier(SMTPConfig{User: "noreply@lostpets.example"})
+	require.NoError(t, err)
+	assert.Equal(t, "noreply@lostpets.example", notifier.from.Address)
+}
+
+func TestSMTPSend(t *testing.T) {
+	mailFrom := make(chan string, 1)
+	received := make(chan string, 1)
+	config := smtpServer(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		reply("220 localhost ready")
+		data := false
+		body := []string{}
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			if data {
+				if line == "." {
+					data = false
+					received <- strings.Join(body, "\n")
+					reply("250 queued")
+				} else {
+					body = append(body, line)
+				}
+				continue
+			}
+			switch strings.ToUpper(strings.SplitN(line, " ", 2)[0]) {
+			case "MAIL":
+				mailFrom <- line
+				reply("250 ok")
+			case "DATA":
+				data = true
+				reply("354 go ahead")
+			case "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	})
+
+	config.From = "Lost Pets <noreply@lostpets.example>"
+
+	notifier, err := NewSMTPNotifier(config)
+	require.NoError(t, err)
+	require.NoError(t, notifier.Notify(lostpets.Notification{Recipient: "owner@example.org", Subject: "Matches", Body: "body"}))
+	assert.Equal(t, "MAIL FROM:<noreply@lostpets.example>", <-mailFrom, "the server is only given the address")
+	message := <-received
+	assert.Contains(t, message, "To: owner@example.org")
+	assert.True(t, strings.HasSuffix(message, "\n\nbody"))
+}
+
+func TestSMTPTimesOut(t *testing.T) {
+	//a server that accepts the connection and never answers
+	config := smtpServer(t, func(conn net.Conn) {
+		conn.Read(make([]byte, 1))
+	})
+	config.TimeoutSeconds = 1
+
+	notifier, err := NewSMTPNotifier(config)
+	require.NoError(t, err)
+	start := time.Now()
+	err = notifier.Notify(lostpets.Notification{Recipient: "owner@example.org"})
+	require.Error(t, err)
+	var netErr net.Error
+	require.ErrorAs(t, err, &netErr)
+	assert.True(t, netErr.Timeout())
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestSMTPTimeoutIsBelowTheLease(t *testing.T) {
+	email := func(config Config, smtp SMTPConfig) *SMTPNotifier {
+		notifiers, err := Notifiers(config, smtp)
+		require.NoError(t, err)
+		return notifiers[lostpets.ChannelEmail].(*SMTPNotifier)
+	}
+
+	assert.Equal(t, defaultSMTPTimeout, email(Config{}, SMTPConfig{}).timeout)
+	assert.Equal(t, 10*time.Second, email(Config{}, SMTPConfig{TimeoutSeconds: 10}).timeout)
+	assert.Equal(t, 15*time.Second, email(Config{LeaseSeconds: 30}, SMTPConfig{TimeoutSeconds: 60}).timeout)
+	assert.Equal(t, 15*time.Second, email(Config{LeaseSeconds: 30}, SMTPConfig{}).timeout)
+}
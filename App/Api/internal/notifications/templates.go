@@ -0,0 +1,264 @@
+package notifications
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+type (
+	// Templates parses each template file once and keeps it for every email after
+	Templates struct {
+		mu     sync.Mutex
+		parsed map[string]executor
+	}
+
+	// executor is a parsed text or html template
+	executor interface {
+		Execute(w io.Writer, data interface{}) error
+	}
+
+	// Event is what an email is about, each event has its own templates
+	Event string
+
+	/*
+		Registry renders the emails for an event in the recipient's locale. Templates are read from
+		<dir>/<locale>/<event>.subject, <event>.txt and <event>.html, a locale without them falls back to its
+		language ("fr" for "fr-CA") and then the default locale. The html part is optional, a missing subject
+		uses the built in one and a missing text part uses the Default template.
+	*/
+	Registry struct {
+		templates     *Templates
+		dir           string
+		defaultLocale string
+		defaultText   *template.Template
+	}
+
+	// Message is a rendered email, HTML is empty when the event has no html template
+	Message struct {
+		Subject string
+		Text    string
+		HTML    string
+	}
+
+	// MatchData is what the new-match templates are rendered with, every event's data has a Link
+	MatchData struct {
+		Type string
+		Link string
+	}
+
+	// MessageData is a message from the owner of the Other record about the recipient's Target
+	MessageData struct {
+		Target  string
+		Other   string
+		Message string
+		Link    string
+	}
+
+	// ExpiringData is for a posting or sighting about to be expired
+	ExpiringData struct {
+		Type      string
+		Link      string
+		ExpiresOn time.Time
+	}
+
+	// VerificationData is for the link that confirms an owner's email address
+	VerificationData struct {
+		Type      string
+		Link      string
+		ExpiresOn time.Time
+	}
+
+	event struct {
+		subject string
+		sample  interface{}
+	}
+)
+
+const (
+	EventNewMatch        Event = "new-match"
+	EventNewMessage      Event = "new-message"
+	EventPostingExpiring Event = "posting-expiring"
+	EventVerification    Event = "verification"
+
+	defaultLocale = "en"
+	// used when the config has no Default template
+	defaultTextTemplate = "{{.Link}}\n"
+)
+
+// events are the emails the registry knows, the sample data is what previews are rendered with
+var events = map[Event]event{
+	EventNewMatch: {
+		subject: "Matches have been found for your lost pets post",
+		sample:  MatchData{Type: "Postings", Link: "http://localhost:4200/postings/private/sample-guid"},
+	},
+	EventNewMessage: {
+		subject: "A new message about your {{.Target}}",
+		sample: MessageData{Target: "posting", Other: "sighting", Message: "I think I saw your cat near the park.",
+			Link: "http://localhost:4200/postings/private/sample-guid"},
+	},
+	EventPostingExpiring: {
+		subject: "Your lost pets post is about to expire",
+		sample: ExpiringData{Type: "posting", Link: "http://localhost:4200/postings/private/sample-guid",
+			ExpiresOn: time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+	},
+	EventVerification: {
+		subject: "Confirm your email address to publish your lost pets post",
+		sample: VerificationData{Type: "posting", Link: "http://localhost:8080/verify/posting.1.sample-signature",
+			ExpiresOn: time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC)},
+	},
+}
+
+var errNoTemplate = errors.New("no template")
+
+func NewTemplates() *Templates {
+	return &Templates{parsed: map[string]executor{}}
+}
+
+// get returns the parsed template for the file, .html files are html templates. A file that fails to parse is tried again on the next call
+func (t *Templates) get(path string) (executor, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if parsed, ok := t.parsed[path]; ok {
+		return parsed, nil
+	}
+
+	var parsed executor
+	var err error
+	if filepath.Ext(path) == ".html" {
+		parsed, err = htmltemplate.ParseFiles(path)
+	} else {
+		parsed, err = template.ParseFiles(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.parsed[path] = parsed
+	return parsed, nil
+}
+
+// Render executes the template for the file with data
+func (t *Templates) Render(path string, data interface{}) (string, error) {
+	parsed, err := t.get(path)
+	if err != nil {
+		return "", err
+	}
+	return execute(parsed, data)
+}
+
+// NewRegistry reads templates from dir, defaultText is the text part used when an event has no text template
+func NewRegistry(dir string, locale string, defaultText string) (*Registry, error) {
+	if locale == "" {
+		locale = defaultLocale
+	}
+	if defaultText == "" {
+		defaultText = defaultTextTemplate
+	}
+	parsed, err := template.New("default").Parse(defaultText)
+	if err != nil {
+		return nil, fmt.Errorf("parsing default template: %w", err)
+	}
+	return &Registry{templates: NewTemplates(), dir: dir, defaultLocale: locale, defaultText: parsed}, nil
+}
+
+// Events lists the events with templates
+func Events() []Event {
+	return []Event{EventNewMatch, EventNewMessage, EventPostingExpiring, EventVerification}
+}
+
+// Sample is the data previews of the event are rendered with, false for an unknown event
+func Sample(e Event) (interface{}, bool) {
+	ev, ok := events[e]
+	return ev.sample, ok
+}
+
+// Render renders the event's email in the locale, or the nearest one there are templates for
+func (r *Registry) Render(e Event, locale string, data interface{}) (Message, error) {
+	ev, ok := events[e]
+	if !ok {
+		return Message{}, fmt.Errorf("unknown email event %q", e)
+	}
+
+	subject, err := r.render(e, locale, ".subject", data)
+	if errors.Is(err, errNoTemplate) {
+		subject, err = execute(template.Must(template.New("subject").Parse(ev.subject)), data)
+	}
+	if err != nil {
+		return Message{}, err
+	}
+
+	text, err := r.render(e, locale, ".txt", data)
+	if errors.Is(err, errNoTemplate) {
+		text, err = execute(r.defaultText, data)
+	}
+	if err != nil {
+		return Message{}, err
+	}
+
+	html, err := r.render(e, locale, ".html", data)
+	if err != nil && !errors.Is(err, errNoTemplate) {
+		return Message{}, err
+	}
+
+	//subjects are one line whatever the template's trailing newline
+	return Message{Subject: strings.TrimSpace(subject), Text: text, HTML: html}, nil
+}
+
+// render executes the first template found for the locales, errNoTemplate when there is none
+func (r *Registry) render(e Event, locale string, ext string, data interface{}) (string, error) {
+	if r.dir == "" {
+		return "", errNoTemplate
+	}
+	for _, l := range r.locales(locale) {
+		out, err := r.templates.Render(filepath.Join(r.dir, l, string(e)+ext), data)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("rendering %s%s for %s: %w", e, ext, l, err)
+		}
+		return out, nil
+	}
+	return "", errNoTemplate
+}
+
+// locales are tried in order, the locale, its language then the default
+func (r *Registry) locales(locale string) []string {
+	locales := []string{}
+	add := func(l string) {
+		//locales are folder names, keep them from reaching outside the template dir
+		if l == "" || strings.ContainsAny(l, `/\.`) {
+			return
+		}
+		for _, existing := range locales {
+			if existing == l {
+				return
+			}
+		}
+		locales = append(locales, l)
+	}
+
+	add(locale)
+	if i := strings.IndexAny(locale, "-_"); i > 0 {
+		add(locale[:i])
+	}
+	add(r.defaultLocale)
+	return locales
+}
+
+func execute(parsed executor, data interface{}) (string, error) {
+	buf := new(bytes.Buffer)
+	if err := parsed.Execute(buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
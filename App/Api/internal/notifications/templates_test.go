@@ -0,0 +1,104 @@
+package notifications
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTemplate(t *testing.T, dir string, locale string, name string, content string) {
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, locale), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, locale, name), []byte(content), 0o600))
+}
+
+func TestTemplatesAreParsedOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "matches.html")
+	require.NoError(t, os.WriteFile(path, []byte(`<a href="{{.Link}}">Matches</a>`), 0o600))
+
+	templates := NewTemplates()
+	body, err := templates.Render(path, map[string]string{"Link": "http://localhost/private/guid"})
+	require.NoError(t, err)
+	assert.Equal(t, `<a href="http://localhost/private/guid">Matches</a>`, body)
+
+	//changes to the file aren't seen once it is parsed
+	require.NoError(t, os.WriteFile(path, []byte(`changed`), 0o600))
+	body, err = templates.Render(path, map[string]string{"Link": "http://localhost/private/guid"})
+	require.NoError(t, err)
+	assert.Equal(t, `<a href="http://localhost/private/guid">Matches</a>`, body)
+
+	_, err = templates.Render(filepath.Join(t.TempDir(), "missing.html"), nil)
+	assert.Error(t, err)
+}
+
+func TestRegistryFallsBack(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplate(t, dir, "en", "new-match.txt", "Matches: {{.Link}}")
+	writeTemplate(t, dir, "en", "new-match.html", `<a href="{{.Link}}">Matches</a>`)
+	writeTemplate(t, dir, "fr", "new-match.subject", "Correspondances trouvées\n")
+	writeTemplate(t, dir, "fr", "new-match.txt", "Correspondances : {{.Link}}")
+
+	registry, err := NewRegistry(dir, "en", "Default: {{.Link}}")
+	require.NoError(t, err)
+	data := MatchData{Link: "http://localhost/private/guid"}
+
+	//fr-CA has no folder, so fr is used and the missing html part comes from en
+	message, err := registry.Render(EventNewMatch, "fr-CA", data)
+	require.NoError(t, err)
+	assert.Equal(t, Message{
+		Subject: "Correspondances trouvées",
+		Text:    "Correspondances : http://localhost/private/guid",
+		HTML:    `<a href="http://localhost/private/guid">Matches</a>`,
+	}, message)
+
+	message, err = registry.Render(EventNewMatch, "", data)
+	require.NoError(t, err)
+	assert.Equal(t, "Matches have been found for your lost pets post", message.Subject)
+	assert.Equal(t, "Matches: http://localhost/private/guid", message.Text)
+
+	//events without files use the built in subject and the Default template, and have no html part
+	message, err = registry.Render(EventPostingExpiring, "en", ExpiringData{Link: "http://localhost/private/guid"})
+	require.NoError(t, err)
+	assert.Equal(t, Message{Subject: "Your lost pets post is about to expire", Text: "Default: http://localhost/private/guid"}, message)
+
+	//a locale can't point outside the template dir
+	assert.Equal(t, []string{"en"}, registry.locales("../en"))
+
+	_, err = registry.Render(Event("unknown"), "en", data)
+	assert.Error(t, err)
+	_, err = NewRegistry(dir, "en", "{{.Link")
+	assert.Error(t, err)
+}
+
+// the templates in config are rendered with each event's sample data, so a typo shows up here and not in someone's inbox
+func TestConfigTemplatesRender(t *testing.T) {
+	registry, err := NewRegistry(filepath.Join("..", "..", "config", "templates"), "en", "")
+	require.NoError(t, err)
+
+	for _, locale := range []string{"en", "fr"} {
+		for _, event := range Events() {
+			sample, ok := Sample(event)
+			require.True(t, ok)
+			message, err := registry.Render(event, locale, sample)
+			require.NoError(t, err, "%s %s", event, locale)
+			assert.NotEmpty(t, message.Subject)
+			assert.NotEmpty(t, message.Text)
+		}
+	}
+}
+
+func TestMIME(t *testing.T) {
+	contentType, body, err := Message{Text: "plain"}.MIME()
+	require.NoError(t, err)
+	assert.Equal(t, defaultContentType, contentType)
+	assert.Equal(t, "plain", body)
+
+	contentType, body, err = Message{Text: "plain", HTML: `<p class="x">html</p>`}.MIME()
+	require.NoError(t, err)
+	assert.Contains(t, contentType, "multipart/alternative; boundary=")
+	assert.Contains(t, body, "Content-Type: text/plain; charset=UTF-8")
+	assert.Contains(t, body, "Content-Transfer-Encoding: quoted-printable")
+	assert.Contains(t, body, `<p class=3D"x">html</p>`)
+}
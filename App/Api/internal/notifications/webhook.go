@@ -0,0 +1,218 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	domain "lostpets"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	WebhookConfig struct {
+		// Enabled turns webhooks on, each owner's webhooks are signed with the secret they were given when setting the url
+		Enabled        bool `json:"enabled"`
+		TimeoutSeconds int  `json:"timeoutSeconds"`
+		// AllowedHosts limits where webhooks can be sent, any host when empty
+		AllowedHosts []string `json:"allowedHosts"`
+		// AllowHTTP lets webhooks go to plain http urls, for local development
+		AllowHTTP bool `json:"allowHttp"`
+		// AllowPrivate lets webhooks go to loopback and private addresses, for local development
+		AllowPrivate bool `json:"allowPrivate"`
+	}
+
+	// WebhookNotifier posts notifications as signed JSON to the url the owner gave
+	WebhookNotifier struct {
+		config WebhookConfig
+		client *http.Client
+		now    func() time.Time
+	}
+
+	webhookPayload struct {
+		ID        int       `json:"id"`
+		Kind      string    `json:"kind"`
+		Subject   string    `json:"subject"`
+		Text      string    `json:"text"`
+		CreatedOn time.Time `json:"createdOn"`
+	}
+)
+
+const (
+	defaultWebhookTimeout = 10 * time.Second
+	lookupTimeout         = 2 * time.Second
+
+	WebhookTimestampHeader = "X-LostPets-Timestamp"
+	WebhookSignatureHeader = "X-LostPets-Signature"
+)
+
+var (
+	errWebhookRedirect = errors.New("webhook redirects aren't followed")
+	// the url was set before each owner had their own secret, they need to set it again to get one
+	errWebhookSecret = errors.New("webhook has no secret")
+)
+
+/*
+NewWebhookNotifier sends with a client that only connects to public addresses, checked after the host is resolved
+so a name pointing at an internal service is refused too, and that doesn't follow redirects.
+*/
+func NewWebhookNotifier(config WebhookConfig) *WebhookNotifier {
+	timeout := time.Duration(config.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultWebhookTimeout
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	//a proxy would make the connection for us, past the address check
+	transport.Proxy = nil
+	transport.DialContext = config.dial(&net.Dialer{Timeout: timeout})
+
+	client := &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return errWebhookRedirect
+		},
+	}
+	return &WebhookNotifier{config: config, client: client, now: time.Now}
+}
+
+/*
+Notify posts the notification to the recipient url. The request carries the unix time it was signed at and
+an HMAC-SHA256 of "timestamp.body" with the recipient's secret, receivers should check both before trusting it.
+*/
+func (w *WebhookNotifier) Notify(n domain.Notification) error {
+	if n.Secret == "" {
+		return errWebhookSecret
+	}
+	if err := w.config.CheckURL(context.Background(), n.Recipient); err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(webhookPayload{ID: n.ID, Kind: n.Kind, Subject: n.Subject, Text: n.Body, CreatedOn: n.CreatedOn})
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(w.now().Unix(), 10)
+	req, err := http.NewRequest(http.MethodPost, n.Recipient, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, "sha256="+SignWebhook(n.Secret, timestamp, body))
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", res.Status)
+	}
+	return nil
+}
+
+/*
+CheckURL keeps webhooks to https, the allowed hosts and public addresses. It is checked again when sending,
+the api checks it when the owner sets the url so a url that can't be used is refused straight away.
+A host that can't be resolved isn't refused here, the address is checked again when connecting.
+*/
+func (c WebhookConfig) CheckURL(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook url: %w", err)
+	}
+	if u.Scheme != "https" && !(c.AllowHTTP && u.Scheme == "http") {
+		return fmt.Errorf("webhook url must use https: %s", rawURL)
+	}
+	if len(c.AllowedHosts) > 0 && !containsHost(c.AllowedHosts, u.Hostname()) {
+		return fmt.Errorf("webhook host isn't allowed: %s", u.Hostname())
+	}
+	if c.AllowPrivate {
+		return nil
+	}
+
+	if ip := net.ParseIP(u.Hostname()); ip != nil {
+		return checkIP(ip)
+	}
+	if strings.EqualFold(u.Hostname(), "localhost") || strings.HasSuffix(strings.ToLower(u.Hostname()), ".localhost") {
+		return fmt.Errorf("webhook address isn't allowed: %s", u.Hostname())
+	}
+
+	lookup, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(lookup, u.Hostname())
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if err := checkIP(addr.IP); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dial resolves the host itself so the address connected to is the one that was checked
+func (c WebhookConfig) dial(dialer *net.Dialer) func(ctx context.Context, network string, address string) (net.Conn, error) {
+	return func(ctx context.Context, network string, address string) (net.Conn, error) {
+		if c.AllowPrivate {
+			return dialer.DialContext(ctx, network, address)
+		}
+
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		if len(addrs) == 0 {
+			return nil, fmt.Errorf("webhook host has no addresses: %s", host)
+		}
+		for _, addr := range addrs {
+			if err := checkIP(addr.IP); err != nil {
+				return nil, err
+			}
+		}
+		return dialer.DialContext(ctx, network, net.JoinHostPort(addrs[0].IP.String(), port))
+	}
+}
+
+// checkIP refuses the addresses of the machine itself, the local network and cloud metadata services
+func checkIP(ip net.IP) error {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("webhook address isn't allowed: %s", ip)
+	}
+	return nil
+}
+
+func containsHost(hosts []string, host string) bool {
+	for _, h := range hosts {
+		if strings.EqualFold(h, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// SignWebhook is the hex HMAC-SHA256 of "timestamp.body" with the secret
+func SignWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,112 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"lostpets"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookIsSigned(t *testing.T) {
+	var header http.Header
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	webhook := NewWebhookNotifier(WebhookConfig{AllowHTTP: true, AllowPrivate: true})
+	webhook.now = func() time.Time { return time.Unix(1792324800, 0) }
+
+	require.NoError(t, webhook.Notify(lostpets.Notification{ID: 7, Kind: "matches", Recipient: server.URL, Secret: "owner-secret", Subject: "Matches", Body: "see them here"}))
+	assert.Equal(t, "1792324800", header.Get(WebhookTimestampHeader))
+	assert.Equal(t, "sha256="+SignWebhook("owner-secret", "1792324800", body), header.Get(WebhookSignatureHeader))
+
+	payload := webhookPayload{}
+	require.NoError(t, json.Unmarshal(body, &payload))
+	assert.Equal(t, 7, payload.ID)
+	assert.Equal(t, "see them here", payload.Text)
+
+	//a different secret doesn't give the same signature
+	assert.NotEqual(t, SignWebhook("other-secret", "1792324800", body), SignWebhook("owner-secret", "1792324800", body))
+
+	//urls set before owners had their own secret aren't sent to
+	header = nil
+	assert.ErrorIs(t, webhook.Notify(lostpets.Notification{Recipient: server.URL}), errWebhookSecret)
+	assert.Nil(t, header)
+}
+
+func TestWebhookURLChecks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer server.Close()
+
+	//plain http isn't allowed by default
+	webhook := NewWebhookNotifier(WebhookConfig{})
+	assert.Error(t, webhook.Notify(lostpets.Notification{Recipient: server.URL, Secret: "owner-secret"}))
+
+	webhook = NewWebhookNotifier(WebhookConfig{AllowHTTP: true, AllowedHosts: []string{"hooks.example.org"}})
+	assert.EqualError(t, webhook.Notify(lostpets.Notification{Recipient: server.URL, Secret: "owner-secret"}), "webhook host isn't allowed: 127.0.0.1")
+
+	//failed deliveries are errors so they are retried
+	webhook = NewWebhookNotifier(WebhookConfig{AllowHTTP: true, AllowPrivate: true})
+	assert.EqualError(t, webhook.Notify(lostpets.Notification{Recipient: server.URL, Secret: "owner-secret"}), "webhook returned 410 Gone")
+}
+
+func TestWebhookPrivateAddresses(t *testing.T) {
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+
+	webhook := NewWebhookNotifier(WebhookConfig{AllowHTTP: true})
+	assert.EqualError(t, webhook.Notify(lostpets.Notification{Recipient: server.URL, Secret: "owner-secret"}), "webhook address isn't allowed: 127.0.0.1")
+	assert.False(t, called)
+
+	config := WebhookConfig{AllowHTTP: true}
+	for _, u := range []string{
+		"http://169.254.169.254/latest/meta-data/",
+		"http://10.0.0.5/hook",
+		"http://192.168.1.1/hook",
+		"http://[::1]:8080/hook",
+		"http://0.0.0.0/hook",
+		"http://localhost:8080/hook",
+		"http://api.localhost/hook",
+	} {
+		assert.Error(t, config.CheckURL(context.Background(), u), u)
+	}
+	assert.NoError(t, config.CheckURL(context.Background(), "http://93.184.216.34/hook"))
+
+	//the dialer checks the address the name resolves to, not just the url
+	dial := config.dial(&net.Dialer{})
+	_, err := dial(context.Background(), "tcp", "localhost:80")
+	assert.Error(t, err)
+}
+
+func TestWebhookRedirectsAreRefused(t *testing.T) {
+	redirected := false
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		redirected = true
+	}))
+	defer target.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, http.StatusTemporaryRedirect)
+	}))
+	defer server.Close()
+
+	webhook := NewWebhookNotifier(WebhookConfig{AllowHTTP: true, AllowPrivate: true})
+	err := webhook.Notify(lostpets.Notification{Recipient: server.URL, Secret: "owner-secret"})
+	assert.ErrorIs(t, err, errWebhookRedirect)
+	assert.False(t, redirected)
+}
@@ -1,6 +1,9 @@
 package lostpets
 
 import (
+	"errors"
+	"fmt"
+	"math"
 	"mime/multipart"
 	"time"
 )
@@ -13,16 +16,84 @@ type (
 
 	FilterMap map[string][]Filter
 
+	// FilterError is returned by a repo when a filter uses a field or comparator it does not support
+	FilterError struct {
+		Field      string
+		Comparator string
+		Reason     string
+	}
+
+	// Page limits and orders the results of a list query
+	Page struct {
+		Limit  int
+		Offset int
+		Sort   string
+		Order  string
+	}
+
 	Posting struct {
 		ID       int
 		GUID     string
 		Pet      Pet
 		Date     time.Time
 		Location string
-		Name     string
-		Email    string
+		// Coordinates is nil when only the free text location is known
+		Coordinates *Coordinates
+		Name        string
+		Email       string
+		Status      Status
+		// ReunitedWith is the ID of the sighting (or posting for a sighting) that led to the reunion
+		ReunitedWith int
+		CreatedOn    time.Time
+		ReunitedOn   *time.Time
+		ClosedOn     *time.Time
+		ExpiredOn    *time.Time
+		// HiddenOn is set when a moderator hid the record, hidden records aren't shown publicly or matched
+		HiddenOn *time.Time
+		// PendingOn is when the record last started waiting for its email to be verified, VerifiedOn when it was
+		PendingOn  *time.Time
+		VerifiedOn *time.Time
+		NotificationPreferences
+	}
+
+	/*
+		NotificationPreferences is how an owner wants to hear about matches and messages, Phone and WebhookURL are where for those channels.
+		WebhookSecret signs the owner's webhooks, it is made when the url is set and only shown to them then.
+		Locale is the language emails are sent in, such as "fr" or "fr-CA", empty for the default.
+	*/
+	NotificationPreferences struct {
+		NotifyBy      []Channel
+		Phone         string
+		WebhookURL    string
+		WebhookSecret string
+		Locale        string
+	}
+
+	// Coordinates is a point on the map, AccuracyKm is how far from the point the pet could have been (0 if exact)
+	Coordinates struct {
+		Lat        float64
+		Lng        float64
+		AccuracyKm float64
+		// Place is the gazetteer's name for the location when the coordinates were geocoded, empty when the owner gave them
+		Place string
 	}
 
+	// Radius is the value of a FilterNear filter, records within Km of the point match
+	Radius struct {
+		Lat float64
+		Lng float64
+		Km  float64
+	}
+
+	// MatchScore is how closely a posting and sighting match from 0 to 1, Fields holds the score of each compared field
+	MatchScore struct {
+		Total  float64
+		Fields map[string]float64
+	}
+
+	// Status is where a posting or sighting is in its lifecycle, only open records are listed and matched, pending ones wait for their email to be verified
+	Status string
+
 	Sighting struct {
 		InCustody bool
 		Posting
@@ -53,6 +124,67 @@ type (
 	}
 )
 
+// Page sort fields and orders
+const (
+	SortDate     = "date"
+	SortID       = "id"
+	SortLocation = "location"
+	SortPetType  = "pettype"
+	SortCreated  = "createdon"
+
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
+
+// FilterNear is the filter field for a radius search, it only takes the ComparatorWithin comparator with a Radius value
+const (
+	FilterNear       = "near"
+	ComparatorWithin = "within"
+)
+
+const earthRadiusKm = 6371
+
+// DistanceKm is the great circle (haversine) distance between the two points
+func (c Coordinates) DistanceKm(other Coordinates) float64 {
+	lat1 := c.Lat * math.Pi / 180
+	lat2 := other.Lat * math.Pi / 180
+	dLat := lat2 - lat1
+	dLng := (other.Lng - c.Lng) * math.Pi / 180
+
+	a := math.Pow(math.Sin(dLat/2), 2) + math.Cos(lat1)*math.Cos(lat2)*math.Pow(math.Sin(dLng/2), 2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+func (e *FilterError) Error() string {
+	if e.Comparator != "" {
+		return fmt.Sprintf("invalid filter %s %s: %s", e.Field, e.Comparator, e.Reason)
+	}
+	return fmt.Sprintf("invalid filter %s: %s", e.Field, e.Reason)
+}
+
+const (
+	StatusPending  Status = "pending"
+	StatusOpen     Status = "open"
+	StatusReunited Status = "reunited"
+	StatusClosed   Status = "closed"
+	StatusExpired  Status = "expired"
+)
+
+// ErrStatusTransition is returned when a status change is not allowed from the current status
+var ErrStatusTransition = Conflict("status transition not allowed")
+
+// CanTransition reports if a record can move from s to the next status, only open and pending records can change
+func (s Status) CanTransition(next Status) bool {
+	switch s {
+	case StatusOpen:
+		return next == StatusReunited || next == StatusClosed || next == StatusExpired
+	case StatusPending:
+		return next == StatusOpen || next == StatusClosed || next == StatusExpired
+	}
+	return false
+}
+
+// the Get methods of the repos return nil and no error when the record doesn't exist
 type LostPetsRepo interface {
 	GetPostingByGUID(guid string) (*Posting, error)
 	GetSightingByGUID(guid string) (*Sighting, error)
@@ -68,24 +200,468 @@ type LostPetsRepo interface {
 	GetAllSightings(filters ...FilterMap) ([]Sighting, error)
 	GetMatchingSightings(pId int) ([]Sighting, error)
 
+	// GetPostingsPage returns one page of the filtered postings and the total number of matching postings
+	GetPostingsPage(page Page, filters ...FilterMap) ([]Posting, int, error)
+	GetSightingsPage(page Page, filters ...FilterMap) ([]Sighting, int, error)
+
 	AddPosting(newPosting *Posting) error
 	AddSighting(newSighting *Sighting) error
 
-	AddMatch(pID int, sID int) error
+	// UpdatePosting replaces the posting, its pet, breeds and tag, matched on the posting, pet and tag IDs
+	UpdatePosting(posting *Posting) error
+	UpdateSighting(sighting *Sighting) error
+
+	// UpdatePostingCoordinates sets the coordinates without touching the rest of the posting
+	UpdatePostingCoordinates(id int, coordinates *Coordinates) error
+	UpdateSightingCoordinates(id int, coordinates *Coordinates) error
+
+	// UpdatePostingNotifications replaces how the posting's owner is notified
+	UpdatePostingNotifications(id int, preferences NotificationPreferences) error
+	UpdateSightingNotifications(id int, preferences NotificationPreferences) error
+
+	// UpdatePostingStatus moves an open posting to status, reunitedWith is the sighting that led to a reunion or 0
+	UpdatePostingStatus(id int, status Status, reunitedWith int) error
+	UpdateSightingStatus(id int, status Status, reunitedWith int) error
+
+	// ExpirePostings expires open postings created before the given time and returns the number expired
+	ExpirePostings(createdBefore time.Time) (int, error)
+	// WarnExpiringPostings marks the open postings created before the given time that haven't been warned yet and returns their ids
+	WarnExpiringPostings(createdBefore time.Time) ([]int, error)
+	ExpireSightings(createdBefore time.Time) (int, error)
+
+	// VerifyPosting opens a pending posting once its email is verified, ErrStatusTransition if it isn't pending
+	VerifyPosting(id int) error
+	VerifySighting(id int) error
+
+	// UnverifyPosting puts an open or pending posting back to pending, for when its email changes
+	UnverifyPosting(id int) error
+	UnverifySighting(id int) error
+
+	// ExpirePendingPostings expires postings pending since before the given time and returns the number expired
+	ExpirePendingPostings(pendingBefore time.Time) (int, error)
+	ExpirePendingSightings(pendingBefore time.Time) (int, error)
+
+	// DeletePosting removes the posting along with its pet, breeds, tag and matches
+	DeletePosting(id int) error
+	DeleteSighting(id int) error
+
+	// GetMatch returns the match between the posting and sighting, nil if they aren't matched
+	GetMatch(pID int, sID int) (*Match, error)
+	// AddMatch records the match and returns true if the pair is new, an existing match is re-scored and returns false. Dismissed matches are left as they are
+	AddMatch(pID int, sID int, score MatchScore) (bool, error)
+	// DecideMatch records an owner confirming or dismissing a match, it returns ErrNotFound if the pair isn't matched
+	DecideMatch(pID int, sID int, decision MatchDecision, decidedBy ModerationTarget) error
+	// GetMatchFeedback counts the decisions made on matches in each band of score
+	GetMatchFeedback() ([]MatchFeedback, error)
 	UpdateMatch(pID int, sID int, contactedOn time.Time) error
 	RemoveMatch(pID int, sID int) error
+	// RemoveStaleMatches removes the pairs that no longer match, matches an owner decided on or wrote about are kept
+	RemoveStaleMatches(stale []Match) error
 
 	GetPetTypes() ([]PetType, error)
 }
 
+// Match is a posting and sighting that may be the same pet
+type Match struct {
+	PostingID     int
+	SightingID    int
+	Score         float64
+	LastContacted *time.Time
+	// Decision is what the owners said about the match, empty until one of them decides. DecidedBy is the side that did
+	Decision  MatchDecision
+	DecidedBy ModerationTarget
+	DecidedOn *time.Time
+}
+
+// MatchDecision is an owner's feedback on a match, dismissed matches are no longer shown or matched again
+type MatchDecision string
+
+const (
+	MatchConfirmed MatchDecision = "confirmed"
+	MatchDismissed MatchDecision = "dismissed"
+)
+
+// MatchFeedback counts the matches with a score from MinScore up to MaxScore and what owners decided about them
+type MatchFeedback struct {
+	MinScore  float64
+	MaxScore  float64
+	Matches   int
+	Confirmed int
+	Dismissed int
+}
+
+// MatchService finds and records the matches for a posting or sighting, returning how many were found
+type MatchService interface {
+	MatchPosting(posting Posting) (int, error)
+	MatchSighting(sighting Sighting) (int, error)
+}
+
+// Place is where a free text location was found, Name is the location written in a standard form
+type Place struct {
+	Name        string
+	Coordinates Coordinates
+}
+
+// Geocoder finds where a free text location is, returning nil if it doesn't know the location
+type Geocoder interface {
+	Geocode(location string) (*Place, error)
+}
+
+// Job is a unit of background work, Payload is the json the job handler for Kind expects
+type Job struct {
+	ID          int
+	Kind        string
+	Payload     []byte
+	Attempts    int
+	MaxAttempts int
+	RunAt       time.Time
+	LastError   string
+}
+
+type JobRepo interface {
+	AddJob(job *Job) error
+	// ClaimJob locks the next due job for the lease and counts the attempt, it returns nil if no job is due
+	ClaimJob(lease time.Duration) (*Job, error)
+	CompleteJob(id int) error
+	RetryJob(id int, runAt time.Time, lastError string) error
+	// DeadLetterJob stops a job from being retried, it is kept for inspection
+	DeadLetterJob(id int, lastError string) error
+}
+
+// JobQueue queues work to be run in the background, the payload is stored as json
+type JobQueue interface {
+	Enqueue(kind string, payload interface{}) error
+}
+
+// Channel is a way of sending a notification
+type Channel string
+
+const (
+	ChannelEmail   Channel = "email"
+	ChannelSMS     Channel = "sms"
+	ChannelWebhook Channel = "webhook"
+)
+
+var Channels = []Channel{ChannelEmail, ChannelSMS, ChannelWebhook}
+
+// NotificationStatus is where a notification is in the outbox
+type NotificationStatus string
+
+const (
+	NotificationPending NotificationStatus = "pending"
+	NotificationSending NotificationStatus = "sending"
+	NotificationSent    NotificationStatus = "sent"
+	NotificationFailed  NotificationStatus = "failed"
+)
+
+/*
+Notification is a message waiting in the outbox or already sent, Kind is what it is about such as "matches".
+Recipient is the email address, phone number or webhook url for the Channel, Secret is the recipient's webhook secret.
+*/
+type Notification struct {
+	ID          int
+	Kind        string
+	Channel     Channel
+	Recipient   string
+	Secret      string
+	ReplyTo     string
+	Subject     string
+	Body        string
+	ContentType string
+	Status      NotificationStatus
+	Attempts    int
+	MaxAttempts int
+	RunAt       time.Time
+	LastError   string
+	CreatedOn   time.Time
+	SentOn      *time.Time
+}
+
+type NotificationRepo interface {
+	AddNotification(notification *Notification) error
+	// ClaimNotification locks the next due notification for the lease and counts the attempt, it returns nil if none is due
+	ClaimNotification(lease time.Duration) (*Notification, error)
+	MarkNotificationSent(id int, sentOn time.Time) error
+	RetryNotification(id int, runAt time.Time, lastError string) error
+	// DeferNotification puts a notification back until runAt without counting the attempt
+	DeferNotification(id int, runAt time.Time) error
+	FailNotification(id int, lastError string) error
+	// CountSentTo counts the notifications sent to the recipient since the given time
+	CountSentTo(recipient string, since time.Time) (int, error)
+	GetNotificationsPage(page Page, filters ...FilterMap) ([]Notification, int, error)
+}
+
+// Notifier sends a notification over one channel
+type Notifier interface {
+	Notify(notification Notification) error
+}
+
+// Outbox stores notifications to be sent in the background
+type Outbox interface {
+	Enqueue(notification *Notification) error
+}
+
+// Role is what an account is allowed to do, each role can do everything the roles below it can
+type Role string
+
+const (
+	RoleReporter Role = "reporter" // member of the public reporting lost or found pets
+	RoleStaff    Role = "staff"    // shelter staff and volunteers
+	RoleAdmin    Role = "admin"
+)
+
+var roleRank = map[Role]int{
+	RoleReporter: 1,
+	RoleStaff:    2,
+	RoleAdmin:    3,
+}
+
+// Valid reports if r is a known role
+func (r Role) Valid() bool {
+	_, ok := roleRank[r]
+	return ok
+}
+
+// AtLeast reports if r has at least the access of min
+func (r Role) AtLeast(min Role) bool {
+	return r.Valid() && roleRank[r] >= roleRank[min]
+}
+
+type User struct {
+	ID           int
+	Email        string
+	Name         string
+	PasswordHash string
+	Role         Role
+	CreatedOn    time.Time
+}
+
+// RefreshToken is stored as a hash of the token handed to the client, it can be used once
+type RefreshToken struct {
+	ID        int
+	UserID    int
+	TokenHash string
+	ExpiresOn time.Time
+	RevokedOn *time.Time
+}
+
+// Principal is the authenticated user making a request
+type Principal struct {
+	UserID int
+	Email  string
+	Role   Role
+}
+
+// Tokens are issued on login and refresh, ExpiresIn is the seconds until the access token expires
+type Tokens struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    int
+}
+
+var (
+	// ErrEmailTaken is returned when adding a user with an email that is already registered
+	ErrEmailTaken = Conflict("email already registered")
+	// ErrInvalidCredentials is returned for a wrong email or password and for bad, expired or revoked tokens
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+type UserRepo interface {
+	AddUser(user *User) error
+	GetUserByID(id int) (*User, error)
+	GetUserByEmail(email string) (*User, error)
+
+	AddRefreshToken(token *RefreshToken) error
+	GetRefreshToken(tokenHash string) (*RefreshToken, error)
+	// RevokeRefreshToken returns ErrInvalidCredentials if the token was already revoked
+	RevokeRefreshToken(id int) error
+}
+
+// AuthService manages accounts and the tokens used to authenticate requests
+type AuthService interface {
+	Register(email, name, password string, role Role) (*User, error)
+	Login(email, password string) (*Tokens, error)
+	// Refresh swaps a refresh token for new tokens, the old refresh token can't be used again
+	Refresh(refreshToken string) (*Tokens, error)
+	Logout(refreshToken string) error
+	// Authenticate checks an access token and returns who it was issued to
+	Authenticate(accessToken string) (*Principal, error)
+}
+
+// ModerationTarget is the kind of record a moderation action is taken on
+type ModerationTarget string
+
+const (
+	TargetPosting  ModerationTarget = "posting"
+	TargetSighting ModerationTarget = "sighting"
+	TargetPicture  ModerationTarget = "picture"
+)
+
+// ModerationAction is what a moderator did to a record
+type ModerationAction string
+
+const (
+	ActionHide   ModerationAction = "hide"
+	ActionUnhide ModerationAction = "unhide"
+	// ActionDelete removes the record for good, deleting a posting or sighting deletes its picture as well
+	ActionDelete ModerationAction = "delete"
+)
+
+// ModerationEntry is one line of the moderation audit log, UserID is 0 for automatic actions and once the moderator's account is deleted
+type ModerationEntry struct {
+	ID        int
+	UserID    int
+	Target    ModerationTarget
+	TargetID  int
+	Action    ModerationAction
+	Reason    string
+	CreatedOn time.Time
+}
+
+// ErrNotFound is returned when a record to change doesn't exist
+var ErrNotFound = NotFound("record not found")
+
+type ModerationRepo interface {
+	// Moderate applies the entry's action to its target and adds the entry to the audit log, both or neither are saved.
+	// An action by a moderator (UserID set) resolves the open reports on the target, automatic actions leave them for review
+	Moderate(entry *ModerationEntry) error
+	GetModerationLog(page Page, filters ...FilterMap) ([]ModerationEntry, int, error)
+}
+
+// ReportReason is why a member of the public reported a posting or sighting
+type ReportReason string
+
+const (
+	ReasonScam    ReportReason = "scam"
+	ReasonFake    ReportReason = "fake"
+	ReasonAbusive ReportReason = "abusive"
+	ReasonSpam    ReportReason = "spam"
+	ReasonOther   ReportReason = "other"
+)
+
+// ReportReasons are the reasons a report can give
+var ReportReasons = []ReportReason{ReasonScam, ReasonFake, ReasonAbusive, ReasonSpam, ReasonOther}
+
+/*
+Report is an abuse report on a posting or sighting, Reporter identifies who sent it so each person is only counted once.
+Address is a hash of the IP address it was sent from, so logging in or out doesn't count the same person again.
+*/
+type Report struct {
+	ID         int
+	Target     ModerationTarget
+	TargetID   int
+	Reporter   string
+	Address    string
+	Reason     ReportReason
+	Text       string
+	CreatedOn  time.Time
+	ResolvedOn *time.Time
+}
+
+// ReportedRecord is a posting or sighting waiting for a moderator, Reports counts the distinct reporters
+type ReportedRecord struct {
+	Target          ModerationTarget
+	TargetID        int
+	Reports         int
+	Reasons         []string
+	Hidden          bool
+	FirstReportedOn time.Time
+	LastReportedOn  time.Time
+}
+
+type ReportRepo interface {
+	// AddReport stores the report and returns the number of open reports on the record, repeat reports from a reporter or address are ignored
+	AddReport(report *Report) (int, error)
+	// CountReportsFrom counts the reports stored from the address since the given time
+	CountReportsFrom(address string, since time.Time) (int, error)
+	GetReports(target ModerationTarget, targetID int) ([]Report, error)
+	// GetReportQueue returns the records with open reports, most reported first
+	GetReportQueue(page Page) ([]ReportedRecord, int, error)
+}
+
+/*
+Contact is a conversation relayed between the owner of a posting or sighting and someone who wrote to them.
+Each side replies to the other's alias so neither sees the other's email address.
+*/
+type Contact struct {
+	ID       int
+	Target   ModerationTarget
+	TargetID int
+	// MatchedWith is the requester's own record on the other side when they wrote from one, 0 otherwise
+	MatchedWith    int
+	RequesterName  string
+	RequesterEmail string
+	// RequesterAlias routes email to the requester, OwnerAlias to the owner of the target
+	RequesterAlias string
+	OwnerAlias     string
+	CreatedOn      time.Time
+}
+
+// ContactMessage is one message in a conversation, Address is a hash of the IP address a message to the owner came from
+type ContactMessage struct {
+	ID        int
+	ContactID int
+	FromOwner bool
+	Message   string
+	Address   string
+	CreatedOn time.Time
+	SentOn    *time.Time
+}
+
+type ContactRepo interface {
+	// AddContact starts a conversation, or fills in the existing one if the requester already wrote about the target
+	AddContact(contact *Contact) error
+	GetContact(id int) (*Contact, error)
+	// GetContactByAlias finds the conversation with the requester or owner alias, nil if there isn't one
+	GetContactByAlias(alias string) (*Contact, error)
+
+	AddContactMessage(message *ContactMessage) error
+	GetContactMessage(id int) (*ContactMessage, error)
+	MarkContactMessageSent(id int, sentOn time.Time) error
+	// CountContactMessagesFrom counts the messages to owners sent from the address since the given time
+	CountContactMessagesFrom(address string, since time.Time) (int, error)
+	// CountContactMessagesTo counts the messages sent to the owner of the record since the given time, their own replies aren't counted
+	CountContactMessagesTo(target ModerationTarget, targetID int, since time.Time) (int, error)
+}
+
+// MatchMessage is a message in the thread between the owners of a matched posting and sighting
+type MatchMessage struct {
+	ID         int
+	PostingID  int
+	SightingID int
+	// FromPosting is true when the posting's owner wrote the message and false when the sighting's did
+	FromPosting bool
+	Message     string
+	CreatedOn   time.Time
+	// ReadOn is when the other side first read the message, NotifiedOn when they were emailed about it
+	ReadOn     *time.Time
+	NotifiedOn *time.Time
+}
+
+type MessageRepo interface {
+	// AddMatchMessage adds the message to its match's thread and marks the match as contacted
+	AddMatchMessage(message *MatchMessage) error
+	GetMatchMessage(id int) (*MatchMessage, error)
+	// GetMatchMessages returns the thread between the posting and sighting, oldest first
+	GetMatchMessages(pID int, sID int) ([]MatchMessage, error)
+	// MarkMatchMessagesRead marks the unread messages sent to the reader's side as read
+	MarkMatchMessagesRead(pID int, sID int, readerIsPosting bool, readOn time.Time) error
+	MarkMatchMessageNotified(id int, notifiedOn time.Time) error
+}
+
 type FileMeta struct {
 	ID          int
 	GUID        string
 	ContentType string
+	CreatedOn   time.Time
+	// HiddenOn is set when a moderator hid the picture, hidden pictures aren't served
+	HiddenOn *time.Time
 }
 
 type FileRepo interface {
 	GetFileMeta(id int) (*FileMeta, error)
+	// GetFileMetaPage returns one page of the filtered pictures and the total number of matching pictures
+	GetFileMetaPage(page Page, filters ...FilterMap) ([]FileMeta, int, error)
 	SaveFileMeta(meta *FileMeta) error
 	RemoveFileMeta(id int) error
 }
@@ -3,6 +3,7 @@ import { Injectable } from '@angular/core';
 import { Observable } from 'rxjs';
 import { map } from "rxjs/operators";
 import { ISighting, ISightingsResponse } from 'src/app/sightings/models/sighting';
+import { IResponse } from 'src/app/shared/models/response';
 import { environment } from 'src/environments/environment';
 import { IPosting, IPostingsResponse, PostingRequest } from '../models/posting';
 
@@ -15,9 +16,9 @@ export class PostingsService {
   constructor(private readonly http: HttpClient) { }
 
   getAllPostings() :Observable<Array<IPosting>>{
-    return this.http.get<IPostingsResponse>(`${this.url}postings`).pipe(
-      map((res: IPostingsResponse) => {
-        return res.postings
+    return this.http.get<IResponse<IPostingsResponse>>(`${this.url}postings`).pipe(
+      map((res: IResponse<IPostingsResponse>) => {
+        return res.data.postings
       }),
     );
   }
@@ -0,0 +1,12 @@
+export interface IResponse<T> {
+    data: T
+    meta?: IPageMeta
+}
+
+export interface IPageMeta {
+    total: number
+    limit: number
+    offset: number
+    next?: string
+    prev?: string
+}
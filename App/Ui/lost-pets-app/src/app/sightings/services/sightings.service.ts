@@ -4,6 +4,7 @@ import { Observable } from 'rxjs';
 import { map } from "rxjs/operators";
 import { IPosting, IPostingsResponse } from 'src/app/postings/models/posting';
 import { ISighting, ISightingsResponse, SightingRequest } from 'src/app/sightings/models/sighting';
+import { IResponse } from 'src/app/shared/models/response';
 import { environment } from 'src/environments/environment';
 
 @Injectable({
@@ -15,9 +16,9 @@ export class SightingsService {
   constructor(private readonly http: HttpClient) { }
 
   getAllSightings() :Observable<Array<ISighting>>{
-    return this.http.get<ISightingsResponse>(`${this.url}sightings`).pipe(
-      map((res: ISightingsResponse) => {
-        return res.sightings
+    return this.http.get<IResponse<ISightingsResponse>>(`${this.url}sightings`).pipe(
+      map((res: IResponse<ISightingsResponse>) => {
+        return res.data.sightings
       }),
     );
   }